@@ -0,0 +1,397 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	minTLSVersionField                   = "mintlsversion"
+	allowSharedKeyAccessField            = "allowsharedkeyaccess"
+	encryptionKeyVaultURIField           = "encryptionkeyvaulturi"
+	encryptionKeyNameField               = "encryptionkeyname"
+	encryptionKeyVersionField            = "encryptionkeyversion"
+	encryptionUserAssignedIdentityField  = "encryptionuserassignedidentity"
+	requireInfrastructureEncryptionField = "requireinfrastructureencryption"
+	encryptionScopeField                 = "encryptionscope"
+
+	// error returned by storage data plane when shared key access is disabled on storage account
+	keyBasedAuthenticationNotPermitted = "KeyBasedAuthenticationNotPermitted"
+)
+
+var (
+	// See https://docs.microsoft.com/en-us/azure/storage/common/storage-encryption-scopes
+	encryptionScopeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]{3,63}$`)
+
+	// auth types which blobfuse could use to access storage account without account key
+	aadAuthTypes = []string{"msi", "spn"}
+)
+
+// storageSecurityOptions defines security settings of storage account and container,
+// nil or empty fields are not checked or changed
+type storageSecurityOptions struct {
+	minTLSVersion        storage.MinimumTLSVersion
+	allowSharedKeyAccess *bool
+	// customer-managed key in key vault, encryptionUserAssignedIdentity must have access to the key
+	encryptionKeyVaultURI          string
+	encryptionKeyName              string
+	encryptionKeyVersion           string
+	encryptionUserAssignedIdentity string
+	// infrastructure encryption could only be enabled when creating storage account
+	requireInfrastructureEncryption *bool
+	// default encryption scope of container
+	encryptionScope string
+}
+
+// parseStorageSecurityOptions parses security settings from storage class parameters
+func parseStorageSecurityOptions(parameters map[string]string) (*storageSecurityOptions, error) {
+	o := &storageSecurityOptions{}
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case minTLSVersionField:
+			for _, version := range storage.PossibleMinimumTLSVersionValues() {
+				if strings.EqualFold(v, string(version)) {
+					o.minTLSVersion = version
+				}
+			}
+			if o.minTLSVersion == "" {
+				return nil, fmt.Errorf("%s(%s) is not supported, supported value list: %v", k, v, storage.PossibleMinimumTLSVersionValues())
+			}
+		case allowSharedKeyAccessField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			o.allowSharedKeyAccess = to.BoolPtr(value)
+		case encryptionKeyVaultURIField:
+			o.encryptionKeyVaultURI = strings.TrimSuffix(v, "/")
+		case encryptionKeyNameField:
+			o.encryptionKeyName = v
+		case encryptionKeyVersionField:
+			o.encryptionKeyVersion = v
+		case encryptionUserAssignedIdentityField:
+			o.encryptionUserAssignedIdentity = v
+		case requireInfrastructureEncryptionField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			o.requireInfrastructureEncryption = to.BoolPtr(value)
+		case encryptionScopeField:
+			if !encryptionScopeNameRegex.MatchString(v) {
+				return nil, fmt.Errorf("invalid %s: %s, should be 3 to 63 alphanumeric characters", k, v)
+			}
+			o.encryptionScope = v
+		}
+	}
+
+	if o.encryptionKeyVaultURI != "" {
+		if o.encryptionKeyName == "" || o.encryptionUserAssignedIdentity == "" {
+			return nil, fmt.Errorf("%s and %s must be specified with %s", encryptionKeyNameField, encryptionUserAssignedIdentityField, encryptionKeyVaultURIField)
+		}
+	} else if o.encryptionKeyName != "" || o.encryptionKeyVersion != "" || o.encryptionUserAssignedIdentity != "" {
+		return nil, fmt.Errorf("%s must be specified with %s, %s or %s", encryptionKeyVaultURIField, encryptionKeyNameField, encryptionKeyVersionField, encryptionUserAssignedIdentityField)
+	}
+	return o, nil
+}
+
+// isAccountOptionSet checks whether any storage account level setting is specified
+func (o *storageSecurityOptions) isAccountOptionSet() bool {
+	return o.minTLSVersion != "" || o.allowSharedKeyAccess != nil || o.encryptionKeyVaultURI != "" || o.requireInfrastructureEncryption != nil
+}
+
+// isSharedKeyAccessDisabled checks whether storage account should only be accessed with Azure AD
+func (o *storageSecurityOptions) isSharedKeyAccessDisabled() bool {
+	return o.allowSharedKeyAccess != nil && !*o.allowSharedKeyAccess
+}
+
+// isImmutableSettingMatched checks settings which could not be changed after storage account is created
+func (o *storageSecurityOptions) isImmutableSettingMatched(account storage.Account) bool {
+	if o.requireInfrastructureEncryption == nil {
+		return true
+	}
+	var enabled bool
+	if account.AccountProperties != nil && account.AccountProperties.Encryption != nil {
+		enabled = to.Bool(account.AccountProperties.Encryption.RequireInfrastructureEncryption)
+	}
+	return enabled == *o.requireInfrastructureEncryption
+}
+
+type accountSecurityUpdate struct {
+	storage.AccountUpdateParameters
+}
+
+func (u accountSecurityUpdate) changed() bool {
+	p := u.AccountPropertiesUpdateParameters
+	return p.MinimumTLSVersion != "" || p.AllowSharedKeyAccess != nil || p.Encryption != nil
+}
+
+// getAccountUpdateParameters returns mutable settings which are not applied on storage account yet
+func (o *storageSecurityOptions) getAccountUpdateParameters(account storage.Account) accountSecurityUpdate {
+	props := account.AccountProperties
+	if props == nil {
+		props = &storage.AccountProperties{}
+	}
+	update := accountSecurityUpdate{storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{},
+	}}
+	p := update.AccountPropertiesUpdateParameters
+
+	if o.minTLSVersion != "" && props.MinimumTLSVersion != o.minTLSVersion {
+		p.MinimumTLSVersion = o.minTLSVersion
+	}
+	// shared key access is allowed if the property is not set
+	if o.allowSharedKeyAccess != nil && (props.AllowSharedKeyAccess == nil || *props.AllowSharedKeyAccess) != *o.allowSharedKeyAccess {
+		p.AllowSharedKeyAccess = o.allowSharedKeyAccess
+	}
+	if o.encryptionKeyVaultURI != "" && !o.isCustomerManagedKeyMatched(props.Encryption) {
+		p.Encryption = o.getCustomerManagedKeyEncryption()
+		update.Identity = o.getEncryptionIdentity()
+	}
+	return update
+}
+
+func (o *storageSecurityOptions) isCustomerManagedKeyMatched(encryption *storage.Encryption) bool {
+	if encryption == nil || encryption.KeySource != storage.KeySourceMicrosoftKeyvault || encryption.KeyVaultProperties == nil {
+		return false
+	}
+	kv := encryption.KeyVaultProperties
+	if !strings.EqualFold(strings.TrimSuffix(to.String(kv.KeyVaultURI), "/"), o.encryptionKeyVaultURI) ||
+		!strings.EqualFold(to.String(kv.KeyName), o.encryptionKeyName) {
+		return false
+	}
+	return o.encryptionKeyVersion == "" || strings.EqualFold(to.String(kv.KeyVersion), o.encryptionKeyVersion)
+}
+
+func (o *storageSecurityOptions) getCustomerManagedKeyEncryption() *storage.Encryption {
+	encryption := &storage.Encryption{
+		KeySource: storage.KeySourceMicrosoftKeyvault,
+		KeyVaultProperties: &storage.KeyVaultProperties{
+			KeyVaultURI: to.StringPtr(o.encryptionKeyVaultURI),
+			KeyName:     to.StringPtr(o.encryptionKeyName),
+		},
+		EncryptionIdentity: &storage.EncryptionIdentity{
+			EncryptionUserAssignedIdentity: to.StringPtr(o.encryptionUserAssignedIdentity),
+		},
+	}
+	if o.encryptionKeyVersion != "" {
+		encryption.KeyVaultProperties.KeyVersion = to.StringPtr(o.encryptionKeyVersion)
+	}
+	return encryption
+}
+
+func (o *storageSecurityOptions) getEncryptionIdentity() *storage.Identity {
+	return &storage.Identity{
+		Type: storage.IdentityTypeUserAssigned,
+		UserAssignedIdentities: map[string]*storage.UserAssignedIdentity{
+			o.encryptionUserAssignedIdentity: {},
+		},
+	}
+}
+
+// getKeyURI returns key vault key URI used by encryption scope, e.g. https://vault.vault.azure.net/keys/key
+func (o *storageSecurityOptions) getKeyURI() string {
+	keyURI := fmt.Sprintf("%s/keys/%s", o.encryptionKeyVaultURI, o.encryptionKeyName)
+	if o.encryptionKeyVersion != "" {
+		keyURI = keyURI + "/" + o.encryptionKeyVersion
+	}
+	return keyURI
+}
+
+// dedicatedAccountOptions defines account wide settings applied by driver on storage account ensured by cloud provider,
+// storage account with any of these settings is dedicated to volumes with the same settings
+type dedicatedAccountOptions struct {
+	security   *storageSecurityOptions
+	accessTier storage.AccessTier
+	// privateEndpointSubnetID is set if private endpoint is created, public network access of the account is denied
+	privateEndpointSubnetID string
+	// subnetIDs and ipRules specified by user are appended to network rules of the account with default action Deny
+	subnetIDs []string
+	ipRules   []string
+	// dataProtection is applied on blob service of the account
+	dataProtection *dataProtectionOptions
+}
+
+func (o *dedicatedAccountOptions) isSet() bool {
+	return o.security.isAccountOptionSet() || o.accessTier != "" || o.privateEndpointSubnetID != "" || o.isNetworkRuleSet() ||
+		(o.dataProtection != nil && o.dataProtection.isSet())
+}
+
+func (o *dedicatedAccountOptions) isNetworkRuleSet() bool {
+	return len(o.subnetIDs) > 0 || len(o.ipRules) > 0
+}
+
+// getDedicatedStorageAccountName returns a deterministic storage account name for storage account and dedicated settings,
+// e.g. fuse0123456789abcdef0123, so that storage account created by cloud provider is dedicated to the settings
+// and is not matched by volumes without these account wide settings
+func getDedicatedStorageAccountName(prefix, subscriptionID string, accountOptions *azure.AccountOptions, o *dedicatedAccountOptions) string {
+	fields := []string{
+		subscriptionID,
+		accountOptions.ResourceGroup,
+		accountOptions.Type,
+		accountOptions.Kind,
+		accountOptions.Location,
+		strconv.FormatBool(accountOptions.EnableHTTPSTrafficOnly),
+		strconv.FormatBool(to.Bool(accountOptions.IsHnsEnabled)),
+		strconv.FormatBool(to.Bool(accountOptions.EnableNfsV3)),
+		strconv.FormatBool(to.Bool(accountOptions.AllowBlobPublicAccess)),
+		sortedList(accountOptions.VirtualNetworkResourceIDs),
+		string(o.security.minTLSVersion),
+		strconv.FormatBool(o.security.isSharedKeyAccessDisabled()),
+		o.security.encryptionKeyVaultURI,
+		o.security.encryptionKeyName,
+		o.security.encryptionKeyVersion,
+		o.security.encryptionUserAssignedIdentity,
+		string(o.accessTier),
+	}
+	// settings added later are only appended when they are set, so that names of existing accounts are not changed
+	if o.privateEndpointSubnetID != "" {
+		fields = append(fields, privateEndpointSubnetIDField+"="+o.privateEndpointSubnetID)
+	}
+	if len(o.subnetIDs) > 0 {
+		fields = append(fields, subnetIDsField+"="+sortedList(o.subnetIDs))
+	}
+	if len(o.ipRules) > 0 {
+		fields = append(fields, ipRulesField+"="+sortedList(o.ipRules))
+	}
+	if o.dataProtection != nil && o.dataProtection.isSet() {
+		fields = append(fields, o.dataProtection.String())
+	}
+	key := strings.ToLower(strings.Join(fields, separator))
+	accountName := fmt.Sprintf("%s%x", strings.ToLower(prefix), sha256.Sum256([]byte(key)))
+	return accountName[:consts.StorageAccountNameMaxLength]
+}
+
+func sortedList(list []string) string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// checkDriverNamedAccount checks security settings of storage account named by driver,
+// infrastructure encryption could not be enabled on storage account created by cloud provider,
+// so it's only supported with encryption scope
+func (o *storageSecurityOptions) checkDriverNamedAccount() error {
+	if to.Bool(o.requireInfrastructureEncryption) && o.encryptionScope == "" {
+		return fmt.Errorf("%s is only supported with %s or on existing storage account specified by %s", requireInfrastructureEncryptionField, encryptionScopeField, storageAccountField)
+	}
+	return nil
+}
+
+// ensureStorageAccountSecurity applies mutable security settings on storage account ensured by cloud provider,
+// immutable settings of existing storage account specified by user are checked,
+// on storage account named by driver, infrastructure encryption is applied on encryption scope instead
+func (d *Driver) ensureStorageAccountSecurity(ctx context.Context, resourceGroup, accountName string, security *storageSecurityOptions, isDriverNamed bool) error {
+	if d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	if !isDriverNamed && !security.isImmutableSettingMatched(account) {
+		return status.Errorf(codes.FailedPrecondition, "%s(%v) does not match setting of existing storage account(%s)", requireInfrastructureEncryptionField, *security.requireInfrastructureEncryption, accountName)
+	}
+	if update := security.getAccountUpdateParameters(account); update.changed() {
+		klog.V(2).Infof("updating security settings of storage account(%s) under rg(%s)", accountName, resourceGroup)
+		if rerr := d.cloud.StorageAccountClient.Update(ctx, resourceGroup, accountName, update.AccountUpdateParameters); rerr != nil {
+			return fmt.Errorf("failed to update security settings of storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+		}
+	}
+	return nil
+}
+
+// ensureEncryptionScope creates encryption scope in storage account if it does not exist,
+// customer-managed key is used by encryption scope if it's specified
+func (d *Driver) ensureEncryptionScope(ctx context.Context, resourceGroup, accountName string, security *storageSecurityOptions) error {
+	if d.encryptionScopesClient == nil {
+		return fmt.Errorf("encryption scopes client is not initialized")
+	}
+	if _, err := d.encryptionScopesClient.Get(ctx, resourceGroup, accountName, security.encryptionScope); err == nil {
+		return nil
+	} else if !isNotFoundError(err) {
+		return fmt.Errorf("failed to get encryption scope(%s) of storage account(%s): %v", security.encryptionScope, accountName, err)
+	}
+
+	props := &storage.EncryptionScopeProperties{
+		Source:                          storage.EncryptionScopeSourceMicrosoftStorage,
+		State:                           storage.EncryptionScopeStateEnabled,
+		RequireInfrastructureEncryption: security.requireInfrastructureEncryption,
+	}
+	if security.encryptionKeyVaultURI != "" {
+		props.Source = storage.EncryptionScopeSourceMicrosoftKeyVault
+		props.KeyVaultProperties = &storage.EncryptionScopeKeyVaultProperties{KeyURI: to.StringPtr(security.getKeyURI())}
+	}
+	klog.V(2).Infof("creating encryption scope(%s) with source(%s) in storage account(%s)", security.encryptionScope, props.Source, accountName)
+	if _, err := d.encryptionScopesClient.Put(ctx, resourceGroup, accountName, security.encryptionScope, storage.EncryptionScope{EncryptionScopeProperties: props}); err != nil {
+		return fmt.Errorf("failed to create encryption scope(%s) in storage account(%s): %v", security.encryptionScope, accountName, err)
+	}
+	return nil
+}
+
+// createBlobContainer creates container by storage management API which does not need account key,
+// encryption scope is set as default encryption scope of container and could not be overridden by blobs
+func (d *Driver) createBlobContainer(ctx context.Context, resourceGroup, accountName, containerName, encryptionScope string) error {
+	if d.blobContainersClient == nil {
+		return fmt.Errorf("blob containers client is not initialized")
+	}
+	if _, err := d.blobContainersClient.Get(ctx, resourceGroup, accountName, containerName); err == nil {
+		klog.V(2).Infof("container(%s) already exists in storage account(%s)", containerName, accountName)
+		return nil
+	} else if !isNotFoundError(err) {
+		return err
+	}
+
+	container := storage.BlobContainer{
+		ContainerProperties: &storage.ContainerProperties{PublicAccess: storage.PublicAccessNone},
+	}
+	if encryptionScope != "" {
+		container.ContainerProperties.DefaultEncryptionScope = to.StringPtr(encryptionScope)
+		container.ContainerProperties.DenyEncryptionScopeOverride = to.BoolPtr(true)
+	}
+	_, err := d.blobContainersClient.Create(ctx, resourceGroup, accountName, containerName, container)
+	return err
+}
+
+// isAADAuthType checks whether blobfuse accesses storage account with Azure AD instead of account key
+func isAADAuthType(authType string) bool {
+	for _, t := range aadAuthTypes {
+		if strings.EqualFold(authType, t) {
+			return true
+		}
+	}
+	return false
+}
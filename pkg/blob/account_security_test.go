@@ -0,0 +1,390 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/blob/mockstorageapi"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParseStorageSecurityOptions(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parameters map[string]string
+		expected   *storageSecurityOptions
+		expectErr  bool
+	}{
+		{
+			desc:       "no security options",
+			parameters: map[string]string{"skuName": "Standard_LRS"},
+			expected:   &storageSecurityOptions{},
+		},
+		{
+			desc: "all security options",
+			parameters: map[string]string{
+				"minTLSVersion":                   "tls1_2",
+				"allowSharedKeyAccess":            "false",
+				"encryptionKeyVaultURI":           "https://vault.vault.azure.net/",
+				"encryptionKeyName":               "key",
+				"encryptionKeyVersion":            "v1",
+				"encryptionUserAssignedIdentity":  "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+				"requireInfrastructureEncryption": "true",
+				"encryptionScope":                 "scope1",
+			},
+			expected: &storageSecurityOptions{
+				minTLSVersion:                   storage.MinimumTLSVersionTLS12,
+				allowSharedKeyAccess:            to.BoolPtr(false),
+				encryptionKeyVaultURI:           "https://vault.vault.azure.net",
+				encryptionKeyName:               "key",
+				encryptionKeyVersion:            "v1",
+				encryptionUserAssignedIdentity:  "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+				requireInfrastructureEncryption: to.BoolPtr(true),
+				encryptionScope:                 "scope1",
+			},
+		},
+		{
+			desc:       "invalid min TLS version",
+			parameters: map[string]string{"minTLSVersion": "TLS1_3"},
+			expectErr:  true,
+		},
+		{
+			desc:       "invalid allowSharedKeyAccess",
+			parameters: map[string]string{"allowSharedKeyAccess": "no"},
+			expectErr:  true,
+		},
+		{
+			desc:       "invalid requireInfrastructureEncryption",
+			parameters: map[string]string{"requireInfrastructureEncryption": "yes"},
+			expectErr:  true,
+		},
+		{
+			desc:       "invalid encryption scope",
+			parameters: map[string]string{"encryptionScope": "scope-1"},
+			expectErr:  true,
+		},
+		{
+			desc:       "key vault without key name",
+			parameters: map[string]string{"encryptionKeyVaultURI": "https://vault.vault.azure.net", "encryptionUserAssignedIdentity": "id"},
+			expectErr:  true,
+		},
+		{
+			desc:       "key name without key vault",
+			parameters: map[string]string{"encryptionKeyName": "key"},
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseStorageSecurityOptions(test.parameters)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestGetAccountUpdateParameters(t *testing.T) {
+	security := &storageSecurityOptions{
+		minTLSVersion:                   storage.MinimumTLSVersionTLS12,
+		allowSharedKeyAccess:            to.BoolPtr(false),
+		encryptionKeyVaultURI:           "https://vault.vault.azure.net",
+		encryptionKeyName:               "key",
+		encryptionUserAssignedIdentity:  "id",
+		requireInfrastructureEncryption: to.BoolPtr(true),
+	}
+	matchedProps := func() *storage.AccountProperties {
+		return &storage.AccountProperties{
+			MinimumTLSVersion:    storage.MinimumTLSVersionTLS12,
+			AllowSharedKeyAccess: to.BoolPtr(false),
+			Encryption: &storage.Encryption{
+				KeySource:                       storage.KeySourceMicrosoftKeyvault,
+				RequireInfrastructureEncryption: to.BoolPtr(true),
+				KeyVaultProperties: &storage.KeyVaultProperties{
+					KeyVaultURI: to.StringPtr("https://vault.vault.azure.net/"),
+					KeyName:     to.StringPtr("key"),
+					KeyVersion:  to.StringPtr("v1"),
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc              string
+		modify            func(*storage.AccountProperties)
+		expectedImmutable bool
+		expectedUpdate    bool
+	}{
+		{
+			desc:              "all settings matched",
+			modify:            func(*storage.AccountProperties) {},
+			expectedImmutable: true,
+		},
+		{
+			desc:              "min TLS version not matched",
+			modify:            func(p *storage.AccountProperties) { p.MinimumTLSVersion = storage.MinimumTLSVersionTLS10 },
+			expectedImmutable: true,
+			expectedUpdate:    true,
+		},
+		{
+			desc:              "shared key access is allowed by default",
+			modify:            func(p *storage.AccountProperties) { p.AllowSharedKeyAccess = nil },
+			expectedImmutable: true,
+			expectedUpdate:    true,
+		},
+		{
+			desc:              "key vault not matched",
+			modify:            func(p *storage.AccountProperties) { p.Encryption.KeySource = storage.KeySourceMicrosoftStorage },
+			expectedImmutable: true,
+			expectedUpdate:    true,
+		},
+		{
+			desc: "infrastructure encryption not matched",
+			modify: func(p *storage.AccountProperties) {
+				p.Encryption.RequireInfrastructureEncryption = nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		props := matchedProps()
+		test.modify(props)
+		account := storage.Account{AccountProperties: props}
+		assert.Equal(t, test.expectedImmutable, security.isImmutableSettingMatched(account), test.desc)
+		update := security.getAccountUpdateParameters(account)
+		assert.Equal(t, test.expectedUpdate, update.changed(), test.desc)
+		if update.Encryption != nil {
+			assert.Equal(t, "id", to.String(update.Encryption.EncryptionIdentity.EncryptionUserAssignedIdentity), test.desc)
+			assert.Equal(t, storage.IdentityTypeUserAssigned, update.Identity.Type, test.desc)
+		}
+	}
+}
+
+func TestGetDedicatedStorageAccountName(t *testing.T) {
+	accountOptions := &azureprovider.AccountOptions{
+		ResourceGroup:             "rg",
+		Type:                      "Standard_LRS",
+		VirtualNetworkResourceIDs: []string{"subnet2", "subnet1"},
+	}
+	security := &storageSecurityOptions{minTLSVersion: storage.MinimumTLSVersionTLS12}
+	dedicated := func(security *storageSecurityOptions, accessTier storage.AccessTier) *dedicatedAccountOptions {
+		return &dedicatedAccountOptions{security: security, accessTier: accessTier}
+	}
+
+	accountName := getDedicatedStorageAccountName("fuse", "subs", accountOptions, dedicated(security, ""))
+	assert.Equal(t, 24, len(accountName))
+	assert.True(t, strings.HasPrefix(accountName, "fuse"))
+	// name of existing dedicated storage account is not changed by settings added later
+	assert.Equal(t, "fuse5513a09867936df9898a", accountName)
+
+	// name is deterministic
+	sameOptions := &azureprovider.AccountOptions{
+		ResourceGroup:             "RG",
+		Type:                      "Standard_LRS",
+		VirtualNetworkResourceIDs: []string{"subnet1", "subnet2"},
+	}
+	assert.Equal(t, accountName, getDedicatedStorageAccountName("fuse", "subs", sameOptions, dedicated(security, "")))
+
+	// different settings lead to different storage accounts
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs2", accountOptions, dedicated(security, "")))
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, dedicated(&storageSecurityOptions{minTLSVersion: storage.MinimumTLSVersionTLS11}, "")))
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, dedicated(&storageSecurityOptions{
+		minTLSVersion:        storage.MinimumTLSVersionTLS12,
+		allowSharedKeyAccess: to.BoolPtr(false),
+	}, "")))
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, dedicated(security, storage.AccessTierCool)))
+	assert.NotEqual(t, getDedicatedStorageAccountName("fuse", "subs", accountOptions, dedicated(security, storage.AccessTierHot)),
+		getDedicatedStorageAccountName("fuse", "subs", accountOptions, dedicated(security, storage.AccessTierCool)))
+	privateEndpoint := &dedicatedAccountOptions{security: security, privateEndpointSubnetID: "subnet1"}
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, privateEndpoint))
+	assert.NotEqual(t, getDedicatedStorageAccountName("fuse", "subs", accountOptions, privateEndpoint),
+		getDedicatedStorageAccountName("fuse", "subs", accountOptions, &dedicatedAccountOptions{security: security, privateEndpointSubnetID: "subnet2"}))
+	ipRules := &dedicatedAccountOptions{security: security, ipRules: []string{"1.2.3.4", "10.0.0.0/24"}}
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, ipRules))
+	assert.Equal(t, getDedicatedStorageAccountName("fuse", "subs", accountOptions, ipRules),
+		getDedicatedStorageAccountName("fuse", "subs", accountOptions, &dedicatedAccountOptions{security: security, ipRules: []string{"10.0.0.0/24", "1.2.3.4"}}))
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, &dedicatedAccountOptions{security: security, subnetIDs: []string{"subnet1"}}))
+	assert.Equal(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, &dedicatedAccountOptions{security: security, dataProtection: &dataProtectionOptions{}}))
+	versioningEnabled := &dedicatedAccountOptions{security: security, dataProtection: &dataProtectionOptions{versioning: to.BoolPtr(true)}}
+	versioningDisabled := &dedicatedAccountOptions{security: security, dataProtection: &dataProtectionOptions{versioning: to.BoolPtr(false)}}
+	assert.NotEqual(t, accountName, getDedicatedStorageAccountName("fuse", "subs", accountOptions, versioningEnabled))
+	assert.NotEqual(t, getDedicatedStorageAccountName("fuse", "subs", accountOptions, versioningEnabled),
+		getDedicatedStorageAccountName("fuse", "subs", accountOptions, versioningDisabled))
+	assert.NotEqual(t, getDedicatedStorageAccountName("fuse", "subs", accountOptions, &dedicatedAccountOptions{security: security, dataProtection: &dataProtectionOptions{blobSoftDeleteDays: 7}}),
+		getDedicatedStorageAccountName("fuse", "subs", accountOptions, &dedicatedAccountOptions{security: security, dataProtection: &dataProtectionOptions{blobSoftDeleteDays: 14}}))
+}
+
+func TestDedicatedAccountOptionsIsSet(t *testing.T) {
+	assert.False(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}}).isSet())
+	assert.True(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}, accessTier: storage.AccessTierCool}).isSet())
+	assert.True(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}, privateEndpointSubnetID: "subnet"}).isSet())
+	assert.True(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}, ipRules: []string{"1.2.3.4"}}).isSet())
+	assert.True(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}, subnetIDs: []string{"subnet"}}).isSet())
+	assert.False(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}, dataProtection: &dataProtectionOptions{}}).isSet())
+	assert.True(t, (&dedicatedAccountOptions{security: &storageSecurityOptions{}, dataProtection: &dataProtectionOptions{changeFeed: to.BoolPtr(false)}}).isSet())
+}
+
+func TestCheckDriverNamedAccount(t *testing.T) {
+	assert.NoError(t, (&storageSecurityOptions{minTLSVersion: storage.MinimumTLSVersionTLS12}).checkDriverNamedAccount())
+	assert.NoError(t, (&storageSecurityOptions{requireInfrastructureEncryption: to.BoolPtr(false)}).checkDriverNamedAccount())
+	assert.NoError(t, (&storageSecurityOptions{requireInfrastructureEncryption: to.BoolPtr(true), encryptionScope: "scope1"}).checkDriverNamedAccount())
+	assert.Error(t, (&storageSecurityOptions{requireInfrastructureEncryption: to.BoolPtr(true)}).checkDriverNamedAccount())
+}
+
+func TestEnsureStorageAccountSecurity(t *testing.T) {
+	security := &storageSecurityOptions{
+		minTLSVersion:                   storage.MinimumTLSVersionTLS12,
+		requireInfrastructureEncryption: to.BoolPtr(true),
+	}
+	matchedAccount := storage.Account{
+		Name: to.StringPtr("matched"),
+		AccountProperties: &storage.AccountProperties{
+			MinimumTLSVersion: storage.MinimumTLSVersionTLS12,
+			Encryption:        &storage.Encryption{RequireInfrastructureEncryption: to.BoolPtr(true)},
+		},
+	}
+	unmatchedAccount := storage.Account{
+		Name:              to.StringPtr("unmatched"),
+		AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS10},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{}
+	assert.Error(t, d.ensureStorageAccountSecurity(context.TODO(), "rg", "matched", security, false))
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+
+	// all settings are already applied
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "matched").Return(matchedAccount, nil).Times(1)
+	assert.NoError(t, d.ensureStorageAccountSecurity(context.TODO(), "rg", "matched", security, false))
+
+	// mutable settings are applied on existing account
+	tlsSecurity := &storageSecurityOptions{minTLSVersion: storage.MinimumTLSVersionTLS12}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "unmatched").Return(unmatchedAccount, nil).Times(1)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "rg", "unmatched", storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{MinimumTLSVersion: storage.MinimumTLSVersionTLS12},
+	}).Return(nil).Times(1)
+	assert.NoError(t, d.ensureStorageAccountSecurity(context.TODO(), "rg", "unmatched", tlsSecurity, false))
+
+	// infrastructure encryption could not be enabled on existing account
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "unmatched").Return(unmatchedAccount, nil).Times(1)
+	err := d.ensureStorageAccountSecurity(context.TODO(), "rg", "unmatched", security, false)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// infrastructure encryption is not checked on storage account named by driver
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "unmatched").Return(unmatchedAccount, nil).Times(1)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "rg", "unmatched", gomock.Any()).Return(nil).Times(1)
+	assert.NoError(t, d.ensureStorageAccountSecurity(context.TODO(), "rg", "unmatched", security, true))
+
+	// failed to get storage account
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "notexist").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("not found")}).Times(1)
+	assert.Error(t, d.ensureStorageAccountSecurity(context.TODO(), "rg", "notexist", security, false))
+}
+
+func TestCreateBlobContainer(t *testing.T) {
+	d := NewFakeDriver()
+	assert.Error(t, d.createBlobContainer(context.TODO(), "rg", "account", "container", ""))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockContainersClient := mockstorageapi.NewMockBlobContainersClientAPI(ctrl)
+	d.blobContainersClient = mockContainersClient
+
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").Return(storage.BlobContainer{}, autorest.DetailedError{StatusCode: http.StatusNotFound}).Times(1)
+	mockContainersClient.EXPECT().Create(gomock.Any(), "rg", "account", "container", storage.BlobContainer{
+		ContainerProperties: &storage.ContainerProperties{
+			PublicAccess:                storage.PublicAccessNone,
+			DefaultEncryptionScope:      to.StringPtr("scope1"),
+			DenyEncryptionScopeOverride: to.BoolPtr(true),
+		},
+	}).Return(storage.BlobContainer{}, nil).Times(1)
+	assert.NoError(t, d.createBlobContainer(context.TODO(), "rg", "account", "container", "scope1"))
+
+	// existing container is not changed
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").Return(storage.BlobContainer{}, nil).Times(1)
+	assert.NoError(t, d.createBlobContainer(context.TODO(), "rg", "account", "container", ""))
+
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").Return(storage.BlobContainer{}, fmt.Errorf("server error")).Times(1)
+	assert.Error(t, d.createBlobContainer(context.TODO(), "rg", "account", "container", ""))
+}
+
+func TestEnsureEncryptionScope(t *testing.T) {
+	d := NewFakeDriver()
+	security := &storageSecurityOptions{
+		encryptionKeyVaultURI: "https://vault.vault.azure.net",
+		encryptionKeyName:     "key",
+		encryptionKeyVersion:  "v1",
+		encryptionScope:       "scope1",
+	}
+	assert.Error(t, d.ensureEncryptionScope(context.TODO(), "rg", "account", security))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockScopesClient := mockstorageapi.NewMockEncryptionScopesClientAPI(ctrl)
+	d.encryptionScopesClient = mockScopesClient
+
+	notFound := autorest.DetailedError{StatusCode: http.StatusNotFound}
+	mockScopesClient.EXPECT().Get(gomock.Any(), "rg", "account", "scope1").Return(storage.EncryptionScope{}, notFound).Times(1)
+	mockScopesClient.EXPECT().Put(gomock.Any(), "rg", "account", "scope1", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName, encryptionScopeName string, scope storage.EncryptionScope) (storage.EncryptionScope, error) {
+			assert.Equal(t, storage.EncryptionScopeSourceMicrosoftKeyVault, scope.Source)
+			assert.Equal(t, "https://vault.vault.azure.net/keys/key/v1", to.String(scope.KeyVaultProperties.KeyURI))
+			return scope, nil
+		}).Times(1)
+	assert.NoError(t, d.ensureEncryptionScope(context.TODO(), "rg", "account", security))
+
+	security = &storageSecurityOptions{encryptionScope: "scope2", requireInfrastructureEncryption: to.BoolPtr(true)}
+	mockScopesClient.EXPECT().Get(gomock.Any(), "rg", "account", "scope2").Return(storage.EncryptionScope{}, notFound).Times(1)
+	mockScopesClient.EXPECT().Put(gomock.Any(), "rg", "account", "scope2", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName, encryptionScopeName string, scope storage.EncryptionScope) (storage.EncryptionScope, error) {
+			assert.Equal(t, storage.EncryptionScopeSourceMicrosoftStorage, scope.Source)
+			assert.Equal(t, to.BoolPtr(true), scope.RequireInfrastructureEncryption)
+			return scope, nil
+		}).Times(1)
+	assert.NoError(t, d.ensureEncryptionScope(context.TODO(), "rg", "account", security))
+
+	// existing encryption scope is not changed
+	mockScopesClient.EXPECT().Get(gomock.Any(), "rg", "account", "scope2").Return(storage.EncryptionScope{}, nil).Times(1)
+	assert.NoError(t, d.ensureEncryptionScope(context.TODO(), "rg", "account", security))
+}
+
+func TestIsAADAuthType(t *testing.T) {
+	assert.True(t, isAADAuthType("MSI"))
+	assert.True(t, isAADAuthType("spn"))
+	assert.False(t, isAADAuthType("key"))
+	assert.False(t, isAADAuthType(""))
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+// operations of audit records
+const (
+	auditOperationCredential = "ResolveCredential"
+	auditOperationMount      = "Mount"
+	auditOperationUnmount    = "Unmount"
+
+	auditResultSuccess = "Success"
+	auditResultFailure = "Failure"
+)
+
+// sources of storage account credentials in audit records
+const (
+	// credential is read from key vault secret specified in volume attributes
+	credentialSourceKeyVault = "KeyVault"
+	// account key is read from k8s secret by driver
+	credentialSourceSecret = "Secret"
+	// credential is passed in secrets of CSI request, e.g. nodeStageSecretRef or provisioner secret
+	credentialSourceRequestSecret = "RequestSecret"
+	// account key is listed by cluster identity
+	credentialSourceClusterIdentity = "ClusterIdentity"
+	// no account key or SAS token is resolved by driver, blobfuse authenticates by azureStorageAuthType, e.g. msi or spn
+	credentialSourceNone = "None"
+)
+
+// auditEventReasons are reasons of events reported on success and failure of audit operations
+var auditEventReasons = map[string][2]string{
+	auditOperationCredential: {"CredentialResolved", "CredentialResolutionFailed"},
+	auditOperationMount:      {"VolumeMounted", "VolumeMountFailed"},
+	auditOperationUnmount:    {"VolumeUnmounted", "VolumeUnmountFailed"},
+}
+
+// auditObject is the pod and PVC of an audit record, events are reported on them when audit events are enabled
+type auditObject struct {
+	PodName      string `json:"podName,omitempty"`
+	PodNamespace string `json:"podNamespace,omitempty"`
+	PVCName      string `json:"pvcName,omitempty"`
+	PVCNamespace string `json:"pvcNamespace,omitempty"`
+	PVName       string `json:"pvName,omitempty"`
+}
+
+// auditRecord is a structured record of credential resolution, mount or unmount, it never contains secrets
+type auditRecord struct {
+	Time            time.Time `json:"time"`
+	Node            string    `json:"node,omitempty"`
+	Operation       string    `json:"operation"`
+	Result          string    `json:"result"`
+	Error           string    `json:"error,omitempty"`
+	VolumeID        string    `json:"volumeID,omitempty"`
+	Source          string    `json:"source,omitempty"`
+	AuthType        string    `json:"authType,omitempty"`
+	SecretName      string    `json:"secretName,omitempty"`
+	SecretNamespace string    `json:"secretNamespace,omitempty"`
+	AccountName     string    `json:"accountName,omitempty"`
+	ContainerName   string    `json:"containerName,omitempty"`
+	Protocol        string    `json:"protocol,omitempty"`
+	TargetPath      string    `json:"targetPath,omitempty"`
+	auditObject
+}
+
+// auditor writes audit records as json lines to a dedicated audit log, and reports them as events on PVC and pod,
+// a nil auditor drops all records
+type auditor struct {
+	node string
+	// out is the audit log, records are not written if it's nil
+	out  io.Writer
+	lock sync.Mutex
+	// recorder reports events on PVC and pod, events are not reported if it's nil
+	recorder record.EventRecorder
+}
+
+func newAuditor(node string, out io.Writer, recorder record.EventRecorder) *auditor {
+	return &auditor{node: node, out: out, recorder: recorder}
+}
+
+// initAuditor creates auditor when audit log or audit events are enabled, it should be called after cloud is initialized
+func (d *Driver) initAuditor() error {
+	if d.auditLogPath == "" && !d.enableAuditEvents {
+		return nil
+	}
+	var out io.Writer
+	var recorder record.EventRecorder
+	if d.auditLogPath != "" {
+		var err error
+		if out, err = openAuditLog(d.auditLogPath); err != nil {
+			return err
+		}
+	}
+	if d.enableAuditEvents {
+		if d.cloud != nil && d.cloud.KubeClient != nil {
+			recorder = newAuditEventRecorder(d.cloud.KubeClient, d.Name, d.NodeID)
+		} else {
+			klog.Warningf("audit events are disabled since KubeClient is nil")
+		}
+	}
+	d.auditor = newAuditor(d.NodeID, out, recorder)
+	return nil
+}
+
+// openAuditLog opens audit log file for appending, "-" means stdout which is separated from driver logs on stderr
+func openAuditLog(path string) (io.Writer, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log(%s): %v", path, err)
+	}
+	return f, nil
+}
+
+// newAuditEventRecorder returns a recorder which reports events by kubeClient
+func newAuditEventRecorder(kubeClient kubernetes.Interface, component, node string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component, Host: node})
+}
+
+// recordResult sets result of r by err and records it
+func (a *auditor) recordResult(r *auditRecord, err error) {
+	if a == nil {
+		return
+	}
+	r.Result = auditResultSuccess
+	if err != nil {
+		r.Result = auditResultFailure
+		r.Error = util.RedactString(err.Error())
+	}
+	a.record(r)
+}
+
+func (a *auditor) record(r *auditRecord) {
+	if a == nil {
+		return
+	}
+	r.Time = time.Now()
+	r.Node = a.node
+	if a.out != nil {
+		data, err := json.Marshal(r)
+		if err != nil {
+			klog.Warningf("failed to marshal audit record: %v", err)
+			return
+		}
+		a.lock.Lock()
+		_, err = a.out.Write(append(data, '\n'))
+		a.lock.Unlock()
+		if err != nil {
+			klog.Warningf("failed to write audit record: %v", err)
+		}
+	}
+	if a.recorder != nil {
+		eventType, reason, message := getAuditEvent(r)
+		for _, ref := range getAuditObjectReferences(r.auditObject) {
+			a.recorder.Event(ref, eventType, reason, message)
+		}
+	}
+}
+
+// getAuditEvent returns type, reason and message of the event reported for r
+func getAuditEvent(r *auditRecord) (string, string, string) {
+	reasons, ok := auditEventReasons[r.Operation]
+	if !ok {
+		reasons = [2]string{r.Operation, r.Operation + "Failed"}
+	}
+	eventType, reason := v1.EventTypeNormal, reasons[0]
+	if r.Result == auditResultFailure {
+		eventType, reason = v1.EventTypeWarning, reasons[1]
+	}
+
+	details := []string{}
+	for _, field := range []struct{ name, value string }{
+		{"volume", r.VolumeID},
+		{"node", r.Node},
+		{"source", r.Source},
+		{"account", r.AccountName},
+		{"container", r.ContainerName},
+		{"target", r.TargetPath},
+	} {
+		if field.value != "" {
+			details = append(details, fmt.Sprintf("%s(%s)", field.name, field.value))
+		}
+	}
+	message := fmt.Sprintf("%s %s: %s", r.Operation, strings.ToLower(r.Result), strings.Join(details, ", "))
+	if r.Error != "" {
+		message = fmt.Sprintf("%s, error: %s", message, r.Error)
+	}
+	return eventType, reason, message
+}
+
+// getAuditObjectReferences returns references of PVC and pod in obj which are known
+func getAuditObjectReferences(obj auditObject) []*v1.ObjectReference {
+	refs := []*v1.ObjectReference{}
+	if obj.PVCName != "" && obj.PVCNamespace != "" {
+		refs = append(refs, &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: obj.PVCNamespace, Name: obj.PVCName})
+	}
+	if obj.PodName != "" && obj.PodNamespace != "" {
+		refs = append(refs, &v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: obj.PodNamespace, Name: obj.PodName})
+	}
+	return refs
+}
+
+// getAuditObject returns pod and PVC in volume attributes or create volume parameters,
+// they are set by external-provisioner with --extra-create-metadata and by kubelet when podInfoOnMount is true
+func getAuditObject(attrib map[string]string) auditObject {
+	obj := auditObject{}
+	for k, v := range attrib {
+		switch strings.ToLower(k) {
+		case podNameField:
+			obj.PodName = v
+		case podNamespaceField:
+			obj.PodNamespace = v
+		case pvcNameKey:
+			obj.PVCName = v
+		case pvcNamespaceKey:
+			obj.PVCNamespace = v
+		case pvNameKey:
+			obj.PVName = v
+		}
+	}
+	return obj
+}
+
+type auditObjectContextKey struct{}
+
+// withAuditObject returns a context carrying obj, so that credential resolution in the request is recorded with obj
+func withAuditObject(ctx context.Context, obj auditObject) context.Context {
+	return context.WithValue(ctx, auditObjectContextKey{}, obj)
+}
+
+func getAuditObjectFromContext(ctx context.Context) auditObject {
+	obj, _ := ctx.Value(auditObjectContextKey{}).(auditObject)
+	return obj
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	mount "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// newAuditedFakeDriver returns a fake driver which writes audit records to the returned buffer
+func newAuditedFakeDriver() (*Driver, *bytes.Buffer) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	out := new(bytes.Buffer)
+	d.auditor = newAuditor(fakeNodeID, out, nil)
+	return d, out
+}
+
+func parseAuditRecords(t *testing.T, out *bytes.Buffer) []auditRecord {
+	records := []auditRecord{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		r := auditRecord{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &r), line)
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAuditorRecord(t *testing.T) {
+	out := new(bytes.Buffer)
+	recorder := record.NewFakeRecorder(10)
+	a := newAuditor(fakeNodeID, out, recorder)
+	obj := auditObject{PodName: "pod", PodNamespace: "ns", PVCName: "pvc", PVCNamespace: "ns"}
+
+	a.recordResult(&auditRecord{Operation: auditOperationCredential, VolumeID: "rg#account#container", Source: credentialSourceKeyVault, auditObject: obj}, nil)
+	a.recordResult(&auditRecord{Operation: auditOperationMount, VolumeID: "rg#account#container", TargetPath: "/mnt/target", auditObject: obj},
+		fmt.Errorf("mount failed: --account-key=accountKeyValue"))
+	// events are not reported without pod and PVC
+	a.recordResult(&auditRecord{Operation: auditOperationUnmount, VolumeID: "rg#account#container", TargetPath: "/mnt/target"}, nil)
+
+	assert.NotContains(t, out.String(), "accountKeyValue")
+	records := parseAuditRecords(t, out)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, auditOperationCredential, records[0].Operation)
+	assert.Equal(t, auditResultSuccess, records[0].Result)
+	assert.Equal(t, credentialSourceKeyVault, records[0].Source)
+	assert.Equal(t, fakeNodeID, records[0].Node)
+	assert.Equal(t, obj, records[0].auditObject)
+	assert.False(t, records[0].Time.IsZero())
+	assert.Equal(t, auditResultFailure, records[1].Result)
+	assert.Equal(t, "mount failed: --account-key=***redacted***", records[1].Error)
+	assert.Equal(t, auditOperationUnmount, records[2].Operation)
+
+	events := []string{}
+	close(recorder.Events)
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	assert.Equal(t, 4, len(events))
+	assert.True(t, strings.HasPrefix(events[0], "Normal CredentialResolved ResolveCredential success: volume(rg#account#container)"), events[0])
+	assert.Contains(t, events[0], "source(KeyVault)")
+	assert.True(t, strings.HasPrefix(events[2], "Warning VolumeMountFailed Mount failure: "), events[2])
+	assert.Contains(t, events[2], "error: mount failed: --account-key=***redacted***")
+
+	// nil auditor drops records
+	var nilAuditor *auditor
+	nilAuditor.recordResult(&auditRecord{Operation: auditOperationMount}, nil)
+}
+
+func TestGetAuditObject(t *testing.T) {
+	attrib := map[string]string{
+		podNameField:       "pod",
+		podNamespaceField:  "pod-ns",
+		pvcNameKey:         "pvc",
+		pvcNamespaceKey:    "pvc-ns",
+		pvNameKey:          "pv",
+		containerNameField: "container",
+	}
+	expected := auditObject{PodName: "pod", PodNamespace: "pod-ns", PVCName: "pvc", PVCNamespace: "pvc-ns", PVName: "pv"}
+	assert.Equal(t, expected, getAuditObject(attrib))
+	assert.Equal(t, auditObject{}, getAuditObject(nil))
+
+	assert.Equal(t, auditObject{}, getAuditObjectFromContext(context.Background()))
+	assert.Equal(t, expected, getAuditObjectFromContext(withAuditObject(context.Background(), expected)))
+
+	refs := getAuditObjectReferences(expected)
+	assert.Equal(t, 2, len(refs))
+	assert.Equal(t, v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: "pvc-ns", Name: "pvc"}, *refs[0])
+	assert.Equal(t, v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: "pod-ns", Name: "pod"}, *refs[1])
+	assert.Empty(t, getAuditObjectReferences(auditObject{PVCName: "pvc"}))
+}
+
+func TestInitAuditor(t *testing.T) {
+	d := NewFakeDriver()
+	assert.NoError(t, d.initAuditor())
+	assert.Nil(t, d.auditor)
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d.auditLogPath = filepath.Join(dir, "audit.log")
+	d.enableAuditEvents = true
+	d.cloud = &azure.Cloud{KubeClient: fake.NewSimpleClientset()}
+	assert.NoError(t, d.initAuditor())
+	assert.NotNil(t, d.auditor.out)
+	assert.NotNil(t, d.auditor.recorder)
+	info, err := os.Stat(d.auditLogPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	d.auditor.recordResult(&auditRecord{Operation: auditOperationUnmount, VolumeID: "vol"}, nil)
+	content, err := ioutil.ReadFile(d.auditLogPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"operation":"Unmount"`)
+
+	// events are disabled without KubeClient
+	d.auditLogPath = "-"
+	d.cloud = &azure.Cloud{}
+	assert.NoError(t, d.initAuditor())
+	assert.Equal(t, os.Stdout, d.auditor.out)
+	assert.Nil(t, d.auditor.recorder)
+
+	d.auditLogPath = filepath.Join(dir, "not-exist", "audit.log")
+	assert.Error(t, d.initAuditor())
+}
+
+func TestGetAuthEnvAudit(t *testing.T) {
+	volumeID := "rg#account#container"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	key := "key"
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: &key}}}
+
+	tests := []struct {
+		desc           string
+		attrib         map[string]string
+		secrets        map[string]string
+		kubeSecret     *v1.Secret
+		listKeysErr    *retry.Error
+		expectedSource string
+		expectedResult string
+		expectedSecret string
+	}{
+		{
+			desc:           "request secrets",
+			attrib:         map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "default"},
+			secrets:        map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "accountKeyValue"},
+			expectedSource: credentialSourceRequestSecret,
+			expectedResult: auditResultSuccess,
+		},
+		{
+			desc:   "k8s secret",
+			attrib: map[string]string{secretNamespaceField: "ns"},
+			kubeSecret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "azure-storage-account-account-secret", Namespace: "ns"},
+				Data:       map[string][]byte{defaultSecretAccountName: []byte("account"), defaultSecretAccountKey: []byte("accountKeyValue")},
+			},
+			expectedSource: credentialSourceSecret,
+			expectedResult: auditResultSuccess,
+			expectedSecret: "azure-storage-account-account-secret",
+		},
+		{
+			desc:           "cluster identity",
+			expectedSource: credentialSourceClusterIdentity,
+			expectedResult: auditResultSuccess,
+		},
+		{
+			desc:           "cluster identity failure",
+			listKeysErr:    &retry.Error{RawError: fmt.Errorf("forbidden")},
+			expectedSource: credentialSourceClusterIdentity,
+			expectedResult: auditResultFailure,
+		},
+		{
+			desc:           "msi",
+			attrib:         map[string]string{"azurestorageauthtype": "msi"},
+			expectedSource: credentialSourceNone,
+			expectedResult: auditResultSuccess,
+		},
+	}
+	for _, test := range tests {
+		d, out := newAuditedFakeDriver()
+		kubeClient := fake.NewSimpleClientset()
+		if test.kubeSecret != nil {
+			kubeClient = fake.NewSimpleClientset(test.kubeSecret)
+		}
+		d.cloud.KubeClient = kubeClient
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d.cloud.StorageAccountClient = mockStorageAccountsClient
+		mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any()).Return(keys, test.listKeysErr).AnyTimes()
+
+		_, _, _, _ = d.GetAuthEnv(context.TODO(), volumeID, "", test.attrib, test.secrets)
+		assert.NotContains(t, out.String(), "accountKeyValue", test.desc)
+		records := parseAuditRecords(t, out)
+		assert.Equal(t, 1, len(records), test.desc)
+		r := records[0]
+		assert.Equal(t, auditOperationCredential, r.Operation, test.desc)
+		assert.Equal(t, test.expectedSource, r.Source, test.desc)
+		assert.Equal(t, test.expectedResult, r.Result, test.desc)
+		assert.Equal(t, test.expectedSecret, r.SecretName, test.desc)
+		assert.Equal(t, volumeID, r.VolumeID, test.desc)
+		assert.Equal(t, "account", r.AccountName, test.desc)
+		assert.Equal(t, "container", r.ContainerName, test.desc)
+		assert.Equal(t, getAuditObject(test.attrib), r.auditObject, test.desc)
+	}
+
+	// credential is not resolved for nfs
+	d, out := newAuditedFakeDriver()
+	_, _, _, err := d.GetAuthEnv(context.TODO(), volumeID, nfs, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+}
+
+func TestGetStorageAccesskeyAudit(t *testing.T) {
+	obj := auditObject{PVCName: "pvc", PVCNamespace: "default", PVName: "pv"}
+	ctx := withAuditObject(context.Background(), obj)
+	accountOptions := &azure.AccountOptions{Name: "account", ResourceGroup: "rg"}
+
+	d, out := newAuditedFakeDriver()
+	_, _, err := d.GetStorageAccesskey(ctx, accountOptions, map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "accountKeyValue"}, "")
+	assert.NoError(t, err)
+
+	d.cloud.KubeClient = fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "account", Namespace: "default"},
+		Data:       map[string][]byte{defaultSecretAccountKey: []byte("accountKeyValue")},
+	})
+	_, _, err = d.GetStorageAccesskey(ctx, accountOptions, nil, "default")
+	assert.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = mockStorageAccountsClient
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.AccountListKeysResult{}, &retry.Error{RawError: fmt.Errorf("forbidden")}).AnyTimes()
+	_, _, err = d.GetStorageAccesskey(ctx, accountOptions, nil, "other")
+	assert.Error(t, err)
+
+	assert.NotContains(t, out.String(), "accountKeyValue")
+	records := parseAuditRecords(t, out)
+	assert.Equal(t, 3, len(records))
+	for i, expected := range []struct{ source, result, secretName string }{
+		{credentialSourceRequestSecret, auditResultSuccess, ""},
+		{credentialSourceSecret, auditResultSuccess, "account"},
+		{credentialSourceClusterIdentity, auditResultFailure, ""},
+	} {
+		assert.Equal(t, auditOperationCredential, records[i].Operation)
+		assert.Equal(t, expected.source, records[i].Source)
+		assert.Equal(t, expected.result, records[i].Result)
+		assert.Equal(t, expected.secretName, records[i].SecretName)
+		assert.Equal(t, "account", records[i].AccountName)
+		assert.Equal(t, obj, records[i].auditObject)
+	}
+}
+
+func TestMountAudit(t *testing.T) {
+	d, out := newAuditedFakeDriver()
+	d.enableBlobMockMount = true
+	d.mounter = &mount.SafeFormatAndMount{
+		Interface: &fakeMounter{},
+		Exec:      &testingexec.FakeExec{ExactOrder: true},
+	}
+	defer os.RemoveAll(targetTest)
+
+	_, err := d.NodeStageVolume(context.TODO(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#container",
+		StagingTargetPath: targetTest,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+		VolumeContext: map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "default"},
+		Secrets:       map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "accountKeyValue"},
+	})
+	assert.NoError(t, err)
+	_, err = d.NodeUnpublishVolume(context.TODO(), &csi.NodeUnpublishVolumeRequest{VolumeId: "rg#account#container", TargetPath: targetTest})
+	assert.NoError(t, err)
+
+	assert.NotContains(t, out.String(), "accountKeyValue")
+	records := parseAuditRecords(t, out)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, auditOperationCredential, records[0].Operation)
+	assert.Equal(t, auditOperationMount, records[1].Operation)
+	assert.Equal(t, auditResultSuccess, records[1].Result)
+	assert.Equal(t, "account", records[1].AccountName)
+	assert.Equal(t, "container", records[1].ContainerName)
+	assert.Equal(t, targetTest, records[1].TargetPath)
+	assert.Equal(t, "pvc", records[1].PVCName)
+	assert.Equal(t, auditOperationUnmount, records[2].Operation)
+	assert.Equal(t, targetTest, records[2].TargetPath)
+}
@@ -25,9 +25,11 @@ import (
 
 	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-02-01/network"
-	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
 
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -58,7 +60,7 @@ func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent
 			CloudConfigKey:  "cloud-config",
 		},
 	}
-	az.Environment.StorageEndpointSuffix = storage.DefaultBaseURL
+	az.Environment.StorageEndpointSuffix = azstorage.DefaultBaseURL
 
 	kubeClient, err := getKubeClient(kubeconfig)
 	if err != nil {
@@ -135,7 +137,7 @@ func getCloudProvider(kubeconfig, nodeID, secretName, secretNamespace, userAgent
 	}
 
 	if az.Environment.StorageEndpointSuffix == "" {
-		az.Environment.StorageEndpointSuffix = storage.DefaultBaseURL
+		az.Environment.StorageEndpointSuffix = azstorage.DefaultBaseURL
 	}
 	return az, nil
 }
@@ -178,18 +180,13 @@ func (d *Driver) getKeyvaultToken() (authorizer autorest.Authorizer, err error)
 	return authorizer, nil
 }
 
-func (d *Driver) updateSubnetServiceEndpoints(ctx context.Context) error {
+// updateSubnetServiceEndpoints appends storage service endpoint to the subnet if it does not exist
+func (d *Driver) updateSubnetServiceEndpoints(ctx context.Context, resourceGroup, vnetName, subnetName string) error {
 	if d.cloud.SubnetsClient == nil {
 		return fmt.Errorf("SubnetsClient is nil")
 	}
 
-	resourceGroup := d.cloud.ResourceGroup
-	if len(d.cloud.VnetResourceGroup) > 0 {
-		resourceGroup = d.cloud.VnetResourceGroup
-	}
 	location := d.cloud.Location
-	vnetName := d.cloud.VnetName
-	subnetName := d.cloud.SubnetName
 
 	klog.V(2).Infof("updateSubnetServiceEndpoints on VnetName: %s, SubnetName: %s", vnetName, subnetName)
 
@@ -253,3 +250,122 @@ func getKubeClient(kubeconfig string) (*kubernetes.Clientset, error) {
 
 	return kubernetes.NewForConfig(config)
 }
+
+// ensureStorageAccountNetworkRules appends virtual network rules and IP rules into network rule set of the storage account,
+// existing rules of the storage account are kept, and default action is set as Deny since rules take no effect otherwise
+func (d *Driver) ensureStorageAccountNetworkRules(ctx context.Context, resourceGroup, accountName string, vnetResourceIDs, ipRules []string) error {
+	if d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+
+	lockKey := resourceGroup + accountName
+	d.volLockMap.LockEntry(lockKey)
+	defer d.volLockMap.UnlockEntry(lockKey)
+
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+
+	ruleSet := &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionDeny}
+	if account.AccountProperties != nil && account.AccountProperties.NetworkRuleSet != nil {
+		ruleSet = account.AccountProperties.NetworkRuleSet
+	}
+	vnetRules := []storage.VirtualNetworkRule{}
+	if ruleSet.VirtualNetworkRules != nil {
+		vnetRules = *ruleSet.VirtualNetworkRules
+	}
+	ipRuleList := []storage.IPRule{}
+	if ruleSet.IPRules != nil {
+		ipRuleList = *ruleSet.IPRules
+	}
+
+	updated := false
+	if ruleSet.DefaultAction != storage.DefaultActionDeny {
+		// Azure returns DefaultAction Allow on a storage account without network rules
+		klog.V(2).Infof("default action of network rules on storage account(%s) is changed from %q to %q", accountName, ruleSet.DefaultAction, storage.DefaultActionDeny)
+		ruleSet.DefaultAction = storage.DefaultActionDeny
+		updated = true
+	}
+	for i := range vnetResourceIDs {
+		found := false
+		for _, rule := range vnetRules {
+			if strings.EqualFold(to.String(rule.VirtualNetworkResourceID), vnetResourceIDs[i]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			vnetRules = append(vnetRules, storage.VirtualNetworkRule{
+				VirtualNetworkResourceID: to.StringPtr(vnetResourceIDs[i]),
+				Action:                   storage.ActionAllow,
+			})
+			updated = true
+		}
+	}
+	for i := range ipRules {
+		found := false
+		for _, rule := range ipRuleList {
+			if to.String(rule.IPAddressOrRange) == ipRules[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ipRuleList = append(ipRuleList, storage.IPRule{
+				IPAddressOrRange: to.StringPtr(ipRules[i]),
+				Action:           storage.ActionAllow,
+			})
+			updated = true
+		}
+	}
+	if !updated {
+		klog.V(4).Infof("network rules are already set on storage account(%s)", accountName)
+		return nil
+	}
+
+	ruleSet.VirtualNetworkRules = &vnetRules
+	ruleSet.IPRules = &ipRuleList
+	parameters := storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{
+			NetworkRuleSet: ruleSet,
+		},
+	}
+	if rerr := d.cloud.StorageAccountClient.Update(ctx, resourceGroup, accountName, parameters); rerr != nil {
+		return fmt.Errorf("failed to update network rules of storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	klog.V(2).Infof("network rules(vnetResourceIDs: %v, ipRules: %v) are set on storage account(%s)", vnetResourceIDs, ipRules, accountName)
+	return nil
+}
+
+// initManagementClients initializes Azure Resource Manager clients which are not provided by cloud provider,
+// these clients are only used in controller
+func (d *Driver) initManagementClients() error {
+	env := d.cloud.Environment
+	token, err := auth.GetServicePrincipalToken(&d.cloud.Config.AzureAuthConfig, &env, env.ServiceManagementEndpoint)
+	if err != nil {
+		return err
+	}
+	authorizer := autorest.NewBearerAuthorizer(token)
+	d.initPrivateEndpointClients(authorizer)
+
+	blobContainersClient := storage.NewBlobContainersClientWithBaseURI(env.ResourceManagerEndpoint, d.cloud.SubscriptionID)
+	blobContainersClient.Authorizer = authorizer
+	d.blobContainersClient = blobContainersClient
+	encryptionScopesClient := storage.NewEncryptionScopesClientWithBaseURI(env.ResourceManagerEndpoint, d.cloud.SubscriptionID)
+	encryptionScopesClient.Authorizer = authorizer
+	d.encryptionScopesClient = encryptionScopesClient
+	managementPoliciesClient := storage.NewManagementPoliciesClientWithBaseURI(env.ResourceManagerEndpoint, d.cloud.SubscriptionID)
+	managementPoliciesClient.Authorizer = authorizer
+	d.managementPoliciesClient = managementPoliciesClient
+	blobServicesClient := storage.NewBlobServicesClientWithBaseURI(env.ResourceManagerEndpoint, d.cloud.SubscriptionID)
+	blobServicesClient.Authorizer = authorizer
+	d.blobServicesClient = blobServicesClient
+	accountsClient := storage.NewAccountsClientWithBaseURI(env.ResourceManagerEndpoint, d.cloud.SubscriptionID)
+	accountsClient.Authorizer = authorizer
+	d.accountsClient = accountsClient
+	objectReplicationPoliciesClient := storage.NewObjectReplicationPoliciesClientWithBaseURI(env.ResourceManagerEndpoint, d.cloud.SubscriptionID)
+	objectReplicationPoliciesClient.Authorizer = authorizer
+	d.objectReplicationPoliciesClient = objectReplicationPoliciesClient
+	return nil
+}
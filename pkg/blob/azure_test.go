@@ -25,12 +25,15 @@ import (
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-02-01/network"
-	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/golang/mock/gomock"
 
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/stretchr/testify/assert"
 
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/subnetclient/mocksubnetclient"
 	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 
@@ -160,7 +163,7 @@ users:
 		if cloud == nil {
 			t.Errorf("return value of getCloudProvider should not be nil even there is error")
 		} else {
-			assert.Equal(t, cloud.Environment.StorageEndpointSuffix, storage.DefaultBaseURL)
+			assert.Equal(t, cloud.Environment.StorageEndpointSuffix, azstorage.DefaultBaseURL)
 			assert.Equal(t, cloud.UserAgent, test.userAgent)
 		}
 	}
@@ -269,7 +272,7 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 				retErr := retry.NewError(false, fmt.Errorf("the subnet does not exist"))
 				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(network.Subnet{}, retErr).Times(1)
 				expectedErr := fmt.Errorf("failed to get the subnet %s under vnet %s: %v", config.SubnetName, config.VnetName, retErr)
-				err := d.updateSubnetServiceEndpoints(ctx)
+				err := d.updateSubnetServiceEndpoints(ctx, config.ResourceGroup, config.VnetName, config.SubnetName)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
@@ -281,7 +284,7 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(network.Subnet{}, nil).Times(1)
 				mockSubnetClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
 
-				err := d.updateSubnetServiceEndpoints(ctx)
+				err := d.updateSubnetServiceEndpoints(ctx, config.ResourceGroup, config.VnetName, config.SubnetName)
 				if !reflect.DeepEqual(err, nil) {
 					t.Errorf("Unexpected error: %v", err)
 				}
@@ -297,7 +300,7 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fakeSubnet, nil).Times(1)
 				mockSubnetClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
 
-				err := d.updateSubnetServiceEndpoints(ctx)
+				err := d.updateSubnetServiceEndpoints(ctx, config.ResourceGroup, config.VnetName, config.SubnetName)
 				if !reflect.DeepEqual(err, nil) {
 					t.Errorf("Unexpected error: %v", err)
 				}
@@ -315,7 +318,7 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fakeSubnet, nil).Times(1)
 				mockSubnetClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
 
-				err := d.updateSubnetServiceEndpoints(ctx)
+				err := d.updateSubnetServiceEndpoints(ctx, config.ResourceGroup, config.VnetName, config.SubnetName)
 				if !reflect.DeepEqual(err, nil) {
 					t.Errorf("Unexpected error: %v", err)
 				}
@@ -336,7 +339,7 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 
 				mockSubnetClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(fakeSubnet, nil).Times(1)
 
-				err := d.updateSubnetServiceEndpoints(ctx)
+				err := d.updateSubnetServiceEndpoints(ctx, config.ResourceGroup, config.VnetName, config.SubnetName)
 				if !reflect.DeepEqual(err, nil) {
 					t.Errorf("Unexpected error: %v", err)
 				}
@@ -347,7 +350,7 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 			testFunc: func(t *testing.T) {
 				d.cloud.SubnetsClient = nil
 				expectedErr := fmt.Errorf("SubnetsClient is nil")
-				err := d.updateSubnetServiceEndpoints(ctx)
+				err := d.updateSubnetServiceEndpoints(ctx, config.ResourceGroup, config.VnetName, config.SubnetName)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
 				}
@@ -359,3 +362,121 @@ func TestUpdateSubnetServiceEndpoints(t *testing.T) {
 		t.Run(tc.name, tc.testFunc)
 	}
 }
+
+func TestEnsureStorageAccountNetworkRules(t *testing.T) {
+	subnetID := "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	otherSubnetID := "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/other"
+
+	testCases := []struct {
+		desc            string
+		account         storage.Account
+		getErr          *retry.Error
+		vnetResourceIDs []string
+		ipRules         []string
+		expectedRuleSet *storage.NetworkRuleSet
+		expectErr       bool
+	}{
+		{
+			desc:            "network rules are appended to account without network rule set",
+			account:         storage.Account{AccountProperties: &storage.AccountProperties{}},
+			vnetResourceIDs: []string{subnetID},
+			ipRules:         []string{"20.1.2.0/24"},
+			expectedRuleSet: &storage.NetworkRuleSet{
+				DefaultAction: storage.DefaultActionDeny,
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{
+					{VirtualNetworkResourceID: to.StringPtr(subnetID), Action: storage.ActionAllow},
+				},
+				IPRules: &[]storage.IPRule{
+					{IPAddressOrRange: to.StringPtr("20.1.2.0/24"), Action: storage.ActionAllow},
+				},
+			},
+		},
+		{
+			desc: "missing network rules are appended to existing rules",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{
+					DefaultAction: storage.DefaultActionDeny,
+					VirtualNetworkRules: &[]storage.VirtualNetworkRule{
+						{VirtualNetworkResourceID: to.StringPtr(subnetID), Action: storage.ActionAllow},
+					},
+				},
+			}},
+			vnetResourceIDs: []string{subnetID, otherSubnetID},
+			expectedRuleSet: &storage.NetworkRuleSet{
+				DefaultAction: storage.DefaultActionDeny,
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{
+					{VirtualNetworkResourceID: to.StringPtr(subnetID), Action: storage.ActionAllow},
+					{VirtualNetworkResourceID: to.StringPtr(otherSubnetID), Action: storage.ActionAllow},
+				},
+				IPRules: &[]storage.IPRule{},
+			},
+		},
+		{
+			desc: "default action Allow is changed to Deny",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{
+					DefaultAction: storage.DefaultActionAllow,
+					IPRules: &[]storage.IPRule{
+						{IPAddressOrRange: to.StringPtr("20.1.2.3"), Action: storage.ActionAllow},
+					},
+				},
+			}},
+			ipRules: []string{"20.1.2.3"},
+			expectedRuleSet: &storage.NetworkRuleSet{
+				DefaultAction:       storage.DefaultActionDeny,
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{},
+				IPRules: &[]storage.IPRule{
+					{IPAddressOrRange: to.StringPtr("20.1.2.3"), Action: storage.ActionAllow},
+				},
+			},
+		},
+		{
+			desc: "network rules already exist",
+			account: storage.Account{AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{
+					DefaultAction: storage.DefaultActionDeny,
+					VirtualNetworkRules: &[]storage.VirtualNetworkRule{
+						{VirtualNetworkResourceID: to.StringPtr(subnetID), Action: storage.ActionAllow},
+					},
+					IPRules: &[]storage.IPRule{
+						{IPAddressOrRange: to.StringPtr("20.1.2.3"), Action: storage.ActionAllow},
+					},
+				},
+			}},
+			vnetResourceIDs: []string{subnetID},
+			ipRules:         []string{"20.1.2.3"},
+		},
+		{
+			desc:            "failed to get storage account",
+			getErr:          retry.NewError(false, fmt.Errorf("account not found")),
+			vnetResourceIDs: []string{subnetID},
+			expectErr:       true,
+		},
+	}
+
+	for _, test := range testCases {
+		ctrl := gomock.NewController(t)
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		d := NewFakeDriver()
+		d.cloud = &azureprovider.Cloud{StorageAccountClient: mockStorageAccountsClient}
+
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "account").Return(test.account, test.getErr).Times(1)
+		if test.expectedRuleSet != nil {
+			mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "rg", "account", storage.AccountUpdateParameters{
+				AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{NetworkRuleSet: test.expectedRuleSet},
+			}).Return(nil).Times(1)
+		}
+
+		err := d.ensureStorageAccountNetworkRules(context.TODO(), "rg", "account", test.vnetResourceIDs, test.ipRules)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+		ctrl.Finish()
+	}
+
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{}
+	assert.Error(t, d.ensureStorageAccountNetworkRules(context.TODO(), "rg", "account", []string{subnetID}, nil))
+}
@@ -18,10 +18,16 @@ package blob
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage/storageapi"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/pborman/uuid"
 
@@ -36,6 +42,10 @@ import (
 
 	csicommon "sigs.k8s.io/blob-csi-driver/pkg/csi-common"
 	"sigs.k8s.io/blob-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednsclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednszonegroupclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privateendpointclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/virtualnetworklinksclient"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
 
@@ -69,14 +79,42 @@ const (
 	storageAccountNameField      = "storageaccountname"
 	allowBlobPublicAccessField   = "allowblobpublicaccess"
 	ephemeralField               = "csi.storage.k8s.io/ephemeral"
+	podNameField                 = "csi.storage.k8s.io/pod.name"
 	podNamespaceField            = "csi.storage.k8s.io/pod.namespace"
 	mountOptionsField            = "mountoptions"
+	cacheMediumField             = "cachemedium"
+	cacheSizeMBField             = "cachesizemb"
+	cachePathField               = "cachepath"
+	subDirField                  = "subdir"
+	directoryMetadataKey         = "hdi_isfolder"
+	provisioningModeField        = "provisioningmode"
+	onDeleteField                = "ondelete"
+	subnetIDsField               = "subnetids"
+	ipRulesField                 = "iprules"
 	falseValue                   = "false"
 	trueValue                    = "true"
 	defaultSecretAccountName     = "azurestorageaccountname"
 	defaultSecretAccountKey      = "azurestorageaccountkey"
+	defaultSecretAccountSasToken = "azurestorageaccountsastoken"
+	defaultSecretMSISecret       = "msisecret"
+	defaultSecretSPNClientSecret = "azurestoragespnclientsecret"
 	fuse                         = "fuse"
 	nfs                          = "nfs"
+	tmpPathOption                = "--tmp-path"
+	cacheSizeMBOption            = "--cache-size-mb"
+	streamingOption              = "--streaming"
+
+	// provisioningModeContainer creates a container per volume, provisioningModeDirectory creates a directory per volume in an existing container
+	provisioningModeContainer = "container"
+	provisioningModeDirectory = "directory"
+	// actions on the sub directory of a volume in DeleteVolume
+	onDeleteDelete  = "delete"
+	onDeleteArchive = "archive"
+	// sub directory is moved under this directory in the same container when it's archived
+	archivedDirPrefix = "archived"
+	// tags of sub directory and action on delete in volume id
+	subDirVolumeIDTag   = "subdir="
+	onDeleteVolumeIDTag = "ondelete="
 
 	// See https://docs.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-containers--blobs--and-metadata#container-names
 	containerNameMinLength = 3
@@ -103,6 +141,11 @@ var (
 	retriableErrors       = []string{accountNotProvisioned, tooManyRequests, shareNotFound, shareBeingDeleted, clientThrottled}
 )
 
+func init() {
+	// secret fields of volume context and k8s secrets are redacted in logs
+	util.RegisterSensitiveKeys(accountKeyField, defaultSecretAccountKey, defaultSecretAccountSasToken, defaultSecretMSISecret, defaultSecretSPNClientSecret)
+}
+
 // DriverOptions defines driver parameters specified in driver deployment
 type DriverOptions struct {
 	NodeID                     string
@@ -114,7 +157,20 @@ type DriverOptions struct {
 	BlobfuseProxyEndpoint      string
 	EnableBlobfuseProxy        bool
 	BlobfuseProxyConnTimout    int
+	BlobfuseProxyTLSCAFile     string
+	BlobfuseProxyTLSCertFile   string
+	BlobfuseProxyTLSKeyFile    string
+	BlobfuseProxyTLSServerName string
+	BlobfuseProxyFallback      bool
 	EnableBlobMockMount        bool
+	BlobfuseCacheRoot          string
+	BlobfuseCacheSizeMB        int
+	BlobfuseCredentialDelivery string
+	BlobfuseCredentialDir      string
+	VolumeRestoreSyncPeriod    int
+	LeaderElectionNamespace    string
+	AuditLogPath               string
+	EnableAuditEvents          bool
 }
 
 // Driver implements all interfaces of CSI drivers
@@ -130,6 +186,29 @@ type Driver struct {
 	enableBlobMockMount     bool
 	enableBlobfuseProxy     bool
 	blobfuseProxyConnTimout int
+	// TLS is used to connect blobfuse proxy when blobfuseProxyTLSCAFile is specified,
+	// client certificate is presented when blobfuseProxyTLSCertFile is specified(mTLS)
+	blobfuseProxyTLSCAFile     string
+	blobfuseProxyTLSCertFile   string
+	blobfuseProxyTLSKeyFile    string
+	blobfuseProxyTLSServerName string
+	// mount blobfuse inside driver when blobfuse proxy is too old to support capabilities required by the mount
+	blobfuseProxyFallback bool
+	// blobfuseProxyInfo is negotiated with blobfuse proxy on startup or first mount, protected by blobfuseProxyInfoLock
+	blobfuseProxyInfo     *blobfuseProxyInfo
+	blobfuseProxyInfoLock sync.Mutex
+	// per-volume blobfuse cache directories are created under blobfuseCacheRoot
+	blobfuseCacheRoot string
+	// blobfuseCacheSizeMB is the default cache size limit of every blobfuse mount, 0 means no limit
+	blobfuseCacheSizeMB int
+	// blobfuseCredentialDelivery is how credentials are passed to blobfuse mounted inside driver(env, file or fd),
+	// per-mount config files are created in blobfuseCredentialDir on file delivery
+	blobfuseCredentialDelivery string
+	blobfuseCredentialDir      string
+	// interval(seconds) to check restore annotations on persistent volumes in controller, 0 means volume restore is disabled,
+	// restores are only synced by the controller replica which holds the lease in leaderElectionNamespace
+	volumeRestoreSyncPeriod int
+	leaderElectionNamespace string
 	mounter                 *mount.SafeFormatAndMount
 	volLockMap              *util.LockMap
 	// A map storing all volumes with ongoing operations so that additional operations
@@ -137,6 +216,24 @@ type Driver struct {
 	volumeLocks *volumeLocks
 	// only for nfs feature
 	subnetLockMap *util.LockMap
+	// clients used to create private endpoint of storage account, only initialized in controller
+	privateEndpointClient     privateendpointclient.Interface
+	privateDNSClient          privatednsclient.Interface
+	privateDNSZoneGroupClient privatednszonegroupclient.Interface
+	virtualNetworkLinksClient virtualnetworklinksclient.Interface
+	// storage management clients which are not provided by cloud provider, only initialized in controller
+	blobContainersClient     storageapi.BlobContainersClientAPI
+	encryptionScopesClient   storageapi.EncryptionScopesClientAPI
+	managementPoliciesClient storageapi.ManagementPoliciesClientAPI
+	blobServicesClient       storageapi.BlobServicesClientAPI
+	// accounts client is used to restore blobs of a volume to a point in time
+	accountsClient storageapi.AccountsClientAPI
+	// object replication policies client is used to replicate volumes to storage account in secondary region
+	objectReplicationPoliciesClient storageapi.ObjectReplicationPoliciesClientAPI
+	// audit records are written to auditLogPath, and reported as events on PVC and pod if enableAuditEvents is true
+	auditLogPath      string
+	enableAuditEvents bool
+	auditor           *auditor
 }
 
 // NewDriver Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
@@ -153,7 +250,23 @@ func NewDriver(options *DriverOptions) *Driver {
 		blobfuseProxyEndpoint:      options.BlobfuseProxyEndpoint,
 		enableBlobfuseProxy:        options.EnableBlobfuseProxy,
 		blobfuseProxyConnTimout:    options.BlobfuseProxyConnTimout,
+		blobfuseProxyTLSCAFile:     options.BlobfuseProxyTLSCAFile,
+		blobfuseProxyTLSCertFile:   options.BlobfuseProxyTLSCertFile,
+		blobfuseProxyTLSKeyFile:    options.BlobfuseProxyTLSKeyFile,
+		blobfuseProxyTLSServerName: options.BlobfuseProxyTLSServerName,
+		blobfuseProxyFallback:      options.BlobfuseProxyFallback,
 		enableBlobMockMount:        options.EnableBlobMockMount,
+		blobfuseCacheRoot:          options.BlobfuseCacheRoot,
+		blobfuseCacheSizeMB:        options.BlobfuseCacheSizeMB,
+		blobfuseCredentialDelivery: options.BlobfuseCredentialDelivery,
+		blobfuseCredentialDir:      options.BlobfuseCredentialDir,
+		volumeRestoreSyncPeriod:    options.VolumeRestoreSyncPeriod,
+		leaderElectionNamespace:    options.LeaderElectionNamespace,
+		auditLogPath:               options.AuditLogPath,
+		enableAuditEvents:          options.EnableAuditEvents,
+	}
+	if d.blobfuseCacheRoot == "" {
+		d.blobfuseCacheRoot = DefaultBlobfuseCacheRoot
 	}
 	d.Name = options.DriverName
 	d.Version = driverVersion
@@ -181,6 +294,34 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 		Exec:      utilexec.New(),
 	}
 
+	if err := d.initAuditor(); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if d.NodeID != "" {
+		if d.enableBlobfuseProxy {
+			if err := d.checkBlobfuseProxyHealth(); err != nil {
+				klog.Warningf("%v", err)
+			} else if _, err := d.getBlobfuseProxyInfo(); err != nil {
+				klog.Warningf("failed to negotiate with blobfuse proxy: %v, it would be done again on first mount", err)
+			}
+		}
+		if d.blobfuseCredentialDelivery != "" && !util.IsValidBlobfuseCredentialDelivery(d.blobfuseCredentialDelivery) {
+			klog.Fatalf("unsupported blobfuse credential delivery(%s)", d.blobfuseCredentialDelivery)
+		}
+		if d.blobfuseCredentialDelivery == util.BlobfuseCredentialDeliveryFile {
+			if err := util.EnsureBlobfuseCredentialDir(d.blobfuseCredentialDir); err != nil {
+				klog.Fatalf("failed to create blobfuse credential directory: %v", err)
+			}
+		}
+		// clean up cache directories of volumes that are no longer staged on this node
+		d.cleanupBlobfuseCacheDirs()
+	} else if err := d.initManagementClients(); err != nil {
+		klog.Warningf("failed to initialize management clients: %v, private endpoint and storage account hardening would not be supported", err)
+	} else if d.volumeRestoreSyncPeriod > 0 && d.cloud.KubeClient != nil {
+		go d.runVolumeRestoreController(context.Background(), time.Duration(d.volumeRestoreSyncPeriod)*time.Second)
+	}
+
 	// Initialize default library driver
 	d.AddControllerServiceCapabilities(
 		[]csi.ControllerServiceCapability_RPC_Type{
@@ -204,6 +345,7 @@ func (d *Driver) Run(endpoint, kubeconfig string, testBool bool) {
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
 	})
 
 	s := csicommon.NewNonBlockingGRPCServer()
@@ -223,6 +365,75 @@ func GetContainerInfo(id string) (string, string, string, error) {
 	return segments[0], segments[1], segments[2], nil
 }
 
+// isNFSProtocol checks whether protocol in volume attributes is nfs
+func isNFSProtocol(attrib map[string]string) bool {
+	for k, v := range attrib {
+		if strings.EqualFold(k, protocolField) {
+			return strings.EqualFold(v, nfs)
+		}
+	}
+	return false
+}
+
+// getSubDirFromVolumeID get sub directory according to volume id, e.g.
+// input: "rg#f5713de20cde511e8ba4900#container#pvc-name#subdir=project/workload"
+// output: project/workload
+func getSubDirFromVolumeID(id string) string {
+	return getVolumeIDTag(id, subDirVolumeIDTag)
+}
+
+// getOnDeleteFromVolumeID get the action on sub directory in DeleteVolume according to volume id, e.g.
+// input: "rg#f5713de20cde511e8ba4900#container#pvc-name#subdir=project/workload#ondelete=archive"
+// output: archive
+func getOnDeleteFromVolumeID(id string) string {
+	if onDelete := getVolumeIDTag(id, onDeleteVolumeIDTag); onDelete != "" {
+		return onDelete
+	}
+	return onDeleteDelete
+}
+
+// getVolumeIDTag returns value of the segment tagged by tag after volume name in volume id, segments without the tag
+// are ignored, so that extra segments in volume handle of existing static PVs are not taken as sub directory
+func getVolumeIDTag(id, tag string) string {
+	segments := strings.Split(id, separator)
+	if len(segments) < 5 {
+		return ""
+	}
+	for _, segment := range segments[4:] {
+		if strings.HasPrefix(segment, tag) {
+			return strings.TrimPrefix(segment, tag)
+		}
+	}
+	return ""
+}
+
+// getSubDir returns the sub directory in volume attributes, falling back to the one in volume id
+func getSubDir(volumeID string, attrib map[string]string) (string, error) {
+	subDir := getSubDirFromVolumeID(volumeID)
+	for k, v := range attrib {
+		if strings.EqualFold(k, subDirField) {
+			subDir = v
+		}
+	}
+	return normalizeSubDir(subDir)
+}
+
+// normalizeSubDir trims leading and trailing slashes of sub directory, and
+// rejects sub directory which could escape from the container, e.g. "../a"
+func normalizeSubDir(subDir string) (string, error) {
+	subDir = strings.Trim(strings.TrimSpace(subDir), "/")
+	if subDir == "" {
+		return "", nil
+	}
+	if strings.Contains(subDir, separator) {
+		return "", fmt.Errorf("%s(%s) must not contain %q", subDirField, subDir, separator)
+	}
+	if path.Clean(subDir) != subDir || subDir == "." || subDir == ".." || strings.HasPrefix(subDir, "../") {
+		return "", fmt.Errorf("%s(%s) must be a relative path without \".\", \"..\" or empty elements", subDirField, subDir)
+	}
+	return subDir, nil
+}
+
 // A container name must be a valid DNS name, conforming to the following naming rules:
 //	1. Container names must start with a letter or number, and can contain only letters, numbers, and the dash (-) character.
 //	2. Every dash (-) character must be immediately preceded and followed by a letter or number; consecutive dashes are not permitted in container names.
@@ -264,7 +475,7 @@ func isSASToken(key string) bool {
 }
 
 // GetAuthEnv return <accountName, containerName, authEnv, error>
-func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attrib, secrets map[string]string) (string, string, []string, error) {
+func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attrib, secrets map[string]string) (accountName, containerName string, authEnv []string, err error) {
 	rgName, accountName, containerName, err := GetContainerInfo(volumeID)
 	if err != nil {
 		// ignore volumeID parsing error
@@ -281,7 +492,6 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 		keyVaultSecretName      string
 		keyVaultSecretVersion   string
 		azureStorageAuthType    string
-		authEnv                 []string
 		getAccountKeyFromSecret bool
 	)
 
@@ -324,7 +534,7 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 			authEnv = append(authEnv, "AZURE_STORAGE_AAD_ENDPOINT="+v)
 		}
 	}
-	klog.V(2).Infof("volumeID(%s) authEnv: %s", volumeID, authEnv)
+	klog.V(2).Infof("volumeID(%s) authEnv: %s", volumeID, util.RedactKeyValues(authEnv))
 
 	if protocol == nfs {
 		// nfs protocol does not need account key, return directly
@@ -340,10 +550,24 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 		rgName = d.cloud.ResourceGroup
 	}
 
+	credential := &auditRecord{
+		Operation:   auditOperationCredential,
+		VolumeID:    volumeID,
+		Source:      credentialSourceNone,
+		AuthType:    azureStorageAuthType,
+		auditObject: getAuditObject(attrib),
+	}
+	defer func() {
+		credential.AccountName = accountName
+		credential.ContainerName = containerName
+		d.auditor.recordResult(credential, err)
+	}()
+
 	// 1. If keyVaultURL is not nil, preferentially use the key stored in key vault.
 	// 2. Then if secrets map is not nil, use the key stored in the secrets map.
 	// 3. Finally if both keyVaultURL and secrets map are nil, get the key from Azure.
 	if keyVaultURL != "" {
+		credential.Source = credentialSourceKeyVault
 		key, err := d.getKeyVaultSecretContent(ctx, keyVaultURL, keyVaultSecretName, keyVaultSecretVersion)
 		if err != nil {
 			return accountName, containerName, authEnv, err
@@ -360,6 +584,9 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 			}
 			// if msi is specified, don't list account key using cluster identity
 			if secretName != "" && !strings.EqualFold(azureStorageAuthType, "msi") {
+				credential.Source = credentialSourceSecret
+				credential.SecretName = secretName
+				credential.SecretNamespace = secretNamespace
 				// read from k8s secret first
 				var name string
 				name, accountKey, err = d.GetStorageAccountFromSecret(secretName, secretNamespace)
@@ -369,6 +596,9 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 				if err != nil && !getAccountKeyFromSecret {
 					klog.V(2).Infof("get account(%s) key from secret(%s, %s) failed with error: %v, use cluster identity to get account key instead",
 						accountName, secretNamespace, secretName, err)
+					credential.Source = credentialSourceClusterIdentity
+					credential.SecretName = ""
+					credential.SecretNamespace = ""
 					accountKey, err = d.cloud.GetStorageAccesskey(ctx, accountName, rgName)
 					if err != nil {
 						return accountName, containerName, authEnv, fmt.Errorf("no key for storage account(%s) under resource group(%s), err %v", accountName, rgName, err)
@@ -376,6 +606,7 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 				}
 			}
 		} else {
+			credential.Source = credentialSourceRequestSecret
 			for k, v := range secrets {
 				switch strings.ToLower(k) {
 				case accountNameField:
@@ -386,11 +617,11 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 					accountKey = v
 				case defaultSecretAccountKey: // for compatibility with built-in blobfuse plugin
 					accountKey = v
-				case "azurestorageaccountsastoken":
+				case defaultSecretAccountSasToken:
 					accountSasToken = v
-				case "msisecret":
+				case defaultSecretMSISecret:
 					authEnv = append(authEnv, "MSI_SECRET="+v)
-				case "azurestoragespnclientsecret":
+				case defaultSecretSPNClientSecret:
 					authEnv = append(authEnv, "AZURE_STORAGE_SPN_CLIENT_SECRET="+v)
 				}
 			}
@@ -398,7 +629,7 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 	}
 
 	if containerName == "" {
-		err = fmt.Errorf("could not find containerName from attributes(%v) or volumeID(%v)", attrib, volumeID)
+		err = fmt.Errorf("could not find containerName from attributes(%v) or volumeID(%v)", util.RedactMap(attrib), volumeID)
 	}
 
 	if accountSasToken != "" {
@@ -477,7 +708,7 @@ func (d *Driver) GetStorageAccountAndContainer(ctx context.Context, volumeID str
 	}
 
 	if containerName == "" {
-		return "", "", "", "", fmt.Errorf("could not find containerName from attributes(%v) or volumeID(%v)", attrib, volumeID)
+		return "", "", "", "", fmt.Errorf("could not find containerName from attributes(%v) or volumeID(%v)", util.RedactMap(attrib), volumeID)
 	}
 
 	return accountName, accountKey, accountSasToken, containerName, nil
@@ -532,10 +763,10 @@ func getStorageAccount(secrets map[string]string) (string, string, error) {
 	}
 
 	if accountName == "" {
-		return accountName, accountKey, fmt.Errorf("could not find %s or %s field secrets(%v)", accountNameField, defaultSecretAccountName, secrets)
+		return accountName, accountKey, fmt.Errorf("could not find %s or %s field secrets(%v)", accountNameField, defaultSecretAccountName, util.RedactMap(secrets))
 	}
 	if accountKey == "" {
-		return accountName, accountKey, fmt.Errorf("could not find %s or %s field in secrets(%v)", accountKeyField, defaultSecretAccountKey, secrets)
+		return accountName, accountKey, fmt.Errorf("could not find %s or %s field in secrets(%v)", accountKeyField, defaultSecretAccountKey, util.RedactMap(secrets))
 	}
 
 	accountName = strings.TrimSpace(accountName)
@@ -549,7 +780,11 @@ func setAzureCredentials(kubeClient kubernetes.Interface, accountName, accountKe
 		return "", nil
 	}
 	if accountName == "" || accountKey == "" {
-		return "", fmt.Errorf("the account info is not enough, accountName(%v), accountKey(%v)", accountName, accountKey)
+		redactedKey := ""
+		if accountKey != "" {
+			redactedKey = util.RedactedValue
+		}
+		return "", fmt.Errorf("the account info is not enough, accountName(%v), accountKey(%v)", accountName, redactedKey)
 	}
 	secretName := fmt.Sprintf(secretNameTemplate, accountName)
 	secret := &v1.Secret{
@@ -574,17 +809,34 @@ func setAzureCredentials(kubeClient kubernetes.Interface, accountName, accountKe
 }
 
 // GetStorageAccesskey get Azure storage (account name, account key)
+// credential resolution is audited with pod and PVC carried by ctx
 func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.AccountOptions, secrets map[string]string, secretNamespace string) (string, string, error) {
+	credential := &auditRecord{
+		Operation:   auditOperationCredential,
+		AccountName: accountOptions.Name,
+		auditObject: getAuditObjectFromContext(ctx),
+	}
 	if len(secrets) > 0 {
-		return getStorageAccount(secrets)
+		credential.Source = credentialSourceRequestSecret
+		accountName, accountKey, err := getStorageAccount(secrets)
+		credential.AccountName = accountName
+		d.auditor.recordResult(credential, err)
+		return accountName, accountKey, err
 	}
 
 	// read from k8s secret first
+	credential.Source = credentialSourceSecret
+	credential.SecretName = accountOptions.Name
+	credential.SecretNamespace = secretNamespace
 	_, accountKey, err := d.GetStorageAccountFromSecret(accountOptions.Name, secretNamespace)
 	if err != nil {
 		klog.V(2).Infof("could not get account(%s) key from secret, error: %v, use cluster identity to get account key instead", accountOptions.Name, err)
+		credential.Source = credentialSourceClusterIdentity
+		credential.SecretName = ""
+		credential.SecretNamespace = ""
 		accountKey, err = d.cloud.GetStorageAccesskey(ctx, accountOptions.Name, accountOptions.ResourceGroup)
 	}
+	d.auditor.recordResult(credential, err)
 	return accountOptions.Name, accountKey, err
 }
 
@@ -603,12 +855,17 @@ func (d *Driver) GetStorageAccountFromSecret(secretName, secretNamespace string)
 	return string(secret.Data[defaultSecretAccountName][:]), string(secret.Data[defaultSecretAccountKey][:]), nil
 }
 
-// getSubnetResourceID get default subnet resource ID from cloud provider config
-func (d *Driver) getSubnetResourceID() string {
-	subsID := d.cloud.SubscriptionID
+// getNetworkSubscriptionID get subscription ID of virtual network from cloud provider config
+func (d *Driver) getNetworkSubscriptionID() string {
 	if len(d.cloud.NetworkResourceSubscriptionID) > 0 {
-		subsID = d.cloud.NetworkResourceSubscriptionID
+		return d.cloud.NetworkResourceSubscriptionID
 	}
+	return d.cloud.SubscriptionID
+}
+
+// getSubnetResourceID get default subnet resource ID from cloud provider config
+func (d *Driver) getSubnetResourceID() string {
+	subsID := d.getNetworkSubscriptionID()
 
 	rg := d.cloud.ResourceGroup
 	if len(d.cloud.VnetResourceGroup) > 0 {
@@ -618,12 +875,51 @@ func (d *Driver) getSubnetResourceID() string {
 	return fmt.Sprintf(subnetTemplate, subsID, rg, d.cloud.VnetName, d.cloud.SubnetName)
 }
 
+// parseSubnetResourceID parses subnet resource ID, e.g.
+// input: "/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+// output: subsID, rg, vnet, subnet
+func parseSubnetResourceID(id string) (string, string, string, string, error) {
+	segments := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if len(segments) != 10 || !strings.EqualFold(segments[0], "subscriptions") || !strings.EqualFold(segments[2], "resourceGroups") ||
+		!strings.EqualFold(segments[4], "providers") || !strings.EqualFold(segments[5], "Microsoft.Network") ||
+		!strings.EqualFold(segments[6], "virtualNetworks") || !strings.EqualFold(segments[8], "subnets") {
+		return "", "", "", "", fmt.Errorf("invalid subnet resource ID: %q, should be in format %q", id, subnetTemplate)
+	}
+	for _, segment := range []string{segments[1], segments[3], segments[7], segments[9]} {
+		if segment == "" {
+			return "", "", "", "", fmt.Errorf("invalid subnet resource ID: %q, should be in format %q", id, subnetTemplate)
+		}
+	}
+	return segments[1], segments[3], segments[7], segments[9], nil
+}
+
+// parseIPRules parses comma separated IPv4 addresses or CIDR ranges, e.g. "20.1.2.3,20.1.2.0/24"
+func parseIPRules(ipRules string) ([]string, error) {
+	rules := []string{}
+	for _, rule := range strings.Split(ipRules, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if ip, ipNet, err := net.ParseCIDR(rule); err == nil {
+			// See https://docs.microsoft.com/en-us/azure/storage/common/storage-network-security#grant-access-from-an-internet-ip-range
+			if ones, _ := ipNet.Mask.Size(); ip.To4() == nil || ones > 30 {
+				return nil, fmt.Errorf("invalid IP rule %q, only IPv4 CIDR range with prefix size up to /30 is supported", rule)
+			}
+		} else if ip := net.ParseIP(rule); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IP rule %q, should be an IPv4 address or CIDR range", rule)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
 // appendDefaultMountOptions return mount options combined with mountOptions and defaultMountOptions
 func appendDefaultMountOptions(mountOptions []string, tmpPath, containerName string) []string {
 	var defaultMountOptions = map[string]string{
 		"--pre-mount-validate": "true",
 		"--use-https":          "true",
-		"--tmp-path":           tmpPath,
+		tmpPathOption:          tmpPath,
 		"--container-name":     containerName,
 		// prevent billing charges on mounting
 		"--cancel-list-on-mount-seconds": "60",
@@ -654,3 +950,47 @@ func appendDefaultMountOptions(mountOptions []string, tmpPath, containerName str
 
 	return allMountOptions
 }
+
+// appendVolumeMountGroupOptions returns blobfuse args with fuse options which grant volumeMountGroup access to the mount,
+// fuse options already specified in args by user are not overridden
+func appendVolumeMountGroupOptions(args []string, volumeMountGroup string) []string {
+	if volumeMountGroup == "" {
+		return args
+	}
+	// stores the fuse options already included in args, e.g. "-o gid=1000,allow_other"
+	included := make(map[string]bool)
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] != "-o" {
+			continue
+		}
+		for _, option := range strings.Split(args[i+1], ",") {
+			included[strings.SplitN(option, "=", 2)[0]] = true
+		}
+	}
+
+	fuseOptions := []string{
+		"gid=" + volumeMountGroup,
+		// owner and group have full access, others have no access
+		"umask=0007",
+		// allow pod processes other than the mounting user(root) to access the mount
+		"allow_other",
+	}
+	allArgs := args
+	for _, option := range fuseOptions {
+		if !included[strings.SplitN(option, "=", 2)[0]] {
+			allArgs = append(allArgs, "-o", option)
+		}
+	}
+	return allArgs
+}
+
+// validateVolumeMountGroup checks whether volumeMountGroup is a valid numeric group id
+func validateVolumeMountGroup(volumeMountGroup string) error {
+	if volumeMountGroup == "" {
+		return nil
+	}
+	if gid, err := strconv.Atoi(volumeMountGroup); err != nil || gid < 0 {
+		return fmt.Errorf("invalid VolumeMountGroup(%s), should be a non-negative integer", volumeMountGroup)
+	}
+	return nil
+}
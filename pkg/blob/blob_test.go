@@ -17,8 +17,10 @@ limitations under the License.
 package blob
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -27,11 +29,15 @@ import (
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
 
 	"sigs.k8s.io/blob-csi-driver/pkg/util"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
@@ -226,6 +232,130 @@ func TestGetContainerInfo(t *testing.T) {
 	}
 }
 
+func TestGetSubDirFromVolumeID(t *testing.T) {
+	tests := []struct {
+		volumeID string
+		expected string
+	}{
+		{
+			volumeID: "rg#account#container#pvc-name#subdir=project/workload",
+			expected: "project/workload",
+		},
+		{
+			volumeID: "rg#account#container#pvc-name#subdir=project/workload#ondelete=archive",
+			expected: "project/workload",
+		},
+		{
+			// extra segments in volume handle of static PV are not taken as sub directory
+			volumeID: "rg#account#container#uuid#namespace",
+			expected: "",
+		},
+		{
+			volumeID: "rg#account#container#pvc-name",
+			expected: "",
+		},
+		{
+			volumeID: "rg#account#container",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		result := getSubDirFromVolumeID(test.volumeID)
+		if result != test.expected {
+			t.Errorf("input: %q, getSubDirFromVolumeID result: %q, expected: %q", test.volumeID, result, test.expected)
+		}
+	}
+}
+
+func TestGetOnDeleteFromVolumeID(t *testing.T) {
+	assert.Equal(t, onDeleteArchive, getOnDeleteFromVolumeID("rg#account#container#pvc-name#subdir=project/workload#ondelete=archive"))
+	assert.Equal(t, onDeleteDelete, getOnDeleteFromVolumeID("rg#account#container#pvc-name#subdir=project/workload"))
+	assert.Equal(t, onDeleteDelete, getOnDeleteFromVolumeID("rg#account#container#uuid#namespace#archive"))
+	assert.Equal(t, onDeleteDelete, getOnDeleteFromVolumeID("rg#account#container"))
+}
+
+func TestGetSubDir(t *testing.T) {
+	tests := []struct {
+		desc      string
+		volumeID  string
+		attrib    map[string]string
+		expected  string
+		expectErr bool
+	}{
+		{
+			desc:     "sub directory in volume id",
+			volumeID: "rg#account#container#pvc-name#subdir=project/workload",
+			expected: "project/workload",
+		},
+		{
+			desc:     "sub directory in volume attributes overrides volume id",
+			volumeID: "rg#account#container#pvc-name#subdir=project/workload",
+			attrib:   map[string]string{"subDir": "/project/other/"},
+			expected: "project/other",
+		},
+		{
+			desc:     "no sub directory",
+			volumeID: "rg#account#container",
+			attrib:   map[string]string{"protocol": "fuse"},
+			expected: "",
+		},
+		{
+			desc:      "sub directory escaping from container",
+			volumeID:  "rg#account#container",
+			attrib:    map[string]string{"subdir": "../other-container"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := getSubDir(test.volumeID, test.attrib)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestNormalizeSubDir(t *testing.T) {
+	tests := []struct {
+		subDir    string
+		expected  string
+		expectErr bool
+	}{
+		{subDir: "", expected: ""},
+		{subDir: "/", expected: ""},
+		{subDir: "project", expected: "project"},
+		{subDir: "/project/workload/", expected: "project/workload"},
+		{subDir: ".", expectErr: true},
+		{subDir: "..", expectErr: true},
+		{subDir: "../project", expectErr: true},
+		{subDir: "project/../..", expectErr: true},
+		{subDir: "project/./workload", expectErr: true},
+		{subDir: "project//workload", expectErr: true},
+		{subDir: "project#workload", expectErr: true},
+	}
+
+	for _, test := range tests {
+		result, err := normalizeSubDir(test.subDir)
+		if test.expectErr {
+			assert.Error(t, err, test.subDir)
+			continue
+		}
+		assert.NoError(t, err, test.subDir)
+		assert.Equal(t, test.expected, result, test.subDir)
+	}
+}
+
+func TestIsNFSProtocol(t *testing.T) {
+	assert.True(t, isNFSProtocol(map[string]string{"protocol": "nfs"}))
+	assert.True(t, isNFSProtocol(map[string]string{"Protocol": "NFS"}))
+	assert.False(t, isNFSProtocol(map[string]string{"protocol": "fuse"}))
+	assert.False(t, isNFSProtocol(nil))
+}
+
 func TestIsRetriableError(t *testing.T) {
 	tests := []struct {
 		desc         string
@@ -743,6 +873,12 @@ func TestSetAzureCredentials(t *testing.T) {
 			accountKey:  "",
 			expectedErr: fmt.Errorf("the account info is not enough, accountName(testName), accountKey()"),
 		},
+		{
+			desc:        "[failure] accountName is nil and accountKey is redacted",
+			kubeClient:  fakeClient,
+			accountKey:  "testKey",
+			expectedErr: fmt.Errorf("the account info is not enough, accountName(), accountKey(***redacted***)"),
+		},
 		{
 			desc:        "[success] kubeClient is nil",
 			kubeClient:  nil,
@@ -818,3 +954,142 @@ func TestAppendDefaultMountOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestAppendVolumeMountGroupOptions(t *testing.T) {
+	tests := []struct {
+		desc             string
+		args             []string
+		volumeMountGroup string
+		expected         []string
+	}{
+		{
+			desc:     "no volume mount group",
+			args:     []string{"-o", "attr_timeout=240"},
+			expected: []string{"-o", "attr_timeout=240"},
+		},
+		{
+			desc:             "volume mount group",
+			args:             []string{"-o", "attr_timeout=240"},
+			volumeMountGroup: "1000",
+			expected:         []string{"-o", "attr_timeout=240", "-o", "gid=1000", "-o", "umask=0007", "-o", "allow_other"},
+		},
+		{
+			desc:             "fuse options specified by user are not overridden",
+			args:             []string{"-o", "gid=2000,allow_other", "-o", "umask=0022"},
+			volumeMountGroup: "1000",
+			expected:         []string{"-o", "gid=2000,allow_other", "-o", "umask=0022"},
+		},
+	}
+
+	for _, test := range tests {
+		result := appendVolumeMountGroupOptions(test.args, test.volumeMountGroup)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestValidateVolumeMountGroup(t *testing.T) {
+	assert.NoError(t, validateVolumeMountGroup(""))
+	assert.NoError(t, validateVolumeMountGroup("1000"))
+	assert.Error(t, validateVolumeMountGroup("-1"))
+	assert.Error(t, validateVolumeMountGroup("admin"))
+}
+
+func TestParseSubnetResourceID(t *testing.T) {
+	subsID, rg, vnet, subnet, err := parseSubnetResourceID("/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"subsID", "rg", "vnet", "subnet"}, []string{subsID, rg, vnet, subnet})
+
+	for _, id := range []string{
+		"",
+		"subnet",
+		"/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+		"/subscriptions/subsID/resourceGroups/rg/providers/Microsoft.Storage/virtualNetworks/vnet/subnets/subnet",
+		"/subscriptions/subsID/resourceGroups//providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet",
+	} {
+		_, _, _, _, err := parseSubnetResourceID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestParseIPRules(t *testing.T) {
+	rules, err := parseIPRules("20.1.2.3, 20.1.2.0/24,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20.1.2.3", "20.1.2.0/24"}, rules)
+
+	for _, ipRules := range []string{"20.1.2", "20.1.2.3/32", "2001:db8::1", "2001:db8::/64"} {
+		_, err := parseIPRules(ipRules)
+		assert.Error(t, err, ipRules)
+	}
+}
+
+func TestSecretsNotLogged(t *testing.T) {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	assert.NoError(t, fs.Set("logtostderr", "false"))
+	assert.NoError(t, fs.Set("alsologtostderr", "false"))
+	assert.NoError(t, fs.Set("v", "10"))
+	buf := new(bytes.Buffer)
+	klog.SetOutput(buf)
+	defer func() {
+		klog.SetOutput(ioutil.Discard)
+		_ = fs.Set("logtostderr", "true")
+		_ = fs.Set("v", "0")
+	}()
+
+	secretValues := []string{"accountKeyValue", "sasTokenValue", "msiSecretValue", "spnSecretValue", "sigValue", "optionKeyValue"}
+	secrets := map[string]string{
+		defaultSecretAccountName:     "accountname",
+		defaultSecretAccountKey:      "accountKeyValue",
+		defaultSecretAccountSasToken: "?sv=2020-08-04&sig=sasTokenValue",
+		defaultSecretMSISecret:       "msiSecretValue",
+		defaultSecretSPNClientSecret: "spnSecretValue",
+	}
+	attrib := map[string]string{
+		containerNameField: "containername",
+		accountKeyField:    "accountKeyValue",
+		mountOptionsField:  "--account-key=optionKeyValue,--sas-token=sv=2020-08-04&sig=sigValue",
+		"sasurl":           "https://accountname.blob.core.windows.net/containername?sig=sigValue",
+	}
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.enableBlobMockMount = true
+	d.mounter = &mount.SafeFormatAndMount{
+		Interface: &fakeMounter{},
+		Exec:      &testingexec.FakeExec{ExactOrder: true},
+	}
+	defer os.RemoveAll(targetTest)
+	_, err := d.NodeStageVolume(context.TODO(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#accountname#containername",
+		StagingTargetPath: targetTest,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+		VolumeContext: attrib,
+		Secrets:       secrets,
+	})
+	assert.NoError(t, err)
+
+	// errors include volume context and secrets
+	_, _, _, _, err = d.GetStorageAccountAndContainer(context.TODO(), "invalid", map[string]string{accountKeyField: "accountKeyValue"}, secrets)
+	assert.Error(t, err)
+	klog.Error(err)
+	_, _, err = getStorageAccount(map[string]string{defaultSecretAccountKey: "accountKeyValue"})
+	assert.Error(t, err)
+	klog.Error(err)
+	_, err = setAzureCredentials(fake.NewSimpleClientset(), "", "accountKeyValue", "default")
+	assert.Error(t, err)
+	klog.Error(err)
+	_, err = d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{Name: "vol", Parameters: attrib, Secrets: secrets})
+	assert.Error(t, err)
+	klog.Error(err)
+
+	klog.Flush()
+	output := buf.String()
+	assert.Contains(t, output, "rg#accountname#containername")
+	assert.Contains(t, output, util.RedactedValue)
+	for _, value := range secretValues {
+		assert.NotContains(t, output, value)
+	}
+}
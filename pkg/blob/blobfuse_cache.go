@@ -0,0 +1,261 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultBlobfuseCacheRoot is the directory under which per-volume blobfuse cache directories are created
+	DefaultBlobfuseCacheRoot = "/mnt/blobfuse-cache"
+	// blobfuse tmp-path under per-volume cache directory
+	blobfuseCacheSubDir = "cache"
+	// file under per-volume cache directory which records the staging target path
+	blobfuseCacheTargetFile = "target"
+	// file under per-volume cache directory which records the blobfuse tmp-path on local disk
+	blobfuseCacheTmpPathFile = "tmppath"
+
+	// cache medium of blobfuse tmp-path
+	cacheMediumHostPath  = "hostpath"
+	cacheMediumTmpfs     = "tmpfs"
+	cacheMediumLocalDisk = "localdisk"
+	cacheMediumNone      = "none"
+)
+
+var supportedCacheMediumList = []string{cacheMediumHostPath, cacheMediumTmpfs, cacheMediumLocalDisk, cacheMediumNone}
+
+// blobfuseCacheOptions defines where and how much blobfuse caches files of a volume
+type blobfuseCacheOptions struct {
+	medium string
+	// sizeMB is the cache size limit, 0 means no limit
+	sizeMB int
+	// localPath is the local disk directory under which blobfuse tmp-path is created, only for localdisk medium
+	localPath string
+}
+
+// parseBlobfuseCacheOptions parses cache options from volume attributes or storage class parameters
+func parseBlobfuseCacheOptions(attrib map[string]string, defaultSizeMB int) (*blobfuseCacheOptions, error) {
+	cacheOptions := &blobfuseCacheOptions{
+		medium: cacheMediumHostPath,
+		sizeMB: defaultSizeMB,
+	}
+	for k, v := range attrib {
+		switch strings.ToLower(k) {
+		case cacheMediumField:
+			if v != "" {
+				cacheOptions.medium = strings.ToLower(v)
+			}
+		case cacheSizeMBField:
+			size, err := strconv.Atoi(v)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("invalid %s: %s, should be a non-negative integer", k, v)
+			}
+			cacheOptions.sizeMB = size
+		case cachePathField:
+			cacheOptions.localPath = v
+		}
+	}
+
+	switch cacheOptions.medium {
+	case cacheMediumHostPath, cacheMediumNone:
+	case cacheMediumTmpfs:
+		if cacheOptions.sizeMB == 0 {
+			return nil, fmt.Errorf("%s must be specified for %s cache medium", cacheSizeMBField, cacheMediumTmpfs)
+		}
+	case cacheMediumLocalDisk:
+		if !filepath.IsAbs(cacheOptions.localPath) {
+			return nil, fmt.Errorf("%s(%s) must be an absolute path for %s cache medium", cachePathField, cacheOptions.localPath, cacheMediumLocalDisk)
+		}
+	default:
+		return nil, fmt.Errorf("cache medium(%s) is not supported, supported cache medium list: %v", cacheOptions.medium, supportedCacheMediumList)
+	}
+	return cacheOptions, nil
+}
+
+// getBlobfuseCacheDir returns the per-volume cache directory, e.g. /mnt/blobfuse-cache/rg#account#container
+func (d *Driver) getBlobfuseCacheDir(volumeID string) string {
+	return filepath.Join(d.blobfuseCacheRoot, escapeVolumeID(volumeID))
+}
+
+// getBlobfuseTmpPath returns the blobfuse tmp-path of the volume
+func (d *Driver) getBlobfuseTmpPath(volumeID string, cacheOptions *blobfuseCacheOptions) string {
+	if cacheOptions != nil && cacheOptions.medium == cacheMediumLocalDisk {
+		return filepath.Join(cacheOptions.localPath, escapeVolumeID(volumeID))
+	}
+	return filepath.Join(d.getBlobfuseCacheDir(volumeID), blobfuseCacheSubDir)
+}
+
+// ensureBlobfuseCacheDir creates the per-volume cache directory and blobfuse tmp-path on the cache medium,
+// staging target path is recorded to garbage collect cache directories of volumes that are no longer staged
+func (d *Driver) ensureBlobfuseCacheDir(volumeID, targetPath string, cacheOptions *blobfuseCacheOptions) error {
+	cacheDir := d.getBlobfuseCacheDir(volumeID)
+	tmpPath := d.getBlobfuseTmpPath(volumeID, cacheOptions)
+	if err := os.MkdirAll(cacheDir, 0750); err != nil {
+		return fmt.Errorf("failed to create cache directory(%s): %v", cacheDir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cacheDir, blobfuseCacheTargetFile), []byte(targetPath), 0600); err != nil {
+		return fmt.Errorf("failed to write staging target path into cache directory(%s): %v", cacheDir, err)
+	}
+	if cacheOptions.medium == cacheMediumLocalDisk {
+		if err := ioutil.WriteFile(filepath.Join(cacheDir, blobfuseCacheTmpPathFile), []byte(tmpPath), 0600); err != nil {
+			return fmt.Errorf("failed to write tmp path into cache directory(%s): %v", cacheDir, err)
+		}
+	}
+	if err := os.MkdirAll(tmpPath, 0750); err != nil {
+		return fmt.Errorf("failed to create tmp path(%s): %v", tmpPath, err)
+	}
+
+	if cacheOptions.medium == cacheMediumTmpfs {
+		notMnt, err := d.mounter.IsLikelyNotMountPoint(tmpPath)
+		if err != nil {
+			return fmt.Errorf("failed to check mount point(%s): %v", tmpPath, err)
+		}
+		if notMnt {
+			mountOptions := []string{fmt.Sprintf("size=%dm", cacheOptions.sizeMB), "mode=0700"}
+			klog.V(2).Infof("mounting tmpfs on %s with mountOptions: %v", tmpPath, mountOptions)
+			if err := d.mounter.Mount("tmpfs", tmpPath, "tmpfs", mountOptions); err != nil {
+				return fmt.Errorf("failed to mount tmpfs on %s: %v", tmpPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeBlobfuseCacheDir removes the per-volume cache directory, it's a no-op if the directory does not exist
+func (d *Driver) removeBlobfuseCacheDir(volumeID string) error {
+	return d.removeCacheDir(d.getBlobfuseCacheDir(volumeID))
+}
+
+// removeCacheDir unmounts the cache medium and removes the cache directory together with tmp-path on local disk
+func (d *Driver) removeCacheDir(cacheDir string) error {
+	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	tmpPath := filepath.Join(cacheDir, blobfuseCacheSubDir)
+	localTmpPath := ""
+	if content, err := ioutil.ReadFile(filepath.Join(cacheDir, blobfuseCacheTmpPathFile)); err == nil {
+		localTmpPath = strings.TrimSpace(string(content))
+	}
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(tmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check mount point(%s): %v", tmpPath, err)
+	}
+	if err == nil && !notMnt {
+		klog.V(2).Infof("unmounting cache medium on %s", tmpPath)
+		if err := d.mounter.Unmount(tmpPath); err != nil {
+			return fmt.Errorf("failed to unmount cache medium on %s: %v", tmpPath, err)
+		}
+	}
+
+	if localTmpPath != "" {
+		if err := os.RemoveAll(localTmpPath); err != nil {
+			return fmt.Errorf("failed to remove tmp path(%s): %v", localTmpPath, err)
+		}
+	}
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return fmt.Errorf("failed to remove cache directory(%s): %v", cacheDir, err)
+	}
+	klog.V(4).Infof("cache directory(%s) is removed", cacheDir)
+	return nil
+}
+
+// cleanupBlobfuseCacheDirs removes cache directories whose staging target path is no longer mounted,
+// it's called on node server startup to clean up cache directories leaked by previous driver instance
+func (d *Driver) cleanupBlobfuseCacheDirs() {
+	entries, err := ioutil.ReadDir(d.blobfuseCacheRoot)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("failed to read blobfuse cache root(%s): %v", d.blobfuseCacheRoot, err)
+		}
+		return
+	}
+
+	// blobfuse mount points on the host are reported by blobfuse proxy
+	proxyMounts := map[string]bool{}
+	if d.enableBlobfuseProxy {
+		mounts, err := d.listBlobfuseMountsWithProxy()
+		if err != nil {
+			klog.Warningf("failed to list blobfuse mounts with proxy: %v", err)
+		}
+		for _, m := range mounts {
+			proxyMounts[filepath.Clean(m.GetTargetPath())] = true
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		cacheDir := filepath.Join(d.blobfuseCacheRoot, entry.Name())
+		if d.isBlobfuseCacheDirInUse(cacheDir, proxyMounts) {
+			klog.V(4).Infof("cache directory(%s) is still in use", cacheDir)
+			continue
+		}
+		klog.V(2).Infof("removing stale cache directory(%s)", cacheDir)
+		if err := d.removeCacheDir(cacheDir); err != nil {
+			klog.Warningf("failed to remove stale cache directory(%s): %v", cacheDir, err)
+		}
+	}
+}
+
+// isBlobfuseCacheDirInUse checks whether the staging target path recorded in cache directory is still mounted,
+// target paths in proxyMounts are regarded as mounted
+func (d *Driver) isBlobfuseCacheDirInUse(cacheDir string, proxyMounts map[string]bool) bool {
+	content, err := ioutil.ReadFile(filepath.Join(cacheDir, blobfuseCacheTargetFile))
+	if err != nil {
+		// cache directory without staging target path is leaked on a failed NodeStageVolume
+		return false
+	}
+	targetPath := strings.TrimSpace(string(content))
+	if targetPath == "" {
+		return false
+	}
+	if proxyMounts[filepath.Clean(targetPath)] {
+		return true
+	}
+
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false
+		}
+		// keep cache directory of a corrupted mount, it would be cleaned up in NodeUnstageVolume
+		return true
+	}
+	return !notMnt
+}
+
+// escapeVolumeID converts volume ID into a valid directory name, volume ID which would be escaped to "", "." or ".."
+// is hashed, otherwise per-volume directory would resolve to its parent directory and be removed with the parent
+func escapeVolumeID(volumeID string) string {
+	name := strings.Replace(volumeID, "/", "-", -1)
+	if name == "" || name == "." || name == ".." {
+		return fmt.Sprintf("volume-%x", sha256.Sum256([]byte(volumeID)))
+	}
+	return name
+}
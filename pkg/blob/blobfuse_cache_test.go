@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mount "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestGetBlobfuseCacheDir(t *testing.T) {
+	d := NewFakeDriver()
+	d.blobfuseCacheRoot = "/mnt/blobfuse-cache"
+
+	assert.Equal(t, "/mnt/blobfuse-cache/rg#account#container", d.getBlobfuseCacheDir("rg#account#container"))
+	assert.Equal(t, "/mnt/blobfuse-cache/rg#account#a-b", d.getBlobfuseCacheDir("rg#account#a/b"))
+	assert.Equal(t, "/mnt/blobfuse-cache/rg#account#container/cache", d.getBlobfuseTmpPath("rg#account#container", &blobfuseCacheOptions{medium: cacheMediumHostPath}))
+	assert.Equal(t, "/mnt/nvme/rg#account#container", d.getBlobfuseTmpPath("rg#account#container", &blobfuseCacheOptions{medium: cacheMediumLocalDisk, localPath: "/mnt/nvme"}))
+
+	// volume ID which would resolve to cache root or its parent directory is hashed
+	for _, volumeID := range []string{".", "..", ""} {
+		cacheDir := d.getBlobfuseCacheDir(volumeID)
+		assert.Equal(t, "/mnt/blobfuse-cache", filepath.Dir(cacheDir), volumeID)
+		assert.True(t, strings.HasPrefix(filepath.Base(cacheDir), "volume-"), volumeID)
+		tmpPath := d.getBlobfuseTmpPath(volumeID, &blobfuseCacheOptions{medium: cacheMediumLocalDisk, localPath: "/mnt/nvme"})
+		assert.Equal(t, "/mnt/nvme", filepath.Dir(tmpPath), volumeID)
+	}
+	assert.NotEqual(t, d.getBlobfuseCacheDir("."), d.getBlobfuseCacheDir(".."))
+}
+
+func TestParseBlobfuseCacheOptions(t *testing.T) {
+	tests := []struct {
+		desc            string
+		attrib          map[string]string
+		defaultSizeMB   int
+		expectedOptions *blobfuseCacheOptions
+		expectErr       bool
+	}{
+		{
+			desc:            "default cache options",
+			attrib:          map[string]string{},
+			defaultSizeMB:   100,
+			expectedOptions: &blobfuseCacheOptions{medium: cacheMediumHostPath, sizeMB: 100},
+		},
+		{
+			desc:            "tmpfs cache medium",
+			attrib:          map[string]string{"cacheMedium": "TMPFS", "cacheSizeMB": "1024"},
+			expectedOptions: &blobfuseCacheOptions{medium: cacheMediumTmpfs, sizeMB: 1024},
+		},
+		{
+			desc:      "tmpfs cache medium without size limit",
+			attrib:    map[string]string{"cacheMedium": "tmpfs"},
+			expectErr: true,
+		},
+		{
+			desc:            "localdisk cache medium",
+			attrib:          map[string]string{"cacheMedium": "localDisk", "cachePath": "/mnt/nvme"},
+			expectedOptions: &blobfuseCacheOptions{medium: cacheMediumLocalDisk, localPath: "/mnt/nvme"},
+		},
+		{
+			desc:      "localdisk cache medium with relative path",
+			attrib:    map[string]string{"cacheMedium": "localDisk", "cachePath": "nvme"},
+			expectErr: true,
+		},
+		{
+			desc:            "none cache medium",
+			attrib:          map[string]string{"cacheMedium": "none"},
+			expectedOptions: &blobfuseCacheOptions{medium: cacheMediumNone},
+		},
+		{
+			desc:      "invalid cache size",
+			attrib:    map[string]string{"cacheSizeMB": "-1"},
+			expectErr: true,
+		},
+		{
+			desc:      "unsupported cache medium",
+			attrib:    map[string]string{"cacheMedium": "ramdisk"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseBlobfuseCacheOptions(test.attrib, test.defaultSizeMB)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedOptions, result, test.desc)
+	}
+}
+
+func TestEnsureAndRemoveBlobfuseCacheDir(t *testing.T) {
+	cacheRoot, err := ioutil.TempDir("", "blobfuse-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cacheRoot)
+
+	localDisk, err := ioutil.TempDir("", "local-disk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(localDisk)
+
+	d := NewFakeDriver()
+	d.blobfuseCacheRoot = cacheRoot
+	d.mounter = &mount.SafeFormatAndMount{
+		Interface: &fakeMounter{},
+		Exec:      &testingexec.FakeExec{ExactOrder: true},
+	}
+	volumeID := "rg#account#container"
+	cacheOptions := &blobfuseCacheOptions{medium: cacheMediumTmpfs, sizeMB: 100}
+
+	assert.NoError(t, d.ensureBlobfuseCacheDir(volumeID, "/staging/target", cacheOptions))
+	// ensureBlobfuseCacheDir should be idempotent
+	assert.NoError(t, d.ensureBlobfuseCacheDir(volumeID, "/staging/target", cacheOptions))
+	_, err = os.Stat(d.getBlobfuseTmpPath(volumeID, cacheOptions))
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(d.getBlobfuseCacheDir(volumeID), blobfuseCacheTargetFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "/staging/target", string(content))
+
+	assert.NoError(t, d.removeBlobfuseCacheDir(volumeID))
+	_, err = os.Stat(d.getBlobfuseCacheDir(volumeID))
+	assert.True(t, os.IsNotExist(err))
+	// removeBlobfuseCacheDir should be idempotent
+	assert.NoError(t, d.removeBlobfuseCacheDir(volumeID))
+
+	// tmp path on local disk should be removed together with cache directory
+	cacheOptions = &blobfuseCacheOptions{medium: cacheMediumLocalDisk, localPath: localDisk}
+	assert.NoError(t, d.ensureBlobfuseCacheDir(volumeID, "/staging/target", cacheOptions))
+	localTmpPath := d.getBlobfuseTmpPath(volumeID, cacheOptions)
+	_, err = os.Stat(localTmpPath)
+	assert.NoError(t, err)
+	assert.NoError(t, d.removeBlobfuseCacheDir(volumeID))
+	_, err = os.Stat(localTmpPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCleanupBlobfuseCacheDirs(t *testing.T) {
+	cacheRoot, err := ioutil.TempDir("", "blobfuse-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cacheRoot)
+
+	d := NewFakeDriver()
+	d.blobfuseCacheRoot = cacheRoot
+	d.mounter = &mount.SafeFormatAndMount{
+		Interface: &fakeMounter{},
+		Exec:      &testingexec.FakeExec{ExactOrder: true},
+	}
+
+	// fakeMounter regards "false_is_likely" target as a mount point
+	cacheOptions := &blobfuseCacheOptions{medium: cacheMediumHostPath}
+	assert.NoError(t, d.ensureBlobfuseCacheDir("staged", "/false_is_likely_target", cacheOptions))
+	assert.NoError(t, d.ensureBlobfuseCacheDir("unstaged", "/not/mounted/target", cacheOptions))
+	assert.NoError(t, os.MkdirAll(filepath.Join(cacheRoot, "no-target-file"), 0750))
+
+	d.cleanupBlobfuseCacheDirs()
+
+	_, err = os.Stat(d.getBlobfuseCacheDir("staged"))
+	assert.NoError(t, err)
+	_, err = os.Stat(d.getBlobfuseCacheDir("unstaged"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(cacheRoot, "no-target-file"))
+	assert.True(t, os.IsNotExist(err))
+
+	// target path mounted on the host is reported by blobfuse proxy
+	assert.NoError(t, d.ensureBlobfuseCacheDir("proxy", "/host/mounted/target", cacheOptions))
+	assert.True(t, d.isBlobfuseCacheDirInUse(d.getBlobfuseCacheDir("proxy"), map[string]bool{"/host/mounted/target": true}))
+	assert.False(t, d.isBlobfuseCacheDirInUse(d.getBlobfuseCacheDir("proxy"), nil))
+
+	// cache root does not exist
+	d.blobfuseCacheRoot = filepath.Join(cacheRoot, "non-existing")
+	d.cleanupBlobfuseCacheDirs()
+}
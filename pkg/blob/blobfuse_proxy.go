@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/v2"
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	volumehelper "sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+const (
+	unknownBlobfuseProxyVersion = "unknown"
+	// maxBlobfuseOutputLines is the number of last blobfuse output lines kept from mount stream
+	maxBlobfuseOutputLines = 50
+)
+
+// blobfuseProxyInfo is the version and capabilities of blobfuse proxy negotiated by driver
+type blobfuseProxyInfo struct {
+	version      string
+	apiVersions  map[string]bool
+	capabilities map[string]bool
+}
+
+// getMissingCapabilities returns the required capabilities which are not supported by blobfuse proxy
+func (info *blobfuseProxyInfo) getMissingCapabilities(required []string) []string {
+	missing := []string{}
+	for _, c := range required {
+		if !info.capabilities[c] {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// negotiateBlobfuseProxy gets version and capabilities of blobfuse proxy,
+// proxy which does not serve GetVersion only supports v1 MountAzureBlob with space-joined arguments
+func (d *Driver) negotiateBlobfuseProxy() (*blobfuseProxyInfo, error) {
+	conn, err := d.connectBlobfuseProxy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to blobfuse proxy(%s): %v", d.blobfuseProxyEndpoint, err)
+	}
+	defer conn.Close()
+	client := NewMountClient(conn)
+
+	info := &blobfuseProxyInfo{
+		version:      unknownBlobfuseProxyVersion,
+		apiVersions:  map[string]bool{volumehelper.BlobfuseProxyAPIVersionV1: true},
+		capabilities: map[string]bool{},
+	}
+	version, err := client.service.GetVersion(context.TODO(), &mount_azure_blob.GetVersionRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		klog.Warningf("blobfuse proxy(%s) does not support version negotiation, it's too old and should be upgraded", d.blobfuseProxyEndpoint)
+		return info, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version of blobfuse proxy(%s): %v", d.blobfuseProxyEndpoint, err)
+	}
+	info.version = version.GetProxyVersion()
+	for _, v := range version.GetApiVersions() {
+		info.apiVersions[v] = true
+	}
+	capabilities, err := client.service.GetCapabilities(context.TODO(), &mount_azure_blob.GetCapabilitiesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get capabilities of blobfuse proxy(%s): %v", d.blobfuseProxyEndpoint, err)
+	}
+	for _, c := range capabilities.GetCapabilities() {
+		info.capabilities[c] = true
+	}
+	klog.V(2).Infof("blobfuse proxy(%s) version: %s, api versions: %v, capabilities: %v", d.blobfuseProxyEndpoint, info.version, version.GetApiVersions(), capabilities.GetCapabilities())
+	return info, nil
+}
+
+// getBlobfuseProxyInfo returns negotiated blobfuse proxy info, negotiation is done again after resetBlobfuseProxyInfo
+func (d *Driver) getBlobfuseProxyInfo() (*blobfuseProxyInfo, error) {
+	d.blobfuseProxyInfoLock.Lock()
+	defer d.blobfuseProxyInfoLock.Unlock()
+	if d.blobfuseProxyInfo != nil {
+		return d.blobfuseProxyInfo, nil
+	}
+	info, err := d.negotiateBlobfuseProxy()
+	if err != nil {
+		return nil, err
+	}
+	d.blobfuseProxyInfo = info
+	return info, nil
+}
+
+// resetBlobfuseProxyInfo clears negotiated blobfuse proxy info, e.g. when proxy is downgraded
+func (d *Driver) resetBlobfuseProxyInfo() {
+	d.blobfuseProxyInfoLock.Lock()
+	defer d.blobfuseProxyInfoLock.Unlock()
+	d.blobfuseProxyInfo = nil
+}
+
+// getRequiredBlobfuseProxyCapabilities returns capabilities which blobfuse proxy must support to mount with the arguments
+func getRequiredBlobfuseProxyCapabilities(targetPath string, args []string, options map[string]string) []string {
+	// space-joined arguments are split by space, arguments with space could only be passed in structured arguments
+	for _, arg := range volumehelper.BuildBlobfuseArgs(targetPath, args, options) {
+		if strings.Contains(arg, " ") {
+			return []string{volumehelper.BlobfuseProxyCapabilityStructuredArgs}
+		}
+	}
+	return []string{}
+}
+
+// streamMountBlobfuse mounts blobfuse by MountStream of blobfuse proxy, progress and blobfuse output are logged as they arrive,
+// the last blobfuse output lines are returned so that they are reported in mount error
+func streamMountBlobfuse(ctx context.Context, client mount_azure_blob.MountServiceV2Client, req *mount_azure_blob.MountRequest) (string, error) {
+	stream, err := client.MountStream(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return strings.Join(lines, "\n"), nil
+		}
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+		switch {
+		case event.GetPhase() != "" && event.GetMessage() != "":
+			klog.V(2).Infof("[%s] blobfuse mount %s: %s", req.GetTargetPath(), event.GetPhase(), volumehelper.RedactString(event.GetMessage()))
+		case event.GetPhase() != "":
+			klog.V(2).Infof("[%s] blobfuse mount %s", req.GetTargetPath(), event.GetPhase())
+		default:
+			klog.V(2).Infof("[%s] blobfuse: %s", req.GetTargetPath(), volumehelper.RedactString(event.GetLogLine()))
+			lines = append(lines, event.GetLogLine())
+			if len(lines) > maxBlobfuseOutputLines {
+				lines = lines[1:]
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	volumehelper "sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+// fakeMountServiceV2Server is a blobfuse proxy which serves version negotiation and v2 mount service
+type fakeMountServiceV2Server struct {
+	fakeMountServiceServer
+	mount_azure_blob.UnimplementedMountServiceV2Server
+	mountRequests []*mount_azure_blob.MountRequest
+}
+
+func (s *fakeMountServiceV2Server) GetVersion(ctx context.Context, req *mount_azure_blob.GetVersionRequest) (*mount_azure_blob.GetVersionResponse, error) {
+	return &mount_azure_blob.GetVersionResponse{
+		ProxyVersion: "v1.7.0",
+		ApiVersions:  []string{volumehelper.BlobfuseProxyAPIVersionV1, volumehelper.BlobfuseProxyAPIVersionV2},
+	}, nil
+}
+
+func (s *fakeMountServiceV2Server) GetCapabilities(ctx context.Context, req *mount_azure_blob.GetCapabilitiesRequest) (*mount_azure_blob.GetCapabilitiesResponse, error) {
+	return &mount_azure_blob.GetCapabilitiesResponse{
+		Capabilities: []string{volumehelper.BlobfuseProxyCapabilityStructuredArgs, "capability-unknown-to-driver"},
+	}, nil
+}
+
+func (s *fakeMountServiceV2Server) Mount(ctx context.Context, req *mount_azure_blob.MountRequest) (*mount_azure_blob.MountResponse, error) {
+	s.mountRequests = append(s.mountRequests, req)
+	return &mount_azure_blob.MountResponse{Output: "mounted by v2"}, nil
+}
+
+// fakeMountStreamServer is a blobfuse proxy which streams mount progress and blobfuse output
+type fakeMountStreamServer struct {
+	fakeMountServiceV2Server
+	outputLines int
+}
+
+func (s *fakeMountStreamServer) GetCapabilities(ctx context.Context, req *mount_azure_blob.GetCapabilitiesRequest) (*mount_azure_blob.GetCapabilitiesResponse, error) {
+	return &mount_azure_blob.GetCapabilitiesResponse{
+		Capabilities: []string{volumehelper.BlobfuseProxyCapabilityStructuredArgs, volumehelper.BlobfuseProxyCapabilityMountStream},
+	}, nil
+}
+
+func (s *fakeMountStreamServer) MountStream(req *mount_azure_blob.MountRequest, stream mount_azure_blob.MountServiceV2_MountStreamServer) error {
+	s.mountRequests = append(s.mountRequests, req)
+	events := []*mount_azure_blob.MountEvent{{Phase: "Running"}}
+	for i := 0; i < s.outputLines; i++ {
+		events = append(events, &mount_azure_blob.MountEvent{LogLine: fmt.Sprintf("blobfuse output %d", i)})
+	}
+	if strings.HasSuffix(req.TargetPath, "failed") {
+		events = append(events, &mount_azure_blob.MountEvent{Phase: "Failed", Message: "exit status 1"})
+	} else {
+		events = append(events, &mount_azure_blob.MountEvent{Phase: "Mounted", Pid: 100})
+	}
+	for _, event := range events {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+	if strings.HasSuffix(req.TargetPath, "failed") {
+		return status.Error(codes.Internal, "blobfuse mount failed: exit status 1")
+	}
+	return nil
+}
+
+func newBlobfuseProxyDriver(t *testing.T, server mount_azure_blob.MountServiceServer) *Driver {
+	d := NewFakeDriver()
+	d.enableBlobfuseProxy = true
+	d.blobfuseProxyConnTimout = 5
+	d.blobfuseProxyEndpoint = startFakeBlobfuseProxy(t, server)
+	return d
+}
+
+func TestGetRequiredBlobfuseProxyCapabilities(t *testing.T) {
+	assert.Empty(t, getRequiredBlobfuseProxyCapabilities("/mnt/target", []string{"-o", "allow_other"}, map[string]string{"--tmp-path": "/mnt/cache"}))
+	assert.Equal(t, []string{volumehelper.BlobfuseProxyCapabilityStructuredArgs},
+		getRequiredBlobfuseProxyCapabilities("/mnt/target", nil, map[string]string{"--tmp-path": "/mnt/cache dir"}))
+}
+
+func TestMountBlobfuseWithLegacyProxy(t *testing.T) {
+	proxy := &fakeMountServiceServer{}
+	d := newBlobfuseProxyDriver(t, proxy)
+
+	info, err := d.getBlobfuseProxyInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, unknownBlobfuseProxyVersion, info.version)
+	assert.False(t, info.apiVersions[volumehelper.BlobfuseProxyAPIVersionV2])
+	assert.Empty(t, info.capabilities)
+
+	output, err := d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target", []string{"-o", "allow_other"}, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "mounted by v1", output)
+	assert.Equal(t, 1, len(proxy.mounts))
+
+	// target path with space could not be passed to legacy proxy
+	_, err = d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target dir", nil, nil, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "please upgrade blobfuse-proxy")
+	assert.Equal(t, 1, len(proxy.mounts))
+
+	// blobfuse is mounted inside driver with fallback
+	d.blobfuseProxyFallback = true
+	_, _ = d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target dir", nil, nil, nil)
+	assert.Equal(t, 1, len(proxy.mounts))
+}
+
+func TestMountBlobfuseWithProxyV2(t *testing.T) {
+	proxy := &fakeMountServiceV2Server{}
+	d := newBlobfuseProxyDriver(t, proxy)
+
+	info, err := d.getBlobfuseProxyInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.7.0", info.version)
+	assert.True(t, info.apiVersions[volumehelper.BlobfuseProxyAPIVersionV2])
+
+	output, err := d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target dir", nil, map[string]string{"--tmp-path": "/mnt/cache"}, []string{"AZURE_STORAGE_ACCESS_KEY=key"})
+	assert.NoError(t, err)
+	assert.Equal(t, "mounted by v2", output)
+	assert.Equal(t, 1, len(proxy.mountRequests))
+	req := proxy.mountRequests[0]
+	assert.Equal(t, "/mnt/target dir", req.TargetPath)
+	assert.Equal(t, []string{volumehelper.BlobfuseProxyCapabilityStructuredArgs}, req.RequiredCapabilities)
+	assert.Equal(t, []string{"AZURE_STORAGE_ACCESS_KEY=key"}, req.AuthEnv)
+	assert.Empty(t, proxy.mounts)
+}
+
+func TestMountBlobfuseWithDowngradedProxy(t *testing.T) {
+	proxy := &fakeMountServiceServer{}
+	d := newBlobfuseProxyDriver(t, proxy)
+	// negotiated before proxy is downgraded
+	d.blobfuseProxyInfo = &blobfuseProxyInfo{
+		version:      "v1.7.0",
+		apiVersions:  map[string]bool{volumehelper.BlobfuseProxyAPIVersionV1: true, volumehelper.BlobfuseProxyAPIVersionV2: true},
+		capabilities: map[string]bool{volumehelper.BlobfuseProxyCapabilityStructuredArgs: true},
+	}
+
+	output, err := d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target", nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "mounted by v1", output)
+	// negotiated again on next mount
+	assert.Nil(t, d.blobfuseProxyInfo)
+	_, err = d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target dir", nil, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, unknownBlobfuseProxyVersion, d.blobfuseProxyInfo.version)
+}
+
+func TestMountBlobfuseWithProxyStream(t *testing.T) {
+	proxy := &fakeMountStreamServer{outputLines: 2}
+	d := newBlobfuseProxyDriver(t, proxy)
+
+	output, err := d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target", nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "blobfuse output 0\nblobfuse output 1", output)
+	assert.Equal(t, 1, len(proxy.mountRequests))
+
+	// only the last output lines are kept
+	proxy.outputLines = maxBlobfuseOutputLines + 10
+	output, err = d.mountBlobfuseWithProxy(context.TODO(), "/mnt/failed", nil, nil, nil)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	lines := strings.Split(output, "\n")
+	assert.Equal(t, maxBlobfuseOutputLines, len(lines))
+	assert.Equal(t, "blobfuse output 10", lines[0])
+	assert.Equal(t, fmt.Sprintf("blobfuse output %d", maxBlobfuseOutputLines+9), lines[len(lines)-1])
+}
@@ -18,7 +18,11 @@ package blob
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path"
+	"sort"
 	"strings"
 
 	"google.golang.org/grpc/codes"
@@ -40,7 +44,7 @@ import (
 // CreateVolume provisions a volume
 func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		klog.Errorf("invalid create volume req: %v", req)
+		klog.Errorf("invalid create volume req: %v", util.RedactRequest(req))
 		return nil, err
 	}
 
@@ -65,7 +69,12 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if parameters == nil {
 		parameters = make(map[string]string)
 	}
-	var storageAccountType, resourceGroup, location, account, containerName, protocol, customTags, secretNamespace string
+	// PVC set by external-provisioner with --extra-create-metadata is recorded in audit of credential resolution
+	ctx = withAuditObject(ctx, getAuditObject(parameters))
+	var storageAccountType, resourceGroup, location, account, containerName, subDir, protocol, customTags, secretNamespace string
+	provisioningMode, onDelete := provisioningModeContainer, onDeleteDelete
+	var subnetIDs, ipRules []string
+	var networkEndpointType, privateEndpointSubnetID, serverName, storageEndpointSuffix string
 	var isHnsEnabled *bool
 	// set allowBlobPublicAccess as false by default
 	allowBlobPublicAccess := to.BoolPtr(false)
@@ -117,9 +126,51 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		case pvNameKey:
 			// no op
 		case serverNameField:
-			// no op, only used in NodeStageVolume
+			serverName = v
 		case storageEndpointSuffixField:
+			storageEndpointSuffix = v
+		case cacheMediumField, cacheSizeMBField, cachePathField:
+			// no op, only used in NodeStageVolume
+		case rootModeField, rootUIDField, rootGIDField, skipRootPermissionField, skipNFSServerCheckField:
+			// no op, only used in NodeStageVolume
+		case "azurestorageauthtype", "azurestorageidentityclientid", "azurestorageidentityobjectid", "azurestorageidentityresourceid",
+			"msiendpoint", "azurestoragespnclientid", "azurestoragespntenantid", "azurestorageaadendpoint":
 			// no op, only used in NodeStageVolume
+		case minTLSVersionField, allowSharedKeyAccessField, encryptionKeyVaultURIField, encryptionKeyNameField, encryptionKeyVersionField,
+			encryptionUserAssignedIdentityField, requireInfrastructureEncryptionField, encryptionScopeField:
+			// no op, parsed by parseStorageSecurityOptions
+		case immutabilityPeriodDaysField, lockImmutabilityPolicyField, allowProtectedAppendWritesField, legalHoldTagsField:
+			// no op, parsed by parseContainerImmutabilityOptions
+		case accessTierField:
+			// no op, parsed by parseAccessTier
+		case tierToCoolAfterDaysField, tierToArchiveAfterDaysField, deleteAfterDaysField:
+			// no op, parsed by parseLifecycleOptions
+		case enableBlobVersioningField, blobSoftDeleteDaysField, containerSoftDeleteDaysField, enableChangeFeedField, pointInTimeRestoreDaysField:
+			// no op, parsed by parseDataProtectionOptions
+		case replicationLocationField, replicationResourceGroupField, replicationStorageAccountField:
+			// no op, parsed by parseObjectReplicationOptions
+		case subDirField:
+			subDir = v
+		case provisioningModeField:
+			provisioningMode = strings.ToLower(v)
+		case onDeleteField:
+			onDelete = strings.ToLower(v)
+		case subnetIDsField:
+			for _, subnetID := range strings.Split(v, ",") {
+				if subnetID = strings.TrimSpace(subnetID); subnetID != "" {
+					subnetIDs = append(subnetIDs, subnetID)
+				}
+			}
+		case ipRulesField:
+			rules, err := parseIPRules(v)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			ipRules = rules
+		case networkEndpointTypeField:
+			networkEndpointType = strings.ToLower(v)
+		case privateEndpointSubnetIDField:
+			privateEndpointSubnetID = strings.TrimSpace(v)
 		default:
 			return nil, fmt.Errorf("invalid parameter %s in storage class", k)
 		}
@@ -129,6 +180,35 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		resourceGroup = d.cloud.ResourceGroup
 	}
 
+	switch provisioningMode {
+	case provisioningModeContainer:
+	case provisioningModeDirectory:
+		if containerName == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be specified in %s provisioning mode", containerNameField, provisioningModeDirectory)
+		}
+		// allocate a unique directory per volume under subDir in the shared container
+		subDir = path.Join(subDir, name)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported, supported value list: %v", provisioningModeField, provisioningMode, []string{provisioningModeContainer, provisioningModeDirectory})
+	}
+	subDir, err := normalizeSubDir(subDir)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	switch onDelete {
+	case onDeleteDelete:
+	case onDeleteArchive:
+		if subDir == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is only supported with %s or %s provisioning mode", onDeleteField, onDeleteArchive, subDirField, provisioningModeDirectory)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported, supported value list: %v", onDeleteField, onDelete, []string{onDeleteDelete, onDeleteArchive})
+	}
+	if subDir != "" && containerName == "" {
+		// DeleteVolume only deletes the sub directory, a container created for the volume would never be deleted
+		return nil, status.Errorf(codes.InvalidArgument, "%s must be specified with %s", containerNameField, subDirField)
+	}
+
 	if protocol == "" {
 		protocol = fuse
 	}
@@ -136,6 +216,108 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
 	}
 
+	if protocol == fuse {
+		if _, err := parseBlobfuseCacheOptions(parameters, 0); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	security, err := parseStorageSecurityOptions(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if security.isAccountOptionSet() || security.encryptionScope != "" {
+		if len(req.GetSecrets()) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "storage account security settings are not supported when secrets are provided")
+		}
+	}
+	immutability, err := parseContainerImmutabilityOptions(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if immutability.isSet() && len(req.GetSecrets()) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "container immutability settings are not supported when secrets are provided")
+	}
+	accessTier, err := parseAccessTier(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	lifecycle, err := parseLifecycleOptions(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if (accessTier != "" || lifecycle.isSet()) && len(req.GetSecrets()) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "access tier and lifecycle settings are not supported when secrets are provided")
+	}
+	dataProtection, err := parseDataProtectionOptions(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if dataProtection.isSet() && len(req.GetSecrets()) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "data protection settings are not supported when secrets are provided")
+	}
+	replication := parseObjectReplicationOptions(parameters)
+	if replication.isSet() {
+		if len(req.GetSecrets()) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "object replication is not supported when secrets are provided")
+		}
+		if protocol == nfs || (isHnsEnabled != nil && *isHnsEnabled) {
+			return nil, status.Error(codes.InvalidArgument, "object replication is not supported on storage account with hierarchical namespace enabled")
+		}
+		if replication.accountName == "" && security.isAccountOptionSet() {
+			if err := security.checkDriverNamedAccount(); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+	if security.isSharedKeyAccessDisabled() {
+		if subDir != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported when %s is false", subDirField, allowSharedKeyAccessField)
+		}
+		if protocol != nfs {
+			var authType string
+			for k, v := range parameters {
+				if strings.EqualFold(k, "azurestorageauthtype") {
+					authType = v
+				}
+			}
+			if !isAADAuthType(authType) {
+				return nil, status.Errorf(codes.InvalidArgument, "azureStorageAuthType must be one of %v when %s is false", aadAuthTypes, allowSharedKeyAccessField)
+			}
+		}
+		// account key could not be used to access storage account
+		storeAccountKey = false
+	}
+
+	switch networkEndpointType {
+	case "":
+	case privateEndpointType:
+		if len(req.GetSecrets()) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported when secrets are provided", networkEndpointTypeField, networkEndpointType)
+		}
+		if privateEndpointSubnetID == "" {
+			// use default subnet in cloud config
+			privateEndpointSubnetID = d.getSubnetResourceID()
+		}
+		if _, _, _, _, err := parseSubnetResourceID(privateEndpointSubnetID); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported, supported value list: %v", networkEndpointTypeField, networkEndpointType, []string{privateEndpointType})
+	}
+
+	if protocol == nfs {
+		if _, err := parseNFSRootPermission(parameters, ""); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// subnetIDs are taken before default subnet of NFS protocol is added, so that NFS volumes without network rules
+	// still share storage accounts matched by cloud provider
+	dedicated := &dedicatedAccountOptions{security: security, accessTier: accessTier, subnetIDs: subnetIDs, ipRules: ipRules, dataProtection: dataProtection}
+	if networkEndpointType == privateEndpointType {
+		dedicated.privateEndpointSubnetID = privateEndpointSubnetID
+	}
+
 	enableHTTPSTrafficOnly := true
 	accountKind := string(storage.KindStorageV2)
 	var (
@@ -146,16 +328,30 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		enableHTTPSTrafficOnly = false
 		isHnsEnabled = to.BoolPtr(true)
 		enableNfsV3 = to.BoolPtr(true)
-		// set VirtualNetworkResourceIDs for storage account firewall setting
-		vnetResourceID := d.getSubnetResourceID()
-		klog.V(2).Infof("set vnetResourceID(%s) for NFS protocol", vnetResourceID)
-		vnetResourceIDs = []string{vnetResourceID}
-		if err := d.updateSubnetServiceEndpoints(ctx); err != nil {
-			return nil, status.Errorf(codes.Internal, "update service endpoints failed with error: %v", err)
+		if len(subnetIDs) == 0 {
+			// use default subnet in cloud config
+			subnetIDs = []string{d.getSubnetResourceID()}
 		}
 		// NFS protocol does not need account key
 		storeAccountKey = false
 	}
+	if len(subnetIDs) > 0 {
+		// set VirtualNetworkResourceIDs for storage account firewall setting
+		klog.V(2).Infof("set vnetResourceIDs(%v) for protocol(%s)", subnetIDs, protocol)
+		vnetResourceIDs = subnetIDs
+		for _, subnetID := range subnetIDs {
+			subsID, vnetResourceGroup, vnetName, subnetName, err := parseSubnetResourceID(subnetID)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if networkSubsID := d.getNetworkSubscriptionID(); networkSubsID != "" && !strings.EqualFold(subsID, networkSubsID) {
+				return nil, status.Errorf(codes.InvalidArgument, "subnet(%s) is not in subscription(%s) of virtual network", subnetID, networkSubsID)
+			}
+			if err := d.updateSubnetServiceEndpoints(ctx, vnetResourceGroup, vnetName, subnetName); err != nil {
+				return nil, status.Errorf(codes.Internal, "update service endpoints failed with error: %v", err)
+			}
+		}
+	}
 
 	if strings.HasPrefix(strings.ToLower(storageAccountType), "premium") {
 		accountKind = string(storage.KindBlockBlobStorage)
@@ -189,6 +385,15 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	var accountKey string
 	accountName := account
 	if len(req.GetSecrets()) == 0 && accountName == "" {
+		if dedicated.isSet() {
+			if err := security.checkDriverNamedAccount(); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			// storage account with account wide settings is created by cloud provider with a name derived from
+			// the settings, so that it's not shared with volumes without the same settings
+			accountOptions.Name = getDedicatedStorageAccountName(protocol, d.cloud.SubscriptionID, accountOptions, dedicated)
+			accountOptions.CreateAccount = true
+		}
 		lockKey := storageAccountType + accountKind + resourceGroup + location
 		d.volLockMap.LockEntry(lockKey)
 		err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
@@ -205,9 +410,58 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			return nil, status.Errorf(codes.Internal, "failed to ensure storage account: %v", err)
 		}
 	}
+	if len(req.GetSecrets()) == 0 && security.isAccountOptionSet() {
+		if err := d.ensureStorageAccountSecurity(ctx, resourceGroup, accountName, security, account == ""); err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Internal, "failed to apply security settings on storage account(%s): %v", accountName, err)
+		}
+	}
 	accountOptions.Name = accountName
 
-	if accountKey == "" {
+	if len(req.GetSecrets()) == 0 && dedicated.isNetworkRuleSet() {
+		// network rules are only applied on dedicated storage account or existing storage account specified by user,
+		// storage account matched by cloud provider may be shared by other volumes
+		if err := d.ensureStorageAccountNetworkRules(ctx, resourceGroup, accountName, vnetResourceIDs, ipRules); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to ensure network rules on storage account(%s): %v", accountName, err)
+		}
+	}
+
+	if networkEndpointType == privateEndpointType {
+		if storageEndpointSuffix == "" {
+			storageEndpointSuffix = d.cloud.Environment.StorageEndpointSuffix
+		}
+		// public network access is only denied on dedicated storage account, account specified by user is not changed
+		if err := d.ensurePrivateEndpoint(ctx, resourceGroup, accountName, privateEndpointSubnetID, storageEndpointSuffix, account == ""); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to ensure private endpoint of storage account(%s): %v", accountName, err)
+		}
+		if serverName == "" {
+			// access storage account by private endpoint in NodeStageVolume
+			parameters[serverNameField] = getPrivateEndpointServerAddress(accountName, storageEndpointSuffix)
+		}
+	}
+
+	if accessTier != "" {
+		if err := d.ensureStorageAccountAccessTier(ctx, resourceGroup, accountName, accessTier); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set access tier of storage account(%s): %v", accountName, err)
+		}
+	}
+
+	if dataProtection.isSet() {
+		// blob service settings are only applied on dedicated storage account or existing storage account specified by user
+		if err := d.ensureBlobDataProtection(ctx, resourceGroup, accountName, dataProtection); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set data protection of storage account(%s): %v", accountName, err)
+		}
+	}
+
+	if security.encryptionScope != "" {
+		if err := d.ensureEncryptionScope(ctx, resourceGroup, accountName, security); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to ensure encryption scope: %v", err)
+		}
+	}
+
+	if accountKey == "" && !security.isSharedKeyAccessDisabled() {
 		if accountName, accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, req.GetSecrets(), secretNamespace); err != nil {
 			return nil, fmt.Errorf("failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
 		}
@@ -219,6 +473,16 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		parameters[containerNameField] = validContainerName
 	}
 
+	if lifecycle.isSet() {
+		// lifecycle rule is added before creating container, so that volume creation fails early if rule limit is reached
+		if err := d.ensureLifecycleRule(ctx, resourceGroup, accountName, getLifecyclePrefix(validContainerName, subDir), lifecycle); err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Internal, "failed to set lifecycle rule of container(%s): %v", validContainerName, err)
+		}
+	}
+
 	mc := metrics.NewMetricContext(blobCSIDriverName, "controller_create_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
@@ -226,15 +490,69 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}()
 
 	klog.V(2).Infof("begin to create container(%s) on account(%s) type(%s) rg(%s) location(%s) size(%d)", validContainerName, accountName, storageAccountType, resourceGroup, location, requestGiB)
-	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, accountKey, d.cloud.Environment)
+	var container *azstorage.Container
+	if !security.isSharedKeyAccessDisabled() {
+		client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, accountKey, d.cloud.Environment)
+		if err != nil {
+			return nil, err
+		}
+		blobClient := client.GetBlobService()
+		container = blobClient.GetContainerReference(validContainerName)
+	}
+	if security.isSharedKeyAccessDisabled() || security.encryptionScope != "" {
+		// account key could not be used, or encryption scope could not be set by data plane API
+		err = d.createBlobContainer(ctx, resourceGroup, accountName, validContainerName, security.encryptionScope)
+	} else {
+		_, err = container.CreateIfNotExists(&azstorage.CreateContainerOptions{Access: azstorage.ContainerAccessTypePrivate})
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create container(%s) on account(%s) type(%s) rg(%s) location(%s) size(%d), error: %v", validContainerName, accountName, storageAccountType, resourceGroup, location, requestGiB, err)
+	}
+	if immutability.isSet() {
+		if err := d.applyContainerImmutability(ctx, resourceGroup, accountName, validContainerName, immutability); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to apply immutability settings on container(%s): %v", validContainerName, err)
+		}
+	}
+	if subDir != "" {
+		klog.V(2).Infof("begin to create directory(%s) in container(%s) on account(%s)", subDir, validContainerName, accountName)
+		if err := createBlobDirectory(container, subDir); err != nil {
+			return nil, fmt.Errorf("failed to create directory(%s) in container(%s) on account(%s), error: %v", subDir, validContainerName, accountName, err)
+		}
+		for k := range parameters {
+			if strings.EqualFold(k, subDirField) {
+				delete(parameters, k)
+			}
+		}
+		parameters[subDirField] = subDir
 	}
 
-	blobClient := client.GetBlobService()
-	container := blobClient.GetContainerReference(validContainerName)
-	if _, err = container.CreateIfNotExists(&azstorage.CreateContainerOptions{Access: azstorage.ContainerAccessTypePrivate}); err != nil {
-		return nil, fmt.Errorf("failed to create container(%s) on account(%s) type(%s) rg(%s) location(%s) size(%d), error: %v", validContainerName, accountName, storageAccountType, resourceGroup, location, requestGiB, err)
+	if replication.isSet() {
+		replicationResourceGroup, replicationAccount, err := d.ensureReplicationStorageAccount(ctx, accountOptions, replication, &replicaHardeningOptions{
+			security:                security,
+			ipRules:                 ipRules,
+			networkEndpointType:     networkEndpointType,
+			privateEndpointSubnetID: privateEndpointSubnetID,
+			storageEndpointSuffix:   storageEndpointSuffix,
+		})
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+		if err := d.ensureContainerReplication(ctx, resourceGroup, accountName, replicationResourceGroup, replicationAccount, validContainerName, security.encryptionScope); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to replicate container(%s) to storage account(%s): %v", validContainerName, replicationAccount, err)
+		}
+		// record replica in volume context, so a static PV could be created against the replica during failover
+		for k := range parameters {
+			switch strings.ToLower(k) {
+			case replicationLocationField, replicationResourceGroupField, replicationStorageAccountField:
+				delete(parameters, k)
+			}
+		}
+		parameters[replicationResourceGroupField] = replicationResourceGroup
+		parameters[replicationStorageAccountField] = replicationAccount
+		parameters[replicationContainerField] = validContainerName
 	}
 
 	if storeAccountKey && len(req.GetSecrets()) == 0 {
@@ -248,11 +566,19 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	volumeID := fmt.Sprintf(volumeIDTemplate, resourceGroup, accountName, validContainerName)
-	if containerName != "" {
+	if containerName != "" || subDir != "" {
 		// add volume name as suffix to differentiate volumeID since "containerName" is specified
 		// not necessary for dynamic container name creation since volumeID already contains volume name
 		volumeID = volumeID + "#" + name
 	}
+	if subDir != "" {
+		// e.g. rg#account#container#pvc-name#subdir=project/workload
+		volumeID = volumeID + separator + subDirVolumeIDTag + subDir
+		if onDelete != onDeleteDelete {
+			// e.g. rg#account#container#pvc-name#subdir=project/workload#ondelete=archive
+			volumeID = volumeID + separator + onDeleteVolumeIDTag + onDelete
+		}
+	}
 	klog.V(2).Infof("create container %s on storage account %s successfully", validContainerName, accountName)
 
 	isOperationSucceeded = true
@@ -275,7 +601,7 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	}
 
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		return nil, fmt.Errorf("invalid delete volume req: %v", req)
+		return nil, fmt.Errorf("invalid delete volume req: %v", util.RedactRequest(req))
 	}
 
 	if acquired := d.volumeLocks.TryAcquire(volumeID); !acquired {
@@ -312,16 +638,59 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		}
 	}
 
-	klog.V(2).Infof("deleting container(%s) rg(%s) account(%s) volumeID(%s)", containerName, resourceGroupName, accountName, volumeID)
+	if len(req.GetSecrets()) == 0 && d.blobContainersClient != nil {
+		if err := d.checkContainerLegalHold(ctx, resourceGroupName, accountName, containerName); err != nil {
+			return nil, err
+		}
+	}
+
 	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, accountKey, d.cloud.Environment)
 	if err != nil {
 		return nil, err
 	}
 	blobClient := client.GetBlobService()
 	container := blobClient.GetContainerReference(containerName)
+
+	if subDir := getSubDirFromVolumeID(volumeID); subDir != "" {
+		if getOnDeleteFromVolumeID(volumeID) == onDeleteArchive {
+			archiveDir := getArchiveDir(subDir, volumeID)
+			klog.V(2).Infof("archiving directory(%s) to %s in container(%s) rg(%s) account(%s) volumeID(%s)", subDir, archiveDir, containerName, resourceGroupName, accountName, volumeID)
+			if err := copyBlobDirectory(container, subDir, archiveDir); err != nil {
+				return nil, fmt.Errorf("failed to archive directory(%s) to %s in container(%s) on account(%s), error: %v", subDir, archiveDir, containerName, accountName, err)
+			}
+		}
+		// only delete the sub directory since the container may be shared by other volumes
+		klog.V(2).Infof("deleting directory(%s) in container(%s) rg(%s) account(%s) volumeID(%s)", subDir, containerName, resourceGroupName, accountName, volumeID)
+		if err := deleteBlobDirectory(container, subDir); err != nil {
+			if isImmutabilityError(err) {
+				return nil, status.Errorf(codes.FailedPrecondition, "directory(%s) in container(%s) on account(%s) is protected by immutability policy or legal hold: %v", subDir, containerName, accountName, err)
+			}
+			return nil, fmt.Errorf("failed to delete directory(%s) in container(%s) on account(%s), error: %v", subDir, containerName, accountName, err)
+		}
+		if err := d.removeVolumeLifecycleRule(ctx, resourceGroupName, accountName, containerName, subDir, req.GetSecrets()); err != nil {
+			return nil, err
+		}
+		isOperationSucceeded = true
+		klog.V(2).Infof("directory(%s) in container(%s) under rg(%s) account(%s) volumeID(%s) is deleted successfully", subDir, containerName, resourceGroupName, accountName, volumeID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	// object replication rule of source container should be removed before container deletion
+	if err := d.removeContainerReplication(ctx, resourceGroupName, accountName, containerName, req.GetSecrets()); err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("deleting container(%s) rg(%s) account(%s) volumeID(%s)", containerName, resourceGroupName, accountName, volumeID)
 	// todo: check what value to add into DeleteContainerOptions
 	err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
 		_, err := container.DeleteIfExists(nil)
+		if err != nil && strings.Contains(err.Error(), keyBasedAuthenticationNotPermitted) && len(req.GetSecrets()) == 0 && d.blobContainersClient != nil {
+			// shared key access is disabled on storage account, delete container by storage management API
+			klog.V(2).Infof("shared key access is disabled on account(%s), deleting container(%s) by management API", accountName, containerName)
+			_, err = d.blobContainersClient.Delete(ctx, resourceGroupName, accountName, containerName)
+		}
+		if isImmutabilityError(err) {
+			return true, status.Errorf(codes.FailedPrecondition, "container(%s) on account(%s) is protected by immutability policy or legal hold: %v", containerName, accountName, err)
+		}
 		if err != nil && !strings.Contains(err.Error(), "ContainerBeingDeleted") {
 			return false, fmt.Errorf("failed to delete container(%s) on account(%s), error: %v", containerName, accountName, err)
 		}
@@ -330,6 +699,9 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	if err != nil {
 		return nil, err
 	}
+	if err := d.removeVolumeLifecycleRule(ctx, resourceGroupName, accountName, containerName, "", req.GetSecrets()); err != nil {
+		return nil, err
+	}
 
 	isOperationSucceeded = true
 	klog.V(2).Infof("container(%s) under rg(%s) account(%s) volumeID(%s) is deleted successfully", containerName, resourceGroupName, accountName, volumeID)
@@ -448,7 +820,7 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}
 
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
-		return nil, fmt.Errorf("invalid expand volume req: %v", req)
+		return nil, fmt.Errorf("invalid expand volume req: %v", util.RedactRequest(req))
 	}
 
 	volSizeBytes := int64(req.GetCapacityRange().GetRequiredBytes())
@@ -462,3 +834,90 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 
 	return &csi.ControllerExpandVolumeResponse{CapacityBytes: req.GetCapacityRange().GetRequiredBytes()}, nil
 }
+
+// createBlobDirectory creates a directory marker blob in container, which is
+// recognized as a directory by blobfuse and hierarchical namespace accounts
+func createBlobDirectory(container *azstorage.Container, dir string) error {
+	blob := container.GetBlobReference(dir)
+	exists, err := blob.Exists()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	blob.Metadata = azstorage.BlobMetadata{directoryMetadataKey: trueValue}
+	return blob.CreateBlockBlob(nil)
+}
+
+// listBlobDirectory returns names of all blobs under dir together with the directory marker blob
+func listBlobDirectory(container *azstorage.Container, dir string) ([]string, error) {
+	blobNames := []string{}
+	params := azstorage.ListBlobsParameters{Prefix: dir + "/"}
+	for {
+		resp, err := container.ListBlobs(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs under %s: %v", dir, err)
+		}
+		for _, blob := range resp.Blobs {
+			blobNames = append(blobNames, blob.Name)
+		}
+		if resp.NextMarker == "" {
+			break
+		}
+		params.Marker = resp.NextMarker
+	}
+	return append(blobNames, dir), nil
+}
+
+// copyBlobDirectory copies all blobs under srcDir to dstDir in the same container
+func copyBlobDirectory(container *azstorage.Container, srcDir, dstDir string) error {
+	blobNames, err := listBlobDirectory(container, srcDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range blobNames {
+		src := container.GetBlobReference(name)
+		exists, err := src.Exists()
+		if err != nil {
+			return fmt.Errorf("failed to check blob(%s): %v", name, err)
+		}
+		if !exists {
+			// directory marker blob may not exist
+			continue
+		}
+		dst := dstDir + strings.TrimPrefix(name, srcDir)
+		if err := container.GetBlobReference(dst).Copy(src.GetURL(), nil); err != nil {
+			return fmt.Errorf("failed to copy blob(%s) to %s: %v", name, dst, err)
+		}
+	}
+	klog.V(2).Infof("%d blobs under %s are copied to %s", len(blobNames), srcDir, dstDir)
+	return nil
+}
+
+// getArchiveDir returns the directory to which sub directory is archived, e.g. archived/project/workload-1a2b3c4d,
+// suffix is derived from volume ID so that a retried DeleteVolume archives to the same directory
+func getArchiveDir(dir, volumeID string) string {
+	hash := sha256.Sum256([]byte(volumeID))
+	return fmt.Sprintf("%s/%s-%s", archivedDirPrefix, dir, hex.EncodeToString(hash[:])[:8])
+}
+
+// deleteBlobDirectory deletes all blobs under dir together with the directory marker blob,
+// blobs are deleted from the deepest level since a non-empty directory can't be deleted on hierarchical namespace accounts
+func deleteBlobDirectory(container *azstorage.Container, dir string) error {
+	blobNames, err := listBlobDirectory(container, dir)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(blobNames, func(i, j int) bool {
+		return strings.Count(blobNames[i], "/") > strings.Count(blobNames[j], "/")
+	})
+
+	for _, name := range blobNames {
+		if _, err := container.GetBlobReference(name).DeleteIfExists(&azstorage.DeleteBlobOptions{DeleteSnapshots: to.BoolPtr(true)}); err != nil {
+			return fmt.Errorf("failed to delete blob(%s): %v", name, err)
+		}
+	}
+	klog.V(2).Infof("%d blobs under %s are deleted", len(blobNames), dir)
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	enableBlobVersioningField    = "enableblobversioning"
+	blobSoftDeleteDaysField      = "blobsoftdeletedays"
+	containerSoftDeleteDaysField = "containersoftdeletedays"
+	enableChangeFeedField        = "enablechangefeed"
+	pointInTimeRestoreDaysField  = "pointintimerestoredays"
+
+	// See https://docs.microsoft.com/en-us/azure/storage/blobs/soft-delete-blob-overview
+	maxSoftDeleteDays = 365
+)
+
+// dataProtectionOptions defines blob service settings of storage account which protect data from accidental deletion,
+// nil or 0 means the setting is not changed
+type dataProtectionOptions struct {
+	versioning              *bool
+	changeFeed              *bool
+	blobSoftDeleteDays      int32
+	containerSoftDeleteDays int32
+	// point-in-time restore requires versioning, change feed and blob soft delete with longer retention
+	restoreDays int32
+}
+
+// parseDataProtectionOptions parses blob service data protection settings from storage class parameters
+func parseDataProtectionOptions(parameters map[string]string) (*dataProtectionOptions, error) {
+	o := &dataProtectionOptions{}
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case enableBlobVersioningField, enableChangeFeedField:
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			if strings.ToLower(k) == enableBlobVersioningField {
+				o.versioning = to.BoolPtr(enabled)
+			} else {
+				o.changeFeed = to.BoolPtr(enabled)
+			}
+		case blobSoftDeleteDaysField, containerSoftDeleteDaysField, pointInTimeRestoreDaysField:
+			days, err := strconv.Atoi(v)
+			if err != nil || days < 1 || days > maxSoftDeleteDays {
+				return nil, fmt.Errorf("invalid %s: %s, should be an integer between 1 and %d", k, v, maxSoftDeleteDays)
+			}
+			switch strings.ToLower(k) {
+			case blobSoftDeleteDaysField:
+				o.blobSoftDeleteDays = int32(days)
+			case containerSoftDeleteDaysField:
+				o.containerSoftDeleteDays = int32(days)
+			default:
+				o.restoreDays = int32(days)
+			}
+		}
+	}
+
+	if o.restoreDays > 0 {
+		if !to.Bool(o.versioning) || !to.Bool(o.changeFeed) {
+			return nil, fmt.Errorf("%s and %s must be true when %s is specified", enableBlobVersioningField, enableChangeFeedField, pointInTimeRestoreDaysField)
+		}
+		if o.blobSoftDeleteDays <= o.restoreDays {
+			return nil, fmt.Errorf("%s(%d) must be greater than %s(%d)", blobSoftDeleteDaysField, o.blobSoftDeleteDays, pointInTimeRestoreDaysField, o.restoreDays)
+		}
+	}
+	return o, nil
+}
+
+// isSet checks whether any data protection setting is specified
+func (o *dataProtectionOptions) isSet() bool {
+	return o.versioning != nil || o.changeFeed != nil || o.blobSoftDeleteDays > 0 || o.containerSoftDeleteDays > 0 || o.restoreDays > 0
+}
+
+func (o *dataProtectionOptions) String() string {
+	formatBool := func(b *bool) string {
+		if b == nil {
+			return ""
+		}
+		return strconv.FormatBool(*b)
+	}
+	return fmt.Sprintf("%s=%s,%s=%s,%s=%d,%s=%d,%s=%d", enableBlobVersioningField, formatBool(o.versioning), enableChangeFeedField, formatBool(o.changeFeed),
+		blobSoftDeleteDaysField, o.blobSoftDeleteDays, containerSoftDeleteDaysField, o.containerSoftDeleteDays, pointInTimeRestoreDaysField, o.restoreDays)
+}
+
+// apply applies data protection settings on blob service properties, returns true if any property is changed
+func (o *dataProtectionOptions) apply(properties *storage.BlobServicePropertiesProperties) bool {
+	changed := false
+	if o.versioning != nil && to.Bool(properties.IsVersioningEnabled) != *o.versioning {
+		properties.IsVersioningEnabled = o.versioning
+		changed = true
+	}
+	if o.changeFeed != nil && (properties.ChangeFeed == nil || to.Bool(properties.ChangeFeed.Enabled) != *o.changeFeed) {
+		properties.ChangeFeed = &storage.ChangeFeed{Enabled: o.changeFeed}
+		changed = true
+	}
+	isRetentionMatched := func(policy *storage.DeleteRetentionPolicy, days int32) bool {
+		return policy != nil && to.Bool(policy.Enabled) && to.Int32(policy.Days) == days
+	}
+	if o.blobSoftDeleteDays > 0 && !isRetentionMatched(properties.DeleteRetentionPolicy, o.blobSoftDeleteDays) {
+		properties.DeleteRetentionPolicy = &storage.DeleteRetentionPolicy{Enabled: to.BoolPtr(true), Days: to.Int32Ptr(o.blobSoftDeleteDays)}
+		changed = true
+	}
+	if o.containerSoftDeleteDays > 0 && !isRetentionMatched(properties.ContainerDeleteRetentionPolicy, o.containerSoftDeleteDays) {
+		properties.ContainerDeleteRetentionPolicy = &storage.DeleteRetentionPolicy{Enabled: to.BoolPtr(true), Days: to.Int32Ptr(o.containerSoftDeleteDays)}
+		changed = true
+	}
+	if o.restoreDays > 0 && (properties.RestorePolicy == nil || !to.Bool(properties.RestorePolicy.Enabled) || to.Int32(properties.RestorePolicy.Days) != o.restoreDays) {
+		properties.RestorePolicy = &storage.RestorePolicyProperties{Enabled: to.BoolPtr(true), Days: to.Int32Ptr(o.restoreDays)}
+		changed = true
+	}
+	return changed
+}
+
+// ensureBlobDataProtection applies data protection settings on blob service of storage account,
+// settings which are not specified are kept
+func (d *Driver) ensureBlobDataProtection(ctx context.Context, resourceGroup, accountName string, o *dataProtectionOptions) error {
+	if d.blobServicesClient == nil {
+		return fmt.Errorf("blob services client is not initialized")
+	}
+	// blob service properties are shared by all volumes in the storage account
+	lockKey := "blobservice" + resourceGroup + accountName
+	d.volLockMap.LockEntry(lockKey)
+	defer d.volLockMap.UnlockEntry(lockKey)
+
+	service, err := d.blobServicesClient.GetServiceProperties(ctx, resourceGroup, accountName)
+	if err != nil {
+		return fmt.Errorf("failed to get blob service properties of storage account(%s): %v", accountName, err)
+	}
+	properties := &storage.BlobServicePropertiesProperties{}
+	if service.BlobServicePropertiesProperties != nil {
+		properties = service.BlobServicePropertiesProperties
+	}
+	if !o.apply(properties) {
+		return nil
+	}
+
+	klog.V(2).Infof("setting data protection(versioning: %v, changeFeed: %v, blobSoftDeleteDays: %d, containerSoftDeleteDays: %d, restoreDays: %d) on storage account(%s)",
+		to.Bool(properties.IsVersioningEnabled), properties.ChangeFeed != nil && to.Bool(properties.ChangeFeed.Enabled), o.blobSoftDeleteDays, o.containerSoftDeleteDays, o.restoreDays, accountName)
+	if _, err := d.blobServicesClient.SetServiceProperties(ctx, resourceGroup, accountName, storage.BlobServiceProperties{BlobServicePropertiesProperties: properties}); err != nil {
+		return fmt.Errorf("failed to set blob service properties of storage account(%s): %v", accountName, err)
+	}
+	return nil
+}
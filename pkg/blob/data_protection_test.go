@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/blob/mockstorageapi"
+)
+
+func TestParseDataProtectionOptions(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parameters map[string]string
+		expected   *dataProtectionOptions
+		expectErr  bool
+	}{
+		{
+			desc:       "no data protection options",
+			parameters: map[string]string{},
+			expected:   &dataProtectionOptions{},
+		},
+		{
+			desc: "all data protection options",
+			parameters: map[string]string{"enableBlobVersioning": "true", "enableChangeFeed": "true", "blobSoftDeleteDays": "14",
+				"containerSoftDeleteDays": "7", "pointInTimeRestoreDays": "13"},
+			expected: &dataProtectionOptions{versioning: to.BoolPtr(true), changeFeed: to.BoolPtr(true), blobSoftDeleteDays: 14,
+				containerSoftDeleteDays: 7, restoreDays: 13},
+		},
+		{
+			desc:       "disable versioning",
+			parameters: map[string]string{"enableBlobVersioning": "false"},
+			expected:   &dataProtectionOptions{versioning: to.BoolPtr(false)},
+		},
+		{
+			desc:       "invalid enableChangeFeed",
+			parameters: map[string]string{"enableChangeFeed": "invalid"},
+			expectErr:  true,
+		},
+		{
+			desc:       "invalid soft delete days",
+			parameters: map[string]string{"blobSoftDeleteDays": "366"},
+			expectErr:  true,
+		},
+		{
+			desc:       "point-in-time restore without change feed",
+			parameters: map[string]string{"enableBlobVersioning": "true", "blobSoftDeleteDays": "14", "pointInTimeRestoreDays": "7"},
+			expectErr:  true,
+		},
+		{
+			desc:       "point-in-time restore longer than soft delete",
+			parameters: map[string]string{"enableBlobVersioning": "true", "enableChangeFeed": "true", "blobSoftDeleteDays": "7", "pointInTimeRestoreDays": "7"},
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseDataProtectionOptions(test.parameters)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestEnsureBlobDataProtection(t *testing.T) {
+	d := NewFakeDriver()
+	options := &dataProtectionOptions{versioning: to.BoolPtr(true), changeFeed: to.BoolPtr(true), blobSoftDeleteDays: 14, restoreDays: 7}
+	assert.Error(t, d.ensureBlobDataProtection(context.TODO(), "rg", "account", options))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockServicesClient := mockstorageapi.NewMockBlobServicesClientAPI(ctrl)
+	d.blobServicesClient = mockServicesClient
+
+	cors := &storage.CorsRules{CorsRules: &[]storage.CorsRule{}}
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "account").Return(storage.BlobServiceProperties{
+		BlobServicePropertiesProperties: &storage.BlobServicePropertiesProperties{
+			Cors:                           cors,
+			ContainerDeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: to.BoolPtr(true), Days: to.Int32Ptr(30)},
+		},
+	}, nil).Times(1)
+	var applied storage.BlobServiceProperties
+	mockServicesClient.EXPECT().SetServiceProperties(gomock.Any(), "rg", "account", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, parameters storage.BlobServiceProperties) (storage.BlobServiceProperties, error) {
+			applied = parameters
+			return parameters, nil
+		}).Times(1)
+	assert.NoError(t, d.ensureBlobDataProtection(context.TODO(), "rg", "account", options))
+	properties := applied.BlobServicePropertiesProperties
+	assert.True(t, to.Bool(properties.IsVersioningEnabled))
+	assert.True(t, to.Bool(properties.ChangeFeed.Enabled))
+	assert.Equal(t, int32(14), to.Int32(properties.DeleteRetentionPolicy.Days))
+	assert.Equal(t, int32(7), to.Int32(properties.RestorePolicy.Days))
+	// settings which are not specified are kept
+	assert.Equal(t, cors, properties.Cors)
+	assert.Equal(t, int32(30), to.Int32(properties.ContainerDeleteRetentionPolicy.Days))
+
+	// blob service properties are not set again if settings are matched
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "account").Return(applied, nil).Times(1)
+	assert.NoError(t, d.ensureBlobDataProtection(context.TODO(), "rg", "account", options))
+
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "account").Return(applied, nil).Times(1)
+	mockServicesClient.EXPECT().SetServiceProperties(gomock.Any(), "rg", "account", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, parameters storage.BlobServiceProperties) (storage.BlobServiceProperties, error) {
+			assert.Equal(t, int32(7), to.Int32(parameters.ContainerDeleteRetentionPolicy.Days))
+			return parameters, nil
+		}).Times(1)
+	assert.NoError(t, d.ensureBlobDataProtection(context.TODO(), "rg", "account", &dataProtectionOptions{containerSoftDeleteDays: 7}))
+
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), "rg", "account").Return(storage.BlobServiceProperties{}, fmt.Errorf("server error")).Times(1)
+	assert.Error(t, d.ensureBlobDataProtection(context.TODO(), "rg", "account", options))
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	immutabilityPeriodDaysField     = "immutabilityperioddays"
+	lockImmutabilityPolicyField     = "lockimmutabilitypolicy"
+	allowProtectedAppendWritesField = "allowprotectedappendwrites"
+	legalHoldTagsField              = "legalholdtags"
+
+	// See https://docs.microsoft.com/en-us/azure/storage/blobs/immutable-time-based-retention-policy-overview
+	maxImmutabilityPeriodDays = 146000
+	// See https://docs.microsoft.com/en-us/azure/storage/blobs/immutable-legal-hold-overview
+	maxLegalHoldTags = 10
+)
+
+var (
+	legalHoldTagRegex = regexp.MustCompile(`^[a-zA-Z0-9]{3,23}$`)
+
+	// keywords in storage errors returned when blobs or container are protected by immutability policy or legal hold
+	immutabilityErrors = []string{"immutab", "legalhold", "legal hold"}
+)
+
+// containerImmutabilityOptions defines WORM settings of the container created by driver
+type containerImmutabilityOptions struct {
+	// time-based retention period of blobs since creation, 0 means no time-based retention policy
+	periodDays int32
+	// locked policy could not be deleted and retention period could only be extended
+	lock                       bool
+	allowProtectedAppendWrites *bool
+	legalHoldTags              []string
+}
+
+// parseContainerImmutabilityOptions parses container immutability settings from storage class parameters
+func parseContainerImmutabilityOptions(parameters map[string]string) (*containerImmutabilityOptions, error) {
+	o := &containerImmutabilityOptions{}
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case immutabilityPeriodDaysField:
+			days, err := strconv.Atoi(v)
+			if err != nil || days < 1 || days > maxImmutabilityPeriodDays {
+				return nil, fmt.Errorf("invalid %s: %s, should be an integer between 1 and %d", k, v, maxImmutabilityPeriodDays)
+			}
+			o.periodDays = int32(days)
+		case lockImmutabilityPolicyField:
+			lock, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			o.lock = lock
+		case allowProtectedAppendWritesField:
+			allow, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			o.allowProtectedAppendWrites = to.BoolPtr(allow)
+		case legalHoldTagsField:
+			for _, tag := range strings.Split(v, ",") {
+				if tag = strings.TrimSpace(tag); tag == "" {
+					continue
+				}
+				if !legalHoldTagRegex.MatchString(tag) {
+					return nil, fmt.Errorf("invalid legal hold tag %q in %s, should be 3 to 23 alphanumeric characters", tag, k)
+				}
+				o.legalHoldTags = append(o.legalHoldTags, strings.ToLower(tag))
+			}
+			if len(o.legalHoldTags) > maxLegalHoldTags {
+				return nil, fmt.Errorf("at most %d legal hold tags are allowed in %s", maxLegalHoldTags, k)
+			}
+		}
+	}
+
+	if o.periodDays == 0 && (o.lock || o.allowProtectedAppendWrites != nil) {
+		return nil, fmt.Errorf("%s must be specified with %s or %s", immutabilityPeriodDaysField, lockImmutabilityPolicyField, allowProtectedAppendWritesField)
+	}
+	return o, nil
+}
+
+// isSet checks whether any immutability setting is specified
+func (o *containerImmutabilityOptions) isSet() bool {
+	return o.periodDays > 0 || len(o.legalHoldTags) > 0
+}
+
+// applyContainerImmutability applies time-based retention policy and legal hold on container,
+// a locked retention policy is not changed since it could only be extended
+func (d *Driver) applyContainerImmutability(ctx context.Context, resourceGroup, accountName, containerName string, o *containerImmutabilityOptions) error {
+	if d.blobContainersClient == nil {
+		return fmt.Errorf("blob containers client is not initialized")
+	}
+
+	if o.periodDays > 0 {
+		var etag string
+		policy, err := d.blobContainersClient.GetImmutabilityPolicy(ctx, resourceGroup, accountName, containerName, "")
+		if err != nil && !isNotFoundError(err) {
+			return fmt.Errorf("failed to get immutability policy of container(%s): %v", containerName, err)
+		}
+		if err == nil {
+			etag = to.String(policy.Etag)
+		}
+		if err == nil && policy.ImmutabilityPolicyProperty != nil && policy.State == storage.ImmutabilityPolicyStateLocked {
+			klog.V(2).Infof("immutability policy(%d days) of container(%s) is already locked", to.Int32(policy.ImmutabilityPeriodSinceCreationInDays), containerName)
+		} else {
+			klog.V(2).Infof("setting immutability policy(%d days) on container(%s) in account(%s)", o.periodDays, containerName, accountName)
+			policy, err = d.blobContainersClient.CreateOrUpdateImmutabilityPolicy(ctx, resourceGroup, accountName, containerName, &storage.ImmutabilityPolicy{
+				ImmutabilityPolicyProperty: &storage.ImmutabilityPolicyProperty{
+					ImmutabilityPeriodSinceCreationInDays: to.Int32Ptr(o.periodDays),
+					AllowProtectedAppendWrites:            o.allowProtectedAppendWrites,
+				},
+			}, etag)
+			if err != nil {
+				return fmt.Errorf("failed to set immutability policy on container(%s): %v", containerName, err)
+			}
+			if o.lock {
+				klog.V(2).Infof("locking immutability policy of container(%s) in account(%s)", containerName, accountName)
+				if _, err := d.blobContainersClient.LockImmutabilityPolicy(ctx, resourceGroup, accountName, containerName, to.String(policy.Etag)); err != nil {
+					return fmt.Errorf("failed to lock immutability policy of container(%s): %v", containerName, err)
+				}
+			}
+		}
+	}
+
+	if len(o.legalHoldTags) > 0 {
+		klog.V(2).Infof("setting legal hold(%v) on container(%s) in account(%s)", o.legalHoldTags, containerName, accountName)
+		if _, err := d.blobContainersClient.SetLegalHold(ctx, resourceGroup, accountName, containerName, storage.LegalHold{Tags: &o.legalHoldTags}); err != nil {
+			return fmt.Errorf("failed to set legal hold on container(%s): %v", containerName, err)
+		}
+	}
+	return nil
+}
+
+// checkContainerLegalHold returns FailedPrecondition error if container has legal hold,
+// since neither the container nor any blob in it could be deleted until all legal hold tags are cleared
+func (d *Driver) checkContainerLegalHold(ctx context.Context, resourceGroup, accountName, containerName string) error {
+	container, err := d.blobContainersClient.Get(ctx, resourceGroup, accountName, containerName)
+	if err != nil {
+		// container deletion would still report immutability error if the check is skipped
+		klog.Warningf("failed to get properties of container(%s) in account(%s): %v", containerName, accountName, err)
+		return nil
+	}
+	if container.ContainerProperties != nil && to.Bool(container.HasLegalHold) {
+		var tags []string
+		if container.LegalHold != nil && container.LegalHold.Tags != nil {
+			for _, tag := range *container.LegalHold.Tags {
+				tags = append(tags, to.String(tag.Tag))
+			}
+		}
+		return status.Errorf(codes.FailedPrecondition, "container(%s) in account(%s) has legal hold(%v), clear legal hold before deleting the volume", containerName, accountName, tags)
+	}
+	return nil
+}
+
+// isImmutabilityError checks whether deletion fails because blobs or container are protected by immutability policy or legal hold
+func isImmutabilityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, keyword := range immutabilityErrors {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/blob/mockstorageapi"
+)
+
+func TestParseContainerImmutabilityOptions(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parameters map[string]string
+		expected   *containerImmutabilityOptions
+		expectErr  bool
+	}{
+		{
+			desc:       "no immutability options",
+			parameters: map[string]string{},
+			expected:   &containerImmutabilityOptions{},
+		},
+		{
+			desc: "locked immutability policy and legal hold",
+			parameters: map[string]string{
+				"immutabilityPeriodDays":     "365",
+				"lockImmutabilityPolicy":     "true",
+				"allowProtectedAppendWrites": "true",
+				"legalHoldTags":              "Audit2021, case1",
+			},
+			expected: &containerImmutabilityOptions{
+				periodDays:                 365,
+				lock:                       true,
+				allowProtectedAppendWrites: to.BoolPtr(true),
+				legalHoldTags:              []string{"audit2021", "case1"},
+			},
+		},
+		{
+			desc:       "invalid immutability period",
+			parameters: map[string]string{"immutabilityPeriodDays": "0"},
+			expectErr:  true,
+		},
+		{
+			desc:       "immutability period too long",
+			parameters: map[string]string{"immutabilityPeriodDays": "146001"},
+			expectErr:  true,
+		},
+		{
+			desc:       "lock without immutability period",
+			parameters: map[string]string{"lockImmutabilityPolicy": "true"},
+			expectErr:  true,
+		},
+		{
+			desc:       "invalid legal hold tag",
+			parameters: map[string]string{"legalHoldTags": "a-b"},
+			expectErr:  true,
+		},
+		{
+			desc:       "too many legal hold tags",
+			parameters: map[string]string{"legalHoldTags": "tag1,tag2,tag3,tag4,tag5,tag6,tag7,tag8,tag9,tag10,tag11"},
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseContainerImmutabilityOptions(test.parameters)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestApplyContainerImmutability(t *testing.T) {
+	d := NewFakeDriver()
+	options := &containerImmutabilityOptions{periodDays: 30, lock: true, legalHoldTags: []string{"audit"}}
+	assert.Error(t, d.applyContainerImmutability(context.TODO(), "rg", "account", "container", options))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockContainersClient := mockstorageapi.NewMockBlobContainersClientAPI(ctrl)
+	d.blobContainersClient = mockContainersClient
+
+	mockContainersClient.EXPECT().GetImmutabilityPolicy(gomock.Any(), "rg", "account", "container", "").
+		Return(storage.ImmutabilityPolicy{}, autorest.DetailedError{StatusCode: http.StatusNotFound}).Times(1)
+	mockContainersClient.EXPECT().CreateOrUpdateImmutabilityPolicy(gomock.Any(), "rg", "account", "container", &storage.ImmutabilityPolicy{
+		ImmutabilityPolicyProperty: &storage.ImmutabilityPolicyProperty{ImmutabilityPeriodSinceCreationInDays: to.Int32Ptr(30)},
+	}, "").Return(storage.ImmutabilityPolicy{Etag: to.StringPtr("etag")}, nil).Times(1)
+	mockContainersClient.EXPECT().LockImmutabilityPolicy(gomock.Any(), "rg", "account", "container", "etag").Return(storage.ImmutabilityPolicy{}, nil).Times(1)
+	mockContainersClient.EXPECT().SetLegalHold(gomock.Any(), "rg", "account", "container", storage.LegalHold{Tags: &[]string{"audit"}}).Return(storage.LegalHold{}, nil).Times(1)
+	assert.NoError(t, d.applyContainerImmutability(context.TODO(), "rg", "account", "container", options))
+
+	// locked policy is not changed
+	options = &containerImmutabilityOptions{periodDays: 60}
+	mockContainersClient.EXPECT().GetImmutabilityPolicy(gomock.Any(), "rg", "account", "container", "").Return(storage.ImmutabilityPolicy{
+		Etag: to.StringPtr("etag"),
+		ImmutabilityPolicyProperty: &storage.ImmutabilityPolicyProperty{
+			ImmutabilityPeriodSinceCreationInDays: to.Int32Ptr(30),
+			State:                                 storage.ImmutabilityPolicyStateLocked,
+		},
+	}, nil).Times(1)
+	assert.NoError(t, d.applyContainerImmutability(context.TODO(), "rg", "account", "container", options))
+
+	// unlocked policy is updated with its etag
+	mockContainersClient.EXPECT().GetImmutabilityPolicy(gomock.Any(), "rg", "account", "container", "").Return(storage.ImmutabilityPolicy{
+		Etag: to.StringPtr("etag"),
+		ImmutabilityPolicyProperty: &storage.ImmutabilityPolicyProperty{
+			ImmutabilityPeriodSinceCreationInDays: to.Int32Ptr(30),
+			State:                                 storage.ImmutabilityPolicyStateUnlocked,
+		},
+	}, nil).Times(1)
+	mockContainersClient.EXPECT().CreateOrUpdateImmutabilityPolicy(gomock.Any(), "rg", "account", "container", gomock.Any(), "etag").
+		Return(storage.ImmutabilityPolicy{}, fmt.Errorf("server error")).Times(1)
+	assert.Error(t, d.applyContainerImmutability(context.TODO(), "rg", "account", "container", options))
+}
+
+func TestCheckContainerLegalHold(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockContainersClient := mockstorageapi.NewMockBlobContainersClientAPI(ctrl)
+	d.blobContainersClient = mockContainersClient
+
+	// check is skipped if container properties could not be retrieved
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").
+		Return(storage.BlobContainer{}, autorest.DetailedError{StatusCode: http.StatusNotFound}).Times(1)
+	assert.NoError(t, d.checkContainerLegalHold(context.TODO(), "rg", "account", "container"))
+
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").
+		Return(storage.BlobContainer{ContainerProperties: &storage.ContainerProperties{}}, nil).Times(1)
+	assert.NoError(t, d.checkContainerLegalHold(context.TODO(), "rg", "account", "container"))
+
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").Return(storage.BlobContainer{ContainerProperties: &storage.ContainerProperties{
+		HasLegalHold: to.BoolPtr(true),
+		LegalHold: &storage.LegalHoldProperties{
+			Tags: &[]storage.TagProperty{{Tag: to.StringPtr("audit")}},
+		},
+	}}, nil).Times(1)
+	err := d.checkContainerLegalHold(context.TODO(), "rg", "account", "container")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "audit")
+}
+
+func TestIsImmutabilityError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: nil, expected: false},
+		{err: fmt.Errorf("storage: service returned error: StatusCode=409, ErrorCode=BlobImmutableDueToPolicy"), expected: true},
+		{err: fmt.Errorf("storage: service returned error: StatusCode=409, ErrorCode=BlobImmutableDueToLegalHold"), expected: true},
+		{err: fmt.Errorf("storage: service returned error: StatusCode=409, ErrorCode=ContainerBeingDeleted"), expected: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isImmutabilityError(test.err), "%v", test.err)
+	}
+}
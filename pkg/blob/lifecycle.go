@@ -0,0 +1,266 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	accessTierField             = "accesstier"
+	tierToCoolAfterDaysField    = "tiertocoolafterdays"
+	tierToArchiveAfterDaysField = "tiertoarchiveafterdays"
+	deleteAfterDaysField        = "deleteafterdays"
+
+	lifecycleRuleType = "Lifecycle"
+	// rules created by driver are named with this prefix and hash of container prefix
+	lifecycleRuleNamePrefix = "blobcsi"
+	blockBlobType           = "blockBlob"
+	// See https://docs.microsoft.com/en-us/azure/storage/blobs/lifecycle-management-overview#lifecycle-management-policy-definition
+	maxLifecycleRules = 100
+)
+
+// lifecycleOptions defines days after last modification when blobs of a volume are moved to cool or archive tier, or deleted,
+// 0 means the action is not applied
+type lifecycleOptions struct {
+	tierToCoolAfterDays    int
+	tierToArchiveAfterDays int
+	deleteAfterDays        int
+}
+
+// parseAccessTier parses default access tier of storage account from storage class parameters
+func parseAccessTier(parameters map[string]string) (storage.AccessTier, error) {
+	for k, v := range parameters {
+		if strings.ToLower(k) == accessTierField {
+			for _, tier := range storage.PossibleAccessTierValues() {
+				if strings.EqualFold(v, string(tier)) {
+					return tier, nil
+				}
+			}
+			return "", fmt.Errorf("%s(%s) is not supported, supported value list: %v", k, v, storage.PossibleAccessTierValues())
+		}
+	}
+	return "", nil
+}
+
+// parseLifecycleOptions parses lifecycle rule settings from storage class parameters
+func parseLifecycleOptions(parameters map[string]string) (*lifecycleOptions, error) {
+	o := &lifecycleOptions{}
+	for k, v := range parameters {
+		var days *int
+		switch strings.ToLower(k) {
+		case tierToCoolAfterDaysField:
+			days = &o.tierToCoolAfterDays
+		case tierToArchiveAfterDaysField:
+			days = &o.tierToArchiveAfterDays
+		case deleteAfterDaysField:
+			days = &o.deleteAfterDays
+		default:
+			continue
+		}
+		value, err := strconv.Atoi(v)
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("invalid %s: %s, should be a positive integer", k, v)
+		}
+		*days = value
+	}
+
+	if o.tierToCoolAfterDays > 0 && o.tierToArchiveAfterDays > 0 && o.tierToCoolAfterDays >= o.tierToArchiveAfterDays {
+		return nil, fmt.Errorf("%s(%d) must be less than %s(%d)", tierToCoolAfterDaysField, o.tierToCoolAfterDays, tierToArchiveAfterDaysField, o.tierToArchiveAfterDays)
+	}
+	for _, days := range []int{o.tierToCoolAfterDays, o.tierToArchiveAfterDays} {
+		if o.deleteAfterDays > 0 && days >= o.deleteAfterDays {
+			return nil, fmt.Errorf("%s(%d) must be greater than days of tiering actions", deleteAfterDaysField, o.deleteAfterDays)
+		}
+	}
+	return o, nil
+}
+
+// isSet checks whether any lifecycle action is specified
+func (o *lifecycleOptions) isSet() bool {
+	return o.tierToCoolAfterDays > 0 || o.tierToArchiveAfterDays > 0 || o.deleteAfterDays > 0
+}
+
+// getLifecyclePrefix returns blob prefix of a volume used in lifecycle rule filter and blob restore range, e.g. container/ or container/subDir/
+func getLifecyclePrefix(containerName, subDir string) string {
+	if subDir == "" {
+		return containerName + "/"
+	}
+	return containerName + "/" + subDir + "/"
+}
+
+// getLifecycleRuleName returns lifecycle rule name of a volume, rule name only allows alphanumeric characters
+func getLifecycleRuleName(prefix string) string {
+	return fmt.Sprintf("%s%x", lifecycleRuleNamePrefix, sha256.Sum256([]byte(prefix)))[:len(lifecycleRuleNamePrefix)+32]
+}
+
+func (o *lifecycleOptions) getRule(prefix string) storage.ManagementPolicyRule {
+	daysAfterModification := func(days int) *storage.DateAfterModification {
+		if days <= 0 {
+			return nil
+		}
+		return &storage.DateAfterModification{DaysAfterModificationGreaterThan: to.Float64Ptr(float64(days))}
+	}
+	return storage.ManagementPolicyRule{
+		Enabled: to.BoolPtr(true),
+		Name:    to.StringPtr(getLifecycleRuleName(prefix)),
+		Type:    to.StringPtr(lifecycleRuleType),
+		Definition: &storage.ManagementPolicyDefinition{
+			Actions: &storage.ManagementPolicyAction{
+				BaseBlob: &storage.ManagementPolicyBaseBlob{
+					TierToCool:    daysAfterModification(o.tierToCoolAfterDays),
+					TierToArchive: daysAfterModification(o.tierToArchiveAfterDays),
+					Delete:        daysAfterModification(o.deleteAfterDays),
+				},
+			},
+			Filters: &storage.ManagementPolicyFilter{
+				PrefixMatch: &[]string{prefix},
+				BlobTypes:   &[]string{blockBlobType},
+			},
+		},
+	}
+}
+
+// ensureStorageAccountAccessTier sets default access tier of storage account if it's different
+func (d *Driver) ensureStorageAccountAccessTier(ctx context.Context, resourceGroup, accountName string, accessTier storage.AccessTier) error {
+	if d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	if account.AccountProperties != nil && account.AccountProperties.AccessTier == accessTier {
+		return nil
+	}
+
+	klog.V(2).Infof("setting access tier(%s) of storage account(%s) under rg(%s)", accessTier, accountName, resourceGroup)
+	parameters := storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{
+			AccessTier: accessTier,
+		},
+	}
+	if rerr := d.cloud.StorageAccountClient.Update(ctx, resourceGroup, accountName, parameters); rerr != nil {
+		return fmt.Errorf("failed to set access tier of storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	return nil
+}
+
+// ensureLifecycleRule adds or updates lifecycle rule of the volume prefix in management policy of storage account,
+// rules which are not created for this prefix are kept
+func (d *Driver) ensureLifecycleRule(ctx context.Context, resourceGroup, accountName, prefix string, o *lifecycleOptions) error {
+	return d.updateLifecycleRules(ctx, resourceGroup, accountName, prefix, func(rules []storage.ManagementPolicyRule) []storage.ManagementPolicyRule {
+		return append(rules, o.getRule(prefix))
+	})
+}
+
+// removeLifecycleRule removes lifecycle rule of the volume prefix from management policy of storage account,
+// management policy is deleted if there is no rule left
+func (d *Driver) removeLifecycleRule(ctx context.Context, resourceGroup, accountName, prefix string) error {
+	return d.updateLifecycleRules(ctx, resourceGroup, accountName, prefix, func(rules []storage.ManagementPolicyRule) []storage.ManagementPolicyRule {
+		return rules
+	})
+}
+
+// updateLifecycleRules removes the rule of prefix from management policy, and updates management policy with rules returned by update
+func (d *Driver) updateLifecycleRules(ctx context.Context, resourceGroup, accountName, prefix string, update func([]storage.ManagementPolicyRule) []storage.ManagementPolicyRule) error {
+	if d.managementPoliciesClient == nil {
+		return fmt.Errorf("management policies client is not initialized")
+	}
+	// management policy is shared by all volumes in the storage account
+	lockKey := "managementpolicy" + resourceGroup + accountName
+	d.volLockMap.LockEntry(lockKey)
+	defer d.volLockMap.UnlockEntry(lockKey)
+
+	var existingRules []storage.ManagementPolicyRule
+	policy, err := d.managementPoliciesClient.Get(ctx, resourceGroup, accountName)
+	if err != nil && !isNotFoundError(err) {
+		return fmt.Errorf("failed to get management policy of storage account(%s): %v", accountName, err)
+	}
+	if err == nil && policy.ManagementPolicyProperties != nil && policy.Policy != nil && policy.Policy.Rules != nil {
+		existingRules = *policy.Policy.Rules
+	}
+
+	ruleName := getLifecycleRuleName(prefix)
+	rules := []storage.ManagementPolicyRule{}
+	for _, rule := range existingRules {
+		if to.String(rule.Name) != ruleName {
+			rules = append(rules, rule)
+		}
+	}
+	rules = update(rules)
+	if len(rules) == len(existingRules) && !containsRule(existingRules, ruleName) {
+		// rule of prefix does not exist and is not added
+		return nil
+	}
+	if len(rules) > maxLifecycleRules {
+		return status.Errorf(codes.ResourceExhausted, "management policy of storage account(%s) already has %d rules, rule of prefix(%s) could not be added since at most %d rules are allowed, specify another storage account by %s",
+			accountName, len(existingRules), prefix, maxLifecycleRules, storageAccountField)
+	}
+
+	if len(rules) == 0 {
+		klog.V(2).Infof("deleting management policy of storage account(%s) since rule(%s) of prefix(%s) is the last rule", accountName, ruleName, prefix)
+		if _, err := d.managementPoliciesClient.Delete(ctx, resourceGroup, accountName); err != nil {
+			return fmt.Errorf("failed to delete management policy of storage account(%s): %v", accountName, err)
+		}
+		return nil
+	}
+
+	klog.V(2).Infof("updating management policy of storage account(%s) with %d rules, rule(%s) of prefix(%s) is updated", accountName, len(rules), ruleName, prefix)
+	policy = storage.ManagementPolicy{
+		ManagementPolicyProperties: &storage.ManagementPolicyProperties{
+			Policy: &storage.ManagementPolicySchema{Rules: &rules},
+		},
+	}
+	if _, err := d.managementPoliciesClient.CreateOrUpdate(ctx, resourceGroup, accountName, policy); err != nil {
+		return fmt.Errorf("failed to update management policy of storage account(%s): %v", accountName, err)
+	}
+	return nil
+}
+
+// removeVolumeLifecycleRule removes lifecycle rule of a deleted volume, it's skipped if storage account is provided by secrets
+func (d *Driver) removeVolumeLifecycleRule(ctx context.Context, resourceGroup, accountName, containerName, subDir string, secrets map[string]string) error {
+	if len(secrets) > 0 || d.managementPoliciesClient == nil {
+		return nil
+	}
+	if err := d.removeLifecycleRule(ctx, resourceGroup, accountName, getLifecyclePrefix(containerName, subDir)); err != nil {
+		return status.Errorf(codes.Internal, "failed to remove lifecycle rule of container(%s): %v", containerName, err)
+	}
+	return nil
+}
+
+func containsRule(rules []storage.ManagementPolicyRule, ruleName string) bool {
+	for _, rule := range rules {
+		if to.String(rule.Name) == ruleName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/blob/mockstorageapi"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestParseAccessTier(t *testing.T) {
+	tier, err := parseAccessTier(map[string]string{"accessTier": "cool"})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.AccessTierCool, tier)
+
+	tier, err = parseAccessTier(map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.AccessTier(""), tier)
+
+	_, err = parseAccessTier(map[string]string{"accessTier": "Archive"})
+	assert.Error(t, err)
+}
+
+func TestParseLifecycleOptions(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parameters map[string]string
+		expected   *lifecycleOptions
+		expectErr  bool
+	}{
+		{
+			desc:       "no lifecycle options",
+			parameters: map[string]string{},
+			expected:   &lifecycleOptions{},
+		},
+		{
+			desc:       "all lifecycle options",
+			parameters: map[string]string{"tierToCoolAfterDays": "30", "tierToArchiveAfterDays": "90", "deleteAfterDays": "365"},
+			expected:   &lifecycleOptions{tierToCoolAfterDays: 30, tierToArchiveAfterDays: 90, deleteAfterDays: 365},
+		},
+		{
+			desc:       "invalid days",
+			parameters: map[string]string{"deleteAfterDays": "0"},
+			expectErr:  true,
+		},
+		{
+			desc:       "archive before cool",
+			parameters: map[string]string{"tierToCoolAfterDays": "90", "tierToArchiveAfterDays": "30"},
+			expectErr:  true,
+		},
+		{
+			desc:       "delete before archive",
+			parameters: map[string]string{"tierToArchiveAfterDays": "90", "deleteAfterDays": "30"},
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseLifecycleOptions(test.parameters)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestGetLifecycleRuleName(t *testing.T) {
+	assert.Equal(t, "container/", getLifecyclePrefix("container", ""))
+	assert.Equal(t, "container/dir/pvc/", getLifecyclePrefix("container", "dir/pvc"))
+
+	name := getLifecycleRuleName("container/")
+	assert.Regexp(t, regexp.MustCompile("^blobcsi[0-9a-f]{32}$"), name)
+	assert.Equal(t, name, getLifecycleRuleName("container/"))
+	assert.NotEqual(t, name, getLifecycleRuleName("container/dir/"))
+}
+
+func TestEnsureLifecycleRule(t *testing.T) {
+	d := NewFakeDriver()
+	options := &lifecycleOptions{tierToCoolAfterDays: 30, deleteAfterDays: 365}
+	assert.Error(t, d.ensureLifecycleRule(context.TODO(), "rg", "account", "container1/", options))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPoliciesClient := mockstorageapi.NewMockManagementPoliciesClientAPI(ctrl)
+	d.managementPoliciesClient = mockPoliciesClient
+	notFound := autorest.DetailedError{StatusCode: http.StatusNotFound}
+	newPolicy := func(rules ...storage.ManagementPolicyRule) storage.ManagementPolicy {
+		return storage.ManagementPolicy{
+			ManagementPolicyProperties: &storage.ManagementPolicyProperties{
+				Policy: &storage.ManagementPolicySchema{Rules: &rules},
+			},
+		}
+	}
+	rule1 := options.getRule("container1/")
+	rule2 := options.getRule("container2/")
+
+	// removing rule without management policy is no-op
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(storage.ManagementPolicy{}, notFound).Times(1)
+	assert.NoError(t, d.removeLifecycleRule(context.TODO(), "rg", "account", "container1/"))
+
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(storage.ManagementPolicy{}, notFound).Times(1)
+	mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account", newPolicy(rule1)).Return(storage.ManagementPolicy{}, nil).Times(1)
+	assert.NoError(t, d.ensureLifecycleRule(context.TODO(), "rg", "account", "container1/", options))
+
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(rule1), nil).Times(1)
+	mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account", newPolicy(rule1, rule2)).Return(storage.ManagementPolicy{}, nil).Times(1)
+	assert.NoError(t, d.ensureLifecycleRule(context.TODO(), "rg", "account", "container2/", options))
+
+	// rule is updated instead of added again
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(rule1, rule2), nil).Times(1)
+	mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, policy storage.ManagementPolicy) (storage.ManagementPolicy, error) {
+			rules := *policy.Policy.Rules
+			assert.Equal(t, 2, len(rules))
+			assert.Equal(t, rule2, rules[0])
+			baseBlob := rules[1].Definition.Actions.BaseBlob
+			assert.Equal(t, getLifecycleRuleName("container1/"), to.String(rules[1].Name))
+			assert.Nil(t, baseBlob.TierToCool)
+			assert.Equal(t, float64(7), to.Float64(baseBlob.Delete.DaysAfterModificationGreaterThan))
+			assert.Equal(t, []string{"container1/"}, *rules[1].Definition.Filters.PrefixMatch)
+			return policy, nil
+		}).Times(1)
+	assert.NoError(t, d.ensureLifecycleRule(context.TODO(), "rg", "account", "container1/", &lifecycleOptions{deleteAfterDays: 7}))
+
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(rule1, rule2), nil).Times(1)
+	mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account", newPolicy(rule2)).Return(storage.ManagementPolicy{}, nil).Times(1)
+	assert.NoError(t, d.removeLifecycleRule(context.TODO(), "rg", "account", "container1/"))
+
+	// management policy is deleted with the last rule
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(rule2), nil).Times(1)
+	mockPoliciesClient.EXPECT().Delete(gomock.Any(), "rg", "account").Return(autorest.Response{}, nil).Times(1)
+	assert.NoError(t, d.removeLifecycleRule(context.TODO(), "rg", "account", "container2/"))
+
+	// rule is not added if management policy already has the maximum number of rules
+	fullRules := []storage.ManagementPolicyRule{}
+	for i := 0; i < maxLifecycleRules; i++ {
+		fullRules = append(fullRules, options.getRule(fmt.Sprintf("container%d/", i+3)))
+	}
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(fullRules...), nil).Times(1)
+	err := d.ensureLifecycleRule(context.TODO(), "rg", "account", "container1/", options)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// existing rule could still be updated
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(fullRules...), nil).Times(1)
+	mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account", gomock.Any()).Return(storage.ManagementPolicy{}, nil).Times(1)
+	assert.NoError(t, d.ensureLifecycleRule(context.TODO(), "rg", "account", "container3/", &lifecycleOptions{deleteAfterDays: 7}))
+
+	// rule is not removed if storage account is provided by secrets
+	assert.NoError(t, d.removeVolumeLifecycleRule(context.TODO(), "rg", "account", "container1", "", map[string]string{"accountname": "account"}))
+	mockPoliciesClient.EXPECT().Get(gomock.Any(), "rg", "account").Return(newPolicy(rule1), nil).Times(1)
+	mockPoliciesClient.EXPECT().Delete(gomock.Any(), "rg", "account").Return(autorest.Response{}, nil).Times(1)
+	assert.NoError(t, d.removeVolumeLifecycleRule(context.TODO(), "rg", "account", "container1", "", nil))
+}
+
+func TestEnsureStorageAccountAccessTier(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{StorageAccountClient: mockStorageAccountsClient}
+
+	account := storage.Account{AccountProperties: &storage.AccountProperties{AccessTier: storage.AccessTierHot}}
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "account").Return(account, nil).Times(2)
+	mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "rg", "account", storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{AccessTier: storage.AccessTierCool},
+	}).Return(nil).Times(1)
+
+	assert.NoError(t, d.ensureStorageAccountAccessTier(context.TODO(), "rg", "account", storage.AccessTierHot))
+	assert.NoError(t, d.ensureStorageAccountAccessTier(context.TODO(), "rg", "account", storage.AccessTierCool))
+}
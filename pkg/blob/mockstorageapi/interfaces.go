@@ -0,0 +1,844 @@
+// /*
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// */
+//
+
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage/storageapi (interfaces: AccountsClientAPI,BlobContainersClientAPI,BlobServicesClientAPI,EncryptionScopesClientAPI,ManagementPoliciesClientAPI,ObjectReplicationPoliciesClientAPI)
+
+// Package mockstorageapi is a generated GoMock package.
+package mockstorageapi
+
+import (
+	context "context"
+	reflect "reflect"
+
+	storage "github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	autorest "github.com/Azure/go-autorest/autorest"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockAccountsClientAPI is a mock of AccountsClientAPI interface.
+type MockAccountsClientAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccountsClientAPIMockRecorder
+}
+
+// MockAccountsClientAPIMockRecorder is the mock recorder for MockAccountsClientAPI.
+type MockAccountsClientAPIMockRecorder struct {
+	mock *MockAccountsClientAPI
+}
+
+// NewMockAccountsClientAPI creates a new mock instance.
+func NewMockAccountsClientAPI(ctrl *gomock.Controller) *MockAccountsClientAPI {
+	mock := &MockAccountsClientAPI{ctrl: ctrl}
+	mock.recorder = &MockAccountsClientAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccountsClientAPI) EXPECT() *MockAccountsClientAPIMockRecorder {
+	return m.recorder
+}
+
+// CheckNameAvailability mocks base method.
+func (m *MockAccountsClientAPI) CheckNameAvailability(arg0 context.Context, arg1 storage.AccountCheckNameAvailabilityParameters) (storage.CheckNameAvailabilityResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckNameAvailability", arg0, arg1)
+	ret0, _ := ret[0].(storage.CheckNameAvailabilityResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckNameAvailability indicates an expected call of CheckNameAvailability.
+func (mr *MockAccountsClientAPIMockRecorder) CheckNameAvailability(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckNameAvailability", reflect.TypeOf((*MockAccountsClientAPI)(nil).CheckNameAvailability), arg0, arg1)
+}
+
+// Create mocks base method.
+func (m *MockAccountsClientAPI) Create(arg0 context.Context, arg1, arg2 string, arg3 storage.AccountCreateParameters) (storage.AccountsCreateFuture, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.AccountsCreateFuture)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockAccountsClientAPIMockRecorder) Create(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAccountsClientAPI)(nil).Create), arg0, arg1, arg2, arg3)
+}
+
+// Delete mocks base method.
+func (m *MockAccountsClientAPI) Delete(arg0 context.Context, arg1, arg2 string) (autorest.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1, arg2)
+	ret0, _ := ret[0].(autorest.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockAccountsClientAPIMockRecorder) Delete(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAccountsClientAPI)(nil).Delete), arg0, arg1, arg2)
+}
+
+// Failover mocks base method.
+func (m *MockAccountsClientAPI) Failover(arg0 context.Context, arg1, arg2 string) (storage.AccountsFailoverFuture, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Failover", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.AccountsFailoverFuture)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Failover indicates an expected call of Failover.
+func (mr *MockAccountsClientAPIMockRecorder) Failover(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Failover", reflect.TypeOf((*MockAccountsClientAPI)(nil).Failover), arg0, arg1, arg2)
+}
+
+// GetProperties mocks base method.
+func (m *MockAccountsClientAPI) GetProperties(arg0 context.Context, arg1, arg2 string, arg3 storage.AccountExpand) (storage.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProperties", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProperties indicates an expected call of GetProperties.
+func (mr *MockAccountsClientAPIMockRecorder) GetProperties(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProperties", reflect.TypeOf((*MockAccountsClientAPI)(nil).GetProperties), arg0, arg1, arg2, arg3)
+}
+
+// List mocks base method.
+func (m *MockAccountsClientAPI) List(arg0 context.Context) (storage.AccountListResultPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", arg0)
+	ret0, _ := ret[0].(storage.AccountListResultPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockAccountsClientAPIMockRecorder) List(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockAccountsClientAPI)(nil).List), arg0)
+}
+
+// ListAccountSAS mocks base method.
+func (m *MockAccountsClientAPI) ListAccountSAS(arg0 context.Context, arg1, arg2 string, arg3 storage.AccountSasParameters) (storage.ListAccountSasResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAccountSAS", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.ListAccountSasResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAccountSAS indicates an expected call of ListAccountSAS.
+func (mr *MockAccountsClientAPIMockRecorder) ListAccountSAS(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAccountSAS", reflect.TypeOf((*MockAccountsClientAPI)(nil).ListAccountSAS), arg0, arg1, arg2, arg3)
+}
+
+// ListByResourceGroup mocks base method.
+func (m *MockAccountsClientAPI) ListByResourceGroup(arg0 context.Context, arg1 string) (storage.AccountListResultPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByResourceGroup", arg0, arg1)
+	ret0, _ := ret[0].(storage.AccountListResultPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByResourceGroup indicates an expected call of ListByResourceGroup.
+func (mr *MockAccountsClientAPIMockRecorder) ListByResourceGroup(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByResourceGroup", reflect.TypeOf((*MockAccountsClientAPI)(nil).ListByResourceGroup), arg0, arg1)
+}
+
+// ListByResourceGroupComplete mocks base method.
+func (m *MockAccountsClientAPI) ListByResourceGroupComplete(arg0 context.Context, arg1 string) (storage.AccountListResultIterator, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByResourceGroupComplete", arg0, arg1)
+	ret0, _ := ret[0].(storage.AccountListResultIterator)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByResourceGroupComplete indicates an expected call of ListByResourceGroupComplete.
+func (mr *MockAccountsClientAPIMockRecorder) ListByResourceGroupComplete(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByResourceGroupComplete", reflect.TypeOf((*MockAccountsClientAPI)(nil).ListByResourceGroupComplete), arg0, arg1)
+}
+
+// ListComplete mocks base method.
+func (m *MockAccountsClientAPI) ListComplete(arg0 context.Context) (storage.AccountListResultIterator, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListComplete", arg0)
+	ret0, _ := ret[0].(storage.AccountListResultIterator)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListComplete indicates an expected call of ListComplete.
+func (mr *MockAccountsClientAPIMockRecorder) ListComplete(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListComplete", reflect.TypeOf((*MockAccountsClientAPI)(nil).ListComplete), arg0)
+}
+
+// ListKeys mocks base method.
+func (m *MockAccountsClientAPI) ListKeys(arg0 context.Context, arg1, arg2 string, arg3 storage.ListKeyExpand) (storage.AccountListKeysResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListKeys", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.AccountListKeysResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListKeys indicates an expected call of ListKeys.
+func (mr *MockAccountsClientAPIMockRecorder) ListKeys(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListKeys", reflect.TypeOf((*MockAccountsClientAPI)(nil).ListKeys), arg0, arg1, arg2, arg3)
+}
+
+// ListServiceSAS mocks base method.
+func (m *MockAccountsClientAPI) ListServiceSAS(arg0 context.Context, arg1, arg2 string, arg3 storage.ServiceSasParameters) (storage.ListServiceSasResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListServiceSAS", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.ListServiceSasResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListServiceSAS indicates an expected call of ListServiceSAS.
+func (mr *MockAccountsClientAPIMockRecorder) ListServiceSAS(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListServiceSAS", reflect.TypeOf((*MockAccountsClientAPI)(nil).ListServiceSAS), arg0, arg1, arg2, arg3)
+}
+
+// RegenerateKey mocks base method.
+func (m *MockAccountsClientAPI) RegenerateKey(arg0 context.Context, arg1, arg2 string, arg3 storage.AccountRegenerateKeyParameters) (storage.AccountListKeysResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegenerateKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.AccountListKeysResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegenerateKey indicates an expected call of RegenerateKey.
+func (mr *MockAccountsClientAPIMockRecorder) RegenerateKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegenerateKey", reflect.TypeOf((*MockAccountsClientAPI)(nil).RegenerateKey), arg0, arg1, arg2, arg3)
+}
+
+// RestoreBlobRanges mocks base method.
+func (m *MockAccountsClientAPI) RestoreBlobRanges(arg0 context.Context, arg1, arg2 string, arg3 storage.BlobRestoreParameters) (storage.AccountsRestoreBlobRangesFuture, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreBlobRanges", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.AccountsRestoreBlobRangesFuture)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreBlobRanges indicates an expected call of RestoreBlobRanges.
+func (mr *MockAccountsClientAPIMockRecorder) RestoreBlobRanges(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreBlobRanges", reflect.TypeOf((*MockAccountsClientAPI)(nil).RestoreBlobRanges), arg0, arg1, arg2, arg3)
+}
+
+// RevokeUserDelegationKeys mocks base method.
+func (m *MockAccountsClientAPI) RevokeUserDelegationKeys(arg0 context.Context, arg1, arg2 string) (autorest.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserDelegationKeys", arg0, arg1, arg2)
+	ret0, _ := ret[0].(autorest.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeUserDelegationKeys indicates an expected call of RevokeUserDelegationKeys.
+func (mr *MockAccountsClientAPIMockRecorder) RevokeUserDelegationKeys(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserDelegationKeys", reflect.TypeOf((*MockAccountsClientAPI)(nil).RevokeUserDelegationKeys), arg0, arg1, arg2)
+}
+
+// Update mocks base method.
+func (m *MockAccountsClientAPI) Update(arg0 context.Context, arg1, arg2 string, arg3 storage.AccountUpdateParameters) (storage.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockAccountsClientAPIMockRecorder) Update(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockAccountsClientAPI)(nil).Update), arg0, arg1, arg2, arg3)
+}
+
+// MockBlobContainersClientAPI is a mock of BlobContainersClientAPI interface.
+type MockBlobContainersClientAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockBlobContainersClientAPIMockRecorder
+}
+
+// MockBlobContainersClientAPIMockRecorder is the mock recorder for MockBlobContainersClientAPI.
+type MockBlobContainersClientAPIMockRecorder struct {
+	mock *MockBlobContainersClientAPI
+}
+
+// NewMockBlobContainersClientAPI creates a new mock instance.
+func NewMockBlobContainersClientAPI(ctrl *gomock.Controller) *MockBlobContainersClientAPI {
+	mock := &MockBlobContainersClientAPI{ctrl: ctrl}
+	mock.recorder = &MockBlobContainersClientAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBlobContainersClientAPI) EXPECT() *MockBlobContainersClientAPIMockRecorder {
+	return m.recorder
+}
+
+// ClearLegalHold mocks base method.
+func (m *MockBlobContainersClientAPI) ClearLegalHold(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.LegalHold) (storage.LegalHold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearLegalHold", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.LegalHold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClearLegalHold indicates an expected call of ClearLegalHold.
+func (mr *MockBlobContainersClientAPIMockRecorder) ClearLegalHold(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearLegalHold", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).ClearLegalHold), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Create mocks base method.
+func (m *MockBlobContainersClientAPI) Create(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.BlobContainer) (storage.BlobContainer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.BlobContainer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockBlobContainersClientAPIMockRecorder) Create(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).Create), arg0, arg1, arg2, arg3, arg4)
+}
+
+// CreateOrUpdateImmutabilityPolicy mocks base method.
+func (m *MockBlobContainersClientAPI) CreateOrUpdateImmutabilityPolicy(arg0 context.Context, arg1, arg2, arg3 string, arg4 *storage.ImmutabilityPolicy, arg5 string) (storage.ImmutabilityPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOrUpdateImmutabilityPolicy", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(storage.ImmutabilityPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateOrUpdateImmutabilityPolicy indicates an expected call of CreateOrUpdateImmutabilityPolicy.
+func (mr *MockBlobContainersClientAPIMockRecorder) CreateOrUpdateImmutabilityPolicy(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrUpdateImmutabilityPolicy", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).CreateOrUpdateImmutabilityPolicy), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// Delete mocks base method.
+func (m *MockBlobContainersClientAPI) Delete(arg0 context.Context, arg1, arg2, arg3 string) (autorest.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(autorest.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockBlobContainersClientAPIMockRecorder) Delete(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).Delete), arg0, arg1, arg2, arg3)
+}
+
+// DeleteImmutabilityPolicy mocks base method.
+func (m *MockBlobContainersClientAPI) DeleteImmutabilityPolicy(arg0 context.Context, arg1, arg2, arg3, arg4 string) (storage.ImmutabilityPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteImmutabilityPolicy", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.ImmutabilityPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteImmutabilityPolicy indicates an expected call of DeleteImmutabilityPolicy.
+func (mr *MockBlobContainersClientAPIMockRecorder) DeleteImmutabilityPolicy(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteImmutabilityPolicy", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).DeleteImmutabilityPolicy), arg0, arg1, arg2, arg3, arg4)
+}
+
+// ExtendImmutabilityPolicy mocks base method.
+func (m *MockBlobContainersClientAPI) ExtendImmutabilityPolicy(arg0 context.Context, arg1, arg2, arg3, arg4 string, arg5 *storage.ImmutabilityPolicy) (storage.ImmutabilityPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExtendImmutabilityPolicy", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(storage.ImmutabilityPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExtendImmutabilityPolicy indicates an expected call of ExtendImmutabilityPolicy.
+func (mr *MockBlobContainersClientAPIMockRecorder) ExtendImmutabilityPolicy(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExtendImmutabilityPolicy", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).ExtendImmutabilityPolicy), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// Get mocks base method.
+func (m *MockBlobContainersClientAPI) Get(arg0 context.Context, arg1, arg2, arg3 string) (storage.BlobContainer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.BlobContainer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockBlobContainersClientAPIMockRecorder) Get(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).Get), arg0, arg1, arg2, arg3)
+}
+
+// GetImmutabilityPolicy mocks base method.
+func (m *MockBlobContainersClientAPI) GetImmutabilityPolicy(arg0 context.Context, arg1, arg2, arg3, arg4 string) (storage.ImmutabilityPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetImmutabilityPolicy", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.ImmutabilityPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetImmutabilityPolicy indicates an expected call of GetImmutabilityPolicy.
+func (mr *MockBlobContainersClientAPIMockRecorder) GetImmutabilityPolicy(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImmutabilityPolicy", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).GetImmutabilityPolicy), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Lease mocks base method.
+func (m *MockBlobContainersClientAPI) Lease(arg0 context.Context, arg1, arg2, arg3 string, arg4 *storage.LeaseContainerRequest) (storage.LeaseContainerResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lease", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.LeaseContainerResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lease indicates an expected call of Lease.
+func (mr *MockBlobContainersClientAPIMockRecorder) Lease(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lease", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).Lease), arg0, arg1, arg2, arg3, arg4)
+}
+
+// List mocks base method.
+func (m *MockBlobContainersClientAPI) List(arg0 context.Context, arg1, arg2, arg3, arg4 string, arg5 storage.ListContainersInclude) (storage.ListContainerItemsPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(storage.ListContainerItemsPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockBlobContainersClientAPIMockRecorder) List(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).List), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// ListComplete mocks base method.
+func (m *MockBlobContainersClientAPI) ListComplete(arg0 context.Context, arg1, arg2, arg3, arg4 string, arg5 storage.ListContainersInclude) (storage.ListContainerItemsIterator, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListComplete", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(storage.ListContainerItemsIterator)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListComplete indicates an expected call of ListComplete.
+func (mr *MockBlobContainersClientAPIMockRecorder) ListComplete(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListComplete", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).ListComplete), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// LockImmutabilityPolicy mocks base method.
+func (m *MockBlobContainersClientAPI) LockImmutabilityPolicy(arg0 context.Context, arg1, arg2, arg3, arg4 string) (storage.ImmutabilityPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockImmutabilityPolicy", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.ImmutabilityPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockImmutabilityPolicy indicates an expected call of LockImmutabilityPolicy.
+func (mr *MockBlobContainersClientAPIMockRecorder) LockImmutabilityPolicy(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockImmutabilityPolicy", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).LockImmutabilityPolicy), arg0, arg1, arg2, arg3, arg4)
+}
+
+// SetLegalHold mocks base method.
+func (m *MockBlobContainersClientAPI) SetLegalHold(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.LegalHold) (storage.LegalHold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLegalHold", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.LegalHold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetLegalHold indicates an expected call of SetLegalHold.
+func (mr *MockBlobContainersClientAPIMockRecorder) SetLegalHold(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLegalHold", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).SetLegalHold), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Update mocks base method.
+func (m *MockBlobContainersClientAPI) Update(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.BlobContainer) (storage.BlobContainer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.BlobContainer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockBlobContainersClientAPIMockRecorder) Update(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockBlobContainersClientAPI)(nil).Update), arg0, arg1, arg2, arg3, arg4)
+}
+
+// MockBlobServicesClientAPI is a mock of BlobServicesClientAPI interface.
+type MockBlobServicesClientAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockBlobServicesClientAPIMockRecorder
+}
+
+// MockBlobServicesClientAPIMockRecorder is the mock recorder for MockBlobServicesClientAPI.
+type MockBlobServicesClientAPIMockRecorder struct {
+	mock *MockBlobServicesClientAPI
+}
+
+// NewMockBlobServicesClientAPI creates a new mock instance.
+func NewMockBlobServicesClientAPI(ctrl *gomock.Controller) *MockBlobServicesClientAPI {
+	mock := &MockBlobServicesClientAPI{ctrl: ctrl}
+	mock.recorder = &MockBlobServicesClientAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBlobServicesClientAPI) EXPECT() *MockBlobServicesClientAPIMockRecorder {
+	return m.recorder
+}
+
+// GetServiceProperties mocks base method.
+func (m *MockBlobServicesClientAPI) GetServiceProperties(arg0 context.Context, arg1, arg2 string) (storage.BlobServiceProperties, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetServiceProperties", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.BlobServiceProperties)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetServiceProperties indicates an expected call of GetServiceProperties.
+func (mr *MockBlobServicesClientAPIMockRecorder) GetServiceProperties(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetServiceProperties", reflect.TypeOf((*MockBlobServicesClientAPI)(nil).GetServiceProperties), arg0, arg1, arg2)
+}
+
+// List mocks base method.
+func (m *MockBlobServicesClientAPI) List(arg0 context.Context, arg1, arg2 string) (storage.BlobServiceItems, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.BlobServiceItems)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockBlobServicesClientAPIMockRecorder) List(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockBlobServicesClientAPI)(nil).List), arg0, arg1, arg2)
+}
+
+// SetServiceProperties mocks base method.
+func (m *MockBlobServicesClientAPI) SetServiceProperties(arg0 context.Context, arg1, arg2 string, arg3 storage.BlobServiceProperties) (storage.BlobServiceProperties, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetServiceProperties", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.BlobServiceProperties)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetServiceProperties indicates an expected call of SetServiceProperties.
+func (mr *MockBlobServicesClientAPIMockRecorder) SetServiceProperties(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetServiceProperties", reflect.TypeOf((*MockBlobServicesClientAPI)(nil).SetServiceProperties), arg0, arg1, arg2, arg3)
+}
+
+// MockEncryptionScopesClientAPI is a mock of EncryptionScopesClientAPI interface.
+type MockEncryptionScopesClientAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockEncryptionScopesClientAPIMockRecorder
+}
+
+// MockEncryptionScopesClientAPIMockRecorder is the mock recorder for MockEncryptionScopesClientAPI.
+type MockEncryptionScopesClientAPIMockRecorder struct {
+	mock *MockEncryptionScopesClientAPI
+}
+
+// NewMockEncryptionScopesClientAPI creates a new mock instance.
+func NewMockEncryptionScopesClientAPI(ctrl *gomock.Controller) *MockEncryptionScopesClientAPI {
+	mock := &MockEncryptionScopesClientAPI{ctrl: ctrl}
+	mock.recorder = &MockEncryptionScopesClientAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockEncryptionScopesClientAPI) EXPECT() *MockEncryptionScopesClientAPIMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method.
+func (m *MockEncryptionScopesClientAPI) Get(arg0 context.Context, arg1, arg2, arg3 string) (storage.EncryptionScope, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.EncryptionScope)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockEncryptionScopesClientAPIMockRecorder) Get(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockEncryptionScopesClientAPI)(nil).Get), arg0, arg1, arg2, arg3)
+}
+
+// List mocks base method.
+func (m *MockEncryptionScopesClientAPI) List(arg0 context.Context, arg1, arg2 string) (storage.EncryptionScopeListResultPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.EncryptionScopeListResultPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockEncryptionScopesClientAPIMockRecorder) List(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockEncryptionScopesClientAPI)(nil).List), arg0, arg1, arg2)
+}
+
+// ListComplete mocks base method.
+func (m *MockEncryptionScopesClientAPI) ListComplete(arg0 context.Context, arg1, arg2 string) (storage.EncryptionScopeListResultIterator, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListComplete", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.EncryptionScopeListResultIterator)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListComplete indicates an expected call of ListComplete.
+func (mr *MockEncryptionScopesClientAPIMockRecorder) ListComplete(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListComplete", reflect.TypeOf((*MockEncryptionScopesClientAPI)(nil).ListComplete), arg0, arg1, arg2)
+}
+
+// Patch mocks base method.
+func (m *MockEncryptionScopesClientAPI) Patch(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.EncryptionScope) (storage.EncryptionScope, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Patch", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.EncryptionScope)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Patch indicates an expected call of Patch.
+func (mr *MockEncryptionScopesClientAPIMockRecorder) Patch(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockEncryptionScopesClientAPI)(nil).Patch), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Put mocks base method.
+func (m *MockEncryptionScopesClientAPI) Put(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.EncryptionScope) (storage.EncryptionScope, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Put", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.EncryptionScope)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockEncryptionScopesClientAPIMockRecorder) Put(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockEncryptionScopesClientAPI)(nil).Put), arg0, arg1, arg2, arg3, arg4)
+}
+
+// MockManagementPoliciesClientAPI is a mock of ManagementPoliciesClientAPI interface.
+type MockManagementPoliciesClientAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockManagementPoliciesClientAPIMockRecorder
+}
+
+// MockManagementPoliciesClientAPIMockRecorder is the mock recorder for MockManagementPoliciesClientAPI.
+type MockManagementPoliciesClientAPIMockRecorder struct {
+	mock *MockManagementPoliciesClientAPI
+}
+
+// NewMockManagementPoliciesClientAPI creates a new mock instance.
+func NewMockManagementPoliciesClientAPI(ctrl *gomock.Controller) *MockManagementPoliciesClientAPI {
+	mock := &MockManagementPoliciesClientAPI{ctrl: ctrl}
+	mock.recorder = &MockManagementPoliciesClientAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockManagementPoliciesClientAPI) EXPECT() *MockManagementPoliciesClientAPIMockRecorder {
+	return m.recorder
+}
+
+// CreateOrUpdate mocks base method.
+func (m *MockManagementPoliciesClientAPI) CreateOrUpdate(arg0 context.Context, arg1, arg2 string, arg3 storage.ManagementPolicy) (storage.ManagementPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOrUpdate", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.ManagementPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateOrUpdate indicates an expected call of CreateOrUpdate.
+func (mr *MockManagementPoliciesClientAPIMockRecorder) CreateOrUpdate(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrUpdate", reflect.TypeOf((*MockManagementPoliciesClientAPI)(nil).CreateOrUpdate), arg0, arg1, arg2, arg3)
+}
+
+// Delete mocks base method.
+func (m *MockManagementPoliciesClientAPI) Delete(arg0 context.Context, arg1, arg2 string) (autorest.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1, arg2)
+	ret0, _ := ret[0].(autorest.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockManagementPoliciesClientAPIMockRecorder) Delete(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockManagementPoliciesClientAPI)(nil).Delete), arg0, arg1, arg2)
+}
+
+// Get mocks base method.
+func (m *MockManagementPoliciesClientAPI) Get(arg0 context.Context, arg1, arg2 string) (storage.ManagementPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.ManagementPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockManagementPoliciesClientAPIMockRecorder) Get(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockManagementPoliciesClientAPI)(nil).Get), arg0, arg1, arg2)
+}
+
+// MockObjectReplicationPoliciesClientAPI is a mock of ObjectReplicationPoliciesClientAPI interface.
+type MockObjectReplicationPoliciesClientAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockObjectReplicationPoliciesClientAPIMockRecorder
+}
+
+// MockObjectReplicationPoliciesClientAPIMockRecorder is the mock recorder for MockObjectReplicationPoliciesClientAPI.
+type MockObjectReplicationPoliciesClientAPIMockRecorder struct {
+	mock *MockObjectReplicationPoliciesClientAPI
+}
+
+// NewMockObjectReplicationPoliciesClientAPI creates a new mock instance.
+func NewMockObjectReplicationPoliciesClientAPI(ctrl *gomock.Controller) *MockObjectReplicationPoliciesClientAPI {
+	mock := &MockObjectReplicationPoliciesClientAPI{ctrl: ctrl}
+	mock.recorder = &MockObjectReplicationPoliciesClientAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockObjectReplicationPoliciesClientAPI) EXPECT() *MockObjectReplicationPoliciesClientAPIMockRecorder {
+	return m.recorder
+}
+
+// CreateOrUpdate mocks base method.
+func (m *MockObjectReplicationPoliciesClientAPI) CreateOrUpdate(arg0 context.Context, arg1, arg2, arg3 string, arg4 storage.ObjectReplicationPolicy) (storage.ObjectReplicationPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOrUpdate", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(storage.ObjectReplicationPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateOrUpdate indicates an expected call of CreateOrUpdate.
+func (mr *MockObjectReplicationPoliciesClientAPIMockRecorder) CreateOrUpdate(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrUpdate", reflect.TypeOf((*MockObjectReplicationPoliciesClientAPI)(nil).CreateOrUpdate), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Delete mocks base method.
+func (m *MockObjectReplicationPoliciesClientAPI) Delete(arg0 context.Context, arg1, arg2, arg3 string) (autorest.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(autorest.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockObjectReplicationPoliciesClientAPIMockRecorder) Delete(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockObjectReplicationPoliciesClientAPI)(nil).Delete), arg0, arg1, arg2, arg3)
+}
+
+// Get mocks base method.
+func (m *MockObjectReplicationPoliciesClientAPI) Get(arg0 context.Context, arg1, arg2, arg3 string) (storage.ObjectReplicationPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(storage.ObjectReplicationPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockObjectReplicationPoliciesClientAPIMockRecorder) Get(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockObjectReplicationPoliciesClientAPI)(nil).Get), arg0, arg1, arg2, arg3)
+}
+
+// List mocks base method.
+func (m *MockObjectReplicationPoliciesClientAPI) List(arg0 context.Context, arg1, arg2 string) (storage.ObjectReplicationPolicies, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", arg0, arg1, arg2)
+	ret0, _ := ret[0].(storage.ObjectReplicationPolicies)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockObjectReplicationPoliciesClientAPIMockRecorder) List(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockObjectReplicationPoliciesClientAPI)(nil).List), arg0, arg1, arg2)
+}
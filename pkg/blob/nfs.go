@@ -0,0 +1,303 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	rootModeField           = "rootmode"
+	rootUIDField            = "rootuid"
+	rootGIDField            = "rootgid"
+	skipRootPermissionField = "skiprootpermission"
+	skipNFSServerCheckField = "skipnfsservercheck"
+
+	// file in NFSv3 root folder which records the permission applied on first mount
+	nfsRootPermissionMarkerFile = ".blob-csi-root-permission"
+
+	// NFSv3 root folder is accessible by everyone by default
+	defaultNFSRootMode = os.FileMode(0777)
+	// NFSv3 root folder is owned by volume mount group with setgid bit
+	defaultNFSRootGroupMode = os.FileMode(0770) | os.ModeSetgid
+
+	// See https://docs.microsoft.com/en-us/azure/storage/blobs/network-file-system-protocol-support-how-to#step-5-mount-the-container
+	nfsPort         = "2049"
+	portmapperPort  = "111"
+	maxNFSConnect   = 16
+	minNFSIOSize    = 4096
+	maxNFSIOSize    = 1048576
+	nfsCheckTimeout = 5 * time.Second
+)
+
+var (
+	// lookupHost and dialTimeout could be replaced in unit tests
+	lookupHost  = net.LookupHost
+	dialTimeout = net.DialTimeout
+
+	// mount errors which could be recovered by retrying mount
+	retriableNFSMountErrors = []string{"timed out", "connection refused", "no route to host", "network is unreachable"}
+)
+
+// nfsRootPermission defines ownership and permission of NFSv3 root folder
+type nfsRootPermission struct {
+	mode os.FileMode
+	// uid and gid are -1 if ownership should not be changed
+	uid int
+	gid int
+	// skip does not change ownership and permission of root folder
+	skip bool
+}
+
+func (p *nfsRootPermission) String() string {
+	return fmt.Sprintf("mode=%04o uid=%d gid=%d", unixPermissionBits(p.mode), p.uid, p.gid)
+}
+
+// parseNFSRootPermission parses NFSv3 root folder permission from volume attributes or storage class parameters,
+// root folder is owned by volumeMountGroup if rootGID is not specified
+func parseNFSRootPermission(attrib map[string]string, volumeMountGroup string) (*nfsRootPermission, error) {
+	perm := &nfsRootPermission{uid: -1, gid: -1}
+	var modeSpecified bool
+	for k, v := range attrib {
+		switch strings.ToLower(k) {
+		case rootModeField:
+			mode, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || mode > 07777 {
+				return nil, fmt.Errorf("invalid %s: %s, should be an octal file mode, e.g. 0755", k, v)
+			}
+			perm.mode = fileModeFromUnix(uint32(mode))
+			modeSpecified = true
+		case rootUIDField:
+			uid, err := strconv.Atoi(v)
+			if err != nil || uid < 0 {
+				return nil, fmt.Errorf("invalid %s: %s, should be a non-negative integer", k, v)
+			}
+			perm.uid = uid
+		case rootGIDField:
+			gid, err := strconv.Atoi(v)
+			if err != nil || gid < 0 {
+				return nil, fmt.Errorf("invalid %s: %s, should be a non-negative integer", k, v)
+			}
+			perm.gid = gid
+		case skipRootPermissionField:
+			skip, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			perm.skip = skip
+		}
+	}
+
+	if perm.gid < 0 && volumeMountGroup != "" {
+		gid, err := strconv.Atoi(volumeMountGroup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VolumeMountGroup(%s): %v", volumeMountGroup, err)
+		}
+		perm.gid = gid
+		if !modeSpecified {
+			perm.mode = defaultNFSRootGroupMode
+			modeSpecified = true
+		}
+	}
+	if !modeSpecified {
+		perm.mode = defaultNFSRootMode
+	}
+	return perm, nil
+}
+
+// setNFSRootPermission applies ownership and permission on NFSv3 root folder on first mount,
+// a marker file is created in root folder after it's applied so that later mounts would not override changes made by workloads
+func setNFSRootPermission(targetPath string, perm *nfsRootPermission) error {
+	if perm.skip {
+		klog.V(2).Infof("skip setting permission of NFSv3 root folder(%s)", targetPath)
+		return nil
+	}
+
+	markerFile := filepath.Join(targetPath, nfsRootPermissionMarkerFile)
+	if _, err := os.Stat(markerFile); err == nil {
+		klog.V(2).Infof("permission of NFSv3 root folder(%s) is already set", targetPath)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check marker file(%s): %v", markerFile, err)
+	}
+
+	if perm.uid >= 0 || perm.gid >= 0 {
+		if err := os.Lchown(targetPath, perm.uid, perm.gid); err != nil {
+			return fmt.Errorf("Chown(%s) to %d:%d failed with %v", targetPath, perm.uid, perm.gid, err)
+		}
+	}
+	if err := os.Chmod(targetPath, perm.mode); err != nil {
+		return fmt.Errorf("Chmod(%s) failed with %v", targetPath, err)
+	}
+	if err := ioutil.WriteFile(markerFile, []byte(perm.String()), 0644); err != nil {
+		return fmt.Errorf("failed to create marker file(%s): %v", markerFile, err)
+	}
+	klog.V(2).Infof("set permission(%s) of NFSv3 root folder(%s)", perm, targetPath)
+	return nil
+}
+
+// applyNFSRootPermission sets permission of NFSv3 root folder mounted on targetPath, targetPath is unmounted if it fails,
+// so that permission is applied again on retry instead of returning early on an existing mount
+func (d *Driver) applyNFSRootPermission(targetPath string, perm *nfsRootPermission) error {
+	err := setNFSRootPermission(targetPath, perm)
+	if err == nil {
+		return nil
+	}
+	klog.Errorf("failed to set permission of NFSv3 root folder(%s): %v, unmounting it", targetPath, err)
+	if unmountErr := d.mounter.Unmount(targetPath); unmountErr != nil {
+		klog.Errorf("failed to unmount %s: %v", targetPath, unmountErr)
+	}
+	return err
+}
+
+// fileModeFromUnix converts unix permission bits into os.FileMode
+func fileModeFromUnix(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// unixPermissionBits converts os.FileMode into unix permission bits
+func unixPermissionBits(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// validateNFSMountOptions validates mount options of NFSv3 mount, e.g. "nconnect=4,rsize=1048576"
+func validateNFSMountOptions(mountOptions []string) error {
+	for _, mountOption := range mountOptions {
+		for _, option := range strings.Split(mountOption, ",") {
+			kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+			key := kv[0]
+			value := ""
+			if len(kv) == 2 {
+				value = kv[1]
+			}
+			switch key {
+			case "nconnect":
+				if n, err := strconv.Atoi(value); err != nil || n < 1 || n > maxNFSConnect {
+					return fmt.Errorf("invalid mount option %s, nconnect should be an integer between 1 and %d", option, maxNFSConnect)
+				}
+			case "rsize", "wsize":
+				if n, err := strconv.Atoi(value); err != nil || n < minNFSIOSize || n > maxNFSIOSize || n%minNFSIOSize != 0 {
+					return fmt.Errorf("invalid mount option %s, %s should be a multiple of %d between %d and %d", option, key, minNFSIOSize, minNFSIOSize, maxNFSIOSize)
+				}
+			case "actimeo", "acregmin", "acregmax", "acdirmin", "acdirmax":
+				if n, err := strconv.Atoi(value); err != nil || n < 0 {
+					return fmt.Errorf("invalid mount option %s, %s should be a non-negative integer", option, key)
+				}
+			case "vers", "nfsvers":
+				if value != "3" {
+					return fmt.Errorf("invalid mount option %s, only NFSv3 is supported", option)
+				}
+			case "sec":
+				if value != "sys" {
+					return fmt.Errorf("invalid mount option %s, only sec=sys is supported", option)
+				}
+			case "proto":
+				if value != "tcp" {
+					return fmt.Errorf("invalid mount option %s, only proto=tcp is supported", option)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkNFSServer checks DNS resolution and TCP reachability of NFS server before mount,
+// so that misconfigured private DNS zone or network rules are reported with a specific error,
+// NFS server is reachable if any of its resolved addresses is reachable
+func checkNFSServer(server string) error {
+	addrs, err := lookupHost(server)
+	if err != nil || len(addrs) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "failed to resolve NFS server %s: %v, check DNS settings(e.g. private DNS zone of private endpoint) on the node", server, err)
+	}
+	klog.V(4).Infof("NFS server %s is resolved to %v", server, addrs)
+
+	var errs []string
+	for _, addr := range addrs {
+		if err := checkNFSServerAddress(addr); err != nil {
+			klog.V(4).Infof("NFS server %s(%s) is not reachable: %v", server, addr, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		return nil
+	}
+	return status.Errorf(codes.Unavailable, "NFS server %s is not reachable: %s, check network rules of storage account, network security group and private endpoint", server, strings.Join(errs, "; "))
+}
+
+// checkNFSServerAddress checks whether NFS and portmapper ports of addr are reachable
+func checkNFSServerAddress(addr string) error {
+	for _, port := range []string{nfsPort, portmapperPort} {
+		address := net.JoinHostPort(addr, port)
+		conn, err := dialTimeout("tcp", address, nfsCheckTimeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// getNFSMountErrorCode returns gRPC error code according to NFSv3 mount error
+func getNFSMountErrorCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "access denied"), strings.Contains(msg, "permission denied"):
+		return codes.PermissionDenied
+	case strings.Contains(msg, "no such file or directory"):
+		return codes.NotFound
+	}
+	for _, retriableErr := range retriableNFSMountErrors {
+		if strings.Contains(msg, retriableErr) {
+			return codes.Unavailable
+		}
+	}
+	return codes.Internal
+}
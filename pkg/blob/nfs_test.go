@@ -0,0 +1,273 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/stretchr/testify/assert"
+
+	mount "k8s.io/mount-utils"
+)
+
+func TestParseNFSRootPermission(t *testing.T) {
+	tests := []struct {
+		desc             string
+		attrib           map[string]string
+		volumeMountGroup string
+		expected         *nfsRootPermission
+		expectErr        bool
+	}{
+		{
+			desc:     "default permission",
+			attrib:   map[string]string{},
+			expected: &nfsRootPermission{mode: 0777, uid: -1, gid: -1},
+		},
+		{
+			desc:             "volume mount group",
+			attrib:           map[string]string{},
+			volumeMountGroup: "1000",
+			expected:         &nfsRootPermission{mode: 0770 | os.ModeSetgid, uid: -1, gid: 1000},
+		},
+		{
+			desc:             "root gid overrides volume mount group",
+			attrib:           map[string]string{"rootMode": "0750", "rootUID": "1001", "rootGID": "2000"},
+			volumeMountGroup: "1000",
+			expected:         &nfsRootPermission{mode: 0750, uid: 1001, gid: 2000},
+		},
+		{
+			desc:     "sticky bit",
+			attrib:   map[string]string{"rootmode": "1777"},
+			expected: &nfsRootPermission{mode: 0777 | os.ModeSticky, uid: -1, gid: -1},
+		},
+		{
+			desc:     "skip root permission",
+			attrib:   map[string]string{"skipRootPermission": "true"},
+			expected: &nfsRootPermission{mode: 0777, uid: -1, gid: -1, skip: true},
+		},
+		{
+			desc:      "invalid root mode",
+			attrib:    map[string]string{"rootMode": "0999"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid root uid",
+			attrib:    map[string]string{"rootUID": "-1"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid root gid",
+			attrib:    map[string]string{"rootGID": "admin"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid skip root permission",
+			attrib:    map[string]string{"skipRootPermission": "yes please"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseNFSRootPermission(test.attrib, test.volumeMountGroup)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestSetNFSRootPermission(t *testing.T) {
+	targetPath, err := ioutil.TempDir("", "nfs-root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetPath)
+
+	// skip root permission does not create marker file
+	assert.NoError(t, setNFSRootPermission(targetPath, &nfsRootPermission{mode: 0777, uid: -1, gid: -1, skip: true}))
+	_, err = os.Stat(filepath.Join(targetPath, nfsRootPermissionMarkerFile))
+	assert.True(t, os.IsNotExist(err))
+
+	perm := &nfsRootPermission{mode: 0770 | os.ModeSetgid, uid: -1, gid: os.Getgid()}
+	assert.NoError(t, setNFSRootPermission(targetPath, perm))
+	info, err := os.Stat(targetPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0770), info.Mode().Perm())
+	assert.NotZero(t, info.Mode()&os.ModeSetgid)
+	content, err := ioutil.ReadFile(filepath.Join(targetPath, nfsRootPermissionMarkerFile))
+	assert.NoError(t, err)
+	assert.Equal(t, perm.String(), string(content))
+
+	// permission is only applied on first mount
+	assert.NoError(t, os.Chmod(targetPath, 0750))
+	assert.NoError(t, setNFSRootPermission(targetPath, perm))
+	info, err = os.Stat(targetPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+	assert.Error(t, setNFSRootPermission(filepath.Join(targetPath, "non-existing"), perm))
+}
+
+func TestApplyNFSRootPermission(t *testing.T) {
+	targetPath, err := ioutil.TempDir("", "nfs-root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(targetPath)
+
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "server:/account/container", Path: targetPath, Type: "nfs"}})
+	d := NewFakeDriver()
+	d.mounter = &mount.SafeFormatAndMount{Interface: fakeMounter}
+
+	perm := &nfsRootPermission{mode: 0750, uid: -1, gid: -1}
+	assert.NoError(t, d.applyNFSRootPermission(targetPath, perm))
+	mountPoints, _ := fakeMounter.List()
+	assert.Equal(t, 1, len(mountPoints))
+
+	// target path is unmounted when permission could not be applied, so it's applied again on retry
+	missingPath := filepath.Join(targetPath, "missing")
+	fakeMounter.MountPoints = []mount.MountPoint{{Device: "server:/account/container", Path: missingPath, Type: "nfs"}}
+	assert.Error(t, d.applyNFSRootPermission(missingPath, perm))
+	mountPoints, _ = fakeMounter.List()
+	assert.Empty(t, mountPoints)
+}
+
+func TestFileModeConversion(t *testing.T) {
+	for _, mode := range []uint32{0, 0755, 0777, 01777, 02770, 04755, 07777} {
+		assert.Equal(t, mode, unixPermissionBits(fileModeFromUnix(mode)))
+	}
+}
+
+func TestValidateNFSMountOptions(t *testing.T) {
+	tests := []struct {
+		mountOptions []string
+		expectErr    bool
+	}{
+		{mountOptions: nil},
+		{mountOptions: []string{"nconnect=4", "rsize=1048576,wsize=1048576", "actimeo=30", "vers=3", "sec=sys", "proto=tcp", "hard"}},
+		{mountOptions: []string{"nconnect=0"}, expectErr: true},
+		{mountOptions: []string{"nconnect=17"}, expectErr: true},
+		{mountOptions: []string{"rsize=1000"}, expectErr: true},
+		{mountOptions: []string{"rsize=4096,wsize=2097152"}, expectErr: true},
+		{mountOptions: []string{"actimeo=-1"}, expectErr: true},
+		{mountOptions: []string{"acregmax"}, expectErr: true},
+		{mountOptions: []string{"vers=4.1"}, expectErr: true},
+		{mountOptions: []string{"sec=krb5"}, expectErr: true},
+		{mountOptions: []string{"proto=udp"}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateNFSMountOptions(test.mountOptions)
+		if test.expectErr {
+			assert.Error(t, err, "%v", test.mountOptions)
+		} else {
+			assert.NoError(t, err, "%v", test.mountOptions)
+		}
+	}
+}
+
+func TestCheckNFSServer(t *testing.T) {
+	defer func() {
+		lookupHost = net.LookupHost
+		dialTimeout = net.DialTimeout
+	}()
+
+	tests := []struct {
+		desc         string
+		lookupHost   func(string) ([]string, error)
+		dialTimeout  func(string, string, time.Duration) (net.Conn, error)
+		expectedCode codes.Code
+	}{
+		{
+			desc:       "server is reachable",
+			lookupHost: func(string) ([]string, error) { return []string{"10.0.0.4"}, nil },
+			dialTimeout: func(network, address string, timeout time.Duration) (net.Conn, error) {
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			desc:         "server could not be resolved",
+			lookupHost:   func(string) ([]string, error) { return nil, fmt.Errorf("no such host") },
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:       "server is not reachable",
+			lookupHost: func(string) ([]string, error) { return []string{"10.0.0.4"}, nil },
+			dialTimeout: func(network, address string, timeout time.Duration) (net.Conn, error) {
+				return nil, fmt.Errorf("dial tcp %s: i/o timeout", address)
+			},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			desc:       "server is reachable by the second address",
+			lookupHost: func(string) ([]string, error) { return []string{"10.0.0.4", "10.0.0.5"}, nil },
+			dialTimeout: func(network, address string, timeout time.Duration) (net.Conn, error) {
+				if strings.HasPrefix(address, "10.0.0.4:") {
+					return nil, fmt.Errorf("dial tcp %s: i/o timeout", address)
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			desc:       "none of the addresses is reachable",
+			lookupHost: func(string) ([]string, error) { return []string{"10.0.0.4", "10.0.0.5"}, nil },
+			dialTimeout: func(network, address string, timeout time.Duration) (net.Conn, error) {
+				return nil, fmt.Errorf("dial tcp %s: i/o timeout", address)
+			},
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	for _, test := range tests {
+		lookupHost = test.lookupHost
+		dialTimeout = test.dialTimeout
+		err := checkNFSServer("account.blob.core.windows.net")
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+	}
+}
+
+func TestGetNFSMountErrorCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected codes.Code
+	}{
+		{err: nil, expected: codes.OK},
+		{err: fmt.Errorf("mount.nfs: access denied by server while mounting"), expected: codes.PermissionDenied},
+		{err: fmt.Errorf("mount.nfs: mounting failed, reason given by server: No such file or directory"), expected: codes.NotFound},
+		{err: fmt.Errorf("mount.nfs: Connection timed out"), expected: codes.Unavailable},
+		{err: fmt.Errorf("mount.nfs: Connection refused"), expected: codes.Unavailable},
+		{err: fmt.Errorf("unknown error"), expected: codes.Internal},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getNFSMountErrorCode(test.err), "%v", test.err)
+	}
+}
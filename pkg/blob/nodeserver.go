@@ -17,10 +17,14 @@ limitations under the License.
 package blob
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -36,6 +40,7 @@ import (
 	mount "k8s.io/mount-utils"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 
 	"golang.org/x/net/context"
@@ -44,13 +49,16 @@ import (
 )
 
 type MountClient struct {
-	service mount_azure_blob.MountServiceClient
+	service   mount_azure_blob.MountServiceClient
+	serviceV2 mount_azure_blob.MountServiceV2Client
 }
 
 // NewMountClient returns a new mount client
 func NewMountClient(cc *grpc.ClientConn) *MountClient {
-	service := mount_azure_blob.NewMountServiceClient(cc)
-	return &MountClient{service}
+	return &MountClient{
+		service:   mount_azure_blob.NewMountServiceClient(cc),
+		serviceV2: mount_azure_blob.NewMountServiceV2Client(cc),
+	}
 }
 
 // NodePublishVolume mount the volume from staging to target path
@@ -70,12 +78,28 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	}
 
 	context := req.GetVolumeContext()
+	subDir, err := getSubDir(volumeID, context)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	isNFS := isNFSProtocol(context)
+
 	if context != nil && strings.EqualFold(context[ephemeralField], trueValue) {
+		if subDir != "" && !isNFS {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported in ephemeral volume with %s protocol", subDirField, fuse)
+		}
+		for k := range context {
+			switch strings.ToLower(k) {
+			case cacheMediumField, cacheSizeMBField, cachePathField:
+				// cache medium on node is only configurable by cluster admin in storage class or persistent volume
+				return nil, status.Errorf(codes.InvalidArgument, "%s is not supported in ephemeral volume", k)
+			}
+		}
 		context[secretNamespaceField] = context[podNamespaceField]
 		// only get storage account from secret
 		context[getAccountKeyFromSecretField] = trueValue
 		context[storageAccountField] = ""
-		klog.V(2).Infof("NodePublishVolume: ephemeral volume(%s) mount on %s, VolumeContext: %v", volumeID, target, context)
+		klog.V(2).Infof("NodePublishVolume: ephemeral volume(%s) mount on %s, VolumeContext: %v", volumeID, target, volumehelper.RedactMap(context))
 		_, err := d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
 			StagingTargetPath: target,
 			VolumeContext:     context,
@@ -89,6 +113,10 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	if len(source) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Staging target not provided")
 	}
+	if subDir != "" && !isNFS {
+		// blobfuse mounts the whole container on staging path, while nfs mounts sub directory directly in NodeStageVolume
+		source = filepath.Join(source, subDir)
+	}
 
 	mountOptions := []string{"bind"}
 	if req.GetReadonly() {
@@ -104,7 +132,7 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	klog.V(2).Infof("NodePublishVolume: volume %s mounting %s at %s with mountOptions: %v", volumeID, source, target, mountOptions)
+	klog.V(2).Infof("NodePublishVolume: volume %s mounting %s at %s with mountOptions: %v", volumeID, source, target, volumehelper.RedactKeyValues(mountOptions))
 	if d.enableBlobMockMount {
 		klog.Warningf("NodePublishVolume: mock mount on volumeID(%s), this is only for TESTING!!!", volumeID)
 		if err := volumehelper.MakeDir(target); err != nil {
@@ -114,7 +142,20 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	if err := d.mounter.Mount(source, target, "", mountOptions); err != nil {
+	if source != req.GetStagingTargetPath() {
+		if err := os.MkdirAll(source, 0750); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not create sub directory %q: %v", source, err)
+		}
+	}
+
+	err = d.mounter.Mount(source, target, "", mountOptions)
+	d.auditor.recordResult(&auditRecord{
+		Operation:   auditOperationMount,
+		VolumeID:    volumeID,
+		TargetPath:  target,
+		auditObject: getAuditObject(context),
+	}, err)
+	if err != nil {
 		if removeErr := os.Remove(target); removeErr != nil {
 			return nil, status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
 		}
@@ -125,34 +166,182 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (d *Driver) mountBlobfuseWithProxy(args string, authEnv []string) (string, error) {
+// mountBlobfuseWithProxy mounts blobfuse by blobfuse proxy with v2 mount service if proxy serves it,
+// blobfuse is mounted inside driver when proxy does not support capabilities required by the mount and fallback is enabled
+func (d *Driver) mountBlobfuseWithProxy(ctx context.Context, targetPath string, args []string, options map[string]string, authEnv []string) (string, error) {
+	info, err := d.getBlobfuseProxyInfo()
+	if err != nil {
+		return "", err
+	}
+	requiredCapabilities := getRequiredBlobfuseProxyCapabilities(targetPath, args, options)
+	if missing := info.getMissingCapabilities(requiredCapabilities); len(missing) > 0 {
+		if d.blobfuseProxyFallback {
+			klog.Warningf("blobfuse proxy(version %s) does not support capabilities %v, mounting %s inside driver", info.version, missing, targetPath)
+			return d.mountBlobfuseInsideDriver(targetPath, args, options, authEnv)
+		}
+		return "", fmt.Errorf("blobfuse proxy(version %s) does not support capabilities %v required to mount %s, "+
+			"please upgrade blobfuse-proxy on the node, or set --enable-blobfuse-proxy-fallback to mount inside driver", info.version, missing, targetPath)
+	}
+
 	klog.V(2).Infof("mouting using blobfuse proxy")
-	var resp *mount_azure_blob.MountAzureBlobResponse
-	var output string
-	connectionTimout := time.Duration(d.blobfuseProxyConnTimout)
-	ctx, cancel := context.WithTimeout(context.Background(), connectionTimout*time.Second)
+	conn, err := d.connectBlobfuseProxy()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	mountClient := NewMountClient(conn)
+	if info.apiVersions[volumehelper.BlobfuseProxyAPIVersionV2] {
+		req := &mount_azure_blob.MountRequest{
+			TargetPath:           targetPath,
+			Args:                 args,
+			Options:              options,
+			AuthEnv:              authEnv,
+			RequiredCapabilities: requiredCapabilities,
+		}
+		var output string
+		if info.capabilities[volumehelper.BlobfuseProxyCapabilityMountStream] {
+			klog.V(2).Infof("calling BlobfuseProxy: MountStream function")
+			output, err = streamMountBlobfuse(ctx, mountClient.serviceV2, req)
+		} else {
+			klog.V(2).Infof("calling BlobfuseProxy: Mount function")
+			var resp *mount_azure_blob.MountResponse
+			resp, err = mountClient.serviceV2.Mount(ctx, req)
+			output = resp.GetOutput()
+		}
+		if status.Code(err) != codes.Unimplemented {
+			if err != nil {
+				klog.Error("GRPC call returned with an error:", err)
+			}
+			return output, err
+		}
+		// blobfuse proxy is downgraded after negotiation
+		klog.Warningf("blobfuse proxy(%s) does not serve v2 mount service, mounting with v1 mount service", d.blobfuseProxyEndpoint)
+		d.resetBlobfuseProxyInfo()
+	}
+
+	mountreq := mount_azure_blob.MountAzureBlobRequest{
+		// MountArgs is only for old blobfuse-proxy which does not recognize structured arguments
+		MountArgs:  strings.Join(volumehelper.BuildBlobfuseArgs(targetPath, args, options), " "),
+		AuthEnv:    authEnv,
+		TargetPath: targetPath,
+		Args:       args,
+		Options:    options,
+	}
+	klog.V(2).Infof("calling BlobfuseProxy: MountAzureBlob function")
+	resp, err := mountClient.service.MountAzureBlob(ctx, &mountreq)
+	if err != nil {
+		klog.Error("GRPC call returned with an error:", err)
+	}
+	return resp.GetOutput(), err
+}
+
+// unmountBlobfuseWithProxy unmounts blobfuse mount point by blobfuse proxy since blobfuse process runs on the host,
+// it's skipped if target path is not a blobfuse mount point, or proxy is unavailable or does not support unmount,
+// the caller should still clean up the mount point inside driver
+func (d *Driver) unmountBlobfuseWithProxy(targetPath string) error {
+	conn, err := d.connectBlobfuseProxy()
+	if err != nil {
+		klog.Warningf("failed to connect to blobfuse proxy: %v, unmounting %s inside driver", err, targetPath)
+		return nil
+	}
+	defer conn.Close()
+	klog.V(2).Infof("calling BlobfuseProxy: UnmountAzureBlob function on %s", targetPath)
+	_, err = NewMountClient(conn).service.UnmountAzureBlob(context.TODO(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: targetPath})
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound, codes.Unimplemented:
+		klog.V(2).Infof("%s is not unmounted by blobfuse proxy: %v", targetPath, err)
+		return nil
+	default:
+		return fmt.Errorf("blobfuse proxy failed to unmount %s: %v", targetPath, err)
+	}
+}
+
+// listBlobfuseMountsWithProxy returns blobfuse mount points on the host reported by blobfuse proxy
+func (d *Driver) listBlobfuseMountsWithProxy() ([]*mount_azure_blob.MountInfo, error) {
+	conn, err := d.connectBlobfuseProxy()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp, err := NewMountClient(conn).service.ListMounts(context.TODO(), &mount_azure_blob.ListMountsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetMounts(), nil
+}
+
+// checkBlobfuseProxyHealth checks whether blobfuse proxy is ready to serve mounts
+func (d *Driver) checkBlobfuseProxyHealth() error {
+	conn, err := d.connectBlobfuseProxy()
+	if err != nil {
+		return fmt.Errorf("failed to connect to blobfuse proxy(%s): %v", d.blobfuseProxyEndpoint, err)
+	}
+	defer conn.Close()
+	resp, err := NewMountClient(conn).service.Health(context.TODO(), &mount_azure_blob.HealthRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to check health of blobfuse proxy(%s): %v", d.blobfuseProxyEndpoint, err)
+	}
+	if !resp.GetReady() {
+		return fmt.Errorf("blobfuse proxy(%s) is not ready: %s", d.blobfuseProxyEndpoint, resp.GetMessage())
+	}
+	return nil
+}
+
+// connectBlobfuseProxy connects to blobfuse proxy within connection timeout, the caller should close the connection
+func (d *Driver) connectBlobfuseProxy() (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.blobfuseProxyConnTimout)*time.Second)
 	defer cancel()
-	conn, err := grpc.DialContext(ctx, d.blobfuseProxyEndpoint, grpc.WithInsecure(), grpc.WithBlock())
-	if err == nil {
-		mountClient := NewMountClient(conn)
-		mountreq := mount_azure_blob.MountAzureBlobRequest{
-			MountArgs: args,
-			AuthEnv:   authEnv,
+	transportOption := grpc.WithInsecure()
+	if d.blobfuseProxyTLSCAFile != "" {
+		tlsConfig, err := d.getBlobfuseProxyTLSConfig()
+		if err != nil {
+			return nil, err
 		}
-		klog.V(2).Infof("calling BlobfuseProxy: MountAzureBlob function")
-		resp, err = mountClient.service.MountAzureBlob(context.TODO(), &mountreq)
+		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	return grpc.DialContext(ctx, d.blobfuseProxyEndpoint, transportOption, grpc.WithBlock())
+}
+
+// getBlobfuseProxyTLSConfig returns TLS config to connect blobfuse proxy, certificates are loaded on every connection
+// so that rotated certificates are picked up
+func (d *Driver) getBlobfuseProxyTLSConfig() (*tls.Config, error) {
+	ca, err := ioutil.ReadFile(d.blobfuseProxyTLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blobfuse proxy CA file(%s): %v", d.blobfuseProxyTLSCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no valid certificate is found in blobfuse proxy CA file(%s)", d.blobfuseProxyTLSCAFile)
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: d.blobfuseProxyTLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if d.blobfuseProxyTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(d.blobfuseProxyTLSCertFile, d.blobfuseProxyTLSKeyFile)
 		if err != nil {
-			klog.Error("GRPC call returned with an error:", err)
+			return nil, fmt.Errorf("failed to load blobfuse proxy client certificate: %v", err)
 		}
-		output = resp.GetOutput()
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-	return output, err
+	return tlsConfig, nil
 }
 
-func (d *Driver) mountBlobfuseInsideDriver(args string, authEnv []string) (string, error) {
+func (d *Driver) mountBlobfuseInsideDriver(targetPath string, args []string, options map[string]string, authEnv []string) (string, error) {
 	klog.V(2).Infof("mounting blobfuse inside driver")
-	cmd := exec.Command("blobfuse", strings.Split(args, " ")...)
-	cmd.Env = append(os.Environ(), authEnv...)
+	cmdArgs := volumehelper.BuildBlobfuseArgs(targetPath, args, options)
+	// config file is removed once blobfuse returns, blobfuse daemon has read it by then
+	creds, err := volumehelper.PrepareBlobfuseCredentials(d.blobfuseCredentialDelivery, d.blobfuseCredentialDir, cmdArgs, authEnv)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare blobfuse credentials: %v", err)
+	}
+	defer creds.Cleanup()
+	cmd := exec.Command("blobfuse", append(cmdArgs, creds.Args...)...)
+	cmd.Env = append(os.Environ(), creds.Env...)
+	cmd.ExtraFiles = creds.ExtraFiles
 	output, err := cmd.CombinedOutput()
 	return string(output), err
 }
@@ -170,6 +359,7 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 
 	klog.V(2).Infof("NodeUnpublishVolume: unmounting volume %s on %s", volumeID, targetPath)
 	err := mount.CleanupMountPoint(targetPath, d.mounter, true /*extensiveMountPointCheck*/)
+	d.auditor.recordResult(&auditRecord{Operation: auditOperationUnmount, VolumeID: volumeID, TargetPath: targetPath}, err)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount target %q: %v", targetPath, err)
 	}
@@ -208,11 +398,15 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 
 	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
+	volumeMountGroup := req.GetVolumeCapability().GetMount().GetVolumeMountGroup()
+	if err := validateVolumeMountGroup(volumeMountGroup); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	attrib := req.GetVolumeContext()
 	secrets := req.GetSecrets()
 
 	var serverAddress, storageEndpointSuffix, protocol, ephemeralVolMountOptions string
-	var ephemeralVol, isHnsEnabled bool
+	var ephemeralVol, isHnsEnabled, skipNFSServerCheck bool
 	for k, v := range attrib {
 		switch strings.ToLower(k) {
 		case serverNameField:
@@ -227,6 +421,8 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			ephemeralVolMountOptions = v
 		case isHnsEnabledField:
 			isHnsEnabled = strings.EqualFold(v, trueValue)
+		case skipNFSServerCheckField:
+			skipNFSServerCheck = strings.EqualFold(v, trueValue)
 		}
 	}
 
@@ -234,6 +430,20 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	if err != nil {
 		return nil, err
 	}
+	mountRecord := &auditRecord{
+		Operation:     auditOperationMount,
+		VolumeID:      volumeID,
+		AccountName:   accountName,
+		ContainerName: containerName,
+		Protocol:      protocol,
+		TargetPath:    targetPath,
+		auditObject:   getAuditObject(attrib),
+	}
+
+	subDir, err := getSubDir(volumeID, attrib)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	if strings.TrimSpace(storageEndpointSuffix) == "" {
 		if d.cloud.Environment.StorageEndpointSuffix != "" {
@@ -249,20 +459,49 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 
 	if protocol == nfs {
+		rootPermission, err := parseNFSRootPermission(attrib, volumeMountGroup)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		klog.V(2).Infof("target %v\nprotocol %v\n\nvolumeId %v\ncontext %v\nmountflags %v\nserverAddress %v",
-			targetPath, protocol, volumeID, attrib, mountFlags, serverAddress)
+			targetPath, protocol, volumeID, volumehelper.RedactMap(attrib), mountFlags, serverAddress)
 
 		source := fmt.Sprintf("%s:/%s/%s", serverAddress, accountName, containerName)
+		if subDir != "" {
+			source = source + "/" + subDir
+		}
+		if err := validateNFSMountOptions(mountFlags); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if skipNFSServerCheck {
+			klog.V(2).Infof("skip checking NFS server %s of volume(%s)", serverAddress, volumeID)
+		} else if err := checkNFSServer(serverAddress); err != nil {
+			return nil, err
+		}
+
 		mountOptions := util.JoinMountOptions(mountFlags, []string{"sec=sys,vers=3,nolock"})
+		var mountErr error
 		if err := wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
-			return true, d.mounter.MountSensitive(source, targetPath, nfs, mountOptions, []string{})
+			mountErr = d.mounter.MountSensitive(source, targetPath, nfs, mountOptions, []string{})
+			if mountErr == nil {
+				return true, nil
+			}
+			if getNFSMountErrorCode(mountErr) == codes.Unavailable {
+				klog.Warningf("volume(%s) mount %q on %q failed with %v, retrying", volumeID, source, targetPath, mountErr)
+				return false, nil
+			}
+			return false, mountErr
 		}); err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("volume(%s) mount %q on %q failed with %v", volumeID, source, targetPath, err))
+			if mountErr == nil {
+				mountErr = err
+			}
+			d.auditor.recordResult(mountRecord, mountErr)
+			return nil, status.Error(getNFSMountErrorCode(mountErr), fmt.Sprintf("volume(%s) mount %q on %q failed with %v", volumeID, source, targetPath, mountErr))
 		}
+		d.auditor.recordResult(mountRecord, nil)
 
-		// set 0777 for NFSv3 root folder
-		if err := os.Chmod(targetPath, 0777); err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("Chmod(%s) failed with %v", targetPath, err))
+		if err := d.applyNFSRootPermission(targetPath, rootPermission); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
 		}
 		klog.V(2).Infof("volume(%s) mount %q on %q succeeded", volumeID, source, targetPath)
 
@@ -277,17 +516,26 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	if isHnsEnabled {
 		mountOptions = util.JoinMountOptions(mountOptions, []string{"--use-adls=true"})
 	}
-	// set different tmp-path with time info
-	tmpPath := fmt.Sprintf("%s/%s#%d", "/mnt", volumeID, time.Now().Unix())
+	cacheOptions, err := parseBlobfuseCacheOptions(attrib, d.blobfuseCacheSizeMB)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	tmpPath := d.getBlobfuseTmpPath(volumeID, cacheOptions)
 	mountOptions = appendDefaultMountOptions(mountOptions, tmpPath, containerName)
 
-	args := targetPath
-	for _, opt := range mountOptions {
-		args = args + " " + opt
+	args, options := volumehelper.SplitBlobfuseMountOptions(mountOptions)
+	args = appendVolumeMountGroupOptions(args, volumeMountGroup)
+	if _, ok := options[cacheSizeMBOption]; !ok && cacheOptions.sizeMB > 0 {
+		options[cacheSizeMBOption] = strconv.Itoa(cacheOptions.sizeMB)
+	}
+	if _, ok := options[streamingOption]; !ok && cacheOptions.medium == cacheMediumNone {
+		// read and write through without file cache
+		options[streamingOption] = trueValue
 	}
 
-	klog.V(2).Infof("target %v\nprotocol %v\n\nvolumeId %v\ncontext %v\nmountflags %v\nmountOptions %v\nargs %v\nserverAddress %v",
-		targetPath, protocol, volumeID, attrib, mountFlags, mountOptions, args, serverAddress)
+	klog.V(2).Infof("target %v\nprotocol %v\n\nvolumeId %v\ncontext %v\nmountflags %v\nmountOptions %v\nargs %q\nserverAddress %v",
+		targetPath, protocol, volumeID, volumehelper.RedactMap(attrib), mountFlags, volumehelper.RedactKeyValues(mountOptions),
+		volumehelper.RedactKeyValues(volumehelper.BuildBlobfuseArgs(targetPath, args, options)), serverAddress)
 
 	authEnv = append(authEnv, "AZURE_STORAGE_ACCOUNT="+accountName, "AZURE_STORAGE_BLOB_ENDPOINT="+serverAddress)
 	if d.enableBlobMockMount {
@@ -296,18 +544,33 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			klog.Errorf("MakeDir failed on target: %s (%v)", targetPath, err)
 			return nil, err
 		}
+		d.auditor.recordResult(mountRecord, nil)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	// only manage the cache directory when tmp-path is not specified in mount options
+	manageCacheDir := options[tmpPathOption] == tmpPath
+	if manageCacheDir {
+		if err := d.ensureBlobfuseCacheDir(volumeID, targetPath, cacheOptions); err != nil {
+			if removeErr := d.removeBlobfuseCacheDir(volumeID); removeErr != nil {
+				klog.Warningf("%v", removeErr)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	} else if cacheOptions.medium != cacheMediumHostPath {
+		klog.Warningf("tmp-path is specified in mount options, cache medium(%s) is ignored on volume(%s)", cacheOptions.medium, volumeID)
+	}
+
 	var output string
 	if d.enableBlobfuseProxy {
-		output, err = d.mountBlobfuseWithProxy(args, authEnv)
+		output, err = d.mountBlobfuseWithProxy(ctx, targetPath, args, options, authEnv)
 	} else {
-		output, err = d.mountBlobfuseInsideDriver(args, authEnv)
+		output, err = d.mountBlobfuseInsideDriver(targetPath, args, options, authEnv)
 	}
+	d.auditor.recordResult(mountRecord, err)
 
 	if err != nil {
-		err = fmt.Errorf("Mount failed with error: %v, output: %v", err, output)
+		err = fmt.Errorf("Mount failed with error: %v, output: %v", err, volumehelper.RedactString(output))
 		klog.Errorf("%v", err)
 		notMnt, mntErr := d.mounter.IsLikelyNotMountPoint(targetPath)
 		if mntErr != nil {
@@ -331,6 +594,11 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			}
 		}
 		os.Remove(targetPath)
+		if manageCacheDir {
+			if removeErr := d.removeBlobfuseCacheDir(volumeID); removeErr != nil {
+				klog.Warningf("%v", removeErr)
+			}
+		}
 		return nil, err
 	}
 
@@ -356,10 +624,22 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 	defer d.volumeLocks.Release(volumeID)
 
 	klog.V(2).Infof("NodeUnstageVolume: volume %s unmounting on %s", volumeID, stagingTargetPath)
+	unmountRecord := &auditRecord{Operation: auditOperationUnmount, VolumeID: volumeID, TargetPath: stagingTargetPath}
+	if d.enableBlobfuseProxy {
+		// blobfuse process is running on the host
+		if err := d.unmountBlobfuseWithProxy(stagingTargetPath); err != nil {
+			d.auditor.recordResult(unmountRecord, err)
+			return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
+		}
+	}
 	err := mount.CleanupMountPoint(stagingTargetPath, d.mounter, true /*extensiveMountPointCheck*/)
+	d.auditor.recordResult(unmountRecord, err)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
 	}
+	if err := d.removeBlobfuseCacheDir(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	klog.V(2).Infof("NodeUnstageVolume: volume %s unmount on %s successfully", volumeID, stagingTargetPath)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
@@ -18,16 +18,29 @@ package blob
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"syscall"
 	"testing"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -35,6 +48,8 @@ import (
 
 	mount "k8s.io/mount-utils"
 	testingexec "k8s.io/utils/exec/testing"
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	volumehelper "sigs.k8s.io/blob-csi-driver/pkg/util"
 )
 
 const (
@@ -210,6 +225,39 @@ func TestNodePublishVolume(t *testing.T) {
 				Readonly:          true},
 			expectedErr: nil,
 		},
+		{
+			desc: "Valid request with sub directory",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "rg#account#container#pvc-name#subdir=project/workload",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest},
+			expectedErr: nil,
+		},
+		{
+			desc: "Invalid sub directory",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:          "vol_1",
+				VolumeContext:     map[string]string{subDirField: "../project"},
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest},
+			expectedErr: status.Error(codes.InvalidArgument, "subdir(../project) must be a relative path without \".\", \"..\" or empty elements"),
+		},
+		{
+			desc: "Sub directory in blobfuse ephemeral volume",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:      "vol_1",
+				VolumeContext: map[string]string{subDirField: "project", ephemeralField: trueValue},
+				TargetPath:    targetTest},
+			expectedErr: status.Error(codes.InvalidArgument, "subdir is not supported in ephemeral volume with fuse protocol"),
+		},
+		{
+			desc: "Cache path in ephemeral volume",
+			req: csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:      "vol_1",
+				VolumeContext: map[string]string{"cachePath": "/etc", ephemeralField: trueValue},
+				TargetPath:    targetTest},
+			expectedErr: status.Error(codes.InvalidArgument, "cachePath is not supported in ephemeral volume"),
+		},
 	}
 
 	// Setup
@@ -372,6 +420,32 @@ func TestNodeStageVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Invalid volume mount group",
+			testFunc: func(t *testing.T) {
+				req := &csi.NodeStageVolumeRequest{
+					VolumeId:          "unit-test",
+					StagingTargetPath: targetTest,
+					VolumeCapability: &csi.VolumeCapability{
+						AccessMode: &volumeCap,
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "admin"},
+						},
+					},
+				}
+				d := NewFakeDriver()
+				d.mounter = &mount.SafeFormatAndMount{
+					Interface: &fakeMounter{},
+					Exec:      &testingexec.FakeExec{ExactOrder: true},
+				}
+				defer os.RemoveAll(targetTest)
+				_, err := d.NodeStageVolume(context.TODO(), req)
+				expectedErr := status.Error(codes.InvalidArgument, "invalid VolumeMountGroup(admin), should be a non-negative integer")
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
+				}
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, tc.testFunc)
@@ -547,19 +621,196 @@ func TestNodeExpandVolume(t *testing.T) {
 }
 
 func TestMountBlobfuseWithProxy(t *testing.T) {
-	args := "--tmp-path /tmp"
+	options := map[string]string{"--tmp-path": "/tmp"}
 	authEnv := []string{"username=blob", "authkey=blob"}
 	d := NewFakeDriver()
-	_, err := d.mountBlobfuseWithProxy(args, authEnv)
+	_, err := d.mountBlobfuseWithProxy(context.TODO(), "/mnt/target", []string{}, options, authEnv)
 	// should be context.deadlineExceededError{} error
 	assert.NotNil(t, err)
 }
 
+// fakeMountServiceServer is a blobfuse proxy which serves mount, unmount, list and health requests from memory
+type fakeMountServiceServer struct {
+	mount_azure_blob.UnimplementedMountServiceServer
+	mounts     []*mount_azure_blob.MountInfo
+	unmountErr error
+	ready      bool
+}
+
+func (s *fakeMountServiceServer) MountAzureBlob(ctx context.Context, req *mount_azure_blob.MountAzureBlobRequest) (*mount_azure_blob.MountAzureBlobResponse, error) {
+	s.mounts = append(s.mounts, &mount_azure_blob.MountInfo{TargetPath: req.TargetPath, Args: req.Args, Options: req.Options})
+	return &mount_azure_blob.MountAzureBlobResponse{Output: "mounted by v1"}, nil
+}
+
+func (s *fakeMountServiceServer) UnmountAzureBlob(ctx context.Context, req *mount_azure_blob.UnmountAzureBlobRequest) (*mount_azure_blob.UnmountAzureBlobResponse, error) {
+	if s.unmountErr != nil {
+		return nil, s.unmountErr
+	}
+	for i, m := range s.mounts {
+		if m.TargetPath == req.TargetPath {
+			s.mounts = append(s.mounts[:i], s.mounts[i+1:]...)
+			return &mount_azure_blob.UnmountAzureBlobResponse{}, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "not a blobfuse mount point")
+}
+
+func (s *fakeMountServiceServer) ListMounts(ctx context.Context, req *mount_azure_blob.ListMountsRequest) (*mount_azure_blob.ListMountsResponse, error) {
+	return &mount_azure_blob.ListMountsResponse{Mounts: s.mounts}, nil
+}
+
+func (s *fakeMountServiceServer) Health(ctx context.Context, req *mount_azure_blob.HealthRequest) (*mount_azure_blob.HealthResponse, error) {
+	return &mount_azure_blob.HealthResponse{Ready: s.ready, Message: "blobfuse is not found"}, nil
+}
+
+// startFakeBlobfuseProxy starts blobfuse proxy on a unix socket in a temp directory and returns its endpoint
+func startFakeBlobfuseProxy(t *testing.T, server mount_azure_blob.MountServiceServer) string {
+	dir, err := ioutil.TempDir("", "blobfuse-proxy")
+	assert.NoError(t, err)
+	socket := filepath.Join(dir, "blobfuse-proxy.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	mount_azure_blob.RegisterMountServiceServer(grpcServer, server)
+	if serverV2, ok := server.(mount_azure_blob.MountServiceV2Server); ok {
+		mount_azure_blob.RegisterMountServiceV2Server(grpcServer, serverV2)
+	}
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		grpcServer.Stop()
+		os.RemoveAll(dir)
+	})
+	return "unix://" + socket
+}
+
+func TestBlobfuseProxyRPCs(t *testing.T) {
+	proxy := &fakeMountServiceServer{
+		mounts: []*mount_azure_blob.MountInfo{{TargetPath: "/mnt/staging1"}, {TargetPath: "/mnt/staging2"}},
+	}
+	d := NewFakeDriver()
+	d.enableBlobfuseProxy = true
+	d.blobfuseProxyConnTimout = 5
+	d.blobfuseProxyEndpoint = startFakeBlobfuseProxy(t, proxy)
+
+	err := d.checkBlobfuseProxyHealth()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "blobfuse is not found")
+	proxy.ready = true
+	assert.NoError(t, d.checkBlobfuseProxyHealth())
+
+	mounts, err := d.listBlobfuseMountsWithProxy()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(mounts))
+
+	assert.NoError(t, d.unmountBlobfuseWithProxy("/mnt/staging1"))
+	// target path which is not a blobfuse mount point is left to driver
+	assert.NoError(t, d.unmountBlobfuseWithProxy("/mnt/staging1"))
+	mounts, err = d.listBlobfuseMountsWithProxy()
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/staging2", mounts[0].TargetPath)
+
+	proxy.unmountErr = status.Error(codes.Internal, "device is busy")
+	assert.Error(t, d.unmountBlobfuseWithProxy("/mnt/staging2"))
+}
+
+// writeTestCertificate writes a self-signed certificate of 127.0.0.1 used as CA, server and client certificate
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "blobfuse-proxy"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestBlobfuseProxyWithTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobfuse-proxy-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	// blobfuse proxy requires client certificate
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	ca, err := ioutil.ReadFile(certFile)
+	assert.NoError(t, err)
+	assert.True(t, pool.AppendCertsFromPEM(ca))
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	mount_azure_blob.RegisterMountServiceServer(grpcServer, &fakeMountServiceServer{ready: true})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	d := NewFakeDriver()
+	d.blobfuseProxyConnTimout = 1
+	d.blobfuseProxyEndpoint = listener.Addr().String()
+	// plaintext connection is not established
+	assert.Error(t, d.checkBlobfuseProxyHealth())
+
+	d.blobfuseProxyTLSCAFile = filepath.Join(dir, "not-exist.crt")
+	_, err = d.connectBlobfuseProxy()
+	assert.Error(t, err)
+	d.blobfuseProxyTLSCAFile = keyFile
+	_, err = d.connectBlobfuseProxy()
+	assert.Error(t, err)
+
+	d.blobfuseProxyTLSCAFile = certFile
+	d.blobfuseProxyTLSCertFile = certFile
+	d.blobfuseProxyTLSKeyFile = filepath.Join(dir, "not-exist.key")
+	_, err = d.connectBlobfuseProxy()
+	assert.Error(t, err)
+
+	d.blobfuseProxyTLSKeyFile = keyFile
+	assert.NoError(t, d.checkBlobfuseProxyHealth())
+}
+
+func TestBlobfuseProxyUnavailable(t *testing.T) {
+	d := NewFakeDriver()
+	d.blobfuseProxyConnTimout = 1
+	d.blobfuseProxyEndpoint = "unix://" + filepath.Join(os.TempDir(), "blobfuse-proxy-not-exist.sock")
+	// unmount is done inside driver if blobfuse proxy is unavailable
+	assert.NoError(t, d.unmountBlobfuseWithProxy("/mnt/staging"))
+	assert.Error(t, d.checkBlobfuseProxyHealth())
+	_, err := d.listBlobfuseMountsWithProxy()
+	assert.Error(t, err)
+}
+
 func TestMountBlobfuseInsideDriver(t *testing.T) {
-	args := "--tmp-path /tmp"
+	options := map[string]string{"--tmp-path": "/tmp"}
 	authEnv := []string{"username=blob", "authkey=blob"}
 	d := NewFakeDriver()
-	_, err := d.mountBlobfuseInsideDriver(args, authEnv)
+	_, err := d.mountBlobfuseInsideDriver("/mnt/target", []string{}, options, authEnv)
 	// the error should be of type exec.ExitError
 	assert.NotNil(t, err)
+	// config file could not be created in credential directory which does not exist
+	d.blobfuseCredentialDelivery = volumehelper.BlobfuseCredentialDeliveryFile
+	d.blobfuseCredentialDir = filepath.Join(os.TempDir(), "blobfuse-credentials-not-exist")
+	_, err = d.mountBlobfuseInsideDriver("/mnt/target", []string{}, options, []string{"AZURE_STORAGE_ACCESS_KEY=key"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to prepare blobfuse credentials")
 }
@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-02-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/klog/v2"
+
+	azclients "sigs.k8s.io/cloud-provider-azure/pkg/azureclients"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednsclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednszonegroupclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privateendpointclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/virtualnetworklinksclient"
+)
+
+const (
+	networkEndpointTypeField     = "networkendpointtype"
+	privateEndpointSubnetIDField = "privateendpointsubnetid"
+	privateEndpointType          = "privateendpoint"
+
+	// sub-resource of storage account which private endpoint connects to
+	privateEndpointGroupIDBlob = "blob"
+	// private DNS zone is global resource
+	privateDNSZoneLocation = "global"
+
+	privateEndpointNameSuffix       = "-pvtendpoint"
+	privateLinkServiceConnectionTag = "-pvtsvcconn"
+	virtualNetworkLinkNameSuffix    = "-vnetlink"
+	privateDNSZoneGroupName         = "default"
+
+	privateDNSZoneIDTemplate = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/privateDnsZones/%s"
+	vnetIDTemplate           = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s"
+)
+
+// initPrivateEndpointClients initializes clients which are used to create private endpoint and private DNS zone,
+// clients are created in network resource subscription
+func (d *Driver) initPrivateEndpointClients(authorizer autorest.Authorizer) {
+	config := &azclients.ClientConfig{
+		CloudName:               d.cloud.Config.Cloud,
+		Location:                d.cloud.Location,
+		SubscriptionID:          d.getNetworkSubscriptionID(),
+		ResourceManagerEndpoint: d.cloud.Environment.ResourceManagerEndpoint,
+		Authorizer:              authorizer,
+	}
+	d.privateEndpointClient = privateendpointclient.New(config)
+	d.privateDNSClient = privatednsclient.New(config)
+	d.privateDNSZoneGroupClient = privatednszonegroupclient.New(config)
+	d.virtualNetworkLinksClient = virtualnetworklinksclient.New(config)
+}
+
+// getPrivateDNSZoneName returns private DNS zone name of blob endpoint, e.g. privatelink.blob.core.windows.net
+func getPrivateDNSZoneName(storageEndpointSuffix string) string {
+	return fmt.Sprintf("privatelink.blob.%s", storageEndpointSuffix)
+}
+
+// getPrivateEndpointServerAddress returns private link hostname of storage account, e.g. accountname.privatelink.blob.core.windows.net
+func getPrivateEndpointServerAddress(accountName, storageEndpointSuffix string) string {
+	return fmt.Sprintf("%s.%s", accountName, getPrivateDNSZoneName(storageEndpointSuffix))
+}
+
+// ensurePrivateEndpoint creates a private endpoint of storage account blob service in subnet, links the private endpoint
+// to a private DNS zone in the resource group of virtual network, all resources are only created when they do not exist,
+// public network access of storage account is denied only if denyPublicNetworkAccess is true, i.e. account is dedicated
+// to private endpoint in subnet and named by driver
+func (d *Driver) ensurePrivateEndpoint(ctx context.Context, resourceGroup, accountName, subnetID, storageEndpointSuffix string, denyPublicNetworkAccess bool) error {
+	if d.privateEndpointClient == nil || d.privateDNSClient == nil || d.privateDNSZoneGroupClient == nil || d.virtualNetworkLinksClient == nil {
+		return fmt.Errorf("private endpoint clients are not initialized")
+	}
+	if d.cloud.SubnetsClient == nil || d.cloud.StorageAccountClient == nil {
+		return fmt.Errorf("SubnetsClient or StorageAccountClient is nil")
+	}
+	subsID, vnetResourceGroup, vnetName, subnetName, err := parseSubnetResourceID(subnetID)
+	if err != nil {
+		return err
+	}
+
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+
+	lockKey := vnetResourceGroup + vnetName + subnetName
+	d.subnetLockMap.LockEntry(lockKey)
+	defer d.subnetLockMap.UnlockEntry(lockKey)
+
+	privateEndpointName := accountName + privateEndpointNameSuffix
+	if _, err := d.privateEndpointClient.Get(ctx, vnetResourceGroup, privateEndpointName, ""); err != nil {
+		if !isNotFoundError(err) {
+			return fmt.Errorf("failed to get private endpoint(%s) under rg(%s): %v", privateEndpointName, vnetResourceGroup, err)
+		}
+		subnet, rerr := d.cloud.SubnetsClient.Get(ctx, vnetResourceGroup, vnetName, subnetName, "")
+		if rerr != nil {
+			return fmt.Errorf("failed to get the subnet %s under vnet %s: %v", subnetName, vnetName, rerr.Error())
+		}
+		if subnet.SubnetPropertiesFormat == nil {
+			subnet.SubnetPropertiesFormat = &network.SubnetPropertiesFormat{}
+		}
+		if subnet.SubnetPropertiesFormat.PrivateEndpointNetworkPolicies != network.VirtualNetworkPrivateEndpointNetworkPoliciesDisabled {
+			// private endpoint network policies must be disabled before creating private endpoint
+			subnet.SubnetPropertiesFormat.PrivateEndpointNetworkPolicies = network.VirtualNetworkPrivateEndpointNetworkPoliciesDisabled
+			if rerr := d.cloud.SubnetsClient.CreateOrUpdate(ctx, vnetResourceGroup, vnetName, subnetName, subnet); rerr != nil {
+				return fmt.Errorf("failed to disable private endpoint network policies on subnet %s under vnet %s: %v", subnetName, vnetName, rerr.Error())
+			}
+		}
+
+		klog.V(2).Infof("creating private endpoint(%s) for account(%s) in subnet(%s)", privateEndpointName, accountName, subnetID)
+		privateEndpoint := network.PrivateEndpoint{
+			Location: to.StringPtr(d.cloud.Location),
+			PrivateEndpointProperties: &network.PrivateEndpointProperties{
+				Subnet: &network.Subnet{ID: to.StringPtr(subnetID)},
+				PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{
+					{
+						Name: to.StringPtr(accountName + privateLinkServiceConnectionTag),
+						PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+							GroupIds:             &[]string{privateEndpointGroupIDBlob},
+							PrivateLinkServiceID: account.ID,
+						},
+					},
+				},
+			},
+		}
+		if err := d.privateEndpointClient.CreateOrUpdate(ctx, vnetResourceGroup, privateEndpointName, privateEndpoint, true); err != nil {
+			return fmt.Errorf("failed to create private endpoint(%s) under rg(%s): %v", privateEndpointName, vnetResourceGroup, err)
+		}
+	}
+
+	dnsZoneName := getPrivateDNSZoneName(storageEndpointSuffix)
+	if _, err := d.privateDNSClient.Get(ctx, vnetResourceGroup, dnsZoneName); err != nil {
+		if !isNotFoundError(err) {
+			return fmt.Errorf("failed to get private DNS zone(%s) under rg(%s): %v", dnsZoneName, vnetResourceGroup, err)
+		}
+		klog.V(2).Infof("creating private DNS zone(%s) under rg(%s)", dnsZoneName, vnetResourceGroup)
+		zone := privatedns.PrivateZone{Location: to.StringPtr(privateDNSZoneLocation)}
+		if err := d.privateDNSClient.CreateOrUpdate(ctx, vnetResourceGroup, dnsZoneName, zone, true); err != nil {
+			return fmt.Errorf("failed to create private DNS zone(%s) under rg(%s): %v", dnsZoneName, vnetResourceGroup, err)
+		}
+	}
+
+	vnetLinkName := vnetName + virtualNetworkLinkNameSuffix
+	if _, err := d.virtualNetworkLinksClient.Get(ctx, vnetResourceGroup, dnsZoneName, vnetLinkName); err != nil {
+		if !isNotFoundError(err) {
+			return fmt.Errorf("failed to get virtual network link(%s) under rg(%s): %v", vnetLinkName, vnetResourceGroup, err)
+		}
+		klog.V(2).Infof("creating virtual network link(%s) between vnet(%s) and private DNS zone(%s)", vnetLinkName, vnetName, dnsZoneName)
+		link := privatedns.VirtualNetworkLink{
+			Location: to.StringPtr(privateDNSZoneLocation),
+			VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+				VirtualNetwork:      &privatedns.SubResource{ID: to.StringPtr(fmt.Sprintf(vnetIDTemplate, subsID, vnetResourceGroup, vnetName))},
+				RegistrationEnabled: to.BoolPtr(false),
+			},
+		}
+		if err := d.virtualNetworkLinksClient.CreateOrUpdate(ctx, vnetResourceGroup, dnsZoneName, vnetLinkName, link, true); err != nil {
+			return fmt.Errorf("failed to create virtual network link(%s) under rg(%s): %v", vnetLinkName, vnetResourceGroup, err)
+		}
+	}
+
+	if _, err := d.privateDNSZoneGroupClient.Get(ctx, vnetResourceGroup, privateEndpointName, privateDNSZoneGroupName); err != nil {
+		if !isNotFoundError(err) {
+			return fmt.Errorf("failed to get private DNS zone group of private endpoint(%s) under rg(%s): %v", privateEndpointName, vnetResourceGroup, err)
+		}
+		klog.V(2).Infof("creating private DNS zone group of private endpoint(%s) with private DNS zone(%s)", privateEndpointName, dnsZoneName)
+		zoneGroup := network.PrivateDNSZoneGroup{
+			PrivateDNSZoneGroupPropertiesFormat: &network.PrivateDNSZoneGroupPropertiesFormat{
+				PrivateDNSZoneConfigs: &[]network.PrivateDNSZoneConfig{
+					{
+						Name: to.StringPtr(dnsZoneName),
+						PrivateDNSZonePropertiesFormat: &network.PrivateDNSZonePropertiesFormat{
+							PrivateDNSZoneID: to.StringPtr(fmt.Sprintf(privateDNSZoneIDTemplate, subsID, vnetResourceGroup, dnsZoneName)),
+						},
+					},
+				},
+			},
+		}
+		if err := d.privateDNSZoneGroupClient.CreateOrUpdate(ctx, vnetResourceGroup, privateEndpointName, privateDNSZoneGroupName, zoneGroup, true); err != nil {
+			return fmt.Errorf("failed to create private DNS zone group of private endpoint(%s) under rg(%s): %v", privateEndpointName, vnetResourceGroup, err)
+		}
+	}
+
+	if !denyPublicNetworkAccess {
+		klog.V(2).Infof("network rules of storage account(%s) specified by user are not changed, public network access is kept", accountName)
+		return nil
+	}
+	return d.disablePublicNetworkAccess(ctx, resourceGroup, account)
+}
+
+// disablePublicNetworkAccess denies traffic from public network by default on storage account, existing network rules are kept
+func (d *Driver) disablePublicNetworkAccess(ctx context.Context, resourceGroup string, account storage.Account) error {
+	accountName := to.String(account.Name)
+	ruleSet := &storage.NetworkRuleSet{}
+	if account.AccountProperties != nil && account.AccountProperties.NetworkRuleSet != nil {
+		ruleSet = account.AccountProperties.NetworkRuleSet
+	}
+	if ruleSet.DefaultAction == storage.DefaultActionDeny {
+		klog.V(4).Infof("public network access is already disabled on storage account(%s)", accountName)
+		return nil
+	}
+
+	ruleSet.DefaultAction = storage.DefaultActionDeny
+	parameters := storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{
+			NetworkRuleSet: ruleSet,
+		},
+	}
+	if rerr := d.cloud.StorageAccountClient.Update(ctx, resourceGroup, accountName, parameters); rerr != nil {
+		return fmt.Errorf("failed to disable public network access on storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	klog.V(2).Infof("public network access is disabled on storage account(%s)", accountName)
+	return nil
+}
+
+// isNotFoundError checks whether the error returned by Azure SDK is a 404 error
+func isNotFoundError(err error) bool {
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) {
+		if statusCode, ok := detailedErr.StatusCode.(int); ok {
+			return statusCode == http.StatusNotFound
+		}
+	}
+	return err != nil && strings.Contains(err.Error(), "StatusCode=404")
+}
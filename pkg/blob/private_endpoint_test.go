@@ -0,0 +1,181 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-02-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednsclient/mockprivatednsclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednszonegroupclient/mockprivatednszonegroupclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privateendpointclient/mockprivateendpointclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/subnetclient/mocksubnetclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/virtualnetworklinksclient/mockvirtualnetworklinksclient"
+	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestGetPrivateEndpointServerAddress(t *testing.T) {
+	assert.Equal(t, "privatelink.blob.core.windows.net", getPrivateDNSZoneName("core.windows.net"))
+	assert.Equal(t, "account.privatelink.blob.core.chinacloudapi.cn", getPrivateEndpointServerAddress("account", "core.chinacloudapi.cn"))
+}
+
+func TestIsNotFoundError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: nil, expected: false},
+		{err: autorest.DetailedError{StatusCode: http.StatusNotFound}, expected: true},
+		{err: autorest.DetailedError{StatusCode: http.StatusForbidden}, expected: false},
+		{err: fmt.Errorf("wrapped: %w", autorest.DetailedError{StatusCode: http.StatusNotFound}), expected: true},
+		{err: fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: StatusCode=404"), expected: true},
+		{err: fmt.Errorf("internal error"), expected: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isNotFoundError(test.err), "%v", test.err)
+	}
+}
+
+func TestEnsurePrivateEndpoint(t *testing.T) {
+	const (
+		subnetID    = "/subscriptions/subs/resourceGroups/vnetrg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+		accountID   = "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account"
+		dnsZoneName = "privatelink.blob.core.windows.net"
+	)
+	notFoundErr := autorest.DetailedError{StatusCode: http.StatusNotFound}
+
+	testCases := []struct {
+		desc           string
+		exist          bool
+		getErr         error
+		defaultAction  storage.DefaultAction
+		userSpecified  bool
+		expectedUpdate bool
+		expectErr      bool
+	}{
+		{
+			desc:           "create private endpoint and private DNS zone",
+			getErr:         notFoundErr,
+			defaultAction:  storage.DefaultActionAllow,
+			expectedUpdate: true,
+		},
+		{
+			desc:          "public network access of storage account specified by user is kept",
+			getErr:        notFoundErr,
+			defaultAction: storage.DefaultActionAllow,
+			userSpecified: true,
+		},
+		{
+			desc:          "private endpoint and private DNS zone already exist",
+			exist:         true,
+			defaultAction: storage.DefaultActionDeny,
+		},
+		{
+			desc:      "failed to get private endpoint",
+			getErr:    fmt.Errorf("internal error"),
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		ctrl := gomock.NewController(t)
+		mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		mockSubnetClient := mocksubnetclient.NewMockInterface(ctrl)
+		mockPrivateEndpointClient := mockprivateendpointclient.NewMockInterface(ctrl)
+		mockPrivateDNSClient := mockprivatednsclient.NewMockInterface(ctrl)
+		mockVirtualNetworkLinksClient := mockvirtualnetworklinksclient.NewMockInterface(ctrl)
+		mockPrivateDNSZoneGroupClient := mockprivatednszonegroupclient.NewMockInterface(ctrl)
+
+		d := NewFakeDriver()
+		d.cloud = &azureprovider.Cloud{StorageAccountClient: mockStorageAccountsClient, SubnetsClient: mockSubnetClient}
+		d.cloud.Location = "eastus"
+		d.privateEndpointClient = mockPrivateEndpointClient
+		d.privateDNSClient = mockPrivateDNSClient
+		d.virtualNetworkLinksClient = mockVirtualNetworkLinksClient
+		d.privateDNSZoneGroupClient = mockPrivateDNSZoneGroupClient
+
+		account := storage.Account{
+			ID:   to.StringPtr(accountID),
+			Name: to.StringPtr("account"),
+			AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{DefaultAction: test.defaultAction},
+			},
+		}
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", "account").Return(account, nil).Times(1)
+
+		getErr := test.getErr
+		if test.exist {
+			getErr = nil
+		}
+		mockPrivateEndpointClient.EXPECT().Get(gomock.Any(), "vnetrg", "account-pvtendpoint", "").Return(network.PrivateEndpoint{}, getErr).Times(1)
+		if !test.expectErr {
+			mockPrivateDNSClient.EXPECT().Get(gomock.Any(), "vnetrg", dnsZoneName).Return(privatedns.PrivateZone{}, getErr).Times(1)
+			mockVirtualNetworkLinksClient.EXPECT().Get(gomock.Any(), "vnetrg", dnsZoneName, "vnet-vnetlink").Return(privatedns.VirtualNetworkLink{}, getErr).Times(1)
+			mockPrivateDNSZoneGroupClient.EXPECT().Get(gomock.Any(), "vnetrg", "account-pvtendpoint", "default").Return(network.PrivateDNSZoneGroup{}, getErr).Times(1)
+		}
+		if !test.exist && !test.expectErr {
+			mockSubnetClient.EXPECT().Get(gomock.Any(), "vnetrg", "vnet", "subnet", "").Return(network.Subnet{}, nil).Times(1)
+			mockSubnetClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnetrg", "vnet", "subnet", network.Subnet{
+				SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+					PrivateEndpointNetworkPolicies: network.VirtualNetworkPrivateEndpointNetworkPoliciesDisabled,
+				},
+			}).Return(nil).Times(1)
+			mockPrivateEndpointClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnetrg", "account-pvtendpoint", gomock.Any(), true).
+				DoAndReturn(func(ctx context.Context, resourceGroupName, endpointName string, privateEndpoint network.PrivateEndpoint, waitForCompletion bool) error {
+					assert.Equal(t, subnetID, to.String(privateEndpoint.Subnet.ID), test.desc)
+					connection := (*privateEndpoint.PrivateLinkServiceConnections)[0]
+					assert.Equal(t, accountID, to.String(connection.PrivateLinkServiceID), test.desc)
+					assert.Equal(t, []string{"blob"}, *connection.GroupIds, test.desc)
+					return nil
+				}).Times(1)
+			mockPrivateDNSClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnetrg", dnsZoneName, privatedns.PrivateZone{Location: to.StringPtr("global")}, true).Return(nil).Times(1)
+			mockVirtualNetworkLinksClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnetrg", dnsZoneName, "vnet-vnetlink", gomock.Any(), true).Return(nil).Times(1)
+			mockPrivateDNSZoneGroupClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnetrg", "account-pvtendpoint", "default", gomock.Any(), true).Return(nil).Times(1)
+		}
+		if test.expectedUpdate {
+			mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "rg", "account", storage.AccountUpdateParameters{
+				AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{
+					NetworkRuleSet: &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionDeny},
+				},
+			}).Return(nil).Times(1)
+		}
+
+		err := d.ensurePrivateEndpoint(context.TODO(), "rg", "account", subnetID, "core.windows.net", !test.userSpecified)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+		ctrl.Finish()
+	}
+
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{}
+	assert.Error(t, d.ensurePrivateEndpoint(context.TODO(), "rg", "account", subnetID, "core.windows.net", true))
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	replicationLocationField       = "replicationlocation"
+	replicationResourceGroupField  = "replicationresourcegroup"
+	replicationStorageAccountField = "replicationstorageaccount"
+	// replicationContainerField is set in volume context together with replication storage account and resource group
+	replicationContainerField = "replicationcontainer"
+
+	// policy ID of a new object replication policy is generated by destination account
+	newObjectReplicationPolicyID     = "default"
+	storageAccountResourceIDTemplate = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s"
+
+	// replicationSourceTag is set on replication storage account created by driver, its value is resource ID of source account
+	replicationSourceTag                = "k8s-azure-replication-source"
+	replicationStorageAccountNamePrefix = "replica"
+)
+
+// objectReplicationOptions defines destination storage account of object replication
+type objectReplicationOptions struct {
+	location      string
+	resourceGroup string
+	accountName   string
+}
+
+// parseObjectReplicationOptions parses object replication settings from storage class parameters
+func parseObjectReplicationOptions(parameters map[string]string) *objectReplicationOptions {
+	o := &objectReplicationOptions{}
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case replicationLocationField:
+			o.location = v
+		case replicationResourceGroupField:
+			o.resourceGroup = v
+		case replicationStorageAccountField:
+			o.accountName = v
+		}
+	}
+	return o
+}
+
+// isSet checks whether object replication is enabled
+func (o *objectReplicationOptions) isSet() bool {
+	return o.location != "" || o.accountName != ""
+}
+
+// replicaHardeningOptions defines settings of source storage account which are also applied on replication storage account,
+// virtual network rules are in account options of source storage account
+type replicaHardeningOptions struct {
+	security                *storageSecurityOptions
+	ipRules                 []string
+	networkEndpointType     string
+	privateEndpointSubnetID string
+	storageEndpointSuffix   string
+}
+
+// getReplicationStorageAccountName returns a deterministic name of replication storage account dedicated to source account,
+// e.g. replica0123456789abcdef0
+func getReplicationStorageAccountName(subscriptionID, sourceResourceGroup, sourceAccount, resourceGroup, location string) string {
+	key := strings.ToLower(strings.Join([]string{subscriptionID, sourceResourceGroup, sourceAccount, resourceGroup, location}, separator))
+	accountName := fmt.Sprintf("%s%x", replicationStorageAccountNamePrefix, sha256.Sum256([]byte(key)))
+	return accountName[:consts.StorageAccountNameMaxLength]
+}
+
+// ensureReplicationStorageAccount returns destination storage account of object replication,
+// if account name is not specified, a storage account dedicated to the source account is created in replication location,
+// and tagged with resource ID of source account, an existing account of the same name is only used if its tag matches.
+// Security settings, network rules, private endpoint and encryption scope of source account are applied on destination account as well
+func (d *Driver) ensureReplicationStorageAccount(ctx context.Context, sourceOptions *azure.AccountOptions, o *objectReplicationOptions, h *replicaHardeningOptions) (string, string, error) {
+	resourceGroup := o.resourceGroup
+	if resourceGroup == "" {
+		resourceGroup = sourceOptions.ResourceGroup
+	}
+	sourceAccountID := fmt.Sprintf(storageAccountResourceIDTemplate, d.cloud.SubscriptionID, sourceOptions.ResourceGroup, sourceOptions.Name)
+	accountName := o.accountName
+	isDriverNamed := accountName == ""
+	tags := map[string]string{}
+	for k, v := range sourceOptions.Tags {
+		tags[k] = v
+	}
+	if isDriverNamed {
+		sourceLocation := sourceOptions.Location
+		if sourceLocation == "" {
+			sourceLocation = d.cloud.Location
+		}
+		if strings.EqualFold(o.location, sourceLocation) {
+			return "", "", status.Errorf(codes.InvalidArgument, "%s(%s) should be different from location of storage account", replicationLocationField, o.location)
+		}
+		accountName = getReplicationStorageAccountName(d.cloud.SubscriptionID, sourceOptions.ResourceGroup, sourceOptions.Name, resourceGroup, o.location)
+		tags[replicationSourceTag] = sourceAccountID
+	}
+	if strings.EqualFold(accountName, sourceOptions.Name) && strings.EqualFold(resourceGroup, sourceOptions.ResourceGroup) {
+		return "", "", status.Errorf(codes.InvalidArgument, "replication storage account should be different from storage account(%s)", accountName)
+	}
+
+	accountOptions := &azure.AccountOptions{
+		Name:                      accountName,
+		Type:                      sourceOptions.Type,
+		Kind:                      sourceOptions.Kind,
+		ResourceGroup:             resourceGroup,
+		Location:                  o.location,
+		EnableHTTPSTrafficOnly:    sourceOptions.EnableHTTPSTrafficOnly,
+		CreateAccount:             isDriverNamed,
+		Tags:                      tags,
+		AllowBlobPublicAccess:     sourceOptions.AllowBlobPublicAccess,
+		VirtualNetworkResourceIDs: sourceOptions.VirtualNetworkResourceIDs,
+	}
+	lockKey := resourceGroup + accountName
+	d.volLockMap.LockEntry(lockKey)
+	defer d.volLockMap.UnlockEntry(lockKey)
+	if _, _, err := d.cloud.EnsureStorageAccount(ctx, accountOptions, ""); err != nil {
+		return "", "", fmt.Errorf("failed to ensure replication storage account: %v", err)
+	}
+	if isDriverNamed {
+		if err := d.checkReplicationSourceTag(ctx, resourceGroup, accountName, sourceAccountID); err != nil {
+			return "", "", err
+		}
+	}
+	if err := d.hardenReplicationStorageAccount(ctx, resourceGroup, accountName, isDriverNamed, sourceOptions.VirtualNetworkResourceIDs, h); err != nil {
+		return "", "", fmt.Errorf("failed to apply settings of storage account(%s) on replication storage account(%s): %v", sourceOptions.Name, accountName, err)
+	}
+	return resourceGroup, accountName, nil
+}
+
+// checkReplicationSourceTag checks whether replication storage account named by driver is dedicated to source account
+func (d *Driver) checkReplicationSourceTag(ctx context.Context, resourceGroup, accountName, sourceAccountID string) error {
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, resourceGroup, accountName)
+	if rerr != nil {
+		return fmt.Errorf("failed to get replication storage account(%s) under rg(%s): %v", accountName, resourceGroup, rerr.Error())
+	}
+	if !strings.EqualFold(to.String(account.Tags[replicationSourceTag]), sourceAccountID) {
+		return status.Errorf(codes.FailedPrecondition, "storage account(%s) is not tagged with %s(%s), specify %s instead", accountName, replicationSourceTag, sourceAccountID, replicationStorageAccountField)
+	}
+	return nil
+}
+
+// hardenReplicationStorageAccount applies security settings, network rules, private endpoint and encryption scope of
+// source storage account on replication storage account
+func (d *Driver) hardenReplicationStorageAccount(ctx context.Context, resourceGroup, accountName string, isDriverNamed bool, vnetResourceIDs []string, h *replicaHardeningOptions) error {
+	if h.security.isAccountOptionSet() {
+		if err := d.ensureStorageAccountSecurity(ctx, resourceGroup, accountName, h.security, isDriverNamed); err != nil {
+			return err
+		}
+	}
+	if len(vnetResourceIDs) > 0 || len(h.ipRules) > 0 {
+		if err := d.ensureStorageAccountNetworkRules(ctx, resourceGroup, accountName, vnetResourceIDs, h.ipRules); err != nil {
+			return err
+		}
+	}
+	if h.networkEndpointType == privateEndpointType {
+		if err := d.ensurePrivateEndpoint(ctx, resourceGroup, accountName, h.privateEndpointSubnetID, h.storageEndpointSuffix, isDriverNamed); err != nil {
+			return err
+		}
+	}
+	if h.security.encryptionScope != "" {
+		if err := d.ensureEncryptionScope(ctx, resourceGroup, accountName, h.security); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureContainerReplication replicates source container to the container of the same name in destination account,
+// blob versioning and change feed required by object replication are enabled on both accounts,
+// encryptionScope is set as default encryption scope of destination container if it's not empty
+func (d *Driver) ensureContainerReplication(ctx context.Context, sourceResourceGroup, sourceAccount, destResourceGroup, destAccount, containerName, encryptionScope string) error {
+	if d.objectReplicationPoliciesClient == nil {
+		return fmt.Errorf("object replication policies client is not initialized")
+	}
+	versioning := &dataProtectionOptions{versioning: to.BoolPtr(true)}
+	if err := d.ensureBlobDataProtection(ctx, destResourceGroup, destAccount, versioning); err != nil {
+		return err
+	}
+	if err := d.ensureBlobDataProtection(ctx, sourceResourceGroup, sourceAccount, &dataProtectionOptions{versioning: to.BoolPtr(true), changeFeed: to.BoolPtr(true)}); err != nil {
+		return err
+	}
+	if err := d.createBlobContainer(ctx, destResourceGroup, destAccount, containerName, encryptionScope); err != nil {
+		return fmt.Errorf("failed to create container(%s) in replication storage account(%s): %v", containerName, destAccount, err)
+	}
+
+	// object replication policy is shared by all volumes replicated between the two accounts
+	lockKey := "objectreplication" + sourceAccount + destAccount
+	d.volLockMap.LockEntry(lockKey)
+	defer d.volLockMap.UnlockEntry(lockKey)
+
+	policy, err := d.getObjectReplicationPolicy(ctx, destResourceGroup, destAccount, sourceAccount, destAccount)
+	if err != nil {
+		return err
+	}
+	policyID := newObjectReplicationPolicyID
+	var rules []storage.ObjectReplicationPolicyRule
+	if policy != nil {
+		policyID = to.String(policy.PolicyID)
+		if policy.Rules != nil {
+			rules = *policy.Rules
+		}
+	}
+	if policy == nil || findReplicationRule(rules, containerName) < 0 {
+		rules = append(rules, storage.ObjectReplicationPolicyRule{
+			SourceContainer:      to.StringPtr(containerName),
+			DestinationContainer: to.StringPtr(containerName),
+		})
+		klog.V(2).Infof("adding object replication rule of container(%s) from storage account(%s) to %s", containerName, sourceAccount, destAccount)
+		// policy should be created on destination account first, rule IDs are generated by destination account
+		result, err := d.objectReplicationPoliciesClient.CreateOrUpdate(ctx, destResourceGroup, destAccount, policyID,
+			d.newObjectReplicationPolicy(sourceResourceGroup, sourceAccount, destResourceGroup, destAccount, rules))
+		if err != nil {
+			return fmt.Errorf("failed to set object replication policy on storage account(%s): %v", destAccount, err)
+		}
+		policy = result.ObjectReplicationPolicyProperties
+		if policy == nil {
+			return fmt.Errorf("object replication policy returned by storage account(%s) is empty", destAccount)
+		}
+	}
+
+	// source policy is also updated if last update on source account failed
+	sourcePolicy, err := d.getObjectReplicationPolicy(ctx, sourceResourceGroup, sourceAccount, sourceAccount, destAccount)
+	if err != nil {
+		return err
+	}
+	if sourcePolicy != nil && sourcePolicy.Rules != nil && findReplicationRule(*sourcePolicy.Rules, containerName) >= 0 {
+		return nil
+	}
+	if _, err := d.objectReplicationPoliciesClient.CreateOrUpdate(ctx, sourceResourceGroup, sourceAccount, to.String(policy.PolicyID),
+		storage.ObjectReplicationPolicy{ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+			SourceAccount:      policy.SourceAccount,
+			DestinationAccount: policy.DestinationAccount,
+			Rules:              policy.Rules,
+		}}); err != nil {
+		return fmt.Errorf("failed to set object replication policy on storage account(%s): %v", sourceAccount, err)
+	}
+	return nil
+}
+
+// removeContainerReplication removes object replication rules of source container from replication policies on
+// both source and destination accounts, policy is deleted if there is no rule left, replica container is kept
+func (d *Driver) removeContainerReplication(ctx context.Context, resourceGroup, accountName, containerName string, secrets map[string]string) error {
+	if len(secrets) > 0 || d.objectReplicationPoliciesClient == nil {
+		return nil
+	}
+	policies, err := d.objectReplicationPoliciesClient.List(ctx, resourceGroup, accountName)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return status.Errorf(codes.Internal, "failed to list object replication policies of storage account(%s): %v", accountName, err)
+	}
+	if policies.Value == nil {
+		return nil
+	}
+	for _, policy := range *policies.Value {
+		if policy.ObjectReplicationPolicyProperties == nil || policy.Rules == nil || !isSameStorageAccount(to.String(policy.SourceAccount), accountName) {
+			continue
+		}
+		index := findReplicationRule(*policy.Rules, containerName)
+		if index < 0 {
+			continue
+		}
+		rules := append(append([]storage.ObjectReplicationPolicyRule{}, (*policy.Rules)[:index]...), (*policy.Rules)[index+1:]...)
+		destResourceGroup, destAccount := parseStorageAccountResourceID(to.String(policy.DestinationAccount), resourceGroup)
+		klog.V(2).Infof("removing object replication rule of container(%s) from storage account(%s) to %s", containerName, accountName, destAccount)
+		// rule is removed from source account first, then destination container is writable after removing the rule on destination account
+		for _, account := range [][]string{{resourceGroup, accountName}, {destResourceGroup, destAccount}} {
+			if err := d.updateObjectReplicationRules(ctx, account[0], account[1], &policy, rules); err != nil {
+				return status.Errorf(codes.Internal, "failed to remove object replication rule of container(%s): %v", containerName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// updateObjectReplicationRules updates rules of object replication policy on storage account, policy is deleted if rules are empty
+func (d *Driver) updateObjectReplicationRules(ctx context.Context, resourceGroup, accountName string, policy *storage.ObjectReplicationPolicy, rules []storage.ObjectReplicationPolicyRule) error {
+	policyID := to.String(policy.PolicyID)
+	if len(rules) == 0 {
+		if _, err := d.objectReplicationPoliciesClient.Delete(ctx, resourceGroup, accountName, policyID); err != nil && !isNotFoundError(err) {
+			return fmt.Errorf("failed to delete object replication policy(%s) on storage account(%s): %v", policyID, accountName, err)
+		}
+		return nil
+	}
+	if _, err := d.objectReplicationPoliciesClient.CreateOrUpdate(ctx, resourceGroup, accountName, policyID,
+		storage.ObjectReplicationPolicy{ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+			SourceAccount:      policy.SourceAccount,
+			DestinationAccount: policy.DestinationAccount,
+			Rules:              &rules,
+		}}); err != nil {
+		return fmt.Errorf("failed to update object replication policy(%s) on storage account(%s): %v", policyID, accountName, err)
+	}
+	return nil
+}
+
+// getObjectReplicationPolicy returns object replication policy between source and destination accounts on storage account,
+// nil is returned if there is no such policy
+func (d *Driver) getObjectReplicationPolicy(ctx context.Context, resourceGroup, accountName, sourceAccount, destAccount string) (*storage.ObjectReplicationPolicyProperties, error) {
+	policies, err := d.objectReplicationPoliciesClient.List(ctx, resourceGroup, accountName)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list object replication policies of storage account(%s): %v", accountName, err)
+	}
+	if policies.Value == nil {
+		return nil, nil
+	}
+	for _, policy := range *policies.Value {
+		if policy.ObjectReplicationPolicyProperties != nil &&
+			isSameStorageAccount(to.String(policy.SourceAccount), sourceAccount) && isSameStorageAccount(to.String(policy.DestinationAccount), destAccount) {
+			return policy.ObjectReplicationPolicyProperties, nil
+		}
+	}
+	return nil, nil
+}
+
+// newObjectReplicationPolicy returns object replication policy which identifies storage accounts by resource ID
+func (d *Driver) newObjectReplicationPolicy(sourceResourceGroup, sourceAccount, destResourceGroup, destAccount string, rules []storage.ObjectReplicationPolicyRule) storage.ObjectReplicationPolicy {
+	return storage.ObjectReplicationPolicy{
+		ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+			SourceAccount:      to.StringPtr(fmt.Sprintf(storageAccountResourceIDTemplate, d.cloud.SubscriptionID, sourceResourceGroup, sourceAccount)),
+			DestinationAccount: to.StringPtr(fmt.Sprintf(storageAccountResourceIDTemplate, d.cloud.SubscriptionID, destResourceGroup, destAccount)),
+			Rules:              &rules,
+		},
+	}
+}
+
+// findReplicationRule returns index of the rule with source container, -1 if not found
+func findReplicationRule(rules []storage.ObjectReplicationPolicyRule, containerName string) int {
+	for i, rule := range rules {
+		if to.String(rule.SourceContainer) == containerName {
+			return i
+		}
+	}
+	return -1
+}
+
+// isSameStorageAccount checks whether account in replication policy, which is account name or resource ID, is the storage account
+func isSameStorageAccount(account, accountName string) bool {
+	_, name := parseStorageAccountResourceID(account, "")
+	return strings.EqualFold(name, accountName)
+}
+
+// parseStorageAccountResourceID returns resource group and name of storage account from its resource ID,
+// defaultResourceGroup is returned if account is specified by name
+func parseStorageAccountResourceID(account, defaultResourceGroup string) (string, string) {
+	segments := strings.Split(strings.Trim(account, "/"), "/")
+	if len(segments) < 8 {
+		return defaultResourceGroup, account
+	}
+	return segments[3], segments[len(segments)-1]
+}
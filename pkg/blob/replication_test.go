@@ -0,0 +1,248 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/blob/mockstorageapi"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParseObjectReplicationOptions(t *testing.T) {
+	o := parseObjectReplicationOptions(map[string]string{"replicationLocation": "westus", "replicationResourceGroup": "rg2", "skuName": "Standard_LRS"})
+	assert.Equal(t, &objectReplicationOptions{location: "westus", resourceGroup: "rg2"}, o)
+	assert.True(t, o.isSet())
+	assert.False(t, parseObjectReplicationOptions(map[string]string{"replicationResourceGroup": "rg2"}).isSet())
+	assert.True(t, parseObjectReplicationOptions(map[string]string{"replicationStorageAccount": "account2"}).isSet())
+}
+
+func TestParseStorageAccountResourceID(t *testing.T) {
+	rg, name := parseStorageAccountResourceID("/subscriptions/sub/resourceGroups/rg2/providers/Microsoft.Storage/storageAccounts/account2", "rg")
+	assert.Equal(t, "rg2", rg)
+	assert.Equal(t, "account2", name)
+	rg, name = parseStorageAccountResourceID("account2", "rg")
+	assert.Equal(t, "rg", rg)
+	assert.Equal(t, "account2", name)
+	assert.True(t, isSameStorageAccount("/subscriptions/sub/resourceGroups/rg2/providers/Microsoft.Storage/storageAccounts/Account2", "account2"))
+	assert.False(t, isSameStorageAccount("account1", "account2"))
+}
+
+func TestGetReplicationStorageAccountName(t *testing.T) {
+	accountName := getReplicationStorageAccountName("sub", "rg", "account1", "rg2", "westus")
+	assert.Equal(t, 24, len(accountName))
+	assert.True(t, strings.HasPrefix(accountName, replicationStorageAccountNamePrefix))
+	assert.Equal(t, accountName, getReplicationStorageAccountName("sub", "RG", "Account1", "rg2", "WestUS"))
+	assert.NotEqual(t, accountName, getReplicationStorageAccountName("sub", "rg", "account2", "rg2", "westus"))
+	assert.NotEqual(t, accountName, getReplicationStorageAccountName("sub", "rg", "account1", "rg2", "westus2"))
+}
+
+func TestEnsureReplicationStorageAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageAccountsClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{StorageAccountClient: mockStorageAccountsClient}
+	d.cloud.Location = "eastus"
+	d.cloud.SubscriptionID = "sub"
+	sourceOptions := &azureprovider.AccountOptions{Name: "account1", ResourceGroup: "rg", Type: "Standard_LRS", Tags: map[string]string{"key": "value"}}
+	hardening := &replicaHardeningOptions{security: &storageSecurityOptions{minTLSVersion: storage.MinimumTLSVersionTLS12}}
+	keys := storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{Value: to.StringPtr("key")}}}
+
+	_, _, err := d.ensureReplicationStorageAccount(context.TODO(), sourceOptions, &objectReplicationOptions{location: "EastUS"}, hardening)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, _, err = d.ensureReplicationStorageAccount(context.TODO(), sourceOptions, &objectReplicationOptions{accountName: "account1"}, hardening)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// storage account dedicated to source account is created, and security settings of source account are applied
+	accountName := getReplicationStorageAccountName("sub", "rg", "account1", "rg", "westus")
+	sourceAccountID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account1"
+	replica := storage.Account{
+		Tags:              map[string]*string{replicationSourceTag: to.StringPtr(sourceAccountID)},
+		AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS10},
+	}
+	gomock.InOrder(
+		mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "rg", accountName).Return(storage.AccountListKeysResult{}, &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("not found")}).Times(1),
+		mockStorageAccountsClient.EXPECT().Create(gomock.Any(), "rg", accountName, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, parameters storage.AccountCreateParameters) *retry.Error {
+				assert.Equal(t, "westus", to.String(parameters.Location))
+				assert.Equal(t, "value", to.String(parameters.Tags["key"]))
+				assert.Equal(t, sourceAccountID, to.String(parameters.Tags[replicationSourceTag]))
+				return nil
+			}).Times(1),
+		mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "rg", accountName).Return(keys, nil).Times(1),
+		mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", accountName).Return(replica, nil).Times(2),
+		mockStorageAccountsClient.EXPECT().Update(gomock.Any(), "rg", accountName, storage.AccountUpdateParameters{
+			AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{MinimumTLSVersion: storage.MinimumTLSVersionTLS12},
+		}).Return(nil).Times(1),
+	)
+	rg, name, err := d.ensureReplicationStorageAccount(context.TODO(), sourceOptions, &objectReplicationOptions{location: "westus"}, hardening)
+	assert.NoError(t, err)
+	assert.Equal(t, "rg", rg)
+	assert.Equal(t, accountName, name)
+	// tags of source account are not changed
+	assert.Equal(t, map[string]string{"key": "value"}, sourceOptions.Tags)
+
+	// existing storage account which is not dedicated to source account is not used
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "rg", accountName).Return(keys, nil).Times(2)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg", accountName).Return(storage.Account{}, nil).Times(1)
+	_, _, err = d.ensureReplicationStorageAccount(context.TODO(), sourceOptions, &objectReplicationOptions{location: "westus"}, hardening)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// specified storage account is not created and tag is not checked
+	mockStorageAccountsClient.EXPECT().ListKeys(gomock.Any(), "rg2", "account2").Return(keys, nil).Times(1)
+	mockStorageAccountsClient.EXPECT().GetProperties(gomock.Any(), "rg2", "account2").Return(storage.Account{
+		AccountProperties: &storage.AccountProperties{MinimumTLSVersion: storage.MinimumTLSVersionTLS12},
+	}, nil).Times(1)
+	rg, name, err = d.ensureReplicationStorageAccount(context.TODO(), sourceOptions, &objectReplicationOptions{resourceGroup: "rg2", accountName: "account2"}, hardening)
+	assert.NoError(t, err)
+	assert.Equal(t, "rg2", rg)
+	assert.Equal(t, "account2", name)
+}
+
+func TestContainerReplication(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{}
+	d.cloud.SubscriptionID = "sub"
+	assert.Error(t, d.ensureContainerReplication(context.TODO(), "rg", "account1", "rg2", "account2", "container1", ""))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPoliciesClient := mockstorageapi.NewMockObjectReplicationPoliciesClientAPI(ctrl)
+	mockContainersClient := mockstorageapi.NewMockBlobContainersClientAPI(ctrl)
+	mockServicesClient := mockstorageapi.NewMockBlobServicesClientAPI(ctrl)
+	d.objectReplicationPoliciesClient = mockPoliciesClient
+	d.blobContainersClient = mockContainersClient
+	d.blobServicesClient = mockServicesClient
+
+	sourceAccountID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account1"
+	destAccountID := "/subscriptions/sub/resourceGroups/rg2/providers/Microsoft.Storage/storageAccounts/account2"
+	newRule := func(containerName, ruleID string) storage.ObjectReplicationPolicyRule {
+		rule := storage.ObjectReplicationPolicyRule{SourceContainer: to.StringPtr(containerName), DestinationContainer: to.StringPtr(containerName)}
+		if ruleID != "" {
+			rule.RuleID = to.StringPtr(ruleID)
+		}
+		return rule
+	}
+	newPolicies := func(rules ...storage.ObjectReplicationPolicyRule) storage.ObjectReplicationPolicies {
+		return storage.ObjectReplicationPolicies{Value: &[]storage.ObjectReplicationPolicy{{
+			ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+				PolicyID:           to.StringPtr("policy"),
+				SourceAccount:      to.StringPtr(sourceAccountID),
+				DestinationAccount: to.StringPtr(destAccountID),
+				Rules:              &rules,
+			},
+		}}}
+	}
+	versioningEnabled := storage.BlobServiceProperties{BlobServicePropertiesProperties: &storage.BlobServicePropertiesProperties{
+		IsVersioningEnabled: to.BoolPtr(true),
+		ChangeFeed:          &storage.ChangeFeed{Enabled: to.BoolPtr(true)},
+	}}
+
+	// versioning is enabled on both accounts, policy is created on destination account first
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), gomock.Any(), gomock.Any()).Return(storage.BlobServiceProperties{}, nil).Times(2)
+	mockServicesClient.EXPECT().SetServiceProperties(gomock.Any(), "rg2", "account2", gomock.Any()).Return(storage.BlobServiceProperties{}, nil).Times(1)
+	mockServicesClient.EXPECT().SetServiceProperties(gomock.Any(), "rg", "account1", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, parameters storage.BlobServiceProperties) (storage.BlobServiceProperties, error) {
+			assert.True(t, to.Bool(parameters.IsVersioningEnabled))
+			assert.True(t, to.Bool(parameters.ChangeFeed.Enabled))
+			return parameters, nil
+		}).Times(1)
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg2", "account2", "container1").Return(storage.BlobContainer{}, autorest.DetailedError{StatusCode: http.StatusNotFound}).Times(1)
+	// encryption scope of source container is used by replica container
+	mockContainersClient.EXPECT().Create(gomock.Any(), "rg2", "account2", "container1", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName, containerName string, container storage.BlobContainer) (storage.BlobContainer, error) {
+			assert.Equal(t, "scope1", to.String(container.DefaultEncryptionScope))
+			return container, nil
+		}).Times(1)
+	gomock.InOrder(
+		mockPoliciesClient.EXPECT().List(gomock.Any(), "rg2", "account2").Return(storage.ObjectReplicationPolicies{}, nil).Times(1),
+		mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg2", "account2", newObjectReplicationPolicyID,
+			d.newObjectReplicationPolicy("rg", "account1", "rg2", "account2", []storage.ObjectReplicationPolicyRule{newRule("container1", "")})).
+			Return((*newPolicies(newRule("container1", "rule1")).Value)[0], nil).Times(1),
+		mockPoliciesClient.EXPECT().List(gomock.Any(), "rg", "account1").Return(storage.ObjectReplicationPolicies{}, nil).Times(1),
+		// policy ID and rule IDs generated by destination account are used on source account
+		mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account1", "policy",
+			storage.ObjectReplicationPolicy{ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+				SourceAccount:      to.StringPtr(sourceAccountID),
+				DestinationAccount: to.StringPtr(destAccountID),
+				Rules:              &[]storage.ObjectReplicationPolicyRule{newRule("container1", "rule1")},
+			}}).Return(storage.ObjectReplicationPolicy{}, nil).Times(1),
+	)
+	assert.NoError(t, d.ensureContainerReplication(context.TODO(), "rg", "account1", "rg2", "account2", "container1", "scope1"))
+
+	// rule is appended to existing policy
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), gomock.Any(), gomock.Any()).Return(versioningEnabled, nil).Times(2)
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg2", "account2", "container2").Return(storage.BlobContainer{}, nil).Times(1)
+	gomock.InOrder(
+		mockPoliciesClient.EXPECT().List(gomock.Any(), "rg2", "account2").Return(newPolicies(newRule("container1", "rule1")), nil).Times(1),
+		mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg2", "account2", "policy",
+			d.newObjectReplicationPolicy("rg", "account1", "rg2", "account2", []storage.ObjectReplicationPolicyRule{newRule("container1", "rule1"), newRule("container2", "")})).
+			Return((*newPolicies(newRule("container1", "rule1"), newRule("container2", "rule2")).Value)[0], nil).Times(1),
+		mockPoliciesClient.EXPECT().List(gomock.Any(), "rg", "account1").Return(newPolicies(newRule("container1", "rule1")), nil).Times(1),
+		mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account1", "policy", gomock.Any()).Return(storage.ObjectReplicationPolicy{}, nil).Times(1),
+	)
+	assert.NoError(t, d.ensureContainerReplication(context.TODO(), "rg", "account1", "rg2", "account2", "container2", ""))
+
+	// policies are not changed if rule exists on both accounts
+	mockServicesClient.EXPECT().GetServiceProperties(gomock.Any(), gomock.Any(), gomock.Any()).Return(versioningEnabled, nil).Times(2)
+	mockContainersClient.EXPECT().Get(gomock.Any(), "rg2", "account2", "container1").Return(storage.BlobContainer{}, nil).Times(1)
+	mockPoliciesClient.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).Return(newPolicies(newRule("container1", "rule1"), newRule("container2", "rule2")), nil).Times(2)
+	assert.NoError(t, d.ensureContainerReplication(context.TODO(), "rg", "account1", "rg2", "account2", "container1", ""))
+
+	// replication is kept if volume is created with secrets
+	assert.NoError(t, d.removeContainerReplication(context.TODO(), "rg", "account1", "container1", map[string]string{"accountname": "account1"}))
+
+	// rule is removed from source account first
+	gomock.InOrder(
+		mockPoliciesClient.EXPECT().List(gomock.Any(), "rg", "account1").Return(newPolicies(newRule("container1", "rule1"), newRule("container2", "rule2")), nil).Times(1),
+		mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "account1", "policy",
+			storage.ObjectReplicationPolicy{ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+				SourceAccount:      to.StringPtr(sourceAccountID),
+				DestinationAccount: to.StringPtr(destAccountID),
+				Rules:              &[]storage.ObjectReplicationPolicyRule{newRule("container2", "rule2")},
+			}}).Return(storage.ObjectReplicationPolicy{}, nil).Times(1),
+		mockPoliciesClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg2", "account2", "policy", gomock.Any()).Return(storage.ObjectReplicationPolicy{}, nil).Times(1),
+	)
+	assert.NoError(t, d.removeContainerReplication(context.TODO(), "rg", "account1", "container1", nil))
+
+	// policy is deleted with the last rule
+	gomock.InOrder(
+		mockPoliciesClient.EXPECT().List(gomock.Any(), "rg", "account1").Return(newPolicies(newRule("container2", "rule2")), nil).Times(1),
+		mockPoliciesClient.EXPECT().Delete(gomock.Any(), "rg", "account1", "policy").Return(autorest.Response{}, nil).Times(1),
+		mockPoliciesClient.EXPECT().Delete(gomock.Any(), "rg2", "account2", "policy").Return(autorest.Response{}, nil).Times(1),
+	)
+	assert.NoError(t, d.removeContainerReplication(context.TODO(), "rg", "account1", "container2", nil))
+
+	mockPoliciesClient.EXPECT().List(gomock.Any(), "rg", "account1").Return(storage.ObjectReplicationPolicies{}, nil).Times(1)
+	assert.NoError(t, d.removeContainerReplication(context.TODO(), "rg", "account1", "container3", nil))
+}
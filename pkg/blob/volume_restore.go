@@ -0,0 +1,278 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// restoreTimeAnnotation is set on persistent volume by user to restore blobs of the volume to a point in time(RFC3339)
+	restoreTimeAnnotation = "blob.csi.azure.com/restore-time"
+	// lastRestoreTimeAnnotation records restore time of the restore request started by driver
+	lastRestoreTimeAnnotation = "blob.csi.azure.com/last-restore-time"
+	// restoreStatusAnnotation records status of the restore request: InProgress, Complete, Failed or Unknown
+	restoreStatusAnnotation = "blob.csi.azure.com/restore-status"
+	// restoreMessageAnnotation records failure reason of the restore request
+	restoreMessageAnnotation = "blob.csi.azure.com/restore-message"
+	// restoreIDAnnotation records ID of the restore request, status of storage account is only applied on the volume with the same restore ID
+	restoreIDAnnotation = "blob.csi.azure.com/restore-id"
+
+	restoreStatusFailed = "Failed"
+	// status of the restore request is no longer reported by storage account since another restore is started on the account
+	restoreStatusUnknown = "Unknown"
+
+	// lease duration, renew deadline and retry period of leader election among controller replicas
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+// runVolumeRestoreController syncs volume restores every period while this controller replica is the leader,
+// so that restores are started and their status is updated by only one replica, it returns when ctx is done
+func (d *Driver) runVolumeRestoreController(ctx context.Context, period time.Duration) {
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Errorf("failed to get hostname as leader election identity: %v, volume restore is disabled", err)
+		return
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: getVolumeRestoreLeaseName(d.Name), Namespace: d.leaderElectionNamespace},
+		Client:     d.cloud.KubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	// campaign again after leadership is lost
+	wait.Until(func() {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaderElectionLeaseDuration,
+			RenewDeadline:   leaderElectionRenewDeadline,
+			RetryPeriod:     leaderElectionRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            lock.LeaseMeta.Name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.V(2).Infof("%s became leader of volume restore", identity)
+					wait.Until(func() { d.syncVolumeRestores(ctx) }, period, ctx.Done())
+				},
+				OnStoppedLeading: func() {
+					klog.V(2).Infof("%s is no longer leader of volume restore", identity)
+				},
+			},
+		})
+	}, leaderElectionRetryPeriod, ctx.Done())
+}
+
+// getVolumeRestoreLeaseName returns name of the lease which elects the controller replica syncing volume restores
+func getVolumeRestoreLeaseName(driverName string) string {
+	return strings.Replace(driverName, ".", "-", -1) + "-volume-restore"
+}
+
+// syncVolumeRestores starts or updates blob restore of persistent volumes provisioned by driver with restore annotation
+func (d *Driver) syncVolumeRestores(ctx context.Context) {
+	pvs, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("failed to list persistent volumes: %v", err)
+		return
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Annotations[restoreTimeAnnotation] == "" {
+			continue
+		}
+		if err := d.syncVolumeRestore(ctx, pv); err != nil {
+			klog.Errorf("failed to sync restore of persistent volume(%s): %v", pv.Name, err)
+		}
+	}
+}
+
+// syncVolumeRestore starts blob restore when restore time annotation is changed, or updates status of the restore in progress
+func (d *Driver) syncVolumeRestore(ctx context.Context, pv *v1.PersistentVolume) error {
+	restoreTime := pv.Annotations[restoreTimeAnnotation]
+	if pv.Annotations[lastRestoreTimeAnnotation] == restoreTime {
+		if pv.Annotations[restoreStatusAnnotation] != string(storage.BlobRestoreProgressStatusInProgress) {
+			return nil
+		}
+		return d.updateVolumeRestoreStatus(ctx, pv)
+	}
+
+	resourceGroup, accountName, containerName, err := GetContainerInfo(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return d.setVolumeRestoreStatus(ctx, pv, "", restoreStatusFailed, err.Error())
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	parsedTime, err := time.Parse(time.RFC3339, restoreTime)
+	if err != nil {
+		return d.setVolumeRestoreStatus(ctx, pv, "", restoreStatusFailed, fmt.Sprintf("invalid %s: %s, should be in RFC3339 format", restoreTimeAnnotation, restoreTime))
+	}
+	if d.accountsClient == nil {
+		return d.setVolumeRestoreStatus(ctx, pv, "", restoreStatusFailed, "blob restore client is not initialized")
+	}
+
+	// only one restore could be run on a storage account at a time
+	restoreStatus, err := d.getBlobRestoreStatus(ctx, resourceGroup, accountName)
+	if err != nil {
+		return err
+	}
+	if restoreStatus != nil && restoreStatus.Status == storage.BlobRestoreProgressStatusInProgress {
+		klog.V(2).Infof("restore of persistent volume(%s) is pending since restore(%s) is in progress on storage account(%s)", pv.Name, to.String(restoreStatus.RestoreID), accountName)
+		return nil
+	}
+
+	blobRange := getVolumeBlobRange(containerName, getSubDirFromVolumeID(pv.Spec.CSI.VolumeHandle))
+	klog.V(2).Infof("restoring blobs in range(%s, %s) of storage account(%s) to %s for persistent volume(%s)", to.String(blobRange.StartRange), to.String(blobRange.EndRange), accountName, restoreTime, pv.Name)
+	if _, err := d.accountsClient.RestoreBlobRanges(ctx, resourceGroup, accountName, storage.BlobRestoreParameters{
+		TimeToRestore: &date.Time{Time: parsedTime},
+		BlobRanges:    &[]storage.BlobRestoreRange{blobRange},
+	}); err != nil {
+		return d.setVolumeRestoreStatus(ctx, pv, "", restoreStatusFailed, err.Error())
+	}
+
+	restoreID := ""
+	if restoreStatus, err = d.getBlobRestoreStatus(ctx, resourceGroup, accountName); err != nil {
+		// restore ID would be found by restore parameters in later sync
+		klog.Warningf("failed to get restore ID of persistent volume(%s): %v", pv.Name, err)
+	} else if isVolumeBlobRestore(restoreStatus, parsedTime, blobRange) {
+		restoreID = to.String(restoreStatus.RestoreID)
+	}
+	return d.setVolumeRestoreStatus(ctx, pv, restoreID, string(storage.BlobRestoreProgressStatusInProgress), "")
+}
+
+// updateVolumeRestoreStatus updates restore status of persistent volume from blob restore status of storage account,
+// status is only applied when restore ID of storage account is the one recorded on persistent volume
+func (d *Driver) updateVolumeRestoreStatus(ctx context.Context, pv *v1.PersistentVolume) error {
+	resourceGroup, accountName, containerName, err := GetContainerInfo(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return err
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	if d.accountsClient == nil {
+		return fmt.Errorf("blob restore client is not initialized")
+	}
+	restoreStatus, err := d.getBlobRestoreStatus(ctx, resourceGroup, accountName)
+	if err != nil {
+		return err
+	}
+	if restoreStatus == nil {
+		return nil
+	}
+
+	restoreID := pv.Annotations[restoreIDAnnotation]
+	if restoreID == "" {
+		parsedTime, err := time.Parse(time.RFC3339, pv.Annotations[restoreTimeAnnotation])
+		if err != nil {
+			return err
+		}
+		blobRange := getVolumeBlobRange(containerName, getSubDirFromVolumeID(pv.Spec.CSI.VolumeHandle))
+		if !isVolumeBlobRestore(restoreStatus, parsedTime, blobRange) {
+			return d.setVolumeRestoreStatus(ctx, pv, "", restoreStatusUnknown,
+				fmt.Sprintf("restore is not found on storage account(%s), restore(%s) is the latest one", accountName, to.String(restoreStatus.RestoreID)))
+		}
+		restoreID = to.String(restoreStatus.RestoreID)
+	}
+	if to.String(restoreStatus.RestoreID) != restoreID {
+		return d.setVolumeRestoreStatus(ctx, pv, restoreID, restoreStatusUnknown,
+			fmt.Sprintf("status is not available since restore(%s) is started on storage account(%s) afterwards", to.String(restoreStatus.RestoreID), accountName))
+	}
+	if restoreStatus.Status == storage.BlobRestoreProgressStatusInProgress {
+		if pv.Annotations[restoreIDAnnotation] == "" {
+			return d.setVolumeRestoreStatus(ctx, pv, restoreID, string(restoreStatus.Status), "")
+		}
+		return nil
+	}
+	return d.setVolumeRestoreStatus(ctx, pv, restoreID, string(restoreStatus.Status), to.String(restoreStatus.FailureReason))
+}
+
+// getBlobRestoreStatus returns status of the latest blob restore on storage account, nil means no blob restore
+func (d *Driver) getBlobRestoreStatus(ctx context.Context, resourceGroup, accountName string) (*storage.BlobRestoreStatus, error) {
+	account, err := d.accountsClient.GetProperties(ctx, resourceGroup, accountName, storage.AccountExpandBlobRestoreStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob restore status of storage account(%s): %v", accountName, err)
+	}
+	if account.AccountProperties == nil {
+		return nil, nil
+	}
+	return account.BlobRestoreStatus, nil
+}
+
+// isVolumeBlobRestore checks whether restoreStatus is of the restore to restoreTime on blobRange
+func isVolumeBlobRestore(restoreStatus *storage.BlobRestoreStatus, restoreTime time.Time, blobRange storage.BlobRestoreRange) bool {
+	if restoreStatus == nil || restoreStatus.Parameters == nil || restoreStatus.Parameters.TimeToRestore == nil || restoreStatus.Parameters.BlobRanges == nil {
+		return false
+	}
+	if !restoreStatus.Parameters.TimeToRestore.Time.Equal(restoreTime) {
+		return false
+	}
+	for _, r := range *restoreStatus.Parameters.BlobRanges {
+		if to.String(r.StartRange) == to.String(blobRange.StartRange) && to.String(r.EndRange) == to.String(blobRange.EndRange) {
+			return true
+		}
+	}
+	return false
+}
+
+// setVolumeRestoreStatus records ID and status of the restore request of current restore time on persistent volume
+func (d *Driver) setVolumeRestoreStatus(ctx context.Context, pv *v1.PersistentVolume, restoreID, status, message string) error {
+	pv = pv.DeepCopy()
+	pv.Annotations[lastRestoreTimeAnnotation] = pv.Annotations[restoreTimeAnnotation]
+	pv.Annotations[restoreStatusAnnotation] = status
+	if restoreID == "" {
+		delete(pv.Annotations, restoreIDAnnotation)
+	} else {
+		pv.Annotations[restoreIDAnnotation] = restoreID
+	}
+	if message == "" {
+		delete(pv.Annotations, restoreMessageAnnotation)
+	} else {
+		pv.Annotations[restoreMessageAnnotation] = message
+	}
+	klog.V(2).Infof("restore(%s) status of persistent volume(%s) to %s: %s %s", restoreID, pv.Name, pv.Annotations[restoreTimeAnnotation], status, message)
+	if _, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update restore status: %v", err)
+	}
+	return nil
+}
+
+// getVolumeBlobRange returns blob range of all blobs under container or sub directory of the volume,
+// e.g. [container/subDir/, container/subDir0), '0' is the next character of '/'
+func getVolumeBlobRange(containerName, subDir string) storage.BlobRestoreRange {
+	prefix := getLifecyclePrefix(containerName, subDir)
+	return storage.BlobRestoreRange{
+		StartRange: to.StringPtr(prefix),
+		EndRange:   to.StringPtr(strings.TrimSuffix(prefix, "/") + "0"),
+	}
+}
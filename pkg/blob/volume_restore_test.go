@@ -0,0 +1,215 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-02-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/blob/mockstorageapi"
+	azureprovider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// blobRestoreState records restore requests and blob restore status of storage account returned by mock accounts client
+type blobRestoreState struct {
+	requests   []storage.BlobRestoreParameters
+	restoreErr error
+	status     *storage.BlobRestoreStatus
+}
+
+func newMockBlobRestoreClient(ctrl *gomock.Controller, state *blobRestoreState) *mockstorageapi.MockAccountsClientAPI {
+	client := mockstorageapi.NewMockAccountsClientAPI(ctrl)
+	client.EXPECT().GetProperties(gomock.Any(), "rg", "account", storage.AccountExpandBlobRestoreStatus).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, expand storage.AccountExpand) (storage.Account, error) {
+			return storage.Account{AccountProperties: &storage.AccountProperties{BlobRestoreStatus: state.status}}, nil
+		}).AnyTimes()
+	client.EXPECT().RestoreBlobRanges(gomock.Any(), "rg", "account", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroupName, accountName string, parameters storage.BlobRestoreParameters) (storage.AccountsRestoreBlobRangesFuture, error) {
+			if state.restoreErr != nil {
+				return storage.AccountsRestoreBlobRangesFuture{}, state.restoreErr
+			}
+			state.requests = append(state.requests, parameters)
+			state.status = &storage.BlobRestoreStatus{
+				Status:     storage.BlobRestoreProgressStatusInProgress,
+				RestoreID:  to.StringPtr(fmt.Sprintf("restore-%d", len(state.requests))),
+				Parameters: &parameters,
+			}
+			return storage.AccountsRestoreBlobRangesFuture{}, nil
+		}).AnyTimes()
+	return client
+}
+
+func newRestoreTestPV(name, driver, volumeHandle string, annotations map[string]string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: volumeHandle},
+			},
+		},
+	}
+}
+
+func TestGetVolumeBlobRange(t *testing.T) {
+	blobRange := getVolumeBlobRange("container", "")
+	assert.Equal(t, "container/", to.String(blobRange.StartRange))
+	assert.Equal(t, "container0", to.String(blobRange.EndRange))
+
+	blobRange = getVolumeBlobRange("container", "dir/pvc")
+	assert.Equal(t, "container/dir/pvc/", to.String(blobRange.StartRange))
+	assert.Equal(t, "container/dir/pvc0", to.String(blobRange.EndRange))
+}
+
+func TestSyncVolumeRestores(t *testing.T) {
+	restoreTime := "2021-10-01T08:00:00Z"
+	kubeClient := fake.NewSimpleClientset(
+		newRestoreTestPV("pv-restore", fakeDriverName, "rg#account#container#pvc#subdir=dir", map[string]string{restoreTimeAnnotation: restoreTime}),
+		newRestoreTestPV("pv-invalid-time", fakeDriverName, "rg#account#container", map[string]string{restoreTimeAnnotation: "yesterday"}),
+		newRestoreTestPV("pv-no-annotation", fakeDriverName, "rg#account#container", nil),
+		newRestoreTestPV("pv-other-driver", "file.csi.azure.com", "rg#account#share", map[string]string{restoreTimeAnnotation: restoreTime}),
+	)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	restoreState := &blobRestoreState{}
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{KubeClient: kubeClient}
+	d.accountsClient = newMockBlobRestoreClient(ctrl, restoreState)
+
+	getAnnotations := func(name string) map[string]string {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return pv.Annotations
+	}
+
+	d.syncVolumeRestores(context.TODO())
+	assert.Equal(t, 1, len(restoreState.requests))
+	request := restoreState.requests[0]
+	assert.Equal(t, restoreTime, request.TimeToRestore.String())
+	assert.Equal(t, []storage.BlobRestoreRange{getVolumeBlobRange("container", "dir")}, *request.BlobRanges)
+	annotations := getAnnotations("pv-restore")
+	assert.Equal(t, restoreTime, annotations[lastRestoreTimeAnnotation])
+	assert.Equal(t, string(storage.BlobRestoreProgressStatusInProgress), annotations[restoreStatusAnnotation])
+	assert.Equal(t, restoreStatusFailed, getAnnotations("pv-invalid-time")[restoreStatusAnnotation])
+	assert.Empty(t, getAnnotations("pv-no-annotation")[restoreStatusAnnotation])
+	assert.Empty(t, getAnnotations("pv-other-driver")[restoreStatusAnnotation])
+
+	assert.Equal(t, "restore-1", annotations[restoreIDAnnotation])
+
+	// restore is not started again, status is kept while restore is in progress
+	d.syncVolumeRestores(context.TODO())
+	assert.Equal(t, 1, len(restoreState.requests))
+	assert.Equal(t, string(storage.BlobRestoreProgressStatusInProgress), getAnnotations("pv-restore")[restoreStatusAnnotation])
+
+	restoreState.status.Status = storage.BlobRestoreProgressStatusComplete
+	d.syncVolumeRestores(context.TODO())
+	assert.Equal(t, string(storage.BlobRestoreProgressStatusComplete), getAnnotations("pv-restore")[restoreStatusAnnotation])
+
+	// restore is started again with new restore time
+	pv, _ := kubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), "pv-restore", metav1.GetOptions{})
+	pv.Annotations[restoreTimeAnnotation] = "2021-10-02T08:00:00Z"
+	_, _ = kubeClient.CoreV1().PersistentVolumes().Update(context.TODO(), pv, metav1.UpdateOptions{})
+	restoreState.restoreErr = fmt.Errorf("restore is not enabled")
+	d.syncVolumeRestores(context.TODO())
+	annotations = getAnnotations("pv-restore")
+	assert.Equal(t, "2021-10-02T08:00:00Z", annotations[lastRestoreTimeAnnotation])
+	assert.Equal(t, restoreStatusFailed, annotations[restoreStatusAnnotation])
+	assert.Equal(t, "restore is not enabled", annotations[restoreMessageAnnotation])
+	assert.Empty(t, annotations[restoreIDAnnotation])
+}
+
+func TestSyncVolumeRestoresOnSharedAccount(t *testing.T) {
+	restoreTime := "2021-10-01T08:00:00Z"
+	kubeClient := fake.NewSimpleClientset(
+		newRestoreTestPV("pv-1", fakeDriverName, "rg#account#container#pvc-1#subdir=dir-1", map[string]string{restoreTimeAnnotation: restoreTime}),
+		newRestoreTestPV("pv-2", fakeDriverName, "rg#account#container#pvc-2#subdir=dir-2", map[string]string{restoreTimeAnnotation: restoreTime}),
+	)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	restoreState := &blobRestoreState{}
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{KubeClient: kubeClient}
+	d.accountsClient = newMockBlobRestoreClient(ctrl, restoreState)
+
+	getAnnotations := func(name string) map[string]string {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return pv.Annotations
+	}
+
+	// restore of pv-2 is pending while restore of pv-1 is in progress on the same storage account
+	d.syncVolumeRestores(context.TODO())
+	assert.Equal(t, 1, len(restoreState.requests))
+	assert.Equal(t, "restore-1", getAnnotations("pv-1")[restoreIDAnnotation])
+	assert.Empty(t, getAnnotations("pv-2")[restoreStatusAnnotation])
+
+	// status of pv-1 is updated by its own restore ID before restore of pv-2 is started
+	restoreState.status.Status = storage.BlobRestoreProgressStatusComplete
+	d.syncVolumeRestores(context.TODO())
+	assert.Equal(t, string(storage.BlobRestoreProgressStatusComplete), getAnnotations("pv-1")[restoreStatusAnnotation])
+	assert.Equal(t, 2, len(restoreState.requests))
+	assert.Equal(t, "restore-2", getAnnotations("pv-2")[restoreIDAnnotation])
+
+	// status of another restore is not applied on pv-2
+	restoreState.status = &storage.BlobRestoreStatus{Status: storage.BlobRestoreProgressStatusFailed, RestoreID: to.StringPtr("other")}
+	d.syncVolumeRestores(context.TODO())
+	annotations := getAnnotations("pv-2")
+	assert.Equal(t, restoreStatusUnknown, annotations[restoreStatusAnnotation])
+	assert.Equal(t, "restore-2", annotations[restoreIDAnnotation])
+	assert.Equal(t, string(storage.BlobRestoreProgressStatusComplete), getAnnotations("pv-1")[restoreStatusAnnotation])
+}
+
+func TestRunVolumeRestoreController(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		newRestoreTestPV("pv-restore", fakeDriverName, "rg#account#container", map[string]string{restoreTimeAnnotation: "2021-10-01T08:00:00Z"}),
+	)
+	d := NewFakeDriver()
+	d.cloud = &azureprovider.Cloud{KubeClient: kubeClient}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d.accountsClient = newMockBlobRestoreClient(ctrl, &blobRestoreState{})
+	d.leaderElectionNamespace = "kube-system"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.runVolumeRestoreController(ctx, time.Second)
+		close(done)
+	}()
+	err := wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.TODO(), "pv-restore", metav1.GetOptions{})
+		return err == nil && pv.Annotations[restoreStatusAnnotation] != "", err
+	})
+	assert.NoError(t, err)
+	lease, err := kubeClient.CoordinationV1().Leases("kube-system").Get(context.TODO(), getVolumeRestoreLeaseName(fakeDriverName), metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, lease.Spec.HolderIdentity)
+
+	cancel()
+	<-done
+}
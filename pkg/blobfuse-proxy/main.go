@@ -17,10 +17,19 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"net"
+	"net/http"
+	"net/http/pprof"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 
 	server "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/server"
@@ -33,6 +42,25 @@ func init() {
 
 var (
 	blobfuseProxyEndpoint = flag.String("blobfuse-proxy-endpoint", "unix://tmp/blobfuse-proxy.sock", "blobfuse-proxy endpoint")
+	supervisionInterval   = flag.Duration("blobfuse-supervision-interval", 10*time.Second, "interval to check blobfuse daemons and remount when a daemon exits, 0 disables supervision")
+	remountMaxBackoff     = flag.Duration("blobfuse-remount-max-backoff", 5*time.Minute, "maximum delay between failed remounts of an exited blobfuse daemon")
+	remountTimeout        = flag.Duration("blobfuse-remount-timeout", 2*time.Minute, "timeout of a remount of an exited blobfuse daemon, blobfuse is killed and remount is retried with backoff when it expires")
+	maxConcurrentMounts   = flag.Int("max-concurrent-mounts", 10, "maximum number of blobfuse mounts run at the same time, 0 means no limit")
+	allowedUIDs           = flag.String("allowed-uids", "0", "comma separated uids of processes allowed to connect to unix socket endpoint, empty means all users are allowed")
+	tlsCertFile           = flag.String("tls-cert-file", "", "server certificate file, TLS is enabled when it's specified")
+	tlsKeyFile            = flag.String("tls-key-file", "", "server private key file")
+	tlsClientCAFile       = flag.String("tls-client-ca-file", "", "CA file to verify client certificates, mutual TLS is enabled when it's specified")
+	allowedOptions        = flag.String("allowed-blobfuse-options", "", "comma separated blobfuse options allowed in mount requests besides the default ones, e.g. --max-retry")
+	allowedFuseOptions    = flag.String("allowed-fuse-options", "", "comma separated fuse options allowed in -o argument of mount requests besides the default ones, e.g. fsname")
+	allowedTmpPathRoots   = flag.String("allowed-tmp-path-roots", "/mnt", "comma separated directories under which --tmp-path of mount requests must be")
+	allowedCACertRoots    = flag.String("allowed-ca-cert-file-roots", "/etc/ssl/certs,/etc/pki", "comma separated directories under which --ca-cert-file of mount requests must be")
+	credentialDelivery    = flag.String("blobfuse-credential-delivery", "env", "how credentials are passed to blobfuse: env(environment variables), file(per-mount config file in credential dir) or fd(config file read from inherited pipe)")
+	credentialDir         = flag.String("blobfuse-credential-dir", "/run/blobfuse-proxy/credentials", "tmpfs directory of per-mount config files when blobfuse-credential-delivery is file")
+	metricsAddress        = flag.String("metrics-address", "", "address to export prometheus metrics, e.g. 127.0.0.1:29636, empty disables metrics endpoint")
+	enablePprof           = flag.Bool("enable-pprof", false, "serve pprof profiles under /debug/pprof/ on metrics address")
+	mountLogDir           = flag.String("mount-log-dir", "/var/log/blobfuse-proxy", "directory to retain per-mount logs of blobfuse output, empty disables mount logs")
+	mountLogMaxAge        = flag.Duration("mount-log-max-age", 7*24*time.Hour, "per-mount logs not modified in this duration are removed, 0 disables age limit")
+	mountLogMaxFiles      = flag.Int("mount-log-max-files", 100, "maximum number of per-mount logs, logs of unmounted or failed mounts are removed from the oldest, 0 disables count limit")
 )
 
 func main() {
@@ -54,11 +82,100 @@ func main() {
 	if err != nil {
 		klog.Fatal("cannot start server:", err)
 	}
+	if proto == "unix" && *allowedUIDs != "" {
+		uids, err := parseUIDs(*allowedUIDs)
+		if err != nil {
+			klog.Fatalf("invalid allowed-uids: %v", err)
+		}
+		listener = server.NewPeerCredListener(listener, uids)
+	}
 
-	mountServer := server.NewMountServiceServer()
+	var tlsConfig *tls.Config
+	if *tlsCertFile != "" {
+		if tlsConfig, err = server.NewServerTLSConfig(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile); err != nil {
+			klog.Fatalf("failed to create TLS config: %v", err)
+		}
+		if *tlsClientCAFile == "" && proto != "unix" {
+			klog.Warningf("client certificates are not verified on %s endpoint since tls-client-ca-file is not specified, any client which could reach it is able to mount", proto)
+		}
+	} else if proto != "unix" {
+		klog.Warningf("TLS is not enabled on %s endpoint, any client which could reach it is able to mount", proto)
+	}
+
+	mountServer := server.NewMountServiceServer(*maxConcurrentMounts)
+	mountServer.SetArgumentAllowlist(splitList(*allowedOptions), splitList(*allowedFuseOptions), map[string][]string{
+		"--tmp-path":     splitList(*allowedTmpPathRoots),
+		"--ca-cert-file": splitList(*allowedCACertRoots),
+	})
+	if err := mountServer.SetCredentialDelivery(*credentialDelivery, *credentialDir); err != nil {
+		klog.Fatalf("failed to set blobfuse credential delivery: %v", err)
+	}
+	if *mountLogDir != "" {
+		if err := mountServer.SetMountLogDir(*mountLogDir, *mountLogMaxAge, *mountLogMaxFiles); err != nil {
+			klog.Fatalf("failed to create mount log directory %s: %v", *mountLogDir, err)
+		}
+	}
+	if *metricsAddress != "" {
+		legacyregistry.CustomMustRegister(server.NewMountCollector(mountServer))
+		exportMetrics(*metricsAddress, *enablePprof)
+	} else if *enablePprof {
+		klog.Warningf("pprof is not served since metrics-address is not specified")
+	}
+	if *supervisionInterval > 0 {
+		go mountServer.SuperviseMounts(*supervisionInterval, *remountMaxBackoff, *remountTimeout, wait.NeverStop)
+	}
 
 	klog.V(2).Info("Listening for connections on address: %v\n", listener.Addr())
-	if err = server.RunGRPCServer(mountServer, false, listener); err != nil {
+	if err = server.RunGRPCServer(mountServer, tlsConfig, listener); err != nil {
 		klog.Fatalf("Error running grpc server. Error: %v", listener.Addr(), err)
 	}
 }
+
+// exportMetrics serves prometheus metrics, and pprof profiles if enablePprof is true, on address in background
+func exportMetrics(address string, enablePprof bool) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		klog.Warningf("failed to get listener for metrics endpoint: %v", err)
+		return
+	}
+	m := http.NewServeMux()
+	m.Handle("/metrics", legacyregistry.Handler()) //nolint, because metrics of mount server are registered in legacyregistry
+	if enablePprof {
+		m.HandleFunc("/debug/pprof/", pprof.Index)
+		m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	klog.V(2).Infof("set up prometheus server on %v, pprof enabled: %v", l.Addr(), enablePprof)
+	go func() {
+		defer l.Close()
+		if err := http.Serve(l, m); err != nil {
+			klog.Fatalf("serve failure(%v), address(%v)", err, l.Addr())
+		}
+	}()
+}
+
+// parseUIDs parses comma separated uids
+func parseUIDs(s string) ([]uint32, error) {
+	uids := []uint32{}
+	for _, v := range splitList(s) {
+		uid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid(%s): %v", v, err)
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return uids, nil
+}
+
+// splitList splits comma separated list, empty items are ignored
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
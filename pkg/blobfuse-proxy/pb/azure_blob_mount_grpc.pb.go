@@ -19,6 +19,11 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MountServiceClient interface {
 	MountAzureBlob(ctx context.Context, in *MountAzureBlobRequest, opts ...grpc.CallOption) (*MountAzureBlobResponse, error)
+	UnmountAzureBlob(ctx context.Context, in *UnmountAzureBlobRequest, opts ...grpc.CallOption) (*UnmountAzureBlobResponse, error)
+	ListMounts(ctx context.Context, in *ListMountsRequest, opts ...grpc.CallOption) (*ListMountsResponse, error)
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+	GetVersion(ctx context.Context, in *GetVersionRequest, opts ...grpc.CallOption) (*GetVersionResponse, error)
+	GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error)
 }
 
 type mountServiceClient struct {
@@ -38,11 +43,61 @@ func (c *mountServiceClient) MountAzureBlob(ctx context.Context, in *MountAzureB
 	return out, nil
 }
 
+func (c *mountServiceClient) UnmountAzureBlob(ctx context.Context, in *UnmountAzureBlobRequest, opts ...grpc.CallOption) (*UnmountAzureBlobResponse, error) {
+	out := new(UnmountAzureBlobResponse)
+	err := c.cc.Invoke(ctx, "/MountService/UnmountAzureBlob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mountServiceClient) ListMounts(ctx context.Context, in *ListMountsRequest, opts ...grpc.CallOption) (*ListMountsResponse, error) {
+	out := new(ListMountsResponse)
+	err := c.cc.Invoke(ctx, "/MountService/ListMounts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mountServiceClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, "/MountService/Health", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mountServiceClient) GetVersion(ctx context.Context, in *GetVersionRequest, opts ...grpc.CallOption) (*GetVersionResponse, error) {
+	out := new(GetVersionResponse)
+	err := c.cc.Invoke(ctx, "/MountService/GetVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mountServiceClient) GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error) {
+	out := new(GetCapabilitiesResponse)
+	err := c.cc.Invoke(ctx, "/MountService/GetCapabilities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MountServiceServer is the server API for MountService service.
 // All implementations must embed UnimplementedMountServiceServer
 // for forward compatibility
 type MountServiceServer interface {
 	MountAzureBlob(context.Context, *MountAzureBlobRequest) (*MountAzureBlobResponse, error)
+	UnmountAzureBlob(context.Context, *UnmountAzureBlobRequest) (*UnmountAzureBlobResponse, error)
+	ListMounts(context.Context, *ListMountsRequest) (*ListMountsResponse, error)
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	GetVersion(context.Context, *GetVersionRequest) (*GetVersionResponse, error)
+	GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error)
 	mustEmbedUnimplementedMountServiceServer()
 }
 
@@ -53,6 +108,21 @@ type UnimplementedMountServiceServer struct {
 func (UnimplementedMountServiceServer) MountAzureBlob(context.Context, *MountAzureBlobRequest) (*MountAzureBlobResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method MountAzureBlob not implemented")
 }
+func (UnimplementedMountServiceServer) UnmountAzureBlob(context.Context, *UnmountAzureBlobRequest) (*UnmountAzureBlobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnmountAzureBlob not implemented")
+}
+func (UnimplementedMountServiceServer) ListMounts(context.Context, *ListMountsRequest) (*ListMountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMounts not implemented")
+}
+func (UnimplementedMountServiceServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedMountServiceServer) GetVersion(context.Context, *GetVersionRequest) (*GetVersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVersion not implemented")
+}
+func (UnimplementedMountServiceServer) GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCapabilities not implemented")
+}
 func (UnimplementedMountServiceServer) mustEmbedUnimplementedMountServiceServer() {}
 
 // UnsafeMountServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -84,6 +154,96 @@ func _MountService_MountAzureBlob_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MountService_UnmountAzureBlob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnmountAzureBlobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceServer).UnmountAzureBlob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountService/UnmountAzureBlob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceServer).UnmountAzureBlob(ctx, req.(*UnmountAzureBlobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MountService_ListMounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceServer).ListMounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountService/ListMounts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceServer).ListMounts(ctx, req.(*ListMountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MountService_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountService/Health",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MountService_GetVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceServer).GetVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountService/GetVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceServer).GetVersion(ctx, req.(*GetVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MountService_GetCapabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceServer).GetCapabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountService/GetCapabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceServer).GetCapabilities(ctx, req.(*GetCapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MountService_ServiceDesc is the grpc.ServiceDesc for MountService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -95,7 +255,217 @@ var MountService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "MountAzureBlob",
 			Handler:    _MountService_MountAzureBlob_Handler,
 		},
+		{
+			MethodName: "UnmountAzureBlob",
+			Handler:    _MountService_UnmountAzureBlob_Handler,
+		},
+		{
+			MethodName: "ListMounts",
+			Handler:    _MountService_ListMounts_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _MountService_Health_Handler,
+		},
+		{
+			MethodName: "GetVersion",
+			Handler:    _MountService_GetVersion_Handler,
+		},
+		{
+			MethodName: "GetCapabilities",
+			Handler:    _MountService_GetCapabilities_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "azure_blob_mount.proto",
 }
+
+// MountServiceV2Client is the client API for MountServiceV2 service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MountServiceV2Client interface {
+	Mount(ctx context.Context, in *MountRequest, opts ...grpc.CallOption) (*MountResponse, error)
+	// MountStream mounts like Mount and streams progress and blobfuse output,
+	// an error is returned after the Failed event if the mount fails.
+	MountStream(ctx context.Context, in *MountRequest, opts ...grpc.CallOption) (MountServiceV2_MountStreamClient, error)
+	GetMountLog(ctx context.Context, in *GetMountLogRequest, opts ...grpc.CallOption) (*GetMountLogResponse, error)
+}
+
+type mountServiceV2Client struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMountServiceV2Client(cc grpc.ClientConnInterface) MountServiceV2Client {
+	return &mountServiceV2Client{cc}
+}
+
+func (c *mountServiceV2Client) Mount(ctx context.Context, in *MountRequest, opts ...grpc.CallOption) (*MountResponse, error) {
+	out := new(MountResponse)
+	err := c.cc.Invoke(ctx, "/MountServiceV2/Mount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mountServiceV2Client) MountStream(ctx context.Context, in *MountRequest, opts ...grpc.CallOption) (MountServiceV2_MountStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MountServiceV2_ServiceDesc.Streams[0], "/MountServiceV2/MountStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &mountServiceV2MountStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MountServiceV2_MountStreamClient interface {
+	Recv() (*MountEvent, error)
+	grpc.ClientStream
+}
+
+type mountServiceV2MountStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *mountServiceV2MountStreamClient) Recv() (*MountEvent, error) {
+	m := new(MountEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *mountServiceV2Client) GetMountLog(ctx context.Context, in *GetMountLogRequest, opts ...grpc.CallOption) (*GetMountLogResponse, error) {
+	out := new(GetMountLogResponse)
+	err := c.cc.Invoke(ctx, "/MountServiceV2/GetMountLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MountServiceV2Server is the server API for MountServiceV2 service.
+// All implementations must embed UnimplementedMountServiceV2Server
+// for forward compatibility
+type MountServiceV2Server interface {
+	Mount(context.Context, *MountRequest) (*MountResponse, error)
+	// MountStream mounts like Mount and streams progress and blobfuse output,
+	// an error is returned after the Failed event if the mount fails.
+	MountStream(*MountRequest, MountServiceV2_MountStreamServer) error
+	GetMountLog(context.Context, *GetMountLogRequest) (*GetMountLogResponse, error)
+	mustEmbedUnimplementedMountServiceV2Server()
+}
+
+// UnimplementedMountServiceV2Server must be embedded to have forward compatible implementations.
+type UnimplementedMountServiceV2Server struct {
+}
+
+func (UnimplementedMountServiceV2Server) Mount(context.Context, *MountRequest) (*MountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mount not implemented")
+}
+func (UnimplementedMountServiceV2Server) MountStream(*MountRequest, MountServiceV2_MountStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method MountStream not implemented")
+}
+func (UnimplementedMountServiceV2Server) GetMountLog(context.Context, *GetMountLogRequest) (*GetMountLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMountLog not implemented")
+}
+func (UnimplementedMountServiceV2Server) mustEmbedUnimplementedMountServiceV2Server() {}
+
+// UnsafeMountServiceV2Server may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MountServiceV2Server will
+// result in compilation errors.
+type UnsafeMountServiceV2Server interface {
+	mustEmbedUnimplementedMountServiceV2Server()
+}
+
+func RegisterMountServiceV2Server(s grpc.ServiceRegistrar, srv MountServiceV2Server) {
+	s.RegisterService(&MountServiceV2_ServiceDesc, srv)
+}
+
+func _MountServiceV2_Mount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceV2Server).Mount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountServiceV2/Mount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceV2Server).Mount(ctx, req.(*MountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MountServiceV2_MountStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MountRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MountServiceV2Server).MountStream(m, &mountServiceV2MountStreamServer{stream})
+}
+
+type MountServiceV2_MountStreamServer interface {
+	Send(*MountEvent) error
+	grpc.ServerStream
+}
+
+type mountServiceV2MountStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *mountServiceV2MountStreamServer) Send(m *MountEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MountServiceV2_GetMountLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMountLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MountServiceV2Server).GetMountLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/MountServiceV2/GetMountLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MountServiceV2Server).GetMountLog(ctx, req.(*GetMountLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MountServiceV2_ServiceDesc is the grpc.ServiceDesc for MountServiceV2 service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MountServiceV2_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "MountServiceV2",
+	HandlerType: (*MountServiceV2Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Mount",
+			Handler:    _MountServiceV2_Mount_Handler,
+		},
+		{
+			MethodName: "GetMountLog",
+			Handler:    _MountServiceV2_GetMountLog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "MountStream",
+			Handler:       _MountServiceV2_MountStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "azure_blob_mount.proto",
+}
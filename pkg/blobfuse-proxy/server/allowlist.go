@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// defaultAllowedOptions are blobfuse long options accepted in mount requests,
+	// see https://github.com/Azure/azure-storage-fuse#command-line-parameters
+	defaultAllowedOptions = []string{
+		"--tmp-path",
+		"--container-name",
+		"--use-https",
+		"--file-cache-timeout-in-seconds",
+		"--log-level",
+		"--use-attr-cache",
+		"--use-adls",
+		"--no-symlinks",
+		"--cache-on-list",
+		"--upload-modified-only",
+		"--cache-poll-timeout-msec",
+		"--max-eviction",
+		"--invalidate-on-sync",
+		"--max-concurrency",
+		"--cache-size-mb",
+		"--attr-cache-timeout-in-seconds",
+		"--cancel-list-on-mount-seconds",
+		"--high-disk-threshold",
+		"--low-disk-threshold",
+		"--pre-mount-validate",
+		"--basic-remount-check",
+		"--background-download",
+		"--streaming",
+		"--stream-cache-mb",
+		"--max-blocks-per-file",
+		"--block-size-mb",
+		"--empty-dir-check",
+		"--disable-writeback-cache",
+		"--read-stream-cache",
+		"--ca-cert-file",
+		"--http-proxy",
+		"--https-proxy",
+	}
+	// defaultAllowedPathRoots are directories under which values of path options in mount requests must be,
+	// --config-file is not allowed by default since it's added by blobfuse-proxy itself when credentials are passed in config file
+	defaultAllowedPathRoots = map[string][]string{
+		"--tmp-path":     {"/mnt"},
+		"--ca-cert-file": {"/etc/ssl/certs", "/etc/pki"},
+	}
+	// defaultAllowedFuseOptions are fuse options accepted as values of -o in mount requests
+	defaultAllowedFuseOptions = []string{
+		"allow_other",
+		"allow_root",
+		"default_permissions",
+		"ro",
+		"rw",
+		"uid",
+		"gid",
+		"umask",
+		"attr_timeout",
+		"entry_timeout",
+		"negative_timeout",
+		"kernel_cache",
+		"auto_cache",
+		"direct_io",
+		"big_writes",
+		"max_read",
+		"max_write",
+		"nonempty",
+	}
+)
+
+// argumentAllowlist limits blobfuse options and fuse options which could be passed to blobfuse by mount requests,
+// since blobfuse runs as root on the host
+type argumentAllowlist struct {
+	options     map[string]bool
+	fuseOptions map[string]bool
+	// pathRoots are directories under which values of path options must be, keyed by option name
+	pathRoots map[string][]string
+}
+
+// newArgumentAllowlist returns an allowlist with default options and extra options,
+// pathRoots overrides default allowed roots of path options
+func newArgumentAllowlist(extraOptions, extraFuseOptions []string, pathRoots map[string][]string) *argumentAllowlist {
+	allowlist := &argumentAllowlist{options: map[string]bool{}, fuseOptions: map[string]bool{}, pathRoots: map[string][]string{}}
+	for option, roots := range defaultAllowedPathRoots {
+		allowlist.pathRoots[option] = roots
+	}
+	for option, roots := range pathRoots {
+		allowlist.pathRoots[option] = roots
+	}
+	for _, option := range append(append([]string{}, defaultAllowedOptions...), extraOptions...) {
+		if option = strings.TrimSpace(option); option != "" {
+			allowlist.options[option] = true
+		}
+	}
+	for _, option := range append(append([]string{}, defaultAllowedFuseOptions...), extraFuseOptions...) {
+		if option = strings.TrimSpace(option); option != "" {
+			allowlist.fuseOptions[option] = true
+		}
+	}
+	return allowlist
+}
+
+// validate checks that every argument and option is in allowlist, args should be validated by validateMountRequest first
+func (a *argumentAllowlist) validate(args []string, options map[string]string) error {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-o" && i+1 < len(args) {
+			i++
+			for _, fuseOption := range strings.Split(args[i], ",") {
+				name := strings.SplitN(fuseOption, "=", 2)[0]
+				if !a.fuseOptions[name] {
+					return fmt.Errorf("fuse option(%s) is not allowed", name)
+				}
+			}
+			continue
+		}
+		// long options without value are passed as arguments, e.g. --use-attr-cache
+		kv := strings.SplitN(args[i], "=", 2)
+		if !a.options[kv[0]] {
+			return fmt.Errorf("argument(%s) is not allowed", args[i])
+		}
+		if _, ok := a.pathRoots[kv[0]]; ok {
+			if len(kv) != 2 {
+				return fmt.Errorf("argument(%s) requires a value", args[i])
+			}
+			if err := a.validatePath(kv[0], kv[1]); err != nil {
+				return err
+			}
+		}
+	}
+	for k, v := range options {
+		if !a.options[k] {
+			return fmt.Errorf("option(%s) is not allowed", k)
+		}
+		if err := a.validatePath(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePath checks that value of path option is under one of its allowed roots, other options are not checked
+func (a *argumentAllowlist) validatePath(option, path string) error {
+	roots, ok := a.pathRoots[option]
+	if !ok {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("value(%s) of option(%s) must be an absolute path", path, option)
+	}
+	for _, root := range roots {
+		if rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path)); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return nil
+		}
+	}
+	return fmt.Errorf("value(%s) of option(%s) is not under allowed directories %v", path, option, roots)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+)
+
+func TestArgumentAllowlist(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      []string
+		options   map[string]string
+		expectErr bool
+	}{
+		{
+			name:    "default_options",
+			args:    []string{"-o", "allow_other", "-o", "attr_timeout=120,entry_timeout=120", "--use-attr-cache"},
+			options: map[string]string{"--tmp-path": "/mnt/cache", "--container-name": "container", "--pre-mount-validate": "true"},
+		},
+		{
+			name:      "unknown_option",
+			options:   map[string]string{"--tmp-path": "/mnt/cache", "--foreground": "true"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown_argument",
+			args:      []string{"-d"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown_fuse_option",
+			args:      []string{"-o", "allow_other,modules=subdir"},
+			expectErr: true,
+		},
+		{
+			name:    "path_options_under_allowed_roots",
+			args:    []string{"--ca-cert-file=/etc/ssl/certs/ca.pem"},
+			options: map[string]string{"--tmp-path": "/mnt/blobfuse-cache/vol/cache"},
+		},
+		{
+			name:      "tmp_path_out_of_allowed_roots",
+			options:   map[string]string{"--tmp-path": "/mnt/../etc"},
+			expectErr: true,
+		},
+		{
+			name:      "relative_tmp_path",
+			options:   map[string]string{"--tmp-path": "mnt/cache"},
+			expectErr: true,
+		},
+		{
+			name:      "ca_cert_file_argument_out_of_allowed_roots",
+			args:      []string{"--ca-cert-file=/etc/shadow"},
+			expectErr: true,
+		},
+		{
+			name:      "path_argument_without_value",
+			args:      []string{"--tmp-path"},
+			expectErr: true,
+		},
+		{
+			name:      "config_file",
+			options:   map[string]string{"--config-file": "/etc/blobfuse.cfg"},
+			expectErr: true,
+		},
+		{
+			name:    "extra_options",
+			args:    []string{"-o", "fsname=blobfuse", "--max-retry"},
+			options: map[string]string{"--max-retry": "3"},
+		},
+	}
+
+	allowlist := newArgumentAllowlist([]string{"--max-retry"}, []string{" fsname ", ""}, nil)
+	for _, tc := range testCases {
+		err := allowlist.validate(tc.args, tc.options)
+		if tc.expectErr {
+			require.Error(t, err, tc.name)
+		} else {
+			require.NoError(t, err, tc.name)
+		}
+	}
+	// extra options are not allowed by default
+	require.Error(t, newArgumentAllowlist(nil, nil, nil).validate([]string{"-o", "fsname=blobfuse"}, nil))
+
+	// allowed roots of path options are overridden
+	allowlist = newArgumentAllowlist(nil, nil, map[string][]string{"--tmp-path": {"/var/cache/blobfuse"}})
+	require.NoError(t, allowlist.validate(nil, map[string]string{"--tmp-path": "/var/cache/blobfuse/vol"}))
+	require.Error(t, allowlist.validate(nil, map[string]string{"--tmp-path": "/mnt/vol"}))
+	require.NoError(t, allowlist.validate([]string{"--ca-cert-file=/etc/pki/ca.pem"}, nil))
+}
+
+func TestServerMountAzureBlobNotAllowed(t *testing.T) {
+	server, _ := newTestMountServer(t, nil)
+	server.execBlobfuse = func(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error {
+		t.Fatalf("unexpected blobfuse %v", args)
+		return nil
+	}
+	_, err := server.MountAzureBlob(context.Background(), &mount_azure_blob.MountAzureBlobRequest{
+		TargetPath: "/mnt/target",
+		Options:    map[string]string{"--config-file": "/etc/blobfuse.cfg", "--log-file": "/etc/passwd"},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// arguments of old driver are also checked
+	_, err = server.MountAzureBlob(context.Background(), &mount_azure_blob.MountAzureBlobRequest{
+		MountArgs: "/mnt/target -o allow_other -o modules=subdir --container-name=container",
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
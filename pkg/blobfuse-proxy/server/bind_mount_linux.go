@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"path/filepath"
+	"strings"
+
+	mount "k8s.io/mount-utils"
+)
+
+// findBindMounts returns bind mounts of the fuse mount on targetPath, e.g. pod volume paths published by NodePublishVolume,
+// they are found by device number of the fuse mount in mountinfo, and root of a bind mount is relative to targetPath
+func findBindMounts(procRoot, targetPath string) ([]bindMount, error) {
+	targetPath = filepath.Clean(targetPath)
+	infos, err := mount.ParseMountInfo(filepath.Join(procRoot, "self", "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	var target *mount.MountInfo
+	for i := range infos {
+		if filepath.Clean(infos[i].MountPoint) == targetPath {
+			target = &infos[i]
+		}
+	}
+	if target == nil {
+		return nil, nil
+	}
+
+	binds := []bindMount{}
+	for _, info := range infos {
+		if info.Major != target.Major || info.Minor != target.Minor || filepath.Clean(info.MountPoint) == targetPath {
+			continue
+		}
+		root, err := filepath.Rel(target.Root, info.Root)
+		if err != nil || root == ".." || strings.HasPrefix(root, "../") {
+			// not under root of the fuse mount on targetPath
+			continue
+		}
+		bind := bindMount{path: info.MountPoint, root: root}
+		for _, option := range info.MountOptions {
+			if option == "ro" {
+				bind.readOnly = true
+			}
+		}
+		binds = append(binds, bind)
+	}
+	return binds, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	mount "k8s.io/mount-utils"
+)
+
+const testMountInfo = `20 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+100 20 0:50 / /mnt/target rw,nosuid,nodev,relatime shared:50 - fuse blobfuse rw,user_id=0,group_id=0
+101 20 0:50 / /pods/vol rw,nosuid,nodev,relatime shared:50 - fuse blobfuse rw,user_id=0,group_id=0
+102 20 0:50 /dir /pods/sub ro,nosuid,nodev,relatime shared:50 - fuse blobfuse rw,user_id=0,group_id=0
+103 20 0:51 / /mnt/other rw,nosuid,nodev,relatime shared:51 - fuse blobfuse rw,user_id=0,group_id=0
+104 20 0:51 / /pods/other rw,nosuid,nodev,relatime shared:51 - fuse blobfuse rw,user_id=0,group_id=0
+`
+
+func writeMountInfo(t *testing.T, procRoot, mountInfo string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "self"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(procRoot, "self", "mountinfo"), []byte(mountInfo), 0644))
+}
+
+func TestFindBindMounts(t *testing.T) {
+	procRoot := tempDir(t)
+	writeMountInfo(t, procRoot, testMountInfo)
+
+	binds, err := findBindMounts(procRoot, "/mnt/target/")
+	require.NoError(t, err)
+	require.Equal(t, []bindMount{
+		{path: "/pods/vol", root: "."},
+		{path: "/pods/sub", root: "dir", readOnly: true},
+	}, binds)
+
+	binds, err = findBindMounts(procRoot, "/mnt/unknown")
+	require.NoError(t, err)
+	require.Empty(t, binds)
+
+	// bind mounts outside of root of a sub directory mount are skipped
+	writeMountInfo(t, procRoot, `100 20 0:50 /dir /mnt/target rw - fuse blobfuse rw
+101 20 0:50 /dir/sub /pods/sub rw - fuse blobfuse rw
+102 20 0:50 /dir2 /pods/dir2 rw - fuse blobfuse rw
+`)
+	binds, err = findBindMounts(procRoot, "/mnt/target")
+	require.NoError(t, err)
+	require.Equal(t, []bindMount{{path: "/pods/sub", root: "sub"}}, binds)
+
+	_, err = findBindMounts(tempDir(t), "/mnt/target")
+	require.Error(t, err)
+}
+
+func TestCheckMountsRebindMounts(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	writeMountInfo(t, blobfuse.procRoot, testMountInfo)
+	fakeMounter := server.mounter.(*mount.FakeMounter)
+	require.NoError(t, fakeMounter.Mount("/mnt/target", "/pods/vol", "", []string{"bind"}))
+	require.NoError(t, fakeMounter.Mount("/mnt/target/dir", "/pods/sub", "", []string{"bind", "ro"}))
+	fakeMounter.ResetLog()
+
+	blobfuse.kill(101)
+	server.checkMounts(time.Now(), time.Minute)
+	require.Equal(t, 2, blobfuse.calls)
+	require.Equal(t, 1, server.mounts["/mnt/target"].restartCount)
+
+	// published targets are bind mounted again from the new fuse mount
+	require.Equal(t, []mount.FakeAction{
+		{Action: mount.FakeActionUnmount, Target: "/mnt/target"},
+		{Action: mount.FakeActionMount, Target: "/mnt/target", Source: "blobfuse", FSType: "fuse"},
+		{Action: mount.FakeActionUnmount, Target: "/pods/vol"},
+		{Action: mount.FakeActionMount, Target: "/pods/vol", Source: "blobfuse"},
+		{Action: mount.FakeActionUnmount, Target: "/pods/sub"},
+		{Action: mount.FakeActionMount, Target: "/pods/sub", Source: "/mnt/target/dir"},
+	}, fakeMounter.GetLog())
+	mountPoints, err := fakeMounter.List()
+	require.NoError(t, err)
+	require.Contains(t, mountPoints, mount.MountPoint{Device: "blobfuse", Path: "/pods/vol"})
+	require.Contains(t, mountPoints, mount.MountPoint{Device: "/mnt/target/dir", Path: "/pods/sub", Opts: []string{"bind", "ro"}})
+}
+
+func TestCheckMountsRebindMountsFailure(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	writeMountInfo(t, blobfuse.procRoot, testMountInfo)
+	fakeMounter := server.mounter.(*mount.FakeMounter)
+	require.NoError(t, fakeMounter.Mount("/mnt/target", "/pods/vol", "", []string{"bind"}))
+	fakeMounter.UnmountFunc = func(path string) error {
+		if path == "/pods/vol" {
+			return os.ErrPermission
+		}
+		return nil
+	}
+
+	// daemon is restarted while failed bind mount is retried after backoff
+	now := time.Now()
+	blobfuse.kill(101)
+	server.checkMounts(now, time.Minute)
+	require.Equal(t, 2, blobfuse.calls)
+	record := server.mounts["/mnt/target"]
+	require.Equal(t, 1, record.restartCount)
+	require.Equal(t, 102, record.pid)
+	require.Equal(t, []bindMount{{path: "/pods/vol", root: "."}}, record.binds)
+	require.Equal(t, initialRemountBackoff, record.backoff)
+
+	fakeMounter.UnmountFunc = nil
+	fakeMounter.ResetLog()
+	server.checkMounts(now.Add(time.Second), time.Minute)
+	require.Empty(t, fakeMounter.GetLog())
+
+	server.checkMounts(now.Add(initialRemountBackoff), time.Minute)
+	require.Equal(t, 2, blobfuse.calls)
+	require.Empty(t, record.binds)
+	require.Equal(t, []mount.FakeAction{
+		{Action: mount.FakeActionUnmount, Target: "/pods/vol"},
+		{Action: mount.FakeActionMount, Target: "/pods/vol", Source: "blobfuse"},
+	}, fakeMounter.GetLog())
+}
+
+func TestRebindMountsSkipUnmounted(t *testing.T) {
+	server, _ := newTestMountServer(t, []mount.MountPoint{{Device: "blobfuse", Path: "/mnt/target", Type: "fuse"}})
+	binds, err := server.rebindMounts("/mnt/target", []bindMount{
+		{path: "/pods/vol", root: "."},
+		{path: filepath.Join(tempDir(t), "removed"), root: ".", unmounted: true},
+	})
+	require.NoError(t, err)
+	require.Empty(t, binds)
+	require.Empty(t, server.mounter.(*mount.FakeMounter).GetLog())
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"k8s.io/klog/v2"
+)
+
+// NewServerTLSConfig returns TLS config of proxy server,
+// client certificates are required and verified by clientCAFile when it's specified(mTLS)
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// loadCertPool loads PEM encoded CA certificates from file
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file(%s): %v", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no valid certificate is found in CA file(%s)", caFile)
+	}
+	return pool, nil
+}
+
+// peerCredListener accepts unix socket connections only from processes running as allowed users
+type peerCredListener struct {
+	net.Listener
+	allowedUIDs map[uint32]bool
+}
+
+// NewPeerCredListener returns a listener which checks uid of the peer process by SO_PEERCRED on every accepted connection,
+// connections from users not in allowedUIDs are closed
+func NewPeerCredListener(listener net.Listener, allowedUIDs []uint32) net.Listener {
+	l := &peerCredListener{Listener: listener, allowedUIDs: map[uint32]bool{}}
+	for _, uid := range allowedUIDs {
+		l.allowedUIDs[uid] = true
+	}
+	return l
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		cred, err := getPeerCred(conn)
+		if err != nil {
+			klog.Errorf("rejecting connection: failed to get peer credentials: %v", err)
+			conn.Close()
+			continue
+		}
+		if !l.allowedUIDs[cred.uid] {
+			klog.Errorf("rejecting connection from process(pid %d, uid %d, gid %d): uid is not allowed", cred.pid, cred.uid, cred.gid)
+			conn.Close()
+			continue
+		}
+		klog.V(4).Infof("accepted connection from process(pid %d, uid %d, gid %d)", cred.pid, cred.uid, cred.gid)
+		return conn, nil
+	}
+}
+
+// peerCred is the credential of the process on the other side of a unix socket connection
+type peerCred struct {
+	pid int32
+	uid uint32
+	gid uint32
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+)
+
+// writeTestCertificate writes a self-signed certificate for localhost which could be used as CA, server and client certificate,
+// returns paths of certificate and key files
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "blobfuse-proxy"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "blobfuse-proxy")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// startTestGRPCServer runs mount service on listener until the test ends
+func startTestGRPCServer(t *testing.T, tlsConfig *tls.Config, listener net.Listener) {
+	server, _ := newTestMountServer(t, nil)
+	server.blobfuseBinary = "sh"
+	go func() {
+		_ = RunGRPCServer(server, tlsConfig, listener)
+	}()
+	t.Cleanup(func() { listener.Close() })
+}
+
+func callHealth(target string, options ...grpc.DialOption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, target, append(options, grpc.WithBlock(), grpc.FailOnNonTempDialError(true))...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = mount_azure_blob.NewMountServiceClient(conn).Health(ctx, &mount_azure_blob.HealthRequest{})
+	return err
+}
+
+func TestRunGRPCServerWithTLS(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := writeTestCertificate(t, dir)
+	_, err := NewServerTLSConfig(certFile, "not-exist", "")
+	require.Error(t, err)
+	_, err = NewServerTLSConfig(certFile, keyFile, keyFile)
+	require.Error(t, err)
+
+	tlsConfig, err := NewServerTLSConfig(certFile, keyFile, certFile)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	startTestGRPCServer(t, tlsConfig, listener)
+	target := listener.Addr().String()
+
+	pool, err := loadCertPool(certFile)
+	require.NoError(t, err)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+
+	// mutual TLS
+	require.NoError(t, callHealth(target, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}))))
+	// client certificate is required
+	require.Error(t, callHealth(target, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool}))))
+	// plaintext connection is rejected
+	require.Error(t, callHealth(target, grpc.WithInsecure()))
+}
+
+func TestPeerCredListener(t *testing.T) {
+	dir := tempDir(t)
+	socket := filepath.Join(dir, "blobfuse-proxy.sock")
+
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	startTestGRPCServer(t, nil, NewPeerCredListener(listener, []uint32{uint32(os.Getuid())}))
+	require.NoError(t, callHealth("unix://"+socket, grpc.WithInsecure()))
+
+	socket = filepath.Join(dir, "blobfuse-proxy-denied.sock")
+	listener, err = net.Listen("unix", socket)
+	require.NoError(t, err)
+	startTestGRPCServer(t, nil, NewPeerCredListener(listener, []uint32{uint32(os.Getuid()) + 1}))
+	require.Error(t, callHealth("unix://"+socket, grpc.WithInsecure()))
+
+	// peer credentials are not available on tcp connections
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	startTestGRPCServer(t, nil, NewPeerCredListener(listener, []uint32{uint32(os.Getuid())}))
+	require.Error(t, callHealth(listener.Addr().String(), grpc.WithInsecure()))
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	metricsSubsystem = "blobfuse_proxy"
+
+	operationMount   = "mount"
+	operationUnmount = "unmount"
+	operationRemount = "remount"
+
+	// failureReasonBlobfuseExit means blobfuse exits with error
+	failureReasonBlobfuseExit = "BlobfuseExit"
+	failureReasonUnknown      = "Unknown"
+)
+
+var (
+	// mountQueueDepth is the number of mount requests waiting for a mount slot
+	mountQueueDepth = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "mount_queue_depth",
+			Help:           "Number of blobfuse mounts waiting for a free mount slot",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// mountsInProgress is the number of blobfuse commands being run
+	mountsInProgress = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "mounts_in_progress",
+			Help:           "Number of blobfuse mounts being run",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	// operationDuration is the latency of mount, unmount and remount operations
+	operationDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "operation_duration_seconds",
+			Help:           "Latency of blobfuse mount, unmount and remount operations in seconds",
+			Buckets:        []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 25, 50, 120, 300},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "result"},
+	)
+	// operationFailures is the number of failed operations by reason
+	operationFailures = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "operation_failures_total",
+			Help:           "Number of failed blobfuse mount, unmount and remount operations by reason",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "reason"},
+	)
+	// daemonRestarts is the number of blobfuse daemons restarted by supervision
+	daemonRestarts = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "daemon_restarts_total",
+			Help:           "Number of exited blobfuse daemons remounted by supervision",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	activeMountsDesc = metrics.NewDesc(
+		metrics.BuildFQName("", metricsSubsystem, "active_mounts"),
+		"Number of blobfuse mounts made by proxy which are not unmounted",
+		nil, nil, metrics.ALPHA, "")
+	daemonCPUDesc = metrics.NewDesc(
+		metrics.BuildFQName("", metricsSubsystem, "daemon_cpu_seconds_total"),
+		"User and system CPU time consumed by blobfuse daemon in seconds",
+		[]string{"target_path"}, nil, metrics.ALPHA, "")
+	daemonMemoryDesc = metrics.NewDesc(
+		metrics.BuildFQName("", metricsSubsystem, "daemon_resident_memory_bytes"),
+		"Resident memory size of blobfuse daemon in bytes",
+		[]string{"target_path"}, nil, metrics.ALPHA, "")
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers blobfuse-proxy metrics in legacy registry
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(mountQueueDepth)
+		legacyregistry.MustRegister(mountsInProgress)
+		legacyregistry.MustRegister(operationDuration)
+		legacyregistry.MustRegister(operationFailures)
+		legacyregistry.MustRegister(daemonRestarts)
+	})
+}
+
+// recordOperation records latency of an operation started at start, and failure reason if *err is not nil
+func recordOperation(operation string, start time.Time, err *error) {
+	result := "success"
+	if *err != nil {
+		result = "failure"
+		operationFailures.WithLabelValues(operation, getFailureReason(*err)).Inc()
+	}
+	operationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+// getFailureReason returns gRPC status code of err, or BlobfuseExit if blobfuse exits with error
+func getFailureReason(err error) string {
+	if s, ok := status.FromError(err); ok {
+		return s.Code().String()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return failureReasonBlobfuseExit
+	}
+	return failureReasonUnknown
+}
+
+// mountCollector collects number of active mounts and resource usage of blobfuse daemons when metrics are scraped
+type mountCollector struct {
+	metrics.BaseStableCollector
+	server *MountServer
+}
+
+// NewMountCollector returns a collector of mounts made by server, it should be registered once per server
+func NewMountCollector(server *MountServer) metrics.StableCollector {
+	return &mountCollector{server: server}
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface
+func (c *mountCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- activeMountsDesc
+	ch <- daemonCPUDesc
+	ch <- daemonMemoryDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface
+func (c *mountCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.server.mountsLock.Lock()
+	activeMounts := len(c.server.mounts)
+	c.server.mountsLock.Unlock()
+	ch <- metrics.NewLazyConstMetric(activeMountsDesc, metrics.GaugeValue, float64(activeMounts))
+
+	for targetPath, process := range findBlobfuseProcesses(c.server.procRoot) {
+		stat, err := getProcessStat(c.server.procRoot, process.pid)
+		if err != nil {
+			// process exits
+			klog.V(4).Infof("failed to get stat of blobfuse daemon(pid %d): %v", process.pid, err)
+			continue
+		}
+		ch <- metrics.NewLazyConstMetric(daemonCPUDesc, metrics.CounterValue, stat.cpuSeconds, targetPath)
+		ch <- metrics.NewLazyConstMetric(daemonMemoryDesc, metrics.GaugeValue, stat.residentMemoryBytes, targetPath)
+	}
+}
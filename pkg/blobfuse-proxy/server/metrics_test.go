@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics/testutil"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+)
+
+func writeProcStat(t *testing.T, procRoot string, pid int, utime, stime, rss int) {
+	stat := fmt.Sprintf("%d (blobfuse (x)) S 1 %d %d 0 -1 4194560 100 0 0 0 %d %d 0 0 20 0 10 0 1000 1000000 %d 18446744073709551615\n",
+		pid, pid, pid, utime, stime, rss)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(procRoot, fmt.Sprint(pid), "stat"), []byte(stat), 0644))
+}
+
+func TestGetFailureReason(t *testing.T) {
+	require.Equal(t, "PermissionDenied", getFailureReason(status.Error(codes.PermissionDenied, "denied")))
+	require.Equal(t, failureReasonUnknown, getFailureReason(fmt.Errorf("failed")))
+
+	err := exec.Command("sh", "-c", "exit 1").Run()
+	require.Error(t, err)
+	require.Equal(t, failureReasonBlobfuseExit, getFailureReason(err))
+	require.Equal(t, failureReasonBlobfuseExit, getFailureReason(fmt.Errorf("remount failed: %w", err)))
+}
+
+func TestGetProcessStat(t *testing.T) {
+	procRoot := tempDir(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "100"), 0755))
+	writeProcStat(t, procRoot, 100, 150, 50, 10)
+
+	stat, err := getProcessStat(procRoot, 100)
+	require.NoError(t, err)
+	require.Equal(t, 2.0, stat.cpuSeconds)
+	require.Equal(t, float64(10*os.Getpagesize()), stat.residentMemoryBytes)
+
+	_, err = getProcessStat(procRoot, 101)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(procRoot, "100", "stat"), []byte("100 (blobfuse) S 1"), 0644))
+	_, err = getProcessStat(procRoot, 100)
+	require.Error(t, err)
+}
+
+func TestRecordOperation(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	getFailures := func(operation, reason string) float64 {
+		value, err := testutil.GetCounterMetricValue(operationFailures.WithLabelValues(operation, reason))
+		require.NoError(t, err)
+		return value
+	}
+	getCount := func(operation, result string) uint64 {
+		count, err := testutil.GetHistogramMetricCount(operationDuration.WithLabelValues(operation, result))
+		require.NoError(t, err)
+		return count
+	}
+	mounts := getCount(operationMount, "success")
+	denied := getFailures(operationMount, "PermissionDenied")
+	notFound := getFailures(operationUnmount, "NotFound")
+	restarts, err := testutil.GetCounterMetricValue(daemonRestarts)
+	require.NoError(t, err)
+
+	_, err = server.Mount(context.Background(), &mount_azure_blob.MountRequest{TargetPath: "/mnt/target2"})
+	require.NoError(t, err)
+	require.Equal(t, mounts+1, getCount(operationMount, "success"))
+
+	_, err = server.Mount(context.Background(), &mount_azure_blob.MountRequest{TargetPath: "/mnt/target3", Options: map[string]string{"--foreground": "true"}})
+	require.Error(t, err)
+	require.Equal(t, denied+1, getFailures(operationMount, "PermissionDenied"))
+
+	_, err = server.UnmountAzureBlob(context.Background(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: "/mnt/target3"})
+	require.Error(t, err)
+	require.Equal(t, notFound+1, getFailures(operationUnmount, "NotFound"))
+
+	blobfuse.kill(101)
+	server.checkMounts(time.Now(), time.Minute)
+	value, err := testutil.GetCounterMetricValue(daemonRestarts)
+	require.NoError(t, err)
+	require.Equal(t, restarts+1, value)
+}
+
+func TestMountCollector(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	writeProcStat(t, blobfuse.procRoot, 101, 300, 100, 4)
+	// stat of exited daemon is skipped
+	writeProcCmdline(t, blobfuse.procRoot, "200", "blobfuse", "/mnt/exited")
+	// mount record without daemon
+	server.mounts["/mnt/target2"] = &mountRecord{startTime: time.Now()}
+
+	expected := fmt.Sprintf(`
+		# HELP blobfuse_proxy_active_mounts [ALPHA] Number of blobfuse mounts made by proxy which are not unmounted
+		# TYPE blobfuse_proxy_active_mounts gauge
+		blobfuse_proxy_active_mounts 2
+		# HELP blobfuse_proxy_daemon_cpu_seconds_total [ALPHA] User and system CPU time consumed by blobfuse daemon in seconds
+		# TYPE blobfuse_proxy_daemon_cpu_seconds_total counter
+		blobfuse_proxy_daemon_cpu_seconds_total{target_path="/mnt/target"} 4
+		# HELP blobfuse_proxy_daemon_resident_memory_bytes [ALPHA] Resident memory size of blobfuse daemon in bytes
+		# TYPE blobfuse_proxy_daemon_resident_memory_bytes gauge
+		blobfuse_proxy_daemon_resident_memory_bytes{target_path="/mnt/target"} %d
+	`, 4*os.Getpagesize())
+	require.NoError(t, testutil.CustomCollectAndCompare(NewMountCollector(server), strings.NewReader(expected),
+		"blobfuse_proxy_active_mounts", "blobfuse_proxy_daemon_cpu_seconds_total", "blobfuse_proxy_daemon_resident_memory_bytes"))
+}
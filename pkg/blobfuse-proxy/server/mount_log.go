@@ -0,0 +1,208 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+const (
+	mountPhaseQueued  = "Queued"
+	mountPhaseRunning = "Running"
+	mountPhaseMounted = "Mounted"
+	mountPhaseFailed  = "Failed"
+
+	// maxMountLogSize is the size limit of a per-mount log file, the file is truncated before next mount when it's exceeded
+	maxMountLogSize = 1024 * 1024
+)
+
+// mountLog records progress and blobfuse output of a mount, to per-mount log file and mount event stream
+type mountLog struct {
+	lock   sync.Mutex
+	output bytes.Buffer
+	// partial is the blobfuse output after the last newline
+	partial []byte
+	file    *os.File
+	// send sends mount events to stream, nil if the mount is not streamed
+	send func(*mount_azure_blob.MountEvent) error
+}
+
+// getMountLogFile returns log file path of mounts on target path in logDir
+func getMountLogFile(logDir, targetPath string) string {
+	return filepath.Join(logDir, fmt.Sprintf("mount-%x.log", sha256.Sum256([]byte(targetPath))))
+}
+
+// gcMountLogs removes mount log files in logDir which are not modified in maxAge, then the oldest ones when there
+// are more than maxFiles, log files in inUse are kept, zero maxAge or maxFiles disables the limit
+func gcMountLogs(logDir string, inUse map[string]bool, now time.Time, maxAge time.Duration, maxFiles int) {
+	files, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		klog.Warningf("failed to list mount log directory(%s): %v", logDir, err)
+		return
+	}
+	logs := []os.FileInfo{}
+	inUseCount := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), "mount-") || !strings.HasSuffix(file.Name(), ".log") {
+			continue
+		}
+		if inUse[filepath.Join(logDir, file.Name())] {
+			inUseCount++
+			continue
+		}
+		logs = append(logs, file)
+	}
+	// newest first, log files in use are counted in maxFiles
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ModTime().After(logs[j].ModTime()) })
+	for i, file := range logs {
+		expired := maxAge > 0 && now.Sub(file.ModTime()) > maxAge
+		exceeded := maxFiles > 0 && inUseCount+i >= maxFiles
+		if !expired && !exceeded {
+			continue
+		}
+		klog.V(4).Infof("removing mount log file %s, last modified at %v", file.Name(), file.ModTime())
+		if err := os.Remove(filepath.Join(logDir, file.Name())); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("failed to remove mount log file %s: %v", file.Name(), err)
+		}
+	}
+}
+
+// newMountLog returns a mount log which appends to per-mount log file in logDir, log file is not written if logDir is empty
+func newMountLog(logDir, targetPath string, send func(*mount_azure_blob.MountEvent) error) *mountLog {
+	l := &mountLog{send: send}
+	if logDir == "" {
+		return l
+	}
+	logFile := getMountLogFile(logDir, targetPath)
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if info, err := os.Stat(logFile); err == nil && info.Size() > maxMountLogSize {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(logFile, flag, 0600)
+	if err != nil {
+		klog.Warningf("failed to open mount log file(%s) of %s: %v", logFile, targetPath, err)
+		return l
+	}
+	l.file = file
+	l.writeFile(fmt.Sprintf("mount on %s", targetPath))
+	return l
+}
+
+// Write receives blobfuse output, every complete line is sent as a log event
+func (l *mountLog) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.output.Write(p)
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.logLine(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// progress records a phase change of the mount
+func (l *mountLog) progress(phase, message string, pid int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.flush()
+	if message == "" {
+		l.writeFile(phase)
+	} else {
+		l.writeFile(fmt.Sprintf("%s: %s", phase, message))
+	}
+	l.sendEvent(&mount_azure_blob.MountEvent{Phase: phase, Message: message, Pid: int32(pid)})
+}
+
+// getOutput returns all blobfuse output
+func (l *mountLog) getOutput() []byte {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.output.Bytes()
+}
+
+// close flushes the last incomplete line of blobfuse output and closes log file
+func (l *mountLog) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.flush()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+func (l *mountLog) flush() {
+	if len(l.partial) > 0 {
+		l.logLine(string(l.partial))
+		l.partial = nil
+	}
+}
+
+func (l *mountLog) logLine(line string) {
+	// blobfuse may print options or urls with credentials
+	line = util.RedactString(strings.TrimSuffix(line, "\r"))
+	l.writeFile(line)
+	l.sendEvent(&mount_azure_blob.MountEvent{LogLine: line})
+}
+
+func (l *mountLog) writeFile(line string) {
+	if l.file == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(l.file, "%s %s\n", time.Now().Format(time.RFC3339Nano), line); err != nil {
+		klog.Warningf("failed to write mount log file(%s): %v", l.file.Name(), err)
+	}
+}
+
+// sendEvent sends event to stream, events are no longer sent after stream fails, the mount is canceled by context instead
+func (l *mountLog) sendEvent(event *mount_azure_blob.MountEvent) {
+	if l.send == nil {
+		return
+	}
+	event.Timestamp = time.Now().UnixNano()
+	if err := l.send(event); err != nil {
+		klog.V(4).Infof("failed to send mount event: %v", err)
+		l.send = nil
+	}
+}
+
+// readMountLog returns retained log of mounts on target path
+func readMountLog(logDir, targetPath string) (string, error) {
+	if logDir == "" {
+		return "", fmt.Errorf("mount log is disabled")
+	}
+	content, err := ioutil.ReadFile(getMountLogFile(logDir, targetPath))
+	return string(content), err
+}
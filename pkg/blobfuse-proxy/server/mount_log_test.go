@@ -0,0 +1,249 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+)
+
+func TestMountLog(t *testing.T) {
+	logDir := tempDir(t)
+	var events []*mount_azure_blob.MountEvent
+	log := newMountLog(logDir, "/mnt/target", func(event *mount_azure_blob.MountEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	log.progress(mountPhaseRunning, "", 0)
+	fmt.Fprint(log, "line1\nli")
+	fmt.Fprint(log, "ne2\r\nline3")
+	log.progress(mountPhaseMounted, "", 100)
+	log.close()
+
+	require.Equal(t, "line1\nline2\r\nline3", string(log.getOutput()))
+	lines := []string{}
+	for _, event := range events {
+		require.NotZero(t, event.Timestamp)
+		if event.Phase != "" {
+			lines = append(lines, event.Phase)
+		} else {
+			lines = append(lines, event.LogLine)
+		}
+	}
+	require.Equal(t, []string{mountPhaseRunning, "line1", "line2", "line3", mountPhaseMounted}, lines)
+	require.Equal(t, int32(100), events[len(events)-1].Pid)
+
+	content, err := readMountLog(logDir, "/mnt/target")
+	require.NoError(t, err)
+	for _, line := range []string{"mount on /mnt/target", mountPhaseRunning, "line1", "line2", "line3", mountPhaseMounted} {
+		require.Contains(t, content, line)
+	}
+	info, err := os.Stat(getMountLogFile(logDir, "/mnt/target"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// logs of later mounts are appended
+	log = newMountLog(logDir, "/mnt/target", nil)
+	fmt.Fprintln(log, "remount")
+	log.close()
+	content, err = readMountLog(logDir, "/mnt/target")
+	require.NoError(t, err)
+	require.Contains(t, content, "line1")
+	require.Contains(t, content, "remount")
+
+	_, err = readMountLog(logDir, "/mnt/other")
+	require.True(t, os.IsNotExist(err))
+	_, err = readMountLog("", "/mnt/target")
+	require.Error(t, err)
+}
+
+func TestMountLogTruncate(t *testing.T) {
+	logDir := tempDir(t)
+	logFile := getMountLogFile(logDir, "/mnt/target")
+	require.NoError(t, ioutil.WriteFile(logFile, []byte(strings.Repeat("x", maxMountLogSize+1)), 0600))
+
+	log := newMountLog(logDir, "/mnt/target", nil)
+	fmt.Fprintln(log, "new mount")
+	log.close()
+	content, err := readMountLog(logDir, "/mnt/target")
+	require.NoError(t, err)
+	require.NotContains(t, content, "xxx")
+	require.Contains(t, content, "new mount")
+}
+
+func TestMountLogSendFailure(t *testing.T) {
+	sent := 0
+	log := newMountLog("", "/mnt/target", func(event *mount_azure_blob.MountEvent) error {
+		sent++
+		return fmt.Errorf("stream closed")
+	})
+	fmt.Fprintln(log, "line1")
+	fmt.Fprintln(log, "line2")
+	log.close()
+	require.Equal(t, 1, sent)
+	require.Equal(t, "line1\nline2\n", string(log.getOutput()))
+}
+
+func TestMountLogRedact(t *testing.T) {
+	logDir := tempDir(t)
+	var events []*mount_azure_blob.MountEvent
+	log := newMountLog(logDir, "/mnt/target", func(event *mount_azure_blob.MountEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	fmt.Fprintln(log, "invalid option --account-key=accountKeyValue")
+	fmt.Fprintln(log, "GET https://account.blob.core.windows.net/c?sv=2020-08-04&sig=sigValue failed")
+	log.close()
+
+	content, err := readMountLog(logDir, "/mnt/target")
+	require.NoError(t, err)
+	for _, event := range events {
+		content += event.LogLine
+	}
+	require.Contains(t, content, "--account-key=***redacted***")
+	require.NotContains(t, content, "accountKeyValue")
+	require.NotContains(t, content, "sigValue")
+}
+
+func TestServerMountStream(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	require.NoError(t, server.SetMountLogDir(filepath.Join(tempDir(t), "logs"), time.Hour, 10))
+	socket := filepath.Join(tempDir(t), "blobfuse-proxy.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	go func() {
+		_ = RunGRPCServer(server, nil, listener)
+	}()
+	defer listener.Close()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := mount_azure_blob.NewMountServiceV2Client(conn)
+	recvAll := func(req *mount_azure_blob.MountRequest) ([]*mount_azure_blob.MountEvent, error) {
+		stream, err := client.MountStream(context.Background(), req)
+		require.NoError(t, err)
+		events := []*mount_azure_blob.MountEvent{}
+		for {
+			event, err := stream.Recv()
+			if err == io.EOF {
+				return events, nil
+			}
+			if err != nil {
+				return events, err
+			}
+			events = append(events, event)
+		}
+	}
+
+	events, err := recvAll(&mount_azure_blob.MountRequest{TargetPath: "/mnt/stream", Options: map[string]string{"--container-name": "container"}})
+	require.NoError(t, err)
+	require.Equal(t, mountPhaseRunning, events[0].Phase)
+	require.Equal(t, mountPhaseMounted, events[len(events)-1].Phase)
+	require.Equal(t, int32(102), events[len(events)-1].Pid)
+
+	blobfuse.err = fmt.Errorf("exit status 1")
+	events, err = recvAll(&mount_azure_blob.MountRequest{TargetPath: "/mnt/failed"})
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.Equal(t, "mount failed", events[1].LogLine)
+	require.Equal(t, mountPhaseFailed, events[len(events)-1].Phase)
+	require.Equal(t, "exit status 1", events[len(events)-1].Message)
+
+	_, err = recvAll(&mount_azure_blob.MountRequest{TargetPath: "mnt/relative"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := client.GetMountLog(context.Background(), &mount_azure_blob.GetMountLogRequest{TargetPath: "/mnt/failed/"})
+	require.NoError(t, err)
+	require.Contains(t, resp.Log, "mount failed")
+	require.Contains(t, resp.Log, mountPhaseFailed)
+	_, err = client.GetMountLog(context.Background(), &mount_azure_blob.GetMountLogRequest{TargetPath: "/mnt/unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// mount log is retained after unmount
+	_, err = server.UnmountAzureBlob(context.Background(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: "/mnt/stream"})
+	require.NoError(t, err)
+	resp, err = client.GetMountLog(context.Background(), &mount_azure_blob.GetMountLogRequest{TargetPath: "/mnt/stream"})
+	require.NoError(t, err)
+	require.Contains(t, resp.Log, mountPhaseMounted)
+}
+
+func TestGCMountLogs(t *testing.T) {
+	logDir := tempDir(t)
+	now := time.Now()
+	writeLog := func(targetPath string, age time.Duration) string {
+		logFile := getMountLogFile(logDir, targetPath)
+		require.NoError(t, ioutil.WriteFile(logFile, []byte("log"), 0600))
+		require.NoError(t, os.Chtimes(logFile, now.Add(-age), now.Add(-age)))
+		return logFile
+	}
+	mounted := writeLog("/mnt/mounted", 2*time.Hour)
+	expired := writeLog("/mnt/expired", 2*time.Hour)
+	oldest := writeLog("/mnt/oldest", 30*time.Minute)
+	newest := writeLog("/mnt/newest", time.Minute)
+	other := filepath.Join(logDir, "other.log")
+	require.NoError(t, ioutil.WriteFile(other, []byte("other"), 0600))
+
+	gcMountLogs(logDir, map[string]bool{mounted: true}, now, time.Hour, 2)
+	for file, exists := range map[string]bool{mounted: true, expired: false, oldest: false, newest: true, other: true} {
+		_, err := os.Stat(file)
+		require.Equal(t, exists, err == nil, file)
+	}
+
+	// no limit
+	expired = writeLog("/mnt/expired", 2*time.Hour)
+	gcMountLogs(logDir, nil, now, 0, 0)
+	_, err := os.Stat(expired)
+	require.NoError(t, err)
+}
+
+func TestServerGCMountLogs(t *testing.T) {
+	server, _ := newSupervisedMountServer(t)
+	logDir := filepath.Join(tempDir(t), "logs")
+	require.NoError(t, server.SetMountLogDir(logDir, time.Hour, 1))
+	require.NoError(t, ioutil.WriteFile(getMountLogFile(logDir, "/mnt/old"), []byte("log"), 0600))
+
+	// log of unmounted target is removed when count limit is exceeded, log of mounted target is kept
+	require.NoError(t, ioutil.WriteFile(getMountLogFile(logDir, "/mnt/target"), []byte("log"), 0600))
+	server.gcMountLogs()
+	_, err := os.Stat(getMountLogFile(logDir, "/mnt/old"))
+	require.True(t, os.IsNotExist(err))
+	_, err = readMountLog(logDir, "/mnt/target")
+	require.NoError(t, err)
+}
+
+func TestServerGetMountLogDisabled(t *testing.T) {
+	server, _ := newTestMountServer(t, nil)
+	_, err := server.GetMountLog(context.Background(), &mount_azure_blob.GetMountLogRequest{TargetPath: "/mnt/target"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = server.GetMountLog(context.Background(), &mount_azure_blob.GetMountLogRequest{TargetPath: "mnt/target"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
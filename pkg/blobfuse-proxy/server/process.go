@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// blobfuseProcess is a running blobfuse daemon
+type blobfuseProcess struct {
+	pid int
+	// args are command line arguments after the binary, target path is the first one
+	args []string
+}
+
+// findBlobfuseProcesses returns running blobfuse processes in proc filesystem keyed by target path
+func findBlobfuseProcesses(procRoot string) map[string]blobfuseProcess {
+	processes := map[string]blobfuseProcess{}
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return processes
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join(procRoot, entry.Name(), "cmdline"))
+		if err != nil {
+			// process exits
+			continue
+		}
+		argv := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+		if len(argv) < 2 || filepath.Base(argv[0]) != blobfuseBinary {
+			continue
+		}
+		processes[filepath.Clean(argv[1])] = blobfuseProcess{pid: pid, args: argv[1:]}
+	}
+	return processes
+}
+
+// userHZ is the clock ticks per second of cpu times in proc filesystem, it's 100 on all supported architectures
+const userHZ = 100
+
+// processStat is the resource usage of a process
+type processStat struct {
+	cpuSeconds          float64
+	residentMemoryBytes float64
+}
+
+// getProcessStat returns cpu time and resident memory of process pid from /proc/<pid>/stat
+func getProcessStat(procRoot string, pid int) (*processStat, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// command name in parentheses may contain spaces, fields after it start from state(the 3rd field)
+	i := strings.LastIndexByte(string(content), ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat of process %d: %q", pid, string(content))
+	}
+	fields := strings.Fields(string(content[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat of process %d: %q", pid, string(content))
+	}
+	// utime, stime and rss are the 14th, 15th and 24th fields
+	values := make([]float64, 0, 3)
+	for _, field := range []string{fields[11], fields[12], fields[21]} {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stat of process %d: %v", pid, err)
+		}
+		values = append(values, float64(value))
+	}
+	return &processStat{
+		cpuSeconds:          (values[0] + values[1]) / userHZ,
+		residentMemoryBytes: values[2] * float64(os.Getpagesize()),
+	}, nil
+}
@@ -18,65 +18,566 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
 	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+const (
+	blobfuseBinary = "blobfuse"
+	// blobfuse mount points are listed as "blobfuse on /target type fuse"
+	blobfuseDevice    = "blobfuse"
+	fuseType          = "fuse"
+	containerNameFlag = "--container-name"
 )
 
 var (
-	mutex sync.Mutex
+	// optionNameRegexp matches blobfuse long option names, e.g. --tmp-path
+	optionNameRegexp = regexp.MustCompile(`^--[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+	// sensitiveKeywords are matched against option names whose values are stripped in ListMounts
+	sensitiveKeywords = []string{"key", "secret", "token", "password", "sas"}
 )
 
 type MountServer struct {
 	mount_azure_blob.UnimplementedMountServiceServer
+	mount_azure_blob.UnimplementedMountServiceV2Server
+	mounter mount.Interface
+	// procRoot is the proc filesystem where blobfuse processes are looked up
+	procRoot string
+	// blobfuseBinary is checked in Health
+	blobfuseBinary string
+	// targetLocks serializes mount, unmount and remount on the same target path
+	targetLocks *util.LockMap
+	// mountSlots limits concurrent blobfuse commands, nil means no limit
+	mountSlots chan struct{}
+	// argAllowlist limits arguments passed to blobfuse
+	argAllowlist *argumentAllowlist
+	// mounts records blobfuse mounts done by proxy, keyed by target path, protected by mountsLock
+	mounts     map[string]*mountRecord
+	mountsLock sync.Mutex
+	// mountLogDir retains per-mount log files, empty means mount logs are not retained
+	mountLogDir string
+	// log files of unmounted or failed mounts are removed when they are older than mountLogMaxAge,
+	// or there are more than mountLogMaxFiles log files
+	mountLogMaxAge   time.Duration
+	mountLogMaxFiles int
+	// credentialDelivery is how credentials are passed to blobfuse, credentialDir holds per-mount config files
+	credentialDelivery string
+	credentialDir      string
+	// execBlobfuse runs blobfuse with arguments, environment variables and inherited files,
+	// writes combined output to output, blobfuse is killed when ctx is done
+	execBlobfuse func(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error
+	// remountTimeout limits a remount by supervisor, which holds the lock of target path
+	remountTimeout time.Duration
+}
+
+// mountRecord is a blobfuse mount done by proxy
+type mountRecord struct {
+	args      []string
+	options   map[string]string
+	startTime time.Time
+	// cmdArgs and authEnv are used to remount when blobfuse daemon exits, credentials are only held in memory
+	cmdArgs []string
+	authEnv []string
+	// pid of blobfuse daemon, 0 means the daemon is not found and the mount is not supervised
+	pid             int
+	restartCount    int
+	lastRestartTime time.Time
+	// backoff is the delay before next remount after last remount failure
+	backoff   time.Duration
+	nextRetry time.Time
+	// binds are bind mounts of a broken fuse mount which are not bind mounted from the remounted fuse mount yet
+	binds []bindMount
+}
+
+// NewMountServer returns a new Mountserver, at most maxConcurrentMounts blobfuse commands are run at the same time,
+// 0 means no limit
+func NewMountServiceServer(maxConcurrentMounts int) *MountServer {
+	registerMetrics()
+	server := &MountServer{
+		mounter:        mount.New(""),
+		procRoot:       "/proc",
+		blobfuseBinary: blobfuseBinary,
+		targetLocks:    util.NewLockMap(),
+		argAllowlist:   newArgumentAllowlist(nil, nil, nil),
+		mounts:         map[string]*mountRecord{},
+		execBlobfuse:   execBlobfuse,
+		remountTimeout: defaultRemountTimeout,
+	}
+	if maxConcurrentMounts > 0 {
+		server.mountSlots = make(chan struct{}, maxConcurrentMounts)
+	}
+	return server
 }
 
-// NewMountServer returns a new Mountserver
-func NewMountServiceServer() *MountServer {
-	return &MountServer{}
+func execBlobfuse(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error {
+	cmd := exec.CommandContext(ctx, blobfuseBinary, args...)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.ExtraFiles = extraFiles
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return cmd.Run()
 }
 
 // MountAzureBlob mounts an azure blob container to given location
 func (server *MountServer) MountAzureBlob(ctx context.Context,
 	req *mount_azure_blob.MountAzureBlobRequest,
 ) (resp *mount_azure_blob.MountAzureBlobResponse, err error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	var result mount_azure_blob.MountAzureBlobResponse
+	var args []string
+	targetPath, mountArgs, mountOptions := req.GetTargetPath(), req.GetArgs(), req.GetOptions()
+	if req.GetTargetPath() == "" && len(req.GetArgs()) == 0 && len(req.GetOptions()) == 0 {
+		// compatible with old driver which only sets space-joined mountArgs
+		args = strings.Split(req.GetMountArgs(), " ")
+		targetPath, mountArgs, mountOptions = splitBlobfuseArgs(args)
+	} else {
+		if err := validateMountRequest(req.GetTargetPath(), req.GetArgs(), req.GetOptions()); err != nil {
+			klog.Errorf("invalid mount request: %v", err)
+			return &result, status.Error(codes.InvalidArgument, err.Error())
+		}
+		args = util.BuildBlobfuseArgs(req.GetTargetPath(), req.GetArgs(), req.GetOptions())
+	}
+	output, _, err := server.mount(ctx, targetPath, mountArgs, mountOptions, args, req.GetAuthEnv(), nil)
+	result.Output = string(output)
+	return &result, err
+}
 
-	args := req.GetMountArgs()
-	authEnv := req.GetAuthEnv()
-	klog.V(2).Infof("received mount request: Mounting with args %v \n", args)
+// Mount mounts an azure blob container with structured arguments, it's rejected if any required capability is not supported
+func (server *MountServer) Mount(ctx context.Context,
+	req *mount_azure_blob.MountRequest,
+) (*mount_azure_blob.MountResponse, error) {
+	if missing := getMissingCapabilities(req.GetRequiredCapabilities()); len(missing) > 0 {
+		klog.Errorf("mount request on %s requires unsupported capabilities %v", req.GetTargetPath(), missing)
+		return nil, status.Errorf(codes.FailedPrecondition, "capabilities %v are not supported by blobfuse-proxy(version %s)", missing, proxyVersion)
+	}
+	if err := validateMountRequest(req.GetTargetPath(), req.GetArgs(), req.GetOptions()); err != nil {
+		klog.Errorf("invalid mount request: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	args := util.BuildBlobfuseArgs(req.GetTargetPath(), req.GetArgs(), req.GetOptions())
+	output, pid, err := server.mount(ctx, req.GetTargetPath(), req.GetArgs(), req.GetOptions(), args, req.GetAuthEnv(), nil)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			// blobfuse output is returned in error since response is dropped on error
+			err = status.Errorf(codes.Internal, "blobfuse mount failed: %v, output: %s", err, util.RedactString(string(output)))
+		}
+		return nil, err
+	}
+	return &mount_azure_blob.MountResponse{Output: string(output), Pid: int32(pid)}, nil
+}
 
-	var result mount_azure_blob.MountAzureBlobResponse
-	cmd := exec.Command("blobfuse", strings.Split(args, " ")...)
+// MountStream mounts an azure blob container like Mount, and streams progress and blobfuse output to client
+func (server *MountServer) MountStream(req *mount_azure_blob.MountRequest, stream mount_azure_blob.MountServiceV2_MountStreamServer) error {
+	if missing := getMissingCapabilities(req.GetRequiredCapabilities()); len(missing) > 0 {
+		klog.Errorf("mount request on %s requires unsupported capabilities %v", req.GetTargetPath(), missing)
+		return status.Errorf(codes.FailedPrecondition, "capabilities %v are not supported by blobfuse-proxy(version %s)", missing, proxyVersion)
+	}
+	if err := validateMountRequest(req.GetTargetPath(), req.GetArgs(), req.GetOptions()); err != nil {
+		klog.Errorf("invalid mount request: %v", err)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	args := util.BuildBlobfuseArgs(req.GetTargetPath(), req.GetArgs(), req.GetOptions())
+	_, _, err := server.mount(stream.Context(), req.GetTargetPath(), req.GetArgs(), req.GetOptions(), args, req.GetAuthEnv(), stream.Send)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			// blobfuse output has been streamed
+			err = status.Errorf(codes.Internal, "blobfuse mount failed: %v", err)
+		}
+		return err
+	}
+	return nil
+}
 
-	cmd.Env = append(cmd.Env, authEnv...)
-	output, err := cmd.CombinedOutput()
+// GetMountLog returns retained log of mounts on target path
+func (server *MountServer) GetMountLog(ctx context.Context,
+	req *mount_azure_blob.GetMountLogRequest,
+) (*mount_azure_blob.GetMountLogResponse, error) {
+	if !filepath.IsAbs(req.GetTargetPath()) {
+		return nil, status.Errorf(codes.InvalidArgument, "target path(%s) must be an absolute path", req.GetTargetPath())
+	}
+	if server.mountLogDir == "" {
+		return nil, status.Error(codes.FailedPrecondition, "mount log is disabled in blobfuse-proxy")
+	}
+	log, err := readMountLog(server.mountLogDir, filepath.Clean(req.GetTargetPath()))
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "mount log of %s is not found", req.GetTargetPath())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read mount log of %s: %v", req.GetTargetPath(), err)
+	}
+	return &mount_azure_blob.GetMountLogResponse{Log: log}, nil
+}
+
+// mount runs blobfuse with args built from mountArgs and mountOptions, returns blobfuse output and pid of blobfuse daemon,
+// progress and blobfuse output are sent as mount events by send if it's not nil
+func (server *MountServer) mount(ctx context.Context, targetPath string, mountArgs []string, mountOptions map[string]string,
+	args []string, authEnv []string, send func(*mount_azure_blob.MountEvent) error) (output []byte, pid int, err error) {
+	defer recordOperation(operationMount, time.Now(), &err)
+	if err := server.argAllowlist.validate(mountArgs, mountOptions); err != nil {
+		klog.Errorf("mount request is denied: %v", err)
+		return nil, 0, status.Error(codes.PermissionDenied, err.Error())
+	}
+	klog.V(2).Infof("received mount request: Mounting with args %q \n", util.RedactKeyValues(args))
+
+	targetPath = filepath.Clean(targetPath)
+	server.targetLocks.LockEntry(targetPath)
+	defer server.targetLocks.UnlockEntry(targetPath)
+
+	defer server.gcMountLogs()
+	log := newMountLog(server.mountLogDir, targetPath, send)
+	defer log.close()
+	err = server.runBlobfuse(ctx, args, authEnv, log)
+	output = log.getOutput()
 	if err != nil {
 		klog.Error("blobfuse mount failed: with error:", err.Error())
+		log.progress(mountPhaseFailed, err.Error(), 0)
+		return output, 0, err
+	}
+	klog.V(2).Infof("successfully mounted")
+	record := &mountRecord{args: mountArgs, options: mountOptions, startTime: time.Now(), cmdArgs: args, authEnv: authEnv}
+	if process, ok := findBlobfuseProcesses(server.procRoot)[targetPath]; ok {
+		record.pid = process.pid
 	} else {
-		klog.V(2).Infof("successfully mounted")
+		klog.Warningf("blobfuse daemon of %s is not found, the mount would not be supervised", targetPath)
 	}
-	result.Output = string(output)
-	klog.V(2).Infof("blobfuse output: %s\n", result.Output)
-	return &result, err
+	server.mountsLock.Lock()
+	server.mounts[targetPath] = record
+	server.mountsLock.Unlock()
+	klog.V(2).Infof("blobfuse output: %s\n", util.RedactString(string(output)))
+	log.progress(mountPhaseMounted, "", record.pid)
+	return output, record.pid, nil
+}
+
+// UnmountAzureBlob unmounts a blobfuse mount point, NotFound error is returned if target path is not a blobfuse mount
+func (server *MountServer) UnmountAzureBlob(ctx context.Context,
+	req *mount_azure_blob.UnmountAzureBlobRequest,
+) (resp *mount_azure_blob.UnmountAzureBlobResponse, err error) {
+	defer recordOperation(operationUnmount, time.Now(), &err)
+	if !filepath.IsAbs(req.GetTargetPath()) {
+		return nil, status.Errorf(codes.InvalidArgument, "target path(%s) must be an absolute path", req.GetTargetPath())
+	}
+	targetPath := filepath.Clean(req.GetTargetPath())
+	server.targetLocks.LockEntry(targetPath)
+	// target path is no longer mounted by proxy, drop its lock so locks of unmounted paths don't pile up
+	defer server.targetLocks.UnlockAndDeleteEntry(targetPath)
+
+	mountPoints, err := server.listBlobfuseMountPoints()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list mount points: %v", err)
+	}
+	if !mountPoints[targetPath] {
+		server.deleteMountRecord(targetPath)
+		return nil, status.Errorf(codes.NotFound, "target path(%s) is not a blobfuse mount point", targetPath)
+	}
+
+	klog.V(2).Infof("received unmount request: unmounting %s", targetPath)
+	if err := server.mounter.Unmount(targetPath); err != nil {
+		klog.Errorf("blobfuse unmount on %s failed with error: %v", targetPath, err)
+		return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", targetPath, err)
+	}
+	server.deleteMountRecord(targetPath)
+	// mount log is retained for troubleshooting after unmount, until it's removed by gc
+	server.gcMountLogs()
+	klog.V(2).Infof("successfully unmounted %s", targetPath)
+	return &mount_azure_blob.UnmountAzureBlobResponse{}, nil
+}
+
+// ListMounts lists blobfuse mount points on the host, secrets are stripped from blobfuse arguments
+func (server *MountServer) ListMounts(ctx context.Context,
+	req *mount_azure_blob.ListMountsRequest,
+) (*mount_azure_blob.ListMountsResponse, error) {
+	mountPoints, err := server.listBlobfuseMountPoints()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list mount points: %v", err)
+	}
+
+	server.mountsLock.Lock()
+	defer server.mountsLock.Unlock()
+	for targetPath := range server.mounts {
+		if !mountPoints[targetPath] {
+			// blobfuse is unmounted or crashed
+			delete(server.mounts, targetPath)
+		}
+	}
+
+	processes := findBlobfuseProcesses(server.procRoot)
+	resp := &mount_azure_blob.ListMountsResponse{}
+	for targetPath := range mountPoints {
+		info := &mount_azure_blob.MountInfo{TargetPath: targetPath}
+		var args []string
+		var options map[string]string
+		if process, ok := processes[targetPath]; ok {
+			info.Pid = int32(process.pid)
+			_, args, options = splitBlobfuseArgs(process.args)
+		}
+		if record, ok := server.mounts[targetPath]; ok {
+			args, options = record.args, record.options
+			info.StartTime = record.startTime.Unix()
+			info.RestartCount = int32(record.restartCount)
+			if !record.lastRestartTime.IsZero() {
+				info.LastRestartTime = record.lastRestartTime.Unix()
+			}
+		}
+		info.Args, info.Options = stripSecrets(args, options)
+		info.Container = options[containerNameFlag]
+		resp.Mounts = append(resp.Mounts, info)
+	}
+	sort.Slice(resp.Mounts, func(i, j int) bool { return resp.Mounts[i].TargetPath < resp.Mounts[j].TargetPath })
+	return resp, nil
+}
+
+// Health checks whether blobfuse mounts could be served by proxy
+func (server *MountServer) Health(ctx context.Context,
+	req *mount_azure_blob.HealthRequest,
+) (*mount_azure_blob.HealthResponse, error) {
+	if _, err := exec.LookPath(server.blobfuseBinary); err != nil {
+		return &mount_azure_blob.HealthResponse{Ready: false, Message: fmt.Sprintf("%s is not found: %v", server.blobfuseBinary, err)}, nil
+	}
+	if _, err := server.mounter.List(); err != nil {
+		return &mount_azure_blob.HealthResponse{Ready: false, Message: fmt.Sprintf("failed to list mount points: %v", err)}, nil
+	}
+	return &mount_azure_blob.HealthResponse{Ready: true}, nil
+}
+
+// SetArgumentAllowlist allows extra blobfuse options and fuse options besides the default ones in mount requests,
+// pathRoots overrides allowed directories of path options, e.g. --tmp-path
+func (server *MountServer) SetArgumentAllowlist(extraOptions, extraFuseOptions []string, pathRoots map[string][]string) {
+	server.argAllowlist = newArgumentAllowlist(extraOptions, extraFuseOptions, pathRoots)
+}
+
+// SetMountLogDir retains per-mount log files in dir, the directory is created if it does not exist,
+// log files which are not modified in maxAge are removed, and the oldest ones when there are more than maxFiles,
+// zero maxAge or maxFiles disables the limit
+func (server *MountServer) SetMountLogDir(dir string, maxAge time.Duration, maxFiles int) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	server.mountLogDir = dir
+	server.mountLogMaxAge = maxAge
+	server.mountLogMaxFiles = maxFiles
+	server.gcMountLogs()
+	return nil
 }
 
+// gcMountLogs removes expired mount log files, log files of mounts recorded by proxy are kept
+func (server *MountServer) gcMountLogs() {
+	if server.mountLogDir == "" {
+		return
+	}
+	inUse := map[string]bool{}
+	server.mountsLock.Lock()
+	for targetPath := range server.mounts {
+		inUse[getMountLogFile(server.mountLogDir, targetPath)] = true
+	}
+	server.mountsLock.Unlock()
+	gcMountLogs(server.mountLogDir, inUse, time.Now(), server.mountLogMaxAge, server.mountLogMaxFiles)
+}
+
+// SetCredentialDelivery sets how credentials are passed to blobfuse, dir is created for per-mount config files
+// on file delivery, it must be on tmpfs
+func (server *MountServer) SetCredentialDelivery(delivery, dir string) error {
+	if !util.IsValidBlobfuseCredentialDelivery(delivery) {
+		return fmt.Errorf("unsupported blobfuse credential delivery(%s)", delivery)
+	}
+	if delivery == util.BlobfuseCredentialDeliveryFile {
+		if err := util.EnsureBlobfuseCredentialDir(dir); err != nil {
+			return err
+		}
+	}
+	server.credentialDelivery = delivery
+	server.credentialDir = dir
+	return nil
+}
+
+// runBlobfuse runs blobfuse when a mount slot is available, it returns without running blobfuse if ctx is done before that
+func (server *MountServer) runBlobfuse(ctx context.Context, args []string, env []string, log *mountLog) error {
+	if server.mountSlots != nil {
+		select {
+		case server.mountSlots <- struct{}{}:
+		default:
+			log.progress(mountPhaseQueued, "waiting for a mount slot", 0)
+			mountQueueDepth.Inc()
+			select {
+			case server.mountSlots <- struct{}{}:
+				mountQueueDepth.Dec()
+			case <-ctx.Done():
+				mountQueueDepth.Dec()
+				return status.Errorf(codes.Canceled, "mount request is canceled while waiting for a mount slot: %v", ctx.Err())
+			}
+		}
+		defer func() { <-server.mountSlots }()
+	}
+	mountsInProgress.Inc()
+	defer mountsInProgress.Dec()
+	log.progress(mountPhaseRunning, "", 0)
+	// config file is removed once blobfuse returns, blobfuse daemon has read it by then
+	creds, err := util.PrepareBlobfuseCredentials(server.credentialDelivery, server.credentialDir, args, env)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to prepare blobfuse credentials: %v", err)
+	}
+	defer creds.Cleanup()
+	return server.execBlobfuse(ctx, append(append([]string{}, args...), creds.Args...), creds.Env, creds.ExtraFiles, log)
+}
+
+// deleteMountRecord stops tracking the mount on target path
+func (server *MountServer) deleteMountRecord(targetPath string) {
+	server.mountsLock.Lock()
+	defer server.mountsLock.Unlock()
+	delete(server.mounts, targetPath)
+}
+
+// listBlobfuseMountPoints returns target paths of blobfuse mount points
+func (server *MountServer) listBlobfuseMountPoints() (map[string]bool, error) {
+	mountPoints, err := server.mounter.List()
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]bool{}
+	for _, mp := range mountPoints {
+		if mp.Device == blobfuseDevice && (mp.Type == fuseType || strings.HasPrefix(mp.Type, fuseType+".")) {
+			targets[filepath.Clean(mp.Path)] = true
+		}
+	}
+	return targets, nil
+}
+
+// splitBlobfuseArgs splits blobfuse command line arguments into target path, args and "--key=value" options
+func splitBlobfuseArgs(cmdArgs []string) (string, []string, map[string]string) {
+	var targetPath string
+	var args []string
+	options := map[string]string{}
+	for i, arg := range cmdArgs {
+		if i == 0 {
+			targetPath = arg
+			continue
+		}
+		if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 && optionNameRegexp.MatchString(kv[0]) {
+			options[kv[0]] = kv[1]
+			continue
+		}
+		args = append(args, arg)
+	}
+	return targetPath, args, options
+}
+
+// stripSecrets removes options and "-o key=value" args whose names look like secrets
+func stripSecrets(args []string, options map[string]string) ([]string, map[string]string) {
+	var strippedArgs []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-o" && i+1 < len(args) {
+			if isSensitiveOption(strings.SplitN(args[i+1], "=", 2)[0]) {
+				i++
+				continue
+			}
+		}
+		if !isSensitiveOption(strings.SplitN(args[i], "=", 2)[0]) {
+			strippedArgs = append(strippedArgs, args[i])
+		}
+	}
+	strippedOptions := map[string]string{}
+	for k, v := range options {
+		if !isSensitiveOption(k) {
+			strippedOptions[k] = v
+		}
+	}
+	return strippedArgs, strippedOptions
+}
+
+func isSensitiveOption(name string) bool {
+	name = strings.ToLower(name)
+	for _, keyword := range sensitiveKeywords {
+		if strings.Contains(name, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMountRequest makes sure every argument reaches blobfuse as intended:
+// target path must be absolute, the only positional argument is the target path,
+// and option names could not carry another option or argument
+func validateMountRequest(targetPath string, args []string, options map[string]string) error {
+	if !filepath.IsAbs(targetPath) {
+		return fmt.Errorf("target path(%s) must be an absolute path", targetPath)
+	}
+	if strings.ContainsAny(targetPath, "\x00\n") {
+		return fmt.Errorf("target path(%q) contains invalid characters", targetPath)
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" || strings.ContainsAny(arg, "\x00\n") {
+			return fmt.Errorf("argument(%q) is invalid", arg)
+		}
+		if arg == "-o" {
+			if i+1 >= len(args) {
+				return fmt.Errorf("argument -o requires a value")
+			}
+			i++
+			if value := args[i]; value == "" || strings.HasPrefix(value, "-") || strings.ContainsAny(value, "\x00\n") {
+				return fmt.Errorf("value(%q) of argument -o is invalid", value)
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unexpected positional argument(%s)", arg)
+		}
+	}
+
+	for k, v := range options {
+		if !optionNameRegexp.MatchString(k) {
+			return fmt.Errorf("option name(%q) is invalid", k)
+		}
+		if strings.ContainsAny(v, "\x00\n") {
+			return fmt.Errorf("value of option(%s) contains invalid characters", k)
+		}
+	}
+	return nil
+}
+
+// logGRPC logs gRPC requests with credentials stripped, fields marked with csi_secret are stripped by protosanitizer
+func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	klog.V(4).Infof("GRPC call: %s, request: %s", info.FullMethod, util.RedactRequest(req))
+	return handler(ctx, req)
+}
+
+// RunGRPCServer serves mount service on listener, TLS is enabled when tlsConfig is not nil
 func RunGRPCServer(
 	mountServer mount_azure_blob.MountServiceServer,
-	enableTLS bool,
+	tlsConfig *tls.Config,
 	listener net.Listener,
 ) error {
 	serverOptions := []grpc.ServerOption{}
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	serverOptions = append(serverOptions, grpc.UnaryInterceptor(logGRPC))
 	grpcServer := grpc.NewServer(serverOptions...)
 
 	mount_azure_blob.RegisterMountServiceServer(grpcServer, mountServer)
+	if mountServerV2, ok := mountServer.(mount_azure_blob.MountServiceV2Server); ok {
+		mount_azure_blob.RegisterMountServiceV2Server(grpcServer, mountServerV2)
+	}
 
-	klog.V(2).Infof("Start GRPC server at %s, TLS = %t", listener.Addr().String(), enableTLS)
+	klog.V(2).Infof("Start GRPC server at %s, TLS = %t, mTLS = %t", listener.Addr().String(), tlsConfig != nil,
+		tlsConfig != nil && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert)
 	return grpcServer.Serve(listener)
 }
@@ -18,14 +18,41 @@ package server
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/stretchr/testify/require"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics/testutil"
+	mount "k8s.io/mount-utils"
 	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
 )
 
+// newTestMountServer returns a mount server with fake mounter and proc filesystem in a temp directory
+func newTestMountServer(t *testing.T, mountPoints []mount.MountPoint) (*MountServer, string) {
+	procRoot, err := ioutil.TempDir("", "blobfuse-proxy-proc")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(procRoot) })
+	server := NewMountServiceServer(0)
+	server.mounter = mount.NewFakeMounter(mountPoints)
+	server.procRoot = procRoot
+	return server, procRoot
+}
+
+func writeProcCmdline(t *testing.T, procRoot, pid string, argv ...string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, pid), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(procRoot, pid, "cmdline"), []byte(strings.Join(argv, "\x00")+"\x00"), 0644))
+}
+
 func TestServerMountAzureBlob(t *testing.T) {
 	t.Parallel()
 
@@ -48,7 +75,7 @@ func TestServerMountAzureBlob(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			mountServer := NewMountServiceServer()
+			mountServer := NewMountServiceServer(0)
 			req := mount_azure_blob.MountAzureBlobRequest{
 				MountArgs: tc.args,
 				AuthEnv:   tc.authEnv,
@@ -64,3 +91,331 @@ func TestServerMountAzureBlob(t *testing.T) {
 		})
 	}
 }
+
+func TestServerMountAzureBlobInvalidRequest(t *testing.T) {
+	testCases := []struct {
+		name       string
+		targetPath string
+		args       []string
+		options    map[string]string
+	}{
+		{
+			name:       "relative_target_path",
+			targetPath: "mnt/target",
+		},
+		{
+			name:       "positional_argument",
+			targetPath: "/mnt/target",
+			args:       []string{"-o", "allow_other", "/mnt/another"},
+		},
+		{
+			name:       "missing_value_of_o",
+			targetPath: "/mnt/target",
+			args:       []string{"-o"},
+		},
+		{
+			name:       "option_in_value_of_o",
+			targetPath: "/mnt/target",
+			args:       []string{"-o", "--tmp-path=/"},
+		},
+		{
+			name:       "invalid_option_name",
+			targetPath: "/mnt/target",
+			options:    map[string]string{"--tmp-path /mnt --log-level": "LOG_DEBUG"},
+		},
+		{
+			name:       "newline_in_option_value",
+			targetPath: "/mnt/target",
+			options:    map[string]string{"--tmp-path": "/mnt\n--log-level=LOG_DEBUG"},
+		},
+	}
+
+	mountServer := NewMountServiceServer(0)
+	for _, tc := range testCases {
+		req := mount_azure_blob.MountAzureBlobRequest{
+			TargetPath: tc.targetPath,
+			Args:       tc.args,
+			Options:    tc.options,
+		}
+		_, err := mountServer.MountAzureBlob(context.Background(), &req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), tc.name)
+	}
+}
+
+func TestValidateMountRequest(t *testing.T) {
+	err := validateMountRequest("/mnt/target path", []string{"-o", "allow_other", "-d"}, map[string]string{"--tmp-path": "/mnt/cache dir", "--use-https": "true"})
+	require.NoError(t, err)
+}
+
+// blockingBlobfuse blocks blobfuse commands until they are released
+type blockingBlobfuse struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingBlobfuse() *blockingBlobfuse {
+	return &blockingBlobfuse{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (b *blockingBlobfuse) exec(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error {
+	b.started <- args[0]
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *blockingBlobfuse) waitStarted(t *testing.T) string {
+	select {
+	case targetPath := <-b.started:
+		return targetPath
+	case <-time.After(5 * time.Second):
+		t.Fatal("blobfuse is not started")
+		return ""
+	}
+}
+
+func (b *blockingBlobfuse) ensureNotStarted(t *testing.T) {
+	select {
+	case targetPath := <-b.started:
+		t.Fatalf("unexpected blobfuse on %s", targetPath)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func mountAsync(server *MountServer, ctx context.Context, targetPath string) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := server.MountAzureBlob(ctx, &mount_azure_blob.MountAzureBlobRequest{TargetPath: targetPath})
+		errCh <- err
+	}()
+	return errCh
+}
+
+func TestServerMountAzureBlobConcurrency(t *testing.T) {
+	server, _ := newTestMountServer(t, nil)
+	server.mountSlots = make(chan struct{}, 1)
+	blobfuse := newBlockingBlobfuse()
+	server.execBlobfuse = blobfuse.exec
+
+	errCh1 := mountAsync(server, context.Background(), "/mnt/target1")
+	require.Equal(t, "/mnt/target1", blobfuse.waitStarted(t))
+
+	// mount on another target path waits for a mount slot
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh2 := mountAsync(server, ctx, "/mnt/target2")
+	blobfuse.ensureNotStarted(t)
+	depth, err := testutil.GetGaugeMetricValue(mountQueueDepth)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), depth)
+
+	// canceled request leaves the queue without running blobfuse
+	cancel()
+	require.Equal(t, codes.Canceled, status.Code(<-errCh2))
+	blobfuse.ensureNotStarted(t)
+	depth, err = testutil.GetGaugeMetricValue(mountQueueDepth)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), depth)
+
+	errCh3 := mountAsync(server, context.Background(), "/mnt/target3")
+	blobfuse.release <- struct{}{}
+	require.NoError(t, <-errCh1)
+	require.Equal(t, "/mnt/target3", blobfuse.waitStarted(t))
+	blobfuse.release <- struct{}{}
+	require.NoError(t, <-errCh3)
+}
+
+func TestServerMountAzureBlobSameTarget(t *testing.T) {
+	server, _ := newTestMountServer(t, nil)
+	blobfuse := newBlockingBlobfuse()
+	server.execBlobfuse = blobfuse.exec
+
+	errCh1 := mountAsync(server, context.Background(), "/mnt/target1")
+	require.Equal(t, "/mnt/target1", blobfuse.waitStarted(t))
+	// mounts on different target paths are not serialized without limit
+	errCh2 := mountAsync(server, context.Background(), "/mnt/target2")
+	require.Equal(t, "/mnt/target2", blobfuse.waitStarted(t))
+	// mount on the same target path waits for the running one
+	errCh3 := mountAsync(server, context.Background(), "/mnt/target1/")
+	blobfuse.ensureNotStarted(t)
+
+	blobfuse.release <- struct{}{}
+	blobfuse.release <- struct{}{}
+	require.NoError(t, <-errCh1)
+	require.NoError(t, <-errCh2)
+	require.Equal(t, "/mnt/target1/", blobfuse.waitStarted(t))
+
+	// blobfuse is killed when mount request is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh4 := mountAsync(server, ctx, "/mnt/target4")
+	require.Equal(t, "/mnt/target4", blobfuse.waitStarted(t))
+	cancel()
+	require.Error(t, <-errCh4)
+	require.NotContains(t, server.mounts, "/mnt/target4")
+
+	blobfuse.release <- struct{}{}
+	require.NoError(t, <-errCh3)
+}
+
+func TestServerUnmountAzureBlob(t *testing.T) {
+	server, _ := newTestMountServer(t, []mount.MountPoint{
+		{Device: "blobfuse", Path: "/mnt/blobfuse", Type: "fuse"},
+		{Device: "10.0.0.4:/account/container", Path: "/mnt/nfs", Type: "nfs"},
+	})
+	server.mounts["/mnt/blobfuse"] = &mountRecord{startTime: time.Now()}
+
+	_, err := server.UnmountAzureBlob(context.Background(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: "mnt/blobfuse"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = server.UnmountAzureBlob(context.Background(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: "/mnt/nfs"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.UnmountAzureBlob(context.Background(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: "/mnt/blobfuse/"})
+	require.NoError(t, err)
+	require.Empty(t, server.mounts)
+	mountPoints, err := server.mounter.List()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(mountPoints))
+	_, err = server.UnmountAzureBlob(context.Background(), &mount_azure_blob.UnmountAzureBlobRequest{TargetPath: "/mnt/blobfuse"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServerListMounts(t *testing.T) {
+	server, procRoot := newTestMountServer(t, []mount.MountPoint{
+		{Device: "blobfuse", Path: "/mnt/proxy", Type: "fuse"},
+		{Device: "blobfuse", Path: "/mnt/external", Type: "fuse"},
+		{Device: "tmpfs", Path: "/mnt/tmpfs", Type: "tmpfs"},
+	})
+	startTime := time.Unix(1633075200, 0)
+	server.mounts["/mnt/proxy"] = &mountRecord{
+		args:      []string{"-o", "allow_other"},
+		options:   map[string]string{"--container-name": "container1", "--tmp-path": "/mnt/cache"},
+		startTime: startTime,
+	}
+	server.mounts["/mnt/unmounted"] = &mountRecord{startTime: startTime}
+	writeProcCmdline(t, procRoot, "100", "/usr/bin/blobfuse", "/mnt/proxy", "-o", "allow_other", "--container-name=container1", "--tmp-path=/mnt/cache")
+	writeProcCmdline(t, procRoot, "200", "blobfuse", "/mnt/external", "-o", "sas_token=secret", "--container-name=container2", "--account-key=secret")
+	writeProcCmdline(t, procRoot, "300", "/bin/bash")
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "self"), 0755))
+
+	resp, err := server.ListMounts(context.Background(), &mount_azure_blob.ListMountsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(resp.Mounts))
+
+	external := resp.Mounts[0]
+	require.Equal(t, "/mnt/external", external.TargetPath)
+	require.Equal(t, "container2", external.Container)
+	require.Equal(t, int32(200), external.Pid)
+	require.Equal(t, int64(0), external.StartTime)
+	require.Empty(t, external.Args)
+	require.Equal(t, map[string]string{"--container-name": "container2"}, external.Options)
+
+	proxy := resp.Mounts[1]
+	require.Equal(t, "/mnt/proxy", proxy.TargetPath)
+	require.Equal(t, "container1", proxy.Container)
+	require.Equal(t, int32(100), proxy.Pid)
+	require.Equal(t, startTime.Unix(), proxy.StartTime)
+	require.Equal(t, []string{"-o", "allow_other"}, proxy.Args)
+	require.Equal(t, "/mnt/cache", proxy.Options["--tmp-path"])
+
+	// record of unmounted target is removed
+	require.NotContains(t, server.mounts, "/mnt/unmounted")
+}
+
+func TestServerHealth(t *testing.T) {
+	server, _ := newTestMountServer(t, nil)
+	server.blobfuseBinary = "blobfuse-not-exist"
+	resp, err := server.Health(context.Background(), &mount_azure_blob.HealthRequest{})
+	require.NoError(t, err)
+	require.False(t, resp.Ready)
+	require.NotEmpty(t, resp.Message)
+
+	server.blobfuseBinary = "sh"
+	resp, err = server.Health(context.Background(), &mount_azure_blob.HealthRequest{})
+	require.NoError(t, err)
+	require.True(t, resp.Ready)
+}
+
+func TestSplitBlobfuseArgs(t *testing.T) {
+	targetPath, args, options := splitBlobfuseArgs([]string{"/mnt/target", "-o", "allow_other", "--tmp-path=/mnt/cache", "-d"})
+	require.Equal(t, "/mnt/target", targetPath)
+	require.Equal(t, []string{"-o", "allow_other", "-d"}, args)
+	require.Equal(t, map[string]string{"--tmp-path": "/mnt/cache"}, options)
+}
+
+func TestStripSecrets(t *testing.T) {
+	args, options := stripSecrets(
+		[]string{"-o", "allow_other", "-o", "sas_token=secret", "--account-key=secret", "-d"},
+		map[string]string{"--container-name": "container", "--Account-Key": "secret", "--tmp-path": "/mnt/cache"})
+	require.Equal(t, []string{"-o", "allow_other", "-d"}, args)
+	require.Equal(t, map[string]string{"--container-name": "container", "--tmp-path": "/mnt/cache"}, options)
+}
+
+func TestServerMountCredentialDelivery(t *testing.T) {
+	authEnv := []string{"AZURE_STORAGE_ACCESS_KEY=secret", "AZURE_STORAGE_ACCOUNT=account", "MSI_SECRET=msi"}
+	tests := []struct {
+		delivery       string
+		expectedArg    string
+		expectedConfig bool
+	}{
+		{delivery: util.BlobfuseCredentialDeliveryEnv},
+		{delivery: util.BlobfuseCredentialDeliveryFile, expectedArg: "--config-file=", expectedConfig: true},
+		{delivery: util.BlobfuseCredentialDeliveryFD, expectedArg: "--config-file=/dev/fd/3", expectedConfig: true},
+	}
+	for _, test := range tests {
+		server, _ := newTestMountServer(t, nil)
+		// credential dir of file delivery is checked to be on tmpfs in SetCredentialDelivery
+		server.credentialDelivery = test.delivery
+		server.credentialDir = tempDir(t)
+		var execArgs, execEnv []string
+		var config string
+		server.execBlobfuse = func(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error {
+			execArgs, execEnv = args, env
+			last := args[len(args)-1]
+			switch {
+			case len(extraFiles) > 0:
+				content, err := ioutil.ReadAll(extraFiles[0])
+				require.NoError(t, err)
+				config = string(content)
+			case strings.HasPrefix(last, "--config-file="):
+				content, err := ioutil.ReadFile(strings.TrimPrefix(last, "--config-file="))
+				require.NoError(t, err)
+				config = string(content)
+			}
+			return nil
+		}
+		_, err := server.Mount(context.Background(), &mount_azure_blob.MountRequest{TargetPath: "/mnt/target", AuthEnv: authEnv})
+		require.NoError(t, err, test.delivery)
+
+		if !test.expectedConfig {
+			require.Equal(t, authEnv, execEnv, test.delivery)
+			require.Equal(t, []string{"/mnt/target"}, execArgs, test.delivery)
+			continue
+		}
+		require.Equal(t, []string{"MSI_SECRET=msi"}, execEnv, test.delivery)
+		require.True(t, strings.HasPrefix(execArgs[len(execArgs)-1], test.expectedArg), test.delivery)
+		require.Equal(t, "accountKey secret\naccountName account\n", config, test.delivery)
+		// config file is removed after blobfuse returns, credentials are kept in memory for remount
+		files, err := ioutil.ReadDir(server.credentialDir)
+		require.NoError(t, err)
+		require.Empty(t, files, test.delivery)
+		require.Equal(t, []string{"/mnt/target"}, server.mounts["/mnt/target"].cmdArgs, test.delivery)
+		require.Equal(t, authEnv, server.mounts["/mnt/target"].authEnv, test.delivery)
+	}
+
+	server, _ := newTestMountServer(t, nil)
+	require.Error(t, server.SetCredentialDelivery("pipe", ""))
+	require.NoError(t, server.SetCredentialDelivery(util.BlobfuseCredentialDeliveryFD, ""))
+}
+
+func TestLogGRPCStripsSecrets(t *testing.T) {
+	for _, req := range []interface{}{
+		&mount_azure_blob.MountAzureBlobRequest{TargetPath: "/mnt/target", AuthEnv: []string{"AZURE_STORAGE_ACCESS_KEY=secret"}},
+		&mount_azure_blob.MountRequest{TargetPath: "/mnt/target", AuthEnv: []string{"AZURE_STORAGE_ACCESS_KEY=secret"}},
+	} {
+		stripped := protosanitizer.StripSecrets(req).String()
+		require.Contains(t, stripped, "/mnt/target")
+		require.NotContains(t, stripped, "secret")
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// initialRemountBackoff is the delay before retrying a failed remount, it's doubled on every failure
+	initialRemountBackoff = 5 * time.Second
+	defaultRemountTimeout = 2 * time.Minute
+)
+
+// SuperviseMounts checks blobfuse daemons of mounts done by proxy every interval until stopCh is closed,
+// and remounts with original arguments and credentials when a daemon exits, blobfuse is killed if a remount
+// does not finish in remountTimeout
+func (server *MountServer) SuperviseMounts(interval, maxBackoff, remountTimeout time.Duration, stopCh <-chan struct{}) {
+	klog.V(2).Infof("supervising blobfuse daemons every %v, max remount backoff: %v, remount timeout: %v", interval, maxBackoff, remountTimeout)
+	if remountTimeout > 0 {
+		server.remountTimeout = remountTimeout
+	}
+	wait.Until(func() { server.checkMounts(time.Now(), maxBackoff) }, interval, stopCh)
+}
+
+// checkMounts remounts the mounts whose blobfuse daemon exits while mount point still exists,
+// mounts which are unmounted outside proxy are no longer supervised
+func (server *MountServer) checkMounts(now time.Time, maxBackoff time.Duration) {
+	server.mountsLock.Lock()
+	targetPaths := make([]string, 0, len(server.mounts))
+	pendingBinds := map[string]bool{}
+	for targetPath, record := range server.mounts {
+		if len(record.binds) > 0 {
+			pendingBinds[targetPath] = true
+		}
+		if record.pid != 0 {
+			targetPaths = append(targetPaths, targetPath)
+		}
+	}
+	server.mountsLock.Unlock()
+	if len(targetPaths) == 0 {
+		return
+	}
+
+	mountPoints, err := server.listBlobfuseMountPoints()
+	if err != nil {
+		klog.Warningf("failed to list mount points: %v", err)
+		return
+	}
+	processes := findBlobfuseProcesses(server.procRoot)
+	for _, targetPath := range targetPaths {
+		if _, ok := processes[targetPath]; ok && !pendingBinds[targetPath] {
+			continue
+		}
+		server.checkMount(targetPath, mountPoints[targetPath], now, maxBackoff)
+	}
+}
+
+// checkMount remounts target path whose blobfuse daemon is not found and recovers bind mounts of the broken fuse mount,
+// mount and unmount requests on the same target path are not run at the same time
+func (server *MountServer) checkMount(targetPath string, mounted bool, now time.Time, maxBackoff time.Duration) {
+	server.targetLocks.LockEntry(targetPath)
+
+	// record fields are read by ListMounts, they are updated with mountsLock held
+	server.mountsLock.Lock()
+	record, ok := server.mounts[targetPath]
+	if ok && !mounted {
+		klog.V(2).Infof("[%s] blobfuse is unmounted outside proxy, stop supervising", targetPath)
+		delete(server.mounts, targetPath)
+	}
+	server.mountsLock.Unlock()
+	if !ok || !mounted {
+		server.targetLocks.UnlockAndDeleteEntry(targetPath)
+		return
+	}
+	defer server.targetLocks.UnlockEntry(targetPath)
+	if now.Before(record.nextRetry) {
+		return
+	}
+	if _, ok := findBlobfuseProcesses(server.procRoot)[targetPath]; !ok {
+		klog.Warningf("[%s] blobfuse daemon(pid %d) exited, remounting, restart count: %d", targetPath, record.pid, record.restartCount)
+		if err := server.remount(targetPath, record); err != nil {
+			server.mountsLock.Lock()
+			record.retryAfter(now, maxBackoff)
+			server.mountsLock.Unlock()
+			klog.Errorf("[%s] remount failed: %v, retry after %v", targetPath, err, record.backoff)
+			return
+		}
+
+		server.mountsLock.Lock()
+		record.restartCount++
+		daemonRestarts.Inc()
+		record.lastRestartTime = now
+		record.backoff = 0
+		record.nextRetry = time.Time{}
+		if process, ok := findBlobfuseProcesses(server.procRoot)[targetPath]; ok {
+			record.pid = process.pid
+		}
+		server.mountsLock.Unlock()
+		klog.V(2).Infof("[%s] blobfuse daemon is restarted(pid %d), restart count: %d", targetPath, record.pid, record.restartCount)
+	}
+	// daemon is restarted by supervisor or by a mount request after processes are listed
+	if len(record.binds) == 0 {
+		return
+	}
+	binds, err := server.rebindMounts(targetPath, record.binds)
+	server.mountsLock.Lock()
+	defer server.mountsLock.Unlock()
+	record.binds = binds
+	if err != nil {
+		record.retryAfter(now, maxBackoff)
+		klog.Errorf("[%s] failed to recover bind mounts: %v, retry after %v", targetPath, err, record.backoff)
+	}
+}
+
+// retryAfter doubles the backoff of record up to maxBackoff and schedules next retry
+func (record *mountRecord) retryAfter(now time.Time, maxBackoff time.Duration) {
+	record.backoff *= 2
+	if record.backoff < initialRemountBackoff {
+		record.backoff = initialRemountBackoff
+	}
+	if record.backoff > maxBackoff {
+		record.backoff = maxBackoff
+	}
+	record.nextRetry = now.Add(record.backoff)
+}
+
+// bindMount is a bind mount of a fuse mount, e.g. a pod volume path published by NodePublishVolume
+type bindMount struct {
+	path string
+	// root is the directory in the fuse mount which is bind mounted, relative to target path of the fuse mount
+	root     string
+	readOnly bool
+	// unmounted is set when bind mount of the broken fuse mount is unmounted but bind mounting again failed
+	unmounted bool
+}
+
+// remount cleans up the broken fuse mount point and runs blobfuse with original arguments and credentials,
+// bind mounts of the broken fuse mount still refer to the exited daemon, they are saved in record to be bind mounted
+// from the new fuse mount
+func (server *MountServer) remount(targetPath string, record *mountRecord) (err error) {
+	defer recordOperation(operationRemount, time.Now(), &err)
+	binds, findErr := findBindMounts(server.procRoot, targetPath)
+	if findErr != nil {
+		klog.Warningf("[%s] failed to find bind mounts: %v", targetPath, findErr)
+	}
+	if len(binds) > 0 {
+		server.mountsLock.Lock()
+		record.binds = mergeBindMounts(record.binds, binds)
+		server.mountsLock.Unlock()
+	}
+	if err := server.mounter.Unmount(targetPath); err != nil {
+		return fmt.Errorf("failed to unmount broken mount point: %v", err)
+	}
+	log := newMountLog(server.mountLogDir, targetPath, nil)
+	defer log.close()
+	// lock of target path is held during remount, so a hung blobfuse must not block mount requests forever
+	ctx, cancel := context.WithTimeout(context.Background(), server.remountTimeout)
+	defer cancel()
+	if err := server.runBlobfuse(ctx, record.cmdArgs, record.authEnv, log); err != nil {
+		log.progress(mountPhaseFailed, err.Error(), 0)
+		return fmt.Errorf("%v, output: %s", err, string(log.getOutput()))
+	}
+	log.progress(mountPhaseMounted, "remounted by supervisor", 0)
+	return nil
+}
+
+// mergeBindMounts returns binds with bind mounts in found which are not in binds
+func mergeBindMounts(binds, found []bindMount) []bindMount {
+	paths := map[string]bool{}
+	for _, bind := range binds {
+		paths[bind.path] = true
+	}
+	for _, bind := range found {
+		if !paths[bind.path] {
+			binds = append(binds, bind)
+		}
+	}
+	return binds
+}
+
+// rebindMounts replaces bind mounts of the broken fuse mount with bind mounts of the new fuse mount on targetPath,
+// bind mounts which are unmounted meanwhile, e.g. by NodeUnpublishVolume, are skipped, and the ones failed are returned
+func (server *MountServer) rebindMounts(targetPath string, binds []bindMount) ([]bindMount, error) {
+	mountPoints, err := server.mounter.List()
+	if err != nil {
+		return binds, fmt.Errorf("failed to list mount points: %v", err)
+	}
+	mounted := map[string]bool{}
+	for _, mountPoint := range mountPoints {
+		mounted[mountPoint.Path] = true
+	}
+
+	var failed []bindMount
+	var errs []string
+	for _, bind := range binds {
+		if bind.unmounted {
+			if _, err := os.Stat(bind.path); os.IsNotExist(err) {
+				klog.V(2).Infof("[%s] bind mount path %s is removed, skip", targetPath, bind.path)
+				continue
+			}
+		} else if !mounted[bind.path] {
+			klog.V(2).Infof("[%s] bind mount %s is unmounted, skip", targetPath, bind.path)
+			continue
+		}
+		source := filepath.Join(targetPath, bind.root)
+		options := []string{"bind"}
+		if bind.readOnly {
+			options = append(options, "ro")
+		}
+		klog.V(2).Infof("[%s] bind mounting %s on %s again, options: %v", targetPath, source, bind.path, options)
+		if !bind.unmounted {
+			if err := server.mounter.Unmount(bind.path); err != nil {
+				failed = append(failed, bind)
+				errs = append(errs, fmt.Sprintf("failed to unmount %s: %v", bind.path, err))
+				continue
+			}
+		}
+		if err := server.mounter.Mount(source, bind.path, "", options); err != nil {
+			bind.unmounted = true
+			failed = append(failed, bind)
+			errs = append(errs, fmt.Sprintf("failed to bind mount %s on %s: %v", source, bind.path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return failed, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil, nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	mount "k8s.io/mount-utils"
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+)
+
+// fakeBlobfuse mounts target path on fake mounter and starts a fake daemon in proc filesystem
+type fakeBlobfuse struct {
+	t        *testing.T
+	server   *MountServer
+	procRoot string
+	nextPid  int
+	calls    int
+	err      error
+	hang     bool
+	authEnv  []string
+}
+
+func (f *fakeBlobfuse) exec(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error {
+	f.calls++
+	f.authEnv = env
+	if f.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if f.err != nil {
+		fmt.Fprintln(output, "mount failed")
+		return f.err
+	}
+	require.NoError(f.t, f.server.mounter.Mount("blobfuse", args[0], "fuse", nil))
+	f.nextPid++
+	writeProcCmdline(f.t, f.procRoot, fmt.Sprint(f.nextPid), append([]string{"blobfuse"}, args...)...)
+	return nil
+}
+
+func (f *fakeBlobfuse) kill(pid int) {
+	require.NoError(f.t, os.RemoveAll(filepath.Join(f.procRoot, fmt.Sprint(pid))))
+}
+
+func newSupervisedMountServer(t *testing.T) (*MountServer, *fakeBlobfuse) {
+	server, procRoot := newTestMountServer(t, nil)
+	blobfuse := &fakeBlobfuse{t: t, server: server, procRoot: procRoot, nextPid: 100}
+	server.execBlobfuse = blobfuse.exec
+
+	_, err := server.MountAzureBlob(context.Background(), &mount_azure_blob.MountAzureBlobRequest{
+		TargetPath: "/mnt/target",
+		Options:    map[string]string{"--container-name": "container"},
+		AuthEnv:    []string{"AZURE_STORAGE_ACCESS_KEY=secret"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 101, server.mounts["/mnt/target"].pid)
+	return server, blobfuse
+}
+
+func TestCheckMounts(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	now := time.Now()
+
+	// running daemon is not restarted
+	server.checkMounts(now, time.Minute)
+	require.Equal(t, 1, blobfuse.calls)
+	require.Equal(t, 0, server.mounts["/mnt/target"].restartCount)
+
+	// exited daemon is restarted with original credentials
+	blobfuse.kill(101)
+	blobfuse.authEnv = nil
+	server.checkMounts(now, time.Minute)
+	require.Equal(t, 2, blobfuse.calls)
+	require.Equal(t, []string{"AZURE_STORAGE_ACCESS_KEY=secret"}, blobfuse.authEnv)
+	record := server.mounts["/mnt/target"]
+	require.Equal(t, 102, record.pid)
+	require.Equal(t, 1, record.restartCount)
+	require.Equal(t, now, record.lastRestartTime)
+
+	resp, err := server.ListMounts(context.Background(), &mount_azure_blob.ListMountsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Mounts))
+	require.Equal(t, int32(1), resp.Mounts[0].RestartCount)
+	require.Equal(t, now.Unix(), resp.Mounts[0].LastRestartTime)
+}
+
+func TestCheckMountsBackoff(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	now := time.Now()
+	blobfuse.kill(101)
+	blobfuse.err = fmt.Errorf("exit status 1")
+
+	server.checkMounts(now, 8*time.Second)
+	record := server.mounts["/mnt/target"]
+	require.Equal(t, 2, blobfuse.calls)
+	require.Equal(t, initialRemountBackoff, record.backoff)
+	require.Equal(t, now.Add(initialRemountBackoff), record.nextRetry)
+
+	// broken mount point is cleaned up before remount, restore it as a crashed fuse mount
+	require.NoError(t, server.mounter.Mount("blobfuse", "/mnt/target", "fuse", nil))
+	// no retry before backoff expires
+	server.checkMounts(now.Add(time.Second), 8*time.Second)
+	require.Equal(t, 2, blobfuse.calls)
+
+	// backoff is capped by max backoff
+	server.checkMounts(now.Add(initialRemountBackoff), 8*time.Second)
+	require.Equal(t, 3, blobfuse.calls)
+	require.Equal(t, 8*time.Second, record.backoff)
+
+	// backoff is reset after successful remount
+	require.NoError(t, server.mounter.Mount("blobfuse", "/mnt/target", "fuse", nil))
+	blobfuse.err = nil
+	server.checkMounts(now.Add(time.Minute), 8*time.Second)
+	require.Equal(t, 4, blobfuse.calls)
+	require.Equal(t, 1, record.restartCount)
+	require.Equal(t, time.Duration(0), record.backoff)
+}
+
+func TestCheckMountsRemountTimeout(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	server.remountTimeout = 100 * time.Millisecond
+	now := time.Now()
+	blobfuse.kill(101)
+	blobfuse.hang = true
+
+	done := make(chan struct{})
+	go func() {
+		server.checkMounts(now, time.Minute)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("remount of hung blobfuse is not canceled")
+	}
+	record := server.mounts["/mnt/target"]
+	require.Equal(t, 2, blobfuse.calls)
+	require.Equal(t, 0, record.restartCount)
+	require.Equal(t, initialRemountBackoff, record.backoff)
+
+	// lock of target path is released after timeout
+	server.targetLocks.LockEntry("/mnt/target")
+	server.targetLocks.UnlockEntry("/mnt/target")
+}
+
+func TestCheckMountsUnmounted(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+	blobfuse.kill(101)
+	require.NoError(t, server.mounter.Unmount("/mnt/target"))
+
+	server.checkMounts(time.Now(), time.Minute)
+	require.Equal(t, 1, blobfuse.calls)
+	require.Empty(t, server.mounts)
+}
+
+func TestCheckMountsWithoutPid(t *testing.T) {
+	server, _ := newTestMountServer(t, []mount.MountPoint{{Device: "blobfuse", Path: "/mnt/target", Type: "fuse"}})
+	server.execBlobfuse = func(ctx context.Context, args []string, env []string, extraFiles []*os.File, output io.Writer) error {
+		t.Fatalf("unexpected remount of %v", args)
+		return nil
+	}
+	server.mounts["/mnt/target"] = &mountRecord{startTime: time.Now()}
+	server.checkMounts(time.Now(), time.Minute)
+	require.Contains(t, server.mounts, "/mnt/target")
+}
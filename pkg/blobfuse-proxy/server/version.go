@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+// proxyVersion is set during build time via -ldflags
+var proxyVersion = "N/A"
+
+var (
+	// apiVersions are the mount service versions served by proxy
+	apiVersions = []string{util.BlobfuseProxyAPIVersionV1, util.BlobfuseProxyAPIVersionV2}
+	// capabilities are the features supported by proxy
+	capabilities = []string{
+		util.BlobfuseProxyCapabilityStructuredArgs,
+		util.BlobfuseProxyCapabilityUnmount,
+		util.BlobfuseProxyCapabilityListMounts,
+		util.BlobfuseProxyCapabilitySupervision,
+		util.BlobfuseProxyCapabilityMountStream,
+		util.BlobfuseProxyCapabilityMountLog,
+	}
+)
+
+// GetVersion returns build version of proxy and mount service versions it serves
+func (server *MountServer) GetVersion(ctx context.Context,
+	req *mount_azure_blob.GetVersionRequest,
+) (*mount_azure_blob.GetVersionResponse, error) {
+	return &mount_azure_blob.GetVersionResponse{ProxyVersion: proxyVersion, ApiVersions: apiVersions}, nil
+}
+
+// GetCapabilities returns features supported by proxy
+func (server *MountServer) GetCapabilities(ctx context.Context,
+	req *mount_azure_blob.GetCapabilitiesRequest,
+) (*mount_azure_blob.GetCapabilitiesResponse, error) {
+	return &mount_azure_blob.GetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+// getMissingCapabilities returns the required capabilities which are not supported by proxy
+func getMissingCapabilities(required []string) []string {
+	supported := map[string]bool{}
+	for _, c := range capabilities {
+		supported[c] = true
+	}
+	missing := []string{}
+	for _, c := range required {
+		if !supported[c] {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	mount_azure_blob "sigs.k8s.io/blob-csi-driver/pkg/blobfuse-proxy/pb"
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+func TestServerGetVersionAndCapabilities(t *testing.T) {
+	server, _ := newTestMountServer(t, nil)
+	version, err := server.GetVersion(context.Background(), &mount_azure_blob.GetVersionRequest{})
+	require.NoError(t, err)
+	require.Equal(t, proxyVersion, version.ProxyVersion)
+	require.Equal(t, []string{util.BlobfuseProxyAPIVersionV1, util.BlobfuseProxyAPIVersionV2}, version.ApiVersions)
+
+	resp, err := server.GetCapabilities(context.Background(), &mount_azure_blob.GetCapabilitiesRequest{})
+	require.NoError(t, err)
+	require.Contains(t, resp.Capabilities, util.BlobfuseProxyCapabilityStructuredArgs)
+
+	require.Empty(t, getMissingCapabilities([]string{util.BlobfuseProxyCapabilityUnmount}))
+	require.Equal(t, []string{"future"}, getMissingCapabilities([]string{util.BlobfuseProxyCapabilityUnmount, "future"}))
+}
+
+func TestServerMountV2(t *testing.T) {
+	server, blobfuse := newSupervisedMountServer(t)
+
+	resp, err := server.Mount(context.Background(), &mount_azure_blob.MountRequest{
+		TargetPath:           "/mnt/target v2",
+		Options:              map[string]string{"--container-name": "container"},
+		RequiredCapabilities: []string{util.BlobfuseProxyCapabilityStructuredArgs},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(102), resp.Pid)
+	require.Contains(t, server.mounts, "/mnt/target v2")
+
+	_, err = server.Mount(context.Background(), &mount_azure_blob.MountRequest{TargetPath: "mnt/target"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = server.Mount(context.Background(), &mount_azure_blob.MountRequest{TargetPath: "/mnt/target", Options: map[string]string{"--foreground": "true"}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	blobfuse.err = fmt.Errorf("exit status 1")
+	_, err = server.Mount(context.Background(), &mount_azure_blob.MountRequest{TargetPath: "/mnt/failed"})
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.Contains(t, err.Error(), "mount failed")
+	require.Equal(t, 3, blobfuse.calls)
+}
+
+func TestRunGRPCServerV2(t *testing.T) {
+	socket := filepath.Join(tempDir(t), "blobfuse-proxy.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	startTestGRPCServer(t, nil, listener)
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = mount_azure_blob.NewMountServiceV2Client(conn).Mount(context.Background(), &mount_azure_blob.MountRequest{
+		TargetPath:           "/mnt/target",
+		RequiredCapabilities: []string{"future"},
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -45,11 +45,24 @@ var (
 	driverName                 = flag.String("drivername", blob.DefaultDriverName, "name of the driver")
 	enableBlobfuseProxy        = flag.Bool("enable-blobfuse-proxy", false, "Whether using blobfuse proxy for mounts")
 	blobfuseProxyConnTimout    = flag.Int("blobfuse-proxy-connect-timeout", 5, "blobfuse proxy connection timeout(seconds)")
+	blobfuseProxyTLSCAFile     = flag.String("blobfuse-proxy-tls-ca-file", "", "CA file to verify blobfuse proxy server certificate, TLS is used to connect blobfuse proxy when it's specified")
+	blobfuseProxyTLSCertFile   = flag.String("blobfuse-proxy-tls-cert-file", "", "client certificate file presented to blobfuse proxy(mutual TLS)")
+	blobfuseProxyTLSKeyFile    = flag.String("blobfuse-proxy-tls-key-file", "", "client private key file presented to blobfuse proxy(mutual TLS)")
+	blobfuseProxyTLSServerName = flag.String("blobfuse-proxy-tls-server-name", "", "server name to verify blobfuse proxy server certificate, host of blobfuse-proxy-endpoint is used by default")
+	blobfuseProxyFallback      = flag.Bool("enable-blobfuse-proxy-fallback", false, "mount blobfuse inside driver when blobfuse proxy is too old to support capabilities required by the mount")
 	enableBlobMockMount        = flag.Bool("enable-blob-mock-mount", false, "Whether enable mock mount(only for testing)")
 	cloudConfigSecretName      = flag.String("cloud-config-secret-name", "azure-cloud-provider", "secret name of cloud config")
 	cloudConfigSecretNamespace = flag.String("cloud-config-secret-namespace", "kube-system", "secret namespace of cloud config")
 	customUserAgent            = flag.String("custom-user-agent", "", "custom userAgent")
 	userAgentSuffix            = flag.String("user-agent-suffix", "", "userAgent suffix")
+	blobfuseCacheRoot          = flag.String("blobfuse-cache-root", blob.DefaultBlobfuseCacheRoot, "root directory of per-volume blobfuse cache directories")
+	blobfuseCacheSizeMB        = flag.Int("blobfuse-cache-size-mb", 0, "default blobfuse cache size limit(MB) of every volume, 0 means no limit")
+	blobfuseCredentialDelivery = flag.String("blobfuse-credential-delivery", "env", "how credentials are passed to blobfuse mounted inside driver: env(environment variables), file(per-mount config file in credential dir) or fd(config file read from inherited pipe)")
+	blobfuseCredentialDir      = flag.String("blobfuse-credential-dir", "/dev/shm/blob-csi-credentials", "tmpfs directory of per-mount blobfuse config files when blobfuse-credential-delivery is file")
+	volumeRestoreSyncPeriod    = flag.Int("volume-restore-sync-period", 60, "interval(seconds) to check restore annotations on persistent volumes in controller, 0 means volume restore is disabled")
+	leaderElectionNamespace    = flag.String("leader-election-namespace", "kube-system", "namespace of the lease which elects the controller replica syncing volume restores")
+	auditLogPath               = flag.String("audit-log-path", "", "file to write audit records of credential resolutions, mounts and unmounts as json lines, - means stdout, audit log is disabled if it's empty")
+	enableAuditEvents          = flag.Bool("enable-audit-events", false, "report audit records as events on PVC and pod")
 )
 
 func main() {
@@ -78,9 +91,22 @@ func handle() {
 		BlobfuseProxyEndpoint:      *blobfuseProxyEndpoint,
 		EnableBlobfuseProxy:        *enableBlobfuseProxy,
 		BlobfuseProxyConnTimout:    *blobfuseProxyConnTimout,
+		BlobfuseProxyTLSCAFile:     *blobfuseProxyTLSCAFile,
+		BlobfuseProxyTLSCertFile:   *blobfuseProxyTLSCertFile,
+		BlobfuseProxyTLSKeyFile:    *blobfuseProxyTLSKeyFile,
+		BlobfuseProxyTLSServerName: *blobfuseProxyTLSServerName,
+		BlobfuseProxyFallback:      *blobfuseProxyFallback,
 		EnableBlobMockMount:        *enableBlobMockMount,
 		CustomUserAgent:            *customUserAgent,
 		UserAgentSuffix:            *userAgentSuffix,
+		BlobfuseCacheRoot:          *blobfuseCacheRoot,
+		BlobfuseCacheSizeMB:        *blobfuseCacheSizeMB,
+		BlobfuseCredentialDelivery: *blobfuseCredentialDelivery,
+		BlobfuseCredentialDir:      *blobfuseCredentialDir,
+		VolumeRestoreSyncPeriod:    *volumeRestoreSyncPeriod,
+		LeaderElectionNamespace:    *leaderElectionNamespace,
+		AuditLogPath:               *auditLogPath,
+		EnableAuditEvents:          *enableAuditEvents,
 	}
 	driver := blob.NewDriver(&driverOptions)
 	if driver == nil {
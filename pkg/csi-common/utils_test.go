@@ -121,6 +121,17 @@ func TestLogGRPC(t *testing.T) {
 			},
 			`GRPC request: {"secrets":"***stripped***","volume_id":"vol_1"}`,
 		},
+		{
+			"with secrets in volume context",
+			&csi.NodeStageVolumeRequest{
+				VolumeId: "vol_1",
+				VolumeContext: map[string]string{
+					"accountKey":   "testkey",
+					"mountOptions": "--sas-token=testtoken",
+				},
+			},
+			`GRPC request: {"volume_context":{"accountKey":"***redacted***","mountOptions":"--sas-token=***redacted***"},"volume_id":"vol_1"}`,
+		},
 		{
 			"without secrets",
 			&csi.ListSnapshotsRequest{
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ways to pass credentials to blobfuse
+const (
+	// BlobfuseCredentialDeliveryEnv passes credentials in environment variables of blobfuse process
+	BlobfuseCredentialDeliveryEnv = "env"
+	// BlobfuseCredentialDeliveryFile passes credentials in a per-mount config file, which is removed after blobfuse is mounted
+	BlobfuseCredentialDeliveryFile = "file"
+	// BlobfuseCredentialDeliveryFD passes credentials in a config file read from a pipe inherited by blobfuse
+	BlobfuseCredentialDeliveryFD = "fd"
+
+	blobfuseConfigFileOption = "--config-file"
+	// maxBlobfuseConfigSize is the limit of config passed by pipe, so that writing to pipe never blocks
+	maxBlobfuseConfigSize = 4096
+)
+
+// blobfuseConfigKeys maps environment variables read by blobfuse to the keys in blobfuse config file,
+// variables which could not be set in config file are still passed in environment,
+// e.g. MSI_SECRET and AZURE_STORAGE_SPN_CLIENT_SECRET, which blobfuse only reads from environment
+var blobfuseConfigKeys = map[string]string{
+	"AZURE_STORAGE_ACCOUNT":              "accountName",
+	"AZURE_STORAGE_ACCESS_KEY":           "accountKey",
+	"AZURE_STORAGE_SAS_TOKEN":            "sasToken",
+	"AZURE_STORAGE_BLOB_ENDPOINT":        "blobEndpoint",
+	"AZURE_STORAGE_AUTH_TYPE":            "authType",
+	"AZURE_STORAGE_IDENTITY_CLIENT_ID":   "identityClientId",
+	"AZURE_STORAGE_IDENTITY_OBJECT_ID":   "identityObjectId",
+	"AZURE_STORAGE_IDENTITY_RESOURCE_ID": "identityResourceId",
+	"MSI_ENDPOINT":                       "msiEndpoint",
+	"AZURE_STORAGE_SPN_CLIENT_ID":        "servicePrincipalClientId",
+	"AZURE_STORAGE_SPN_TENANT_ID":        "servicePrincipalTenantId",
+	"AZURE_STORAGE_AAD_ENDPOINT":         "aadEndpoint",
+}
+
+// IsValidBlobfuseCredentialDelivery returns whether delivery is a supported way to pass credentials to blobfuse
+func IsValidBlobfuseCredentialDelivery(delivery string) bool {
+	switch delivery {
+	case BlobfuseCredentialDeliveryEnv, BlobfuseCredentialDeliveryFile, BlobfuseCredentialDeliveryFD:
+		return true
+	}
+	return false
+}
+
+// EnsureBlobfuseCredentialDir creates dir for per-mount config files which is only accessible by owner,
+// an error is returned if dir is not on tmpfs so that credentials are never written to disk
+func EnsureBlobfuseCredentialDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	tmpfs, err := isTmpfs(dir)
+	if err != nil {
+		return err
+	}
+	if !tmpfs {
+		return fmt.Errorf("blobfuse credential directory(%s) is not on tmpfs", dir)
+	}
+	return nil
+}
+
+// BlobfuseCredentials are the arguments, environment variables and inherited files to pass credentials to blobfuse,
+// Cleanup should be called after blobfuse command returns, i.e. blobfuse daemon has read the credentials
+type BlobfuseCredentials struct {
+	Args       []string
+	Env        []string
+	ExtraFiles []*os.File
+	cleanup    func()
+}
+
+// Cleanup removes config file or closes pipe of the credentials
+func (c *BlobfuseCredentials) Cleanup() {
+	if c.cleanup != nil {
+		c.cleanup()
+		c.cleanup = nil
+	}
+}
+
+// PrepareBlobfuseCredentials moves authEnv into a blobfuse config file according to delivery,
+// the config file is created in dir with 0600 permission for file delivery,
+// authEnv is passed in environment if delivery is env or a config file is already specified in args
+func PrepareBlobfuseCredentials(delivery, dir string, args []string, authEnv []string) (*BlobfuseCredentials, error) {
+	if delivery == "" || delivery == BlobfuseCredentialDeliveryEnv || hasBlobfuseConfigFile(args) {
+		return &BlobfuseCredentials{Env: authEnv}, nil
+	}
+	config, env, err := buildBlobfuseConfig(authEnv)
+	if err != nil {
+		return nil, err
+	}
+	if len(config) == 0 {
+		return &BlobfuseCredentials{Env: env}, nil
+	}
+
+	switch delivery {
+	case BlobfuseCredentialDeliveryFile:
+		f, err := ioutil.TempFile(dir, "blobfuse-*.cfg")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create blobfuse config file in %s: %v", dir, err)
+		}
+		remove := func() { os.Remove(f.Name()) }
+		// TempFile creates file with 0600 permission, chmod in case umask is not applied as expected
+		if err := f.Chmod(0600); err != nil {
+			f.Close()
+			remove()
+			return nil, fmt.Errorf("failed to chmod blobfuse config file: %v", err)
+		}
+		_, err = f.Write(config)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			remove()
+			return nil, fmt.Errorf("failed to write blobfuse config file: %v", err)
+		}
+		return &BlobfuseCredentials{Args: []string{blobfuseConfigFileOption + "=" + f.Name()}, Env: env, cleanup: remove}, nil
+	case BlobfuseCredentialDeliveryFD:
+		if len(config) > maxBlobfuseConfigSize {
+			return nil, fmt.Errorf("blobfuse config size(%d) exceeds %d bytes", len(config), maxBlobfuseConfigSize)
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pipe: %v", err)
+		}
+		_, err = w.Write(config)
+		w.Close()
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to write blobfuse config to pipe: %v", err)
+		}
+		// the first extra file is fd 3 in child process
+		return &BlobfuseCredentials{
+			Args:       []string{blobfuseConfigFileOption + "=/dev/fd/3"},
+			Env:        env,
+			ExtraFiles: []*os.File{r},
+			cleanup:    func() { r.Close() },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported blobfuse credential delivery(%s)", delivery)
+	}
+}
+
+// buildBlobfuseConfig returns blobfuse config file content built from authEnv, and the variables not in config file
+func buildBlobfuseConfig(authEnv []string) ([]byte, []string, error) {
+	var config bytes.Buffer
+	env := []string{}
+	for _, e := range authEnv {
+		kv := strings.SplitN(e, "=", 2)
+		key, ok := blobfuseConfigKeys[kv[0]]
+		if !ok || len(kv) != 2 {
+			env = append(env, e)
+			continue
+		}
+		if strings.ContainsAny(kv[1], "\r\n") {
+			return nil, nil, fmt.Errorf("value of %s contains newline", kv[0])
+		}
+		fmt.Fprintf(&config, "%s %s\n", key, kv[1])
+	}
+	return config.Bytes(), env, nil
+}
+
+// hasBlobfuseConfigFile returns whether config file is specified in blobfuse arguments
+func hasBlobfuseConfigFile(args []string) bool {
+	for _, arg := range args {
+		if arg == blobfuseConfigFileOption || strings.HasPrefix(arg, blobfuseConfigFileOption+"=") {
+			return true
+		}
+	}
+	return false
+}
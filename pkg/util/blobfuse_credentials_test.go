@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAuthEnv = []string{
+	"AZURE_STORAGE_ACCESS_KEY=key",
+	"MSI_SECRET=msi",
+	"AZURE_STORAGE_ACCOUNT=account",
+	"AZURE_STORAGE_BLOB_ENDPOINT=",
+}
+
+const testBlobfuseConfig = "accountKey key\naccountName account\nblobEndpoint \n"
+
+func TestIsValidBlobfuseCredentialDelivery(t *testing.T) {
+	for _, delivery := range []string{BlobfuseCredentialDeliveryEnv, BlobfuseCredentialDeliveryFile, BlobfuseCredentialDeliveryFD} {
+		assert.True(t, IsValidBlobfuseCredentialDelivery(delivery))
+	}
+	assert.False(t, IsValidBlobfuseCredentialDelivery("pipe"))
+	assert.False(t, IsValidBlobfuseCredentialDelivery(""))
+}
+
+func TestPrepareBlobfuseCredentialsEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery string
+		args     []string
+		authEnv  []string
+	}{
+		{
+			name:     "env delivery",
+			delivery: BlobfuseCredentialDeliveryEnv,
+			authEnv:  testAuthEnv,
+		},
+		{
+			name:    "default delivery",
+			authEnv: testAuthEnv,
+		},
+		{
+			name:     "config file in mount options",
+			delivery: BlobfuseCredentialDeliveryFile,
+			args:     []string{"/mnt/target", "--config-file=/etc/blobfuse.cfg"},
+			authEnv:  testAuthEnv,
+		},
+		{
+			name:     "no credentials in config file",
+			delivery: BlobfuseCredentialDeliveryFD,
+			authEnv:  []string{"MSI_SECRET=msi"},
+		},
+	}
+	for _, test := range tests {
+		creds, err := PrepareBlobfuseCredentials(test.delivery, "", test.args, test.authEnv)
+		assert.NoError(t, err, test.name)
+		assert.Empty(t, creds.Args, test.name)
+		assert.Equal(t, test.authEnv, creds.Env, test.name)
+		assert.Empty(t, creds.ExtraFiles, test.name)
+		creds.Cleanup()
+	}
+}
+
+func TestPrepareBlobfuseCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobfuse-credentials")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	creds, err := PrepareBlobfuseCredentials(BlobfuseCredentialDeliveryFile, dir, []string{"/mnt/target"}, testAuthEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"MSI_SECRET=msi"}, creds.Env)
+	assert.Equal(t, 1, len(creds.Args))
+	configFile := strings.TrimPrefix(creds.Args[0], "--config-file=")
+	assert.Equal(t, dir, filepath.Dir(configFile))
+	info, err := os.Stat(configFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := ioutil.ReadFile(configFile)
+	assert.NoError(t, err)
+	assert.Equal(t, testBlobfuseConfig, string(content))
+
+	creds.Cleanup()
+	_, err = os.Stat(configFile)
+	assert.True(t, os.IsNotExist(err))
+	// cleanup is idempotent
+	creds.Cleanup()
+
+	_, err = PrepareBlobfuseCredentials(BlobfuseCredentialDeliveryFile, filepath.Join(dir, "not-exist"), nil, testAuthEnv)
+	assert.Error(t, err)
+	_, err = PrepareBlobfuseCredentials(BlobfuseCredentialDeliveryFile, dir, nil, []string{"AZURE_STORAGE_SAS_TOKEN=sig\nauthType MSI"})
+	assert.Error(t, err)
+	_, err = PrepareBlobfuseCredentials("pipe", dir, nil, testAuthEnv)
+	assert.Error(t, err)
+}
+
+func TestPrepareBlobfuseCredentialsSPN(t *testing.T) {
+	authEnv := []string{
+		"AZURE_STORAGE_AUTH_TYPE=SPN",
+		"AZURE_STORAGE_SPN_CLIENT_ID=clientID",
+		"AZURE_STORAGE_SPN_TENANT_ID=tenantID",
+		"AZURE_STORAGE_SPN_CLIENT_SECRET=secret",
+		"AZURE_STORAGE_ACCOUNT=account",
+	}
+	for _, delivery := range []string{BlobfuseCredentialDeliveryFile, BlobfuseCredentialDeliveryFD} {
+		dir, err := ioutil.TempDir("", "blobfuse-credentials")
+		assert.NoError(t, err)
+		creds, err := PrepareBlobfuseCredentials(delivery, dir, []string{"/mnt/target"}, authEnv)
+		assert.NoError(t, err, delivery)
+		// SPN client secret is only read from environment by blobfuse
+		assert.Equal(t, []string{"AZURE_STORAGE_SPN_CLIENT_SECRET=secret"}, creds.Env, delivery)
+
+		var content []byte
+		if delivery == BlobfuseCredentialDeliveryFD {
+			content, err = ioutil.ReadAll(creds.ExtraFiles[0])
+		} else {
+			content, err = ioutil.ReadFile(strings.TrimPrefix(creds.Args[0], "--config-file="))
+		}
+		assert.NoError(t, err, delivery)
+		assert.Equal(t, "authType SPN\nservicePrincipalClientId clientID\nservicePrincipalTenantId tenantID\naccountName account\n", string(content), delivery)
+		creds.Cleanup()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPrepareBlobfuseCredentialsFD(t *testing.T) {
+	creds, err := PrepareBlobfuseCredentials(BlobfuseCredentialDeliveryFD, "", []string{"/mnt/target"}, testAuthEnv)
+	assert.NoError(t, err)
+	defer creds.Cleanup()
+	assert.Equal(t, []string{"--config-file=/dev/fd/3"}, creds.Args)
+	assert.Equal(t, []string{"MSI_SECRET=msi"}, creds.Env)
+	assert.Equal(t, 1, len(creds.ExtraFiles))
+	content, err := ioutil.ReadAll(creds.ExtraFiles[0])
+	assert.NoError(t, err)
+	assert.Equal(t, testBlobfuseConfig, string(content))
+
+	_, err = PrepareBlobfuseCredentials(BlobfuseCredentialDeliveryFD, "", nil, []string{"AZURE_STORAGE_SAS_TOKEN=" + strings.Repeat("s", maxBlobfuseConfigSize)})
+	assert.Error(t, err)
+}
+
+func TestEnsureBlobfuseCredentialDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tmpfs is only checked on linux")
+	}
+	dir, err := ioutil.TempDir("", "blobfuse-credentials")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	if tmpfs, _ := isTmpfs(dir); !tmpfs {
+		assert.Error(t, EnsureBlobfuseCredentialDir(filepath.Join(dir, "credentials")))
+	}
+
+	if tmpfs, _ := isTmpfs("/dev/shm"); !tmpfs {
+		t.Skip("/dev/shm is not tmpfs")
+	}
+	shmDir, err := ioutil.TempDir("/dev/shm", "blobfuse-credentials")
+	assert.NoError(t, err)
+	defer os.RemoveAll(shmDir)
+	credentialDir := filepath.Join(shmDir, "credentials")
+	assert.NoError(t, EnsureBlobfuseCredentialDir(credentialDir))
+	info, err := os.Stat(credentialDir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
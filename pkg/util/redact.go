@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+)
+
+// RedactedValue replaces values of sensitive keys in logs and error messages
+const RedactedValue = "***redacted***"
+
+var (
+	// sensitiveKeys is the registry of keys whose values are secrets, keys are normalized by normalizeSensitiveKey
+	sensitiveKeys = map[string]bool{}
+	// sensitiveValueRegexp matches "key=value" of sensitive keys in free text, it's rebuilt when keys are registered
+	sensitiveValueRegexp *regexp.Regexp
+	sensitiveKeysLock    sync.RWMutex
+	// sensitiveQueryParamRegexp matches SAS token query parameters, sig is the signature and se is the expiry of the token
+	sensitiveQueryParamRegexp = regexp.MustCompile(`(?i)((?:^|[^a-z0-9_])(?:sig|se)=)[^&\s,;"'\]\)}]*`)
+)
+
+func init() {
+	RegisterSensitiveKeys(
+		// environment variables read by blobfuse
+		"AZURE_STORAGE_ACCESS_KEY",
+		"AZURE_STORAGE_SAS_TOKEN",
+		"AZURE_STORAGE_SPN_CLIENT_SECRET",
+		"MSI_SECRET",
+		// blobfuse config file keys and options
+		"accountKey",
+		"sasToken",
+		"servicePrincipalClientSecret",
+		"--account-key",
+		"--sas-token",
+	)
+}
+
+// RegisterSensitiveKeys adds keys whose values are redacted, keys are matched case-insensitively,
+// leading dashes of option names are ignored
+func RegisterSensitiveKeys(keys ...string) {
+	sensitiveKeysLock.Lock()
+	defer sensitiveKeysLock.Unlock()
+	for _, key := range keys {
+		sensitiveKeys[normalizeSensitiveKey(key)] = true
+	}
+	names := make([]string, 0, len(sensitiveKeys))
+	for key := range sensitiveKeys {
+		names = append(names, regexp.QuoteMeta(key))
+	}
+	// longer names first so that a name is not matched by its suffix
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	pattern := strings.Join(names, "|")
+	// value follows "=" or ":", or a space for options, and ends at separators of argument list, formatted maps and json
+	sensitiveValueRegexp = regexp.MustCompile(`(?i)((?:^|[^a-z0-9_])(?:-{0,2}(?:` + pattern + `)"?\s*[=:]\s*"?|--(?:` + pattern + `)\s+))[^\s,;"'\]\)}]*`)
+}
+
+// IsSensitiveKey returns whether values of key are secrets
+func IsSensitiveKey(key string) bool {
+	sensitiveKeysLock.RLock()
+	defer sensitiveKeysLock.RUnlock()
+	return sensitiveKeys[normalizeSensitiveKey(key)]
+}
+
+func normalizeSensitiveKey(key string) string {
+	return strings.ToLower(strings.TrimLeft(strings.TrimSpace(key), "-"))
+}
+
+// RedactString redacts values of sensitive keys in "key=value" or "key:value" form, and SAS token query parameters,
+// it's used on free text, e.g. errors and command output
+func RedactString(s string) string {
+	sensitiveKeysLock.RLock()
+	s = sensitiveValueRegexp.ReplaceAllString(s, "${1}"+RedactedValue)
+	sensitiveKeysLock.RUnlock()
+	return sensitiveQueryParamRegexp.ReplaceAllString(s, "${1}"+RedactedValue)
+}
+
+// RedactMap returns a copy of m with values of sensitive keys redacted, e.g. volume context and secrets
+func RedactMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(m))
+	for k, v := range m {
+		if IsSensitiveKey(k) {
+			redacted[k] = RedactedValue
+		} else {
+			redacted[k] = RedactString(v)
+		}
+	}
+	return redacted
+}
+
+// RedactKeyValues returns a copy of "key=value" items with values of sensitive keys redacted,
+// e.g. environment variables and blobfuse arguments
+func RedactKeyValues(items []string) []string {
+	if items == nil {
+		return nil
+	}
+	redacted := make([]string, 0, len(items))
+	for _, item := range items {
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 && IsSensitiveKey(kv[0]) {
+			redacted = append(redacted, kv[0]+"="+RedactedValue)
+		} else {
+			redacted = append(redacted, RedactString(item))
+		}
+	}
+	return redacted
+}
+
+// RedactRequest formats a gRPC request with secret fields stripped by protosanitizer and sensitive values redacted,
+// e.g. secrets in volume context or parameters which are not marked as csi_secret
+func RedactRequest(req interface{}) string {
+	return RedactString(protosanitizer.StripSecrets(req).String())
+}
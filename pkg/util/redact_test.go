@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "c2VjcmV0LWtleQ=="
+
+func TestIsSensitiveKey(t *testing.T) {
+	for _, key := range []string{"AZURE_STORAGE_ACCESS_KEY", "azure_storage_sas_token", "MSI_SECRET", "accountKey", "--account-key", "-sas-token"} {
+		assert.True(t, IsSensitiveKey(key), key)
+	}
+	for _, key := range []string{"AZURE_STORAGE_ACCOUNT", "accountName", "--container-name", "storeaccountkey", ""} {
+		assert.False(t, IsSensitiveKey(key), key)
+	}
+}
+
+func TestRegisterSensitiveKeys(t *testing.T) {
+	assert.False(t, IsSensitiveKey("testsecretfield"))
+	RegisterSensitiveKeys("TestSecretField")
+	assert.True(t, IsSensitiveKey("testsecretfield"))
+	assert.Equal(t, "testsecretfield="+RedactedValue, RedactString("testsecretfield="+testSecret))
+}
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		desc     string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "env list",
+			input:    fmt.Sprintf("%s", []string{"AZURE_STORAGE_ACCESS_KEY=" + testSecret, "AZURE_STORAGE_ACCOUNT=account"}),
+			expected: "[AZURE_STORAGE_ACCESS_KEY=" + RedactedValue + " AZURE_STORAGE_ACCOUNT=account]",
+		},
+		{
+			desc:     "formatted map",
+			input:    fmt.Sprintf("%v", map[string]string{"accountKey": testSecret, "containerName": "c"}),
+			expected: "map[accountKey:" + RedactedValue + " containerName:c]",
+		},
+		{
+			desc:     "json",
+			input:    `{"MSI_SECRET": "` + testSecret + `","accountName":"account"}`,
+			expected: `{"MSI_SECRET": "` + RedactedValue + `","accountName":"account"}`,
+		},
+		{
+			desc:     "blobfuse arguments",
+			input:    "blobfuse /mnt --account-key=" + testSecret + " --sas-token " + testSecret + " --container-name=c",
+			expected: "blobfuse /mnt --account-key=" + RedactedValue + " --sas-token " + RedactedValue + " --container-name=c",
+		},
+		{
+			desc:     "SAS token query parameters",
+			input:    "GET https://account.blob.core.windows.net/c?sv=2020-08-04&se=2021-12-31T00%3A00%3A00Z&sig=" + testSecret + " failed",
+			expected: "GET https://account.blob.core.windows.net/c?sv=2020-08-04&se=" + RedactedValue + "&sig=" + RedactedValue + " failed",
+		},
+		{
+			desc:     "keys which are not secrets",
+			input:    "map[storeaccountkey:true getaccountkeyfromsecret:false cachesize=10 reuse=true]",
+			expected: "map[storeaccountkey:true getaccountkeyfromsecret:false cachesize=10 reuse=true]",
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, RedactString(test.input), test.desc)
+	}
+}
+
+func TestRedactMap(t *testing.T) {
+	assert.Nil(t, RedactMap(nil))
+	m := map[string]string{
+		"AccountKey":    "key with spaces",
+		"containername": "c",
+		"sasurl":        "https://account.blob.core.windows.net/c?sig=" + testSecret,
+	}
+	redacted := RedactMap(m)
+	assert.Equal(t, map[string]string{
+		"AccountKey":    RedactedValue,
+		"containername": "c",
+		"sasurl":        "https://account.blob.core.windows.net/c?sig=" + RedactedValue,
+	}, redacted)
+	// original map is not changed
+	assert.Equal(t, "key with spaces", m["AccountKey"])
+}
+
+func TestRedactKeyValues(t *testing.T) {
+	assert.Nil(t, RedactKeyValues(nil))
+	items := []string{
+		"AZURE_STORAGE_SAS_TOKEN=?sv=2020-08-04&sig=" + testSecret,
+		"AZURE_STORAGE_SPN_CLIENT_SECRET=secret with spaces",
+		"AZURE_STORAGE_ACCOUNT=account",
+		"--account-key=" + testSecret,
+		"-o allow_other",
+	}
+	assert.Equal(t, []string{
+		"AZURE_STORAGE_SAS_TOKEN=" + RedactedValue,
+		"AZURE_STORAGE_SPN_CLIENT_SECRET=" + RedactedValue,
+		"AZURE_STORAGE_ACCOUNT=account",
+		"--account-key=" + RedactedValue,
+		"-o allow_other",
+	}, RedactKeyValues(items))
+	assert.Equal(t, "--account-key="+testSecret, items[3])
+}
+
+func TestRedactRequest(t *testing.T) {
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:      "rg#account#container",
+		Secrets:       map[string]string{"azurestorageaccountkey": testSecret},
+		VolumeContext: map[string]string{"accountKey": testSecret, "mountOptions": "--sas-token=" + testSecret},
+	}
+	redacted := RedactRequest(req)
+	assert.False(t, strings.Contains(redacted, testSecret), redacted)
+	assert.Contains(t, redacted, "rg#account#container")
+}
@@ -19,6 +19,7 @@ package util
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -30,6 +31,24 @@ const (
 	tagKeyValueDelimiter = "="
 )
 
+// mount service versions and capabilities of blobfuse proxy negotiated by driver,
+// capabilities are strings so that driver could ignore the ones it does not know
+const (
+	BlobfuseProxyAPIVersionV1 = "v1"
+	BlobfuseProxyAPIVersionV2 = "v2"
+
+	// BlobfuseProxyCapabilityStructuredArgs means blobfuse arguments are passed one per element instead of a space-joined string
+	BlobfuseProxyCapabilityStructuredArgs = "structured-args"
+	BlobfuseProxyCapabilityUnmount        = "unmount"
+	BlobfuseProxyCapabilityListMounts     = "list-mounts"
+	// BlobfuseProxyCapabilitySupervision means blobfuse daemons are remounted by proxy when they exit
+	BlobfuseProxyCapabilitySupervision = "supervision"
+	// BlobfuseProxyCapabilityMountStream means mount progress and blobfuse output are streamed by MountStream
+	BlobfuseProxyCapabilityMountStream = "mount-stream"
+	// BlobfuseProxyCapabilityMountLog means per-mount logs are retained and returned by GetMountLog
+	BlobfuseProxyCapabilityMountLog = "mount-log"
+)
+
 // RoundUpBytes rounds up the volume size in bytes upto multiplications of GiB
 // in the unit of Bytes
 func RoundUpBytes(volumeSizeBytes int64) int64 {
@@ -77,6 +96,60 @@ func GetMountOptions(options []string) string {
 	return str
 }
 
+// SplitBlobfuseMountOptions splits blobfuse mount options into an argument list and an options map,
+// e.g. "--tmp-path=/mnt/cache" goes to the options map, "-o allow_other" goes to the argument list as "-o", "allow_other".
+// value of "--name=value" is kept as is even if it contains spaces, while other mount options are split by spaces
+// as blobfuse arguments, e.g. "--log-level LOG_DEBUG" goes to the options map, and "-o allow_other -o ro" goes to
+// the argument list as "-o", "allow_other", "-o", "ro"
+func SplitBlobfuseMountOptions(mountOptions []string) ([]string, map[string]string) {
+	args := []string{}
+	options := make(map[string]string)
+	for _, opt := range mountOptions {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		if strings.HasPrefix(opt, "--") && strings.Contains(strings.Fields(opt)[0], "=") {
+			kv := strings.SplitN(opt, "=", 2)
+			options[kv[0]] = kv[1]
+			continue
+		}
+		fields := strings.Fields(opt)
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			switch {
+			case field == "-o" && i+1 < len(fields):
+				args = append(args, "-o", fields[i+1])
+				i++
+			case strings.HasPrefix(field, "--") && strings.Contains(field, "="):
+				kv := strings.SplitN(field, "=", 2)
+				options[kv[0]] = kv[1]
+			case strings.HasPrefix(field, "--") && i+1 < len(fields) && !strings.HasPrefix(fields[i+1], "-"):
+				options[field] = fields[i+1]
+				i++
+			default:
+				args = append(args, field)
+			}
+		}
+	}
+	return args, options
+}
+
+// BuildBlobfuseArgs returns blobfuse command line arguments: target path, args, and options sorted by key
+func BuildBlobfuseArgs(targetPath string, args []string, options map[string]string) []string {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cmdArgs := append([]string{targetPath}, args...)
+	for _, k := range keys {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("%s=%s", k, options[k]))
+	}
+	return cmdArgs
+}
+
 func MakeDir(pathname string) error {
 	err := os.MkdirAll(pathname, os.FileMode(0755))
 	if err != nil {
@@ -91,12 +164,15 @@ func MakeDir(pathname string) error {
 type LockMap struct {
 	sync.Mutex
 	mutexMap map[string]*sync.Mutex
+	// refCount is the number of callers which hold or wait for the lock of an entry
+	refCount map[string]int
 }
 
 // NewLockMap returns a new lock map
 func NewLockMap() *LockMap {
 	return &LockMap{
 		mutexMap: make(map[string]*sync.Mutex),
+		refCount: make(map[string]int),
 	}
 }
 
@@ -107,9 +183,11 @@ func (lm *LockMap) LockEntry(entry string) {
 	if _, exists := lm.mutexMap[entry]; !exists {
 		lm.addEntry(entry)
 	}
+	lm.refCount[entry]++
+	mutex := lm.mutexMap[entry]
 
 	lm.Unlock()
-	lm.lockEntry(entry)
+	mutex.Lock()
 }
 
 // UnlockEntry release the lock associated with the specific entry
@@ -123,15 +201,30 @@ func (lm *LockMap) UnlockEntry(entry string) {
 	lm.unlockEntry(entry)
 }
 
-func (lm *LockMap) addEntry(entry string) {
-	lm.mutexMap[entry] = &sync.Mutex{}
+// UnlockAndDeleteEntry release the lock associated with the specific entry, and deletes the entry when no other
+// caller holds or waits for it, it's used when the entry is not expected to be locked again, e.g. after unmount
+func (lm *LockMap) UnlockAndDeleteEntry(entry string) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	if _, exists := lm.mutexMap[entry]; !exists {
+		return
+	}
+	lm.unlockEntry(entry)
+	if lm.refCount[entry] == 0 {
+		delete(lm.mutexMap, entry)
+		delete(lm.refCount, entry)
+	}
 }
 
-func (lm *LockMap) lockEntry(entry string) {
-	lm.mutexMap[entry].Lock()
+func (lm *LockMap) addEntry(entry string) {
+	lm.mutexMap[entry] = &sync.Mutex{}
 }
 
 func (lm *LockMap) unlockEntry(entry string) {
+	if lm.refCount[entry] > 0 {
+		lm.refCount[entry]--
+	}
 	lm.mutexMap[entry].Unlock()
 }
 
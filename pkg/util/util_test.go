@@ -111,6 +111,35 @@ func TestUnlockEntryNotExists(t *testing.T) {
 	testLockMap.UnlockEntry("entry2")
 	testLockMap.UnlockEntry("entry1")
 }
+
+func TestUnlockAndDeleteEntry(t *testing.T) {
+	testLockMap := NewLockMap()
+
+	callbackChan1 := make(chan interface{})
+	callbackChan2 := make(chan interface{})
+	go testLockMap.lockAndCallback(t, "entry1", callbackChan1)
+	ensureCallbackHappens(t, callbackChan1)
+	go testLockMap.lockAndCallback(t, "entry1", callbackChan2)
+	ensureNoCallback(t, callbackChan2)
+
+	// entry is not deleted while another caller waits for it
+	testLockMap.UnlockAndDeleteEntry("entry1")
+	ensureCallbackHappens(t, callbackChan2)
+	if len(testLockMap.mutexMap) != 1 {
+		t.Fatalf("entry1 is deleted while it's locked")
+	}
+
+	testLockMap.UnlockAndDeleteEntry("entry1")
+	if len(testLockMap.mutexMap) != 0 || len(testLockMap.refCount) != 0 {
+		t.Fatalf("entry1 is not deleted after unlock")
+	}
+	// entry2 does not exist
+	testLockMap.UnlockAndDeleteEntry("entry2")
+
+	go testLockMap.lockAndCallback(t, "entry1", callbackChan1)
+	ensureCallbackHappens(t, callbackChan1)
+	testLockMap.UnlockEntry("entry1")
+}
 func TestBytesToGiB(t *testing.T) {
 	var sizeInBytes int64 = 5 * GiB
 
@@ -156,6 +185,48 @@ func TestGetMountOptions(t *testing.T) {
 	}
 }
 
+func TestSplitBlobfuseMountOptions(t *testing.T) {
+	tests := []struct {
+		options         []string
+		expectedArgs    []string
+		expectedOptions map[string]string
+	}{
+		{
+			options:         []string{"-o allow_other", "-o  ro", "--use-https=true", "-d", ""},
+			expectedArgs:    []string{"-o", "allow_other", "-o", "ro", "-d"},
+			expectedOptions: map[string]string{"--use-https": "true"},
+		},
+		{
+			options:         []string{"--tmp-path=/mnt/dir with space", "--log-level=LOG_DEBUG --foo"},
+			expectedArgs:    []string{},
+			expectedOptions: map[string]string{"--tmp-path": "/mnt/dir with space", "--log-level": "LOG_DEBUG --foo"},
+		},
+		{
+			// options separated by space are split as blobfuse arguments
+			options:         []string{"-o allow_other -o ro", "--use-attr-cache --log-level LOG_DEBUG", "--cache-size-mb 1000 --use-https=true"},
+			expectedArgs:    []string{"-o", "allow_other", "-o", "ro", "--use-attr-cache"},
+			expectedOptions: map[string]string{"--log-level": "LOG_DEBUG", "--cache-size-mb": "1000", "--use-https": "true"},
+		},
+		{
+			options:         []string{"-o", "--log-level"},
+			expectedArgs:    []string{"-o", "--log-level"},
+			expectedOptions: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		args, options := SplitBlobfuseMountOptions(test.options)
+		assert.Equal(t, test.expectedArgs, args)
+		assert.Equal(t, test.expectedOptions, options)
+	}
+}
+
+func TestBuildBlobfuseArgs(t *testing.T) {
+	result := BuildBlobfuseArgs("/mnt/target path", []string{"-o", "allow_other"}, map[string]string{"--use-https": "true", "--container-name": "c"})
+	expected := []string{"/mnt/target path", "-o", "allow_other", "--container-name=c", "--use-https=true"}
+	assert.Equal(t, expected, result)
+}
+
 func TestMakeDir(t *testing.T) {
 	//Successfully create directory
 	targetTest := "./target_test"